@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+// runFsck implements `server fsck`: it compares the database with the storage
+// backends, prints what is wrong and optionally repairs it. Returns the exit
+// code: 0 when everything is consistent (or was repaired), 1 when issues
+// remain and 2 when the check itself failed.
+func runFsck(ctx context.Context, args []string, queries *database.Queries, files, thumbnails services.Backend) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "quarantine orphans, fix counters, mark broken files and clear missing thumbnails")
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	minOrphanAge := flags.Duration("min-orphan-age", time.Hour, "ignore unreferenced objects younger than this (uploads in flight)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: server fsck [-repair] [-json] [-min-orphan-age 1h]")
+		fmt.Fprintln(flags.Output(), "Stop the server before repairing.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	checker := services.NewConsistencyChecker(queries, files, thumbnails)
+	report, err := checker.Run(ctx, services.FsckOptions{
+		Repair:       *repair,
+		MinOrphanAge: *minOrphanAge,
+	})
+	if err != nil {
+		log.Printf("fsck failed: %v", err)
+		return 2
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printFsckReport(report)
+	}
+
+	if report.Unresolved() > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printFsckReport(report services.FsckReport) {
+	fmt.Printf("Checked %d blobs and %d files against %d objects and %d thumbnails\n",
+		report.Blobs, report.Files, report.Objects, report.Thumbnails)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(report.Issues) > 0 {
+		fmt.Fprintln(w, "\nISSUE\tKEY\tFILE\tUSER\tEXPECTED\tACTUAL\tDETAIL\tREPAIR")
+		for _, issue := range report.Issues {
+			repair := "-"
+			switch {
+			case issue.Repaired:
+				repair = "repaired"
+			case issue.RepairError != "":
+				repair = "failed: " + issue.RepairError
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+				issue.Kind, orDash(issue.Key), orDash(issue.FileID), orDash(issue.UserID),
+				issue.Expected, issue.Actual, orDash(issue.Detail), repair)
+		}
+	}
+
+	fmt.Fprintln(w, "\nUSER\tEMAIL\tSTORAGE_USED\tRECOMPUTED")
+	for _, usage := range report.Usage {
+		marker := ""
+		if usage.Recorded != usage.Computed {
+			marker = "  *"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d%s\n", usage.UserID, usage.Email, usage.Recorded, usage.Computed, marker)
+	}
+	w.Flush()
+
+	if report.Quarantine != "" {
+		fmt.Printf("\nOrphans were moved under %s/\n", strings.TrimSuffix(report.Quarantine, "/"))
+	}
+	fmt.Printf("\n%d issues, %d unresolved\n", len(report.Issues), report.Unresolved())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"os"
 	"strconv"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
@@ -56,12 +55,45 @@ func main() {
 		sessionDurationHours = 720 // 30 days default
 	}
 
-	// Create storage directories
-	if err := os.MkdirAll(storagePath, 0755); err != nil {
-		log.Fatalf("Failed to create storage directory: %v", err)
+	// Select storage backend (local disk by default)
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "local"
 	}
-	if err := os.MkdirAll(thumbnailPath, 0755); err != nil {
-		log.Fatalf("Failed to create thumbnail directory: %v", err)
+
+	var fileBackend, thumbnailBackend services.Backend
+	switch storageBackend {
+	case "local":
+		// Create storage directories
+		if err := os.MkdirAll(storagePath, 0755); err != nil {
+			log.Fatalf("Failed to create storage directory: %v", err)
+		}
+		if err := os.MkdirAll(thumbnailPath, 0755); err != nil {
+			log.Fatalf("Failed to create thumbnail directory: %v", err)
+		}
+		fileBackend = services.NewDiskBackend(storagePath)
+		thumbnailBackend = services.NewDiskBackend(thumbnailPath)
+	case "s3":
+		pathStyle, err := strconv.ParseBool(os.Getenv("S3_FORCE_PATH_STYLE"))
+		if err != nil {
+			pathStyle = true // MinIO and most self-hosted stores expect path-style URLs
+		}
+		s3Backend, err := services.NewS3Backend(services.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Prefix:          os.Getenv("S3_PREFIX") + "uploads/",
+			PathStyle:       pathStyle,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure S3 storage: %v", err)
+		}
+		fileBackend = s3Backend
+		thumbnailBackend = s3Backend.WithPrefix(os.Getenv("S3_PREFIX") + "thumbnails/")
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q (expected local or s3)", storageBackend)
 	}
 
 	// Connect to database
@@ -80,10 +112,42 @@ func main() {
 	// Initialize database queries
 	queries := database.New(dbPool)
 
+	// `server fsck` checks storage against the database instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		code := runFsck(context.Background(), os.Args[2:], queries, fileBackend, thumbnailBackend)
+		dbPool.Close()
+		os.Exit(code)
+	}
+
 	// Initialize services
 	authService := services.NewAuthService(jwtSecret, sessionDurationHours)
-	storageService := services.NewStorageService(storagePath, thumbnailPath)
-	cleanupService := services.NewCleanupService(queries, dbPool)
+	storageService := services.NewStorageService(fileBackend, thumbnailBackend)
+	blobService := services.NewBlobService(dbPool, storageService)
+	trashService := services.NewTrashService(dbPool, queries, storageService, blobService)
+	cleanupService := services.NewCleanupService(queries, dbPool, storageService, blobService, trashService)
+	retentionService := services.NewRetentionService(queries, blobService)
+	permissionService := services.NewPermissionService(queries)
+	quotaService := services.NewQuotaService(dbPool, queries)
+	jobQueue := services.NewJobQueue(dbPool, queries)
+	previewService := services.NewPreviewService(dbPool, queries, storageService, jobQueue)
+	// Text extraction only runs queued jobs
+	services.NewContentService(queries, storageService, jobQueue)
+	searchService := services.NewSearchService(dbPool, queries)
+	bulkService := services.NewBulkService(dbPool, storageService, blobService)
+	copyService := services.NewCopyService(dbPool, queries, storageService, jobQueue)
+	archiveService := services.NewArchiveService(queries, storageService)
+	pathService := services.NewPathService(dbPool, queries)
+	uploadService := services.NewUploadService(dbPool, queries, storageService, blobService, trashService, jobQueue)
+
+	// Clear what uploads interrupted by a crash left behind before serving
+	recovery, err := uploadService.Recover(context.Background())
+	if err != nil {
+		log.Printf("Warning: upload recovery failed: %v", err)
+	}
+	if recovery.Objects > 0 || recovery.FilesRemoved > 0 || recovery.FilesRolledBack > 0 {
+		log.Printf("🧹 Upload recovery removed %d staged objects and %d unfinished files, rolled back %d files",
+			recovery.Objects, recovery.FilesRemoved, recovery.FilesRolledBack)
+	}
 
 	// Get trash cleanup configuration
 	trashDays, err := strconv.Atoi(os.Getenv("TRASH_CLEANUP_DAYS"))
@@ -92,18 +156,25 @@ func main() {
 	}
 
 	// Initialize WebSocket hub
-	wsHub := services.NewHub(queries)
+	wsHub := services.NewHub(queries, permissionService)
 	go wsHub.Run()
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(queries, authService)
-	filesHandler := handlers.NewFilesHandler(queries, storageService, dbPool)
-	foldersHandler := handlers.NewFoldersHandler(queries)
-	sharingHandler := handlers.NewSharingHandler(queries, authService)
-	versionsHandler := handlers.NewVersionsHandler(queries)
-	activityHandler := handlers.NewActivityHandler(queries)
-	commentHandler := handlers.NewCommentHandler(queries, wsHub)
+	filesHandler := handlers.NewFilesHandler(queries, storageService, uploadService, permissionService, trashService, quotaService, previewService, pathService)
+	foldersHandler := handlers.NewFoldersHandler(queries, permissionService, trashService, pathService)
+	sharingHandler := handlers.NewSharingHandler(queries, authService, permissionService)
+	publicShareHandler := handlers.NewPublicShareHandler(queries, storageService, authService, archiveService, previewService)
+	versionsHandler := handlers.NewVersionsHandler(queries, storageService, uploadService, retentionService, permissionService)
+	activityHandler := handlers.NewActivityHandler(queries, permissionService)
+	commentHandler := handlers.NewCommentHandler(queries, permissionService, wsHub)
 	storageHandler := handlers.NewStorageHandler(queries)
+	adminHandler := handlers.NewAdminHandler(queries, jobQueue)
+	searchHandler := handlers.NewSearchHandler(searchService)
+	bulkHandler := handlers.NewBulkHandler(bulkService)
+	copyHandler := handlers.NewCopyHandler(queries, permissionService, copyService)
+	archiveHandler := handlers.NewArchiveHandler(queries, permissionService, archiveService)
+	pathsHandler := handlers.NewPathsHandler(queries, permissionService, pathService)
 	wsHandler := handlers.NewWebSocketHandler(wsHub)
 
 	// Setup router
@@ -129,6 +200,18 @@ func main() {
 		r.Get("/me", authHandler.Me) // Can work with or without auth
 	})
 
+	// Public share links (a session, if present, only attributes the access)
+	r.Route("/s/{token}", func(r chi.Router) {
+		r.Use(middleware.OptionalAuthMiddleware(queries))
+		r.Get("/", publicShareHandler.GetShare)
+		r.Post("/unlock", publicShareHandler.UnlockShare)
+		r.Get("/browse", publicShareHandler.BrowseShare)
+		r.Get("/download", publicShareHandler.DownloadShare)
+		r.Head("/download", publicShareHandler.DownloadShare)
+		r.Get("/preview", publicShareHandler.PreviewShare)
+		r.Get("/zip", publicShareHandler.DownloadShareArchive)
+	})
+
 	// Protected routes (authentication required)
 	r.Route("/api", func(r chi.Router) {
 		r.Use(middleware.AuthMiddleware(queries))
@@ -139,6 +222,18 @@ func main() {
 		// User search
 		r.Get("/users/search", authHandler.SearchUsers)
 
+		// Search across files and folders
+		r.Get("/search", searchHandler.Search)
+
+		// Bulk changes to files and folders
+		r.Post("/bulk/{action}", bulkHandler.Apply)
+
+		// Lookup of files and folders by path
+		r.Get("/paths/resolve", pathsHandler.ResolvePath)
+
+		// Archives of selected files and folders
+		r.Post("/archive", archiveHandler.DownloadSelection)
+
 		// File routes
 		r.Route("/files", func(r chi.Router) {
 			r.Get("/", filesHandler.GetFiles)
@@ -146,10 +241,11 @@ func main() {
 			r.Get("/recent", filesHandler.GetRecentFiles)
 			r.Get("/starred", filesHandler.GetStarredFiles)
 			r.Get("/trash", filesHandler.GetTrashedFiles)
-			r.Get("/search", filesHandler.SearchFiles)
+			r.Get("/search", searchHandler.SearchFiles)
 
 			r.Route("/{id}", func(r chi.Router) {
 				r.Get("/download", filesHandler.DownloadFile)
+				r.Head("/download", filesHandler.DownloadFile)
 				r.Get("/thumbnail", filesHandler.GetThumbnail)
 				r.Delete("/", filesHandler.DeleteFile)
 				r.Post("/restore", filesHandler.RestoreFile)
@@ -157,6 +253,19 @@ func main() {
 				r.Post("/star", filesHandler.ToggleStar)
 				r.Put("/rename", filesHandler.RenameFile)
 				r.Put("/move", filesHandler.MoveFile)
+				r.Post("/copy", copyHandler.CopyFile)
+				r.Get("/ancestors", pathsHandler.FileAncestors)
+			})
+		})
+
+		// Resumable upload routes
+		r.Route("/uploads", func(r chi.Router) {
+			r.Post("/", filesHandler.CreateUploadSession)
+			r.Route("/{id}", func(r chi.Router) {
+				r.Get("/", filesHandler.GetUploadSession)
+				r.Put("/", filesHandler.UploadChunk)
+				r.Post("/complete", filesHandler.CompleteUpload)
+				r.Delete("/", filesHandler.AbortUpload)
 			})
 		})
 
@@ -167,10 +276,15 @@ func main() {
 			r.Get("/root", foldersHandler.GetRootFolder)
 			r.Get("/starred", foldersHandler.GetStarredFolders)
 			r.Get("/trash", foldersHandler.GetTrashedFolders)
+			r.Get("/tree", pathsHandler.RootTree)
 			r.Route("/{id}", func(r chi.Router) {
 				r.Get("/", foldersHandler.GetFolderByIDHandler)
 				r.Put("/rename", foldersHandler.RenameFolder)
 				r.Put("/move", foldersHandler.MoveFolder)
+				r.Post("/copy", copyHandler.CopyFolder)
+				r.Get("/archive", archiveHandler.DownloadFolder)
+				r.Get("/ancestors", pathsHandler.FolderAncestors)
+				r.Get("/tree", pathsHandler.FolderTree)
 				r.Post("/star", foldersHandler.ToggleStarFolder)
 				r.Delete("/", foldersHandler.DeleteFolder)
 				r.Post("/restore", foldersHandler.RestoreFolder)
@@ -186,13 +300,26 @@ func main() {
 			r.Post("/link", sharingHandler.CreateShareLink)
 			r.Get("/links", sharingHandler.GetShareLinks)
 			r.Delete("/link/{id}", sharingHandler.DeactivateShareLink)
+			r.Get("/link/{id}/access", sharingHandler.GetShareLinkAccess)
 			r.Get("/shared-with-me", sharingHandler.GetSharedWithMe)
 		})
 
 		// Version history routes
 		r.Route("/versions", func(r chi.Router) {
 			r.Get("/file/{fileId}", versionsHandler.GetFileVersions)
+			r.Get("/file/{fileId}/retention", versionsHandler.GetFileRetentionPolicy)
+			r.Put("/file/{fileId}/retention", versionsHandler.SetFileRetentionPolicy)
+			r.Delete("/file/{fileId}/retention", versionsHandler.DeleteFileRetentionPolicy)
+			r.Get("/retention", versionsHandler.GetRetentionPolicy)
+			r.Put("/retention", versionsHandler.SetRetentionPolicy)
+			r.Delete("/retention", versionsHandler.DeleteRetentionPolicy)
+			r.Get("/retention/preview", versionsHandler.PreviewRetention)
 			r.Get("/{versionId}", versionsHandler.GetFileVersion)
+			r.Get("/{versionId}/download", versionsHandler.DownloadVersion)
+			r.Head("/{versionId}/download", versionsHandler.DownloadVersion)
+			r.Post("/{versionId}/restore", versionsHandler.RestoreVersion)
+			r.Post("/{versionId}/pin", versionsHandler.PinVersion)
+			r.Post("/{versionId}/unpin", versionsHandler.UnpinVersion)
 		})
 
 		// Activity routes
@@ -213,17 +340,46 @@ func main() {
 
 		// Storage analytics routes
 		r.Get("/storage/analytics", storageHandler.GetStorageAnalytics)
+
+		// Admin routes
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(middleware.AdminMiddleware)
+			r.Put("/users/{id}/storage-limit", adminHandler.SetStorageLimit)
+			r.Get("/jobs", adminHandler.ListJobs)
+			r.Get("/jobs/summary", adminHandler.JobSummary)
+			r.Post("/jobs/{id}/retry", adminHandler.RetryJob)
+		})
 	})
 
-	// Start cleanup scheduler (runs daily to permanently delete files in trash older than specified days)
+	// Schedule trash, upload and session cleanup (trash older than the
+	// specified days is permanently deleted daily)
+	if err := cleanupService.RegisterJobs(jobQueue, int32(trashDays)); err != nil {
+		log.Fatalf("Failed to schedule cleanup: %v", err)
+	}
+	// Schedule version retention (prunes versions outside their retention policy)
+	if err := retentionService.RegisterJobs(jobQueue); err != nil {
+		log.Fatalf("Failed to schedule version retention: %v", err)
+	}
+
+	// Start job workers (previews, cleanup, retention and other background work)
 	ctx := context.Background()
-	cleanupService.StartCleanupScheduler(ctx, int32(trashDays), 24*time.Hour)
-	log.Printf("🧹 Trash cleanup scheduler started (deletes files older than %d days)", trashDays)
+	jobWorkers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || jobWorkers < 1 {
+		jobWorkers = 4 // Default 4 workers
+	}
+	if err := jobQueue.Start(ctx, jobWorkers); err != nil {
+		log.Fatalf("Failed to start job queue: %v", err)
+	}
+	log.Printf("⚙️  Job workers started (%d, trash cleanup after %d days)", jobWorkers, trashDays)
 
 	// Start server
 	addr := fmt.Sprintf(":%s", port)
 	log.Printf("🚀 Server starting on http://localhost%s", addr)
-	log.Printf("📁 Storage path: %s", storagePath)
+	if storageBackend == "local" {
+		log.Printf("📁 Storage path: %s", storagePath)
+	} else {
+		log.Printf("📁 Storage backend: %s (bucket %s)", storageBackend, os.Getenv("S3_BUCKET"))
+	}
 	log.Printf("🔒 CORS enabled for: http://localhost:1573")
 
 	if err := http.ListenAndServe(addr, r); err != nil {
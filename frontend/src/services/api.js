@@ -73,11 +73,12 @@ export const filesAPI = {
   },
 
   uploadFile: async (file, folderId = '') => {
+    // The server reads the fields before the file
     const formData = new FormData();
-    formData.append('file', file);
     if (folderId) {
       formData.append('folder_id', folderId);
     }
+    formData.append('file', file);
 
     const response = await api.post('/files/upload', formData, {
       headers: {
@@ -64,7 +64,7 @@ func (q *Queries) DeleteUserSessions(ctx context.Context, userID pgtype.UUID) er
 }
 
 const getSessionByToken = `-- name: GetSessionByToken :one
-SELECT s.id, s.user_id, s.token, s.expires_at, s.created_at, u.email, u.name
+SELECT s.id, s.user_id, s.token, s.expires_at, s.created_at, u.email, u.name, u.is_admin
 FROM sessions s
 JOIN users u ON s.user_id = u.id
 WHERE s.token = $1 AND s.expires_at > NOW()
@@ -78,6 +78,7 @@ type GetSessionByTokenRow struct {
 	CreatedAt pgtype.Timestamp `json:"created_at"`
 	Email     string           `json:"email"`
 	Name      string           `json:"name"`
+	IsAdmin   bool             `json:"is_admin"`
 }
 
 func (q *Queries) GetSessionByToken(ctx context.Context, token string) (GetSessionByTokenRow, error) {
@@ -91,6 +92,7 @@ func (q *Queries) GetSessionByToken(ctx context.Context, token string) (GetSessi
 		&i.CreatedAt,
 		&i.Email,
 		&i.Name,
+		&i.IsAdmin,
 	)
 	return i, err
 }
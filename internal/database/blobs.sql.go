@@ -0,0 +1,148 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: blobs.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const deleteBlobRef = `-- name: DeleteBlobRef :exec
+DELETE FROM blob_refs
+WHERE digest = $1 AND owner_id = $2 AND ref_count = 0
+`
+
+type DeleteBlobRefParams struct {
+	Digest  string      `json:"digest"`
+	OwnerID pgtype.UUID `json:"owner_id"`
+}
+
+func (q *Queries) DeleteBlobRef(ctx context.Context, arg DeleteBlobRefParams) error {
+	_, err := q.db.Exec(ctx, deleteBlobRef, arg.Digest, arg.OwnerID)
+	return err
+}
+
+const deleteUnreferencedBlob = `-- name: DeleteUnreferencedBlob :execrows
+DELETE FROM blobs b
+WHERE b.digest = $1
+  AND NOT EXISTS (SELECT 1 FROM blob_refs r WHERE r.digest = b.digest)
+  AND NOT EXISTS (SELECT 1 FROM file_versions v WHERE v.blob_digest = b.digest)
+`
+
+func (q *Queries) DeleteUnreferencedBlob(ctx context.Context, digest string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUnreferencedBlob, digest)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getBlob = `-- name: GetBlob :one
+SELECT digest, size, created_at FROM blobs WHERE digest = $1
+`
+
+func (q *Queries) GetBlob(ctx context.Context, digest string) (Blob, error) {
+	row := q.db.QueryRow(ctx, getBlob, digest)
+	var i Blob
+	err := row.Scan(&i.Digest, &i.Size, &i.CreatedAt)
+	return i, err
+}
+
+const getUnreferencedBlobBytes = `-- name: GetUnreferencedBlobBytes :one
+SELECT COALESCE(SUM(b.size), 0)::bigint AS bytes
+FROM blobs b
+WHERE b.digest = ANY($1::text[])
+  AND NOT EXISTS (
+      SELECT 1 FROM blob_refs r
+      WHERE r.digest = b.digest AND r.owner_id = $2::uuid
+  )
+`
+
+type GetUnreferencedBlobBytesParams struct {
+	Digests []string    `json:"digests"`
+	OwnerID pgtype.UUID `json:"owner_id"`
+}
+
+// Sums the sizes of the given blobs that owner_id holds no reference on,
+// which is what taking a reference on each would charge the owner
+func (q *Queries) GetUnreferencedBlobBytes(ctx context.Context, arg GetUnreferencedBlobBytesParams) (int64, error) {
+	row := q.db.QueryRow(ctx, getUnreferencedBlobBytes, arg.Digests, arg.OwnerID)
+	var bytes int64
+	err := row.Scan(&bytes)
+	return bytes, err
+}
+
+const lockBlob = `-- name: LockBlob :one
+SELECT digest, size, created_at FROM blobs
+WHERE digest = $1
+FOR UPDATE
+`
+
+func (q *Queries) LockBlob(ctx context.Context, digest string) (Blob, error) {
+	row := q.db.QueryRow(ctx, lockBlob, digest)
+	var i Blob
+	err := row.Scan(&i.Digest, &i.Size, &i.CreatedAt)
+	return i, err
+}
+
+const releaseBlobRef = `-- name: ReleaseBlobRef :one
+UPDATE blob_refs
+SET ref_count = ref_count - 1
+WHERE digest = $1 AND owner_id = $2 AND ref_count > 0
+RETURNING ref_count
+`
+
+type ReleaseBlobRefParams struct {
+	Digest  string      `json:"digest"`
+	OwnerID pgtype.UUID `json:"owner_id"`
+}
+
+func (q *Queries) ReleaseBlobRef(ctx context.Context, arg ReleaseBlobRefParams) (int32, error) {
+	row := q.db.QueryRow(ctx, releaseBlobRef, arg.Digest, arg.OwnerID)
+	var ref_count int32
+	err := row.Scan(&ref_count)
+	return ref_count, err
+}
+
+const retainBlobRef = `-- name: RetainBlobRef :one
+INSERT INTO blob_refs (digest, owner_id, ref_count)
+VALUES ($1, $2, 1)
+ON CONFLICT (digest, owner_id) DO UPDATE SET ref_count = blob_refs.ref_count + 1
+RETURNING ref_count
+`
+
+type RetainBlobRefParams struct {
+	Digest  string      `json:"digest"`
+	OwnerID pgtype.UUID `json:"owner_id"`
+}
+
+func (q *Queries) RetainBlobRef(ctx context.Context, arg RetainBlobRefParams) (int32, error) {
+	row := q.db.QueryRow(ctx, retainBlobRef, arg.Digest, arg.OwnerID)
+	var ref_count int32
+	err := row.Scan(&ref_count)
+	return ref_count, err
+}
+
+const upsertBlob = `-- name: UpsertBlob :one
+INSERT INTO blobs (digest, size)
+VALUES ($1, $2)
+ON CONFLICT (digest) DO UPDATE SET size = EXCLUDED.size
+RETURNING digest, size, created_at
+`
+
+type UpsertBlobParams struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// Inserts the blob row or locks the existing one for the rest of the transaction
+func (q *Queries) UpsertBlob(ctx context.Context, arg UpsertBlobParams) (Blob, error) {
+	row := q.db.QueryRow(ctx, upsertBlob, arg.Digest, arg.Size)
+	var i Blob
+	err := row.Scan(&i.Digest, &i.Size, &i.CreatedAt)
+	return i, err
+}
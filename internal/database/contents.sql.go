@@ -0,0 +1,89 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: contents.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const deleteFileContent = `-- name: DeleteFileContent :exec
+DELETE FROM file_contents WHERE file_id = $1
+`
+
+func (q *Queries) DeleteFileContent(ctx context.Context, fileID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteFileContent, fileID)
+	return err
+}
+
+const getContentSnippets = `-- name: GetContentSnippets :many
+SELECT c.file_id,
+       ts_headline('english', c.content, websearch_to_tsquery('english', $1::text), $2::text)::text AS snippet
+FROM file_contents c
+WHERE c.file_id = ANY($3::uuid[])
+  AND c.search_vector @@ websearch_to_tsquery('english', $1::text)
+`
+
+type GetContentSnippetsParams struct {
+	Query   string        `json:"query"`
+	Options string        `json:"options"`
+	FileIds []pgtype.UUID `json:"file_ids"`
+}
+
+type GetContentSnippetsRow struct {
+	FileID  pgtype.UUID `json:"file_id"`
+	Snippet string      `json:"snippet"`
+}
+
+// Excerpts of the files' text around the words of a websearch query, for
+// files whose text matches it
+func (q *Queries) GetContentSnippets(ctx context.Context, arg GetContentSnippetsParams) ([]GetContentSnippetsRow, error) {
+	rows, err := q.db.Query(ctx, getContentSnippets, arg.Query, arg.Options, arg.FileIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetContentSnippetsRow{}
+	for rows.Next() {
+		var i GetContentSnippetsRow
+		if err := rows.Scan(&i.FileID, &i.Snippet); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setFileContent = `-- name: SetFileContent :execrows
+INSERT INTO file_contents (file_id, version, content)
+SELECT f.id, $1::int, $2::text
+FROM files f
+WHERE f.id = $3 AND COALESCE(f.version, 1) = $1::int
+ON CONFLICT (file_id) DO UPDATE
+SET version = EXCLUDED.version,
+    content = EXCLUDED.content,
+    extracted_at = NOW()
+`
+
+type SetFileContentParams struct {
+	Version int32       `json:"version"`
+	Content string      `json:"content"`
+	FileID  pgtype.UUID `json:"file_id"`
+}
+
+// Stores the text extracted from a version of a file. Affects no rows once
+// the file has moved on to another version.
+func (q *Queries) SetFileContent(ctx context.Context, arg SetFileContentParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setFileContent, arg.Version, arg.Content, arg.FileID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
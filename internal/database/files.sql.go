@@ -17,7 +17,7 @@ INSERT INTO files (
     owner_id, parent_folder_id, preview_available, thumbnail_path
 )
 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-RETURNING id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at
+RETURNING id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at
 `
 
 type CreateFileParams struct {
@@ -64,12 +64,50 @@ func (q *Queries) CreateFile(ctx context.Context, arg CreateFileParams) (File, e
 		&i.UpdatedAt,
 		&i.TrashedAt,
 		&i.LastAccessedAt,
+		&i.TrashOperationID,
+		&i.BrokenAt,
 	)
 	return i, err
 }
 
+const getChildFileNames = `-- name: GetChildFileNames :many
+SELECT name FROM files
+WHERE status = 'active'
+  AND (parent_folder_id = $1::uuid
+       OR ($1::uuid IS NULL AND parent_folder_id IS NULL AND owner_id = $2::uuid))
+  AND ($3::uuid IS NULL OR id <> $3::uuid)
+`
+
+type GetChildFileNamesParams struct {
+	FolderID  pgtype.UUID `json:"folder_id"`
+	OwnerID   pgtype.UUID `json:"owner_id"`
+	ExcludeID pgtype.UUID `json:"exclude_id"`
+}
+
+// Lists the names of the active files directly in folder_id, or at the top of
+// owner_id's drive when folder_id is null, leaving out exclude_id
+func (q *Queries) GetChildFileNames(ctx context.Context, arg GetChildFileNamesParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, getChildFileNames, arg.FolderID, arg.OwnerID, arg.ExcludeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getFileByID = `-- name: GetFileByID :one
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files WHERE id = $1 AND status = 'active'
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files WHERE id = $1 AND status = 'active'
 `
 
 func (q *Queries) GetFileByID(ctx context.Context, id pgtype.UUID) (File, error) {
@@ -94,12 +132,14 @@ func (q *Queries) GetFileByID(ctx context.Context, id pgtype.UUID) (File, error)
 		&i.UpdatedAt,
 		&i.TrashedAt,
 		&i.LastAccessedAt,
+		&i.TrashOperationID,
+		&i.BrokenAt,
 	)
 	return i, err
 }
 
 const getFileByIDAnyStatus = `-- name: GetFileByIDAnyStatus :one
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files WHERE id = $1
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files WHERE id = $1
 `
 
 func (q *Queries) GetFileByIDAnyStatus(ctx context.Context, id pgtype.UUID) (File, error) {
@@ -124,15 +164,16 @@ func (q *Queries) GetFileByIDAnyStatus(ctx context.Context, id pgtype.UUID) (Fil
 		&i.UpdatedAt,
 		&i.TrashedAt,
 		&i.LastAccessedAt,
+		&i.TrashOperationID,
+		&i.BrokenAt,
 	)
 	return i, err
 }
 
 const getFileByNameAndFolder = `-- name: GetFileByNameAndFolder :one
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
-WHERE owner_id = $1
-  AND name = $2
-  AND (parent_folder_id = $3 OR (parent_folder_id IS NULL AND $3 IS NULL))
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
+WHERE name = $2
+  AND (parent_folder_id = $3 OR (parent_folder_id IS NULL AND $3 IS NULL AND owner_id = $1))
   AND status = 'active'
 LIMIT 1
 `
@@ -143,6 +184,8 @@ type GetFileByNameAndFolderParams struct {
 	ParentFolderID pgtype.UUID `json:"parent_folder_id"`
 }
 
+// Files in a folder may belong to other users; owner_id only scopes the top
+// of a drive
 func (q *Queries) GetFileByNameAndFolder(ctx context.Context, arg GetFileByNameAndFolderParams) (File, error) {
 	row := q.db.QueryRow(ctx, getFileByNameAndFolder, arg.OwnerID, arg.Name, arg.ParentFolderID)
 	var i File
@@ -165,25 +208,67 @@ func (q *Queries) GetFileByNameAndFolder(ctx context.Context, arg GetFileByNameA
 		&i.UpdatedAt,
 		&i.TrashedAt,
 		&i.LastAccessedAt,
+		&i.TrashOperationID,
+		&i.BrokenAt,
 	)
 	return i, err
 }
 
 const getFilesByFolder = `-- name: GetFilesByFolder :many
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
-WHERE owner_id = $1
-  AND parent_folder_id = $2
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
+WHERE parent_folder_id = $1
   AND status = 'active'
 ORDER BY created_at DESC
 `
 
-type GetFilesByFolderParams struct {
-	OwnerID        pgtype.UUID `json:"owner_id"`
-	ParentFolderID pgtype.UUID `json:"parent_folder_id"`
+// Lists every file in the folder whoever owns it; callers check access to the folder
+func (q *Queries) GetFilesByFolder(ctx context.Context, parentFolderID pgtype.UUID) ([]File, error) {
+	rows, err := q.db.Query(ctx, getFilesByFolder, parentFolderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []File{}
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.OriginalName,
+			&i.MimeType,
+			&i.Size,
+			&i.StoragePath,
+			&i.OwnerID,
+			&i.ParentFolderID,
+			&i.Status,
+			&i.IsStarred,
+			&i.ThumbnailPath,
+			&i.PreviewAvailable,
+			&i.Version,
+			&i.CurrentVersionID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashedAt,
+			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func (q *Queries) GetFilesByFolder(ctx context.Context, arg GetFilesByFolderParams) ([]File, error) {
-	rows, err := q.db.Query(ctx, getFilesByFolder, arg.OwnerID, arg.ParentFolderID)
+const getFilesByIDs = `-- name: GetFilesByIDs :many
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) GetFilesByIDs(ctx context.Context, ids []pgtype.UUID) ([]File, error) {
+	rows, err := q.db.Query(ctx, getFilesByIDs, ids)
 	if err != nil {
 		return nil, err
 	}
@@ -210,6 +295,8 @@ func (q *Queries) GetFilesByFolder(ctx context.Context, arg GetFilesByFolderPara
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -222,7 +309,7 @@ func (q *Queries) GetFilesByFolder(ctx context.Context, arg GetFilesByFolderPara
 }
 
 const getFilesByOwner = `-- name: GetFilesByOwner :many
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
 WHERE owner_id = $1 AND status = 'active'
 ORDER BY updated_at DESC
 LIMIT $2 OFFSET $3
@@ -262,6 +349,8 @@ func (q *Queries) GetFilesByOwner(ctx context.Context, arg GetFilesByOwnerParams
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -274,7 +363,7 @@ func (q *Queries) GetFilesByOwner(ctx context.Context, arg GetFilesByOwnerParams
 }
 
 const getFilesInTrashOlderThan = `-- name: GetFilesInTrashOlderThan :many
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
 WHERE status = 'trashed'
   AND trashed_at < NOW() - INTERVAL '1 day' * $1
 ORDER BY trashed_at ASC
@@ -308,6 +397,8 @@ func (q *Queries) GetFilesInTrashOlderThan(ctx context.Context, dollar_1 interfa
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -320,7 +411,7 @@ func (q *Queries) GetFilesInTrashOlderThan(ctx context.Context, dollar_1 interfa
 }
 
 const getRecentFiles = `-- name: GetRecentFiles :many
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
 WHERE owner_id = $1 AND status = 'active'
 ORDER BY last_accessed_at DESC NULLS LAST
 LIMIT $2
@@ -359,6 +450,8 @@ func (q *Queries) GetRecentFiles(ctx context.Context, arg GetRecentFilesParams)
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -370,8 +463,27 @@ func (q *Queries) GetRecentFiles(ctx context.Context, arg GetRecentFilesParams)
 	return items, nil
 }
 
+const getRootFileStats = `-- name: GetRootFileStats :one
+SELECT COUNT(*)::bigint AS file_count, COALESCE(SUM(size), 0)::bigint AS size
+FROM files
+WHERE owner_id = $1 AND parent_folder_id IS NULL AND status = 'active'
+`
+
+type GetRootFileStatsRow struct {
+	FileCount int64 `json:"file_count"`
+	Size      int64 `json:"size"`
+}
+
+// Counts the active files at the top of owner_id's drive and their total size
+func (q *Queries) GetRootFileStats(ctx context.Context, ownerID pgtype.UUID) (GetRootFileStatsRow, error) {
+	row := q.db.QueryRow(ctx, getRootFileStats, ownerID)
+	var i GetRootFileStatsRow
+	err := row.Scan(&i.FileCount, &i.Size)
+	return i, err
+}
+
 const getRootFiles = `-- name: GetRootFiles :many
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
 WHERE owner_id = $1
   AND parent_folder_id IS NULL
   AND status = 'active'
@@ -406,6 +518,8 @@ func (q *Queries) GetRootFiles(ctx context.Context, ownerID pgtype.UUID) ([]File
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -418,7 +532,7 @@ func (q *Queries) GetRootFiles(ctx context.Context, ownerID pgtype.UUID) ([]File
 }
 
 const getStarredFiles = `-- name: GetStarredFiles :many
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
 WHERE owner_id = $1 AND is_starred = TRUE AND status = 'active'
 ORDER BY updated_at DESC
 `
@@ -451,6 +565,8 @@ func (q *Queries) GetStarredFiles(ctx context.Context, ownerID pgtype.UUID) ([]F
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -463,11 +579,16 @@ func (q *Queries) GetStarredFiles(ctx context.Context, ownerID pgtype.UUID) ([]F
 }
 
 const getTrashedFiles = `-- name: GetTrashedFiles :many
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
-WHERE owner_id = $1 AND status = 'trashed'
-ORDER BY trashed_at DESC
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files f
+WHERE f.owner_id = $1 AND f.status = 'trashed'
+  AND NOT EXISTS (
+      SELECT 1 FROM folders p
+      WHERE p.id = f.parent_folder_id AND p.trash_operation_id = f.trash_operation_id
+  )
+ORDER BY f.trashed_at DESC
 `
 
+// Files trashed together with their folder are listed through the folder
 func (q *Queries) GetTrashedFiles(ctx context.Context, ownerID pgtype.UUID) ([]File, error) {
 	rows, err := q.db.Query(ctx, getTrashedFiles, ownerID)
 	if err != nil {
@@ -496,6 +617,8 @@ func (q *Queries) GetTrashedFiles(ctx context.Context, ownerID pgtype.UUID) ([]F
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -507,28 +630,67 @@ func (q *Queries) GetTrashedFiles(ctx context.Context, ownerID pgtype.UUID) ([]F
 	return items, nil
 }
 
+const lockFile = `-- name: LockFile :one
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) LockFile(ctx context.Context, id pgtype.UUID) (File, error) {
+	row := q.db.QueryRow(ctx, lockFile, id)
+	var i File
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.OriginalName,
+		&i.MimeType,
+		&i.Size,
+		&i.StoragePath,
+		&i.OwnerID,
+		&i.ParentFolderID,
+		&i.Status,
+		&i.IsStarred,
+		&i.ThumbnailPath,
+		&i.PreviewAvailable,
+		&i.Version,
+		&i.CurrentVersionID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TrashedAt,
+		&i.LastAccessedAt,
+		&i.TrashOperationID,
+		&i.BrokenAt,
+	)
+	return i, err
+}
+
 const moveFile = `-- name: MoveFile :exec
 UPDATE files
-SET parent_folder_id = $2, updated_at = NOW()
+SET parent_folder_id = $2, name = $3, updated_at = NOW()
 WHERE id = $1
 `
 
 type MoveFileParams struct {
 	ID             pgtype.UUID `json:"id"`
 	ParentFolderID pgtype.UUID `json:"parent_folder_id"`
+	Name           string      `json:"name"`
 }
 
 func (q *Queries) MoveFile(ctx context.Context, arg MoveFileParams) error {
-	_, err := q.db.Exec(ctx, moveFile, arg.ID, arg.ParentFolderID)
+	_, err := q.db.Exec(ctx, moveFile, arg.ID, arg.ParentFolderID, arg.Name)
 	return err
 }
 
 const permanentDeleteFile = `-- name: PermanentDeleteFile :exec
-UPDATE files
-SET status = 'deleted'
-WHERE id = $1
+WITH revoked AS (
+    DELETE FROM permissions p WHERE p.item_type = 'file' AND p.item_id = $1::uuid
+), unlinked AS (
+    DELETE FROM shares s WHERE s.item_type = 'file' AND s.item_id = $1::uuid
+)
+DELETE FROM files f
+WHERE f.id = $1::uuid
 `
 
+// Removes the file row along with the grants and share links that point at it.
+// Versions, comments and activity go with the row through their foreign keys.
 func (q *Queries) PermanentDeleteFile(ctx context.Context, id pgtype.UUID) error {
 	_, err := q.db.Exec(ctx, permanentDeleteFile, id)
 	return err
@@ -552,17 +714,23 @@ func (q *Queries) RenameFile(ctx context.Context, arg RenameFileParams) error {
 
 const restoreFile = `-- name: RestoreFile :exec
 UPDATE files
-SET status = 'active', trashed_at = NULL
+SET status = 'active', trashed_at = NULL, trash_operation_id = NULL, parent_folder_id = $2, name = $3
 WHERE id = $1
 `
 
-func (q *Queries) RestoreFile(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, restoreFile, id)
+type RestoreFileParams struct {
+	ID             pgtype.UUID `json:"id"`
+	ParentFolderID pgtype.UUID `json:"parent_folder_id"`
+	Name           string      `json:"name"`
+}
+
+func (q *Queries) RestoreFile(ctx context.Context, arg RestoreFileParams) error {
+	_, err := q.db.Exec(ctx, restoreFile, arg.ID, arg.ParentFolderID, arg.Name)
 	return err
 }
 
 const searchFilesByName = `-- name: SearchFilesByName :many
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
 WHERE owner_id = $1
   AND status = 'active'
   AND to_tsvector('english', name) @@ plainto_tsquery('english', $2)
@@ -604,6 +772,8 @@ func (q *Queries) SearchFilesByName(ctx context.Context, arg SearchFilesByNamePa
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -616,7 +786,7 @@ func (q *Queries) SearchFilesByName(ctx context.Context, arg SearchFilesByNamePa
 }
 
 const searchFilesByType = `-- name: SearchFilesByType :many
-SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at FROM files
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
 WHERE owner_id = $1
   AND status = 'active'
   AND mime_type LIKE $2 || '%'
@@ -656,6 +826,8 @@ func (q *Queries) SearchFilesByType(ctx context.Context, arg SearchFilesByTypePa
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -667,6 +839,22 @@ func (q *Queries) SearchFilesByType(ctx context.Context, arg SearchFilesByTypePa
 	return items, nil
 }
 
+const setFileStarred = `-- name: SetFileStarred :exec
+UPDATE files
+SET is_starred = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetFileStarredParams struct {
+	ID        pgtype.UUID `json:"id"`
+	IsStarred pgtype.Bool `json:"is_starred"`
+}
+
+func (q *Queries) SetFileStarred(ctx context.Context, arg SetFileStarredParams) error {
+	_, err := q.db.Exec(ctx, setFileStarred, arg.ID, arg.IsStarred)
+	return err
+}
+
 const toggleStarFile = `-- name: ToggleStarFile :exec
 UPDATE files
 SET is_starred = NOT is_starred, updated_at = NOW()
@@ -680,12 +868,17 @@ func (q *Queries) ToggleStarFile(ctx context.Context, id pgtype.UUID) error {
 
 const trashFile = `-- name: TrashFile :exec
 UPDATE files
-SET status = 'trashed', trashed_at = NOW()
+SET status = 'trashed', trashed_at = NOW(), trash_operation_id = $2
 WHERE id = $1
 `
 
-func (q *Queries) TrashFile(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, trashFile, id)
+type TrashFileParams struct {
+	ID               pgtype.UUID `json:"id"`
+	TrashOperationID pgtype.UUID `json:"trash_operation_id"`
+}
+
+func (q *Queries) TrashFile(ctx context.Context, arg TrashFileParams) error {
+	_, err := q.db.Exec(ctx, trashFile, arg.ID, arg.TrashOperationID)
 	return err
 }
 
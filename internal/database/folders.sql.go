@@ -14,7 +14,7 @@ import (
 const createFolder = `-- name: CreateFolder :one
 INSERT INTO folders (name, owner_id, parent_folder_id, is_root)
 VALUES ($1, $2, $3, $4)
-RETURNING id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at
+RETURNING id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id
 `
 
 type CreateFolderParams struct {
@@ -43,12 +43,243 @@ func (q *Queries) CreateFolder(ctx context.Context, arg CreateFolderParams) (Fol
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.TrashedAt,
+		&i.TrashOperationID,
 	)
 	return i, err
 }
 
+const getActiveSubtreeFiles = `-- name: GetActiveSubtreeFiles :many
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = $1::uuid AND f.status = 'active'
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.status = 'active'
+)
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
+WHERE parent_folder_id IN (SELECT id FROM tree) AND status = 'active'
+ORDER BY name
+`
+
+// Lists the active files in folder_id and in every active folder below it
+func (q *Queries) GetActiveSubtreeFiles(ctx context.Context, folderID pgtype.UUID) ([]File, error) {
+	rows, err := q.db.Query(ctx, getActiveSubtreeFiles, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []File{}
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.OriginalName,
+			&i.MimeType,
+			&i.Size,
+			&i.StoragePath,
+			&i.OwnerID,
+			&i.ParentFolderID,
+			&i.Status,
+			&i.IsStarred,
+			&i.ThumbnailPath,
+			&i.PreviewAvailable,
+			&i.Version,
+			&i.CurrentVersionID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashedAt,
+			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getActiveSubtreeFolders = `-- name: GetActiveSubtreeFolders :many
+WITH RECURSIVE tree (id, depth, ids) AS (
+    SELECT f.id, 0, ARRAY[f.id]
+    FROM folders f
+    WHERE f.id = $1::uuid AND f.status = 'active'
+    UNION ALL
+    SELECT child.id, tree.depth + 1, tree.ids || child.id
+    FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.status = 'active' AND child.id <> ALL(tree.ids)
+)
+SELECT f.id, f.name, f.owner_id, f.parent_folder_id, f.is_root, f.status, f.is_starred, f.created_at, f.updated_at, f.trashed_at, f.trash_operation_id
+FROM folders f
+JOIN tree ON f.id = tree.id
+ORDER BY tree.depth, f.name
+`
+
+// Lists folder_id and every active folder below it, parents before children.
+// ids stops the walk if folders form a cycle.
+func (q *Queries) GetActiveSubtreeFolders(ctx context.Context, folderID pgtype.UUID) ([]Folder, error) {
+	rows, err := q.db.Query(ctx, getActiveSubtreeFolders, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Folder{}
+	for rows.Next() {
+		var i Folder
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.OwnerID,
+			&i.ParentFolderID,
+			&i.IsRoot,
+			&i.Status,
+			&i.IsStarred,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashedAt,
+			&i.TrashOperationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChildFolderByName = `-- name: GetChildFolderByName :one
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders
+WHERE status = 'active' AND is_root IS NOT TRUE AND name = $1
+  AND (parent_folder_id = $2::uuid
+       OR ($2::uuid IS NULL AND parent_folder_id IS NULL AND owner_id = $3::uuid))
+ORDER BY created_at
+LIMIT 1
+`
+
+type GetChildFolderByNameParams struct {
+	Name     string      `json:"name"`
+	FolderID pgtype.UUID `json:"folder_id"`
+	OwnerID  pgtype.UUID `json:"owner_id"`
+}
+
+// Finds the oldest active folder called name directly in folder_id, or at the
+// top of owner_id's drive when folder_id is null
+func (q *Queries) GetChildFolderByName(ctx context.Context, arg GetChildFolderByNameParams) (Folder, error) {
+	row := q.db.QueryRow(ctx, getChildFolderByName, arg.Name, arg.FolderID, arg.OwnerID)
+	var i Folder
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.OwnerID,
+		&i.ParentFolderID,
+		&i.IsRoot,
+		&i.Status,
+		&i.IsStarred,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TrashedAt,
+		&i.TrashOperationID,
+	)
+	return i, err
+}
+
+const getChildFolderNames = `-- name: GetChildFolderNames :many
+SELECT name FROM folders
+WHERE status = 'active' AND is_root IS NOT TRUE
+  AND (parent_folder_id = $1::uuid
+       OR ($1::uuid IS NULL AND parent_folder_id IS NULL AND owner_id = $2::uuid))
+  AND ($3::uuid IS NULL OR id <> $3::uuid)
+`
+
+type GetChildFolderNamesParams struct {
+	FolderID  pgtype.UUID `json:"folder_id"`
+	OwnerID   pgtype.UUID `json:"owner_id"`
+	ExcludeID pgtype.UUID `json:"exclude_id"`
+}
+
+// Lists the names of the active folders directly in folder_id, or at the top
+// of owner_id's drive when folder_id is null, leaving out exclude_id
+func (q *Queries) GetChildFolderNames(ctx context.Context, arg GetChildFolderNamesParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, getChildFolderNames, arg.FolderID, arg.OwnerID, arg.ExcludeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFolderAncestors = `-- name: GetFolderAncestors :many
+WITH RECURSIVE chain (id, parent_folder_id, depth, ids) AS (
+    SELECT f.id, f.parent_folder_id, 0, ARRAY[f.id]
+    FROM folders f
+    WHERE f.id = $1::uuid
+    UNION ALL
+    SELECT parent.id, parent.parent_folder_id, chain.depth + 1, chain.ids || parent.id
+    FROM folders parent
+    JOIN chain ON parent.id = chain.parent_folder_id
+    WHERE parent.id <> ALL(chain.ids)
+)
+SELECT f.id, f.name, f.owner_id, f.parent_folder_id, f.is_root, f.status, f.is_starred, f.created_at, f.updated_at, f.trashed_at, f.trash_operation_id
+FROM folders f
+JOIN chain ON f.id = chain.id
+ORDER BY chain.depth DESC
+`
+
+// Lists folder_id and every folder above it, top-most first, whatever their
+// status. ids stops the walk if folders form a cycle.
+func (q *Queries) GetFolderAncestors(ctx context.Context, folderID pgtype.UUID) ([]Folder, error) {
+	rows, err := q.db.Query(ctx, getFolderAncestors, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Folder{}
+	for rows.Next() {
+		var i Folder
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.OwnerID,
+			&i.ParentFolderID,
+			&i.IsRoot,
+			&i.Status,
+			&i.IsStarred,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashedAt,
+			&i.TrashOperationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getFolderByID = `-- name: GetFolderByID :one
-SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at FROM folders WHERE id = $1 AND status = 'active'
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders WHERE id = $1 AND status = 'active'
 `
 
 func (q *Queries) GetFolderByID(ctx context.Context, id pgtype.UUID) (Folder, error) {
@@ -65,12 +296,13 @@ func (q *Queries) GetFolderByID(ctx context.Context, id pgtype.UUID) (Folder, er
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.TrashedAt,
+		&i.TrashOperationID,
 	)
 	return i, err
 }
 
 const getFolderByIDAnyStatus = `-- name: GetFolderByIDAnyStatus :one
-SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at FROM folders WHERE id = $1
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders WHERE id = $1
 `
 
 func (q *Queries) GetFolderByIDAnyStatus(ctx context.Context, id pgtype.UUID) (Folder, error) {
@@ -87,12 +319,124 @@ func (q *Queries) GetFolderByIDAnyStatus(ctx context.Context, id pgtype.UUID) (F
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.TrashedAt,
+		&i.TrashOperationID,
 	)
 	return i, err
 }
 
+const getFolderTreeStats = `-- name: GetFolderTreeStats :many
+WITH RECURSIVE tree (id, depth, ids) AS (
+    SELECT f.id, 0, ARRAY[f.id]
+    FROM folders f
+    WHERE f.status = 'active'
+      AND (f.id = $1::uuid
+           OR ($1::uuid IS NULL AND f.parent_folder_id IS NULL
+               AND f.is_root IS NOT TRUE AND f.owner_id = $2::uuid))
+    UNION ALL
+    SELECT child.id, tree.depth + 1, tree.ids || child.id
+    FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.status = 'active' AND child.id <> ALL(tree.ids)
+)
+SELECT f.id, f.name, f.parent_folder_id, f.owner_id, f.updated_at,
+       tree.depth::int AS depth, stats.file_count, stats.size
+FROM folders f
+JOIN tree ON f.id = tree.id
+CROSS JOIN LATERAL (
+    SELECT COUNT(*)::bigint AS file_count, COALESCE(SUM(fi.size), 0)::bigint AS size
+    FROM files fi
+    WHERE fi.parent_folder_id = f.id AND fi.status = 'active'
+) stats
+ORDER BY tree.depth, f.name
+`
+
+type GetFolderTreeStatsParams struct {
+	FolderID pgtype.UUID `json:"folder_id"`
+	OwnerID  pgtype.UUID `json:"owner_id"`
+}
+
+type GetFolderTreeStatsRow struct {
+	ID             pgtype.UUID      `json:"id"`
+	Name           string           `json:"name"`
+	ParentFolderID pgtype.UUID      `json:"parent_folder_id"`
+	OwnerID        pgtype.UUID      `json:"owner_id"`
+	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
+	Depth          int32            `json:"depth"`
+	FileCount      int64            `json:"file_count"`
+	Size           int64            `json:"size"`
+}
+
+// Lists folder_id and every active folder below it, or every active folder of
+// owner_id's drive when folder_id is null, parents first, with the number and
+// total size of the active files directly in each. ids stops the walk if
+// folders form a cycle.
+func (q *Queries) GetFolderTreeStats(ctx context.Context, arg GetFolderTreeStatsParams) ([]GetFolderTreeStatsRow, error) {
+	rows, err := q.db.Query(ctx, getFolderTreeStats, arg.FolderID, arg.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetFolderTreeStatsRow{}
+	for rows.Next() {
+		var i GetFolderTreeStatsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.ParentFolderID,
+			&i.OwnerID,
+			&i.UpdatedAt,
+			&i.Depth,
+			&i.FileCount,
+			&i.Size,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFoldersByIDs = `-- name: GetFoldersByIDs :many
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) GetFoldersByIDs(ctx context.Context, ids []pgtype.UUID) ([]Folder, error) {
+	rows, err := q.db.Query(ctx, getFoldersByIDs, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Folder{}
+	for rows.Next() {
+		var i Folder
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.OwnerID,
+			&i.ParentFolderID,
+			&i.IsRoot,
+			&i.Status,
+			&i.IsStarred,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashedAt,
+			&i.TrashOperationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getFoldersByOwner = `-- name: GetFoldersByOwner :many
-SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at FROM folders
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders
 WHERE owner_id = $1 AND status = 'active'
 ORDER BY name ASC
 `
@@ -117,6 +461,7 @@ func (q *Queries) GetFoldersByOwner(ctx context.Context, ownerID pgtype.UUID) ([
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.TrashedAt,
+			&i.TrashOperationID,
 		); err != nil {
 			return nil, err
 		}
@@ -129,7 +474,7 @@ func (q *Queries) GetFoldersByOwner(ctx context.Context, ownerID pgtype.UUID) ([
 }
 
 const getFoldersInTrashOlderThan = `-- name: GetFoldersInTrashOlderThan :many
-SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at FROM folders
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders
 WHERE status = 'trashed'
   AND trashed_at < NOW() - INTERVAL '1 day' * $1
 ORDER BY trashed_at ASC
@@ -155,6 +500,7 @@ func (q *Queries) GetFoldersInTrashOlderThan(ctx context.Context, dollar_1 inter
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.TrashedAt,
+			&i.TrashOperationID,
 		); err != nil {
 			return nil, err
 		}
@@ -167,7 +513,7 @@ func (q *Queries) GetFoldersInTrashOlderThan(ctx context.Context, dollar_1 inter
 }
 
 const getRootFolder = `-- name: GetRootFolder :one
-SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at FROM folders
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders
 WHERE owner_id = $1 AND is_root = TRUE
 LIMIT 1
 `
@@ -186,12 +532,13 @@ func (q *Queries) GetRootFolder(ctx context.Context, ownerID pgtype.UUID) (Folde
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.TrashedAt,
+		&i.TrashOperationID,
 	)
 	return i, err
 }
 
 const getRootFolders = `-- name: GetRootFolders :many
-SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at FROM folders
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders
 WHERE owner_id = $1
   AND parent_folder_id IS NULL
   AND is_root = FALSE
@@ -219,6 +566,7 @@ func (q *Queries) GetRootFolders(ctx context.Context, ownerID pgtype.UUID) ([]Fo
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.TrashedAt,
+			&i.TrashOperationID,
 		); err != nil {
 			return nil, err
 		}
@@ -231,7 +579,7 @@ func (q *Queries) GetRootFolders(ctx context.Context, ownerID pgtype.UUID) ([]Fo
 }
 
 const getStarredFolders = `-- name: GetStarredFolders :many
-SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at FROM folders
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders
 WHERE owner_id = $1 AND is_starred = TRUE AND status = 'active'
 ORDER BY updated_at DESC
 `
@@ -256,6 +604,7 @@ func (q *Queries) GetStarredFolders(ctx context.Context, ownerID pgtype.UUID) ([
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.TrashedAt,
+			&i.TrashOperationID,
 		); err != nil {
 			return nil, err
 		}
@@ -268,7 +617,7 @@ func (q *Queries) GetStarredFolders(ctx context.Context, ownerID pgtype.UUID) ([
 }
 
 const getSubfolders = `-- name: GetSubfolders :many
-SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at FROM folders
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders
 WHERE parent_folder_id = $1 AND status = 'active'
 ORDER BY name ASC
 `
@@ -293,6 +642,7 @@ func (q *Queries) GetSubfolders(ctx context.Context, parentFolderID pgtype.UUID)
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.TrashedAt,
+			&i.TrashOperationID,
 		); err != nil {
 			return nil, err
 		}
@@ -305,11 +655,16 @@ func (q *Queries) GetSubfolders(ctx context.Context, parentFolderID pgtype.UUID)
 }
 
 const getTrashedFolders = `-- name: GetTrashedFolders :many
-SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at FROM folders
-WHERE owner_id = $1 AND status = 'trashed'
-ORDER BY trashed_at DESC
+SELECT id, name, owner_id, parent_folder_id, is_root, status, is_starred, created_at, updated_at, trashed_at, trash_operation_id FROM folders f
+WHERE f.owner_id = $1 AND f.status = 'trashed'
+  AND NOT EXISTS (
+      SELECT 1 FROM folders p
+      WHERE p.id = f.parent_folder_id AND p.trash_operation_id = f.trash_operation_id
+  )
+ORDER BY f.trashed_at DESC
 `
 
+// Subfolders trashed together with their parent are listed through the parent
 func (q *Queries) GetTrashedFolders(ctx context.Context, ownerID pgtype.UUID) ([]Folder, error) {
 	rows, err := q.db.Query(ctx, getTrashedFolders, ownerID)
 	if err != nil {
@@ -330,6 +685,7 @@ func (q *Queries) GetTrashedFolders(ctx context.Context, ownerID pgtype.UUID) ([
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.TrashedAt,
+			&i.TrashOperationID,
 		); err != nil {
 			return nil, err
 		}
@@ -341,30 +697,42 @@ func (q *Queries) GetTrashedFolders(ctx context.Context, ownerID pgtype.UUID) ([
 	return items, nil
 }
 
-const moveFolder = `-- name: MoveFolder :exec
-UPDATE folders
-SET parent_folder_id = $2, updated_at = NOW()
-WHERE id = $1
+const lockFolder = `-- name: LockFolder :one
+SELECT id FROM folders WHERE id = $1 AND status = 'active' FOR UPDATE
 `
 
-type MoveFolderParams struct {
-	ID             pgtype.UUID `json:"id"`
-	ParentFolderID pgtype.UUID `json:"parent_folder_id"`
+// Serializes changes to a folder's children; hold it for the rest of the transaction
+func (q *Queries) LockFolder(ctx context.Context, id pgtype.UUID) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, lockFolder, id)
+	err := row.Scan(&id)
+	return id, err
 }
 
-func (q *Queries) MoveFolder(ctx context.Context, arg MoveFolderParams) error {
-	_, err := q.db.Exec(ctx, moveFolder, arg.ID, arg.ParentFolderID)
+const lockFolderMoves = `-- name: LockFolderMoves :exec
+SELECT pg_advisory_xact_lock(hashtext('folder_moves'))
+`
+
+// Serializes folder moves, so two moves cannot each pass the cycle check and
+// together form a loop; held until the transaction ends
+func (q *Queries) LockFolderMoves(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, lockFolderMoves)
 	return err
 }
 
-const permanentDeleteFolder = `-- name: PermanentDeleteFolder :exec
+const moveFolder = `-- name: MoveFolder :exec
 UPDATE folders
-SET status = 'deleted'
+SET parent_folder_id = $2, name = $3, updated_at = NOW()
 WHERE id = $1
 `
 
-func (q *Queries) PermanentDeleteFolder(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, permanentDeleteFolder, id)
+type MoveFolderParams struct {
+	ID             pgtype.UUID `json:"id"`
+	ParentFolderID pgtype.UUID `json:"parent_folder_id"`
+	Name           string      `json:"name"`
+}
+
+func (q *Queries) MoveFolder(ctx context.Context, arg MoveFolderParams) error {
+	_, err := q.db.Exec(ctx, moveFolder, arg.ID, arg.ParentFolderID, arg.Name)
 	return err
 }
 
@@ -384,35 +752,98 @@ func (q *Queries) RenameFolder(ctx context.Context, arg RenameFolderParams) erro
 	return err
 }
 
+const resolveFolderPath = `-- name: ResolveFolderPath :one
+WITH RECURSIVE walk (id, depth, created) AS (
+    SELECT f.id, 1, ARRAY[f.created_at]
+    FROM folders f
+    WHERE f.status = 'active' AND f.is_root IS NOT TRUE
+      AND f.name = ($1::text[])[1]
+      AND (f.parent_folder_id = $2::uuid
+           OR ($2::uuid IS NULL AND f.parent_folder_id IS NULL AND f.owner_id = $3::uuid))
+    UNION ALL
+    SELECT child.id, walk.depth + 1, walk.created || child.created_at
+    FROM folders child
+    JOIN walk ON child.parent_folder_id = walk.id
+    WHERE child.status = 'active'
+      AND walk.depth < cardinality($1::text[])
+      AND child.name = ($1::text[])[walk.depth + 1]
+)
+SELECT f.id, f.name, f.owner_id, f.parent_folder_id, f.is_root, f.status, f.is_starred, f.created_at, f.updated_at, f.trashed_at, f.trash_operation_id
+FROM folders f
+JOIN walk ON f.id = walk.id
+WHERE walk.depth = cardinality($1::text[])
+ORDER BY walk.created
+LIMIT 1
+`
+
+type ResolveFolderPathParams struct {
+	Names    []string    `json:"names"`
+	FolderID pgtype.UUID `json:"folder_id"`
+	OwnerID  pgtype.UUID `json:"owner_id"`
+}
+
+// Follows names down from folder_id, or from the top of owner_id's drive when
+// folder_id is null, to the active folder they lead to. Among folders with
+// the same name the oldest is taken.
+func (q *Queries) ResolveFolderPath(ctx context.Context, arg ResolveFolderPathParams) (Folder, error) {
+	row := q.db.QueryRow(ctx, resolveFolderPath, arg.Names, arg.FolderID, arg.OwnerID)
+	var i Folder
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.OwnerID,
+		&i.ParentFolderID,
+		&i.IsRoot,
+		&i.Status,
+		&i.IsStarred,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TrashedAt,
+		&i.TrashOperationID,
+	)
+	return i, err
+}
+
 const restoreFolder = `-- name: RestoreFolder :exec
 UPDATE folders
-SET status = 'active', trashed_at = NULL
+SET status = 'active', trashed_at = NULL, trash_operation_id = NULL, parent_folder_id = $2, name = $3
 WHERE id = $1
 `
 
-func (q *Queries) RestoreFolder(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, restoreFolder, id)
+type RestoreFolderParams struct {
+	ID             pgtype.UUID `json:"id"`
+	ParentFolderID pgtype.UUID `json:"parent_folder_id"`
+	Name           string      `json:"name"`
+}
+
+func (q *Queries) RestoreFolder(ctx context.Context, arg RestoreFolderParams) error {
+	_, err := q.db.Exec(ctx, restoreFolder, arg.ID, arg.ParentFolderID, arg.Name)
 	return err
 }
 
-const toggleStarFolder = `-- name: ToggleStarFolder :exec
+const setFolderStarred = `-- name: SetFolderStarred :exec
 UPDATE folders
-SET is_starred = NOT is_starred, updated_at = NOW()
+SET is_starred = $2, updated_at = NOW()
 WHERE id = $1
 `
 
-func (q *Queries) ToggleStarFolder(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, toggleStarFolder, id)
+type SetFolderStarredParams struct {
+	ID        pgtype.UUID `json:"id"`
+	IsStarred pgtype.Bool `json:"is_starred"`
+}
+
+func (q *Queries) SetFolderStarred(ctx context.Context, arg SetFolderStarredParams) error {
+	_, err := q.db.Exec(ctx, setFolderStarred, arg.ID, arg.IsStarred)
 	return err
 }
 
-const trashFolder = `-- name: TrashFolder :exec
+const toggleStarFolder = `-- name: ToggleStarFolder :exec
 UPDATE folders
-SET status = 'trashed', trashed_at = NOW()
+SET is_starred = NOT is_starred, updated_at = NOW()
 WHERE id = $1
 `
 
-func (q *Queries) TrashFolder(ctx context.Context, id pgtype.UUID) error {
-	_, err := q.db.Exec(ctx, trashFolder, id)
+func (q *Queries) ToggleStarFolder(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, toggleStarFolder, id)
 	return err
 }
@@ -0,0 +1,377 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: fsck.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const clearFileThumbnail = `-- name: ClearFileThumbnail :exec
+UPDATE files
+SET thumbnail_path = NULL
+WHERE id = $1
+`
+
+func (q *Queries) ClearFileThumbnail(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, clearFileThumbnail, id)
+	return err
+}
+
+const deleteBlobRefForRepair = `-- name: DeleteBlobRefForRepair :exec
+DELETE FROM blob_refs WHERE digest = $1 AND owner_id = $2
+`
+
+type DeleteBlobRefForRepairParams struct {
+	Digest  string      `json:"digest"`
+	OwnerID pgtype.UUID `json:"owner_id"`
+}
+
+func (q *Queries) DeleteBlobRefForRepair(ctx context.Context, arg DeleteBlobRefForRepairParams) error {
+	_, err := q.db.Exec(ctx, deleteBlobRefForRepair, arg.Digest, arg.OwnerID)
+	return err
+}
+
+const getBlobRefMismatches = `-- name: GetBlobRefMismatches :many
+WITH expected AS (
+    SELECT v.blob_digest AS digest, f.owner_id, COUNT(*)::int AS ref_count
+    FROM file_versions v
+    JOIN files f ON f.id = v.file_id
+    WHERE v.blob_digest IS NOT NULL
+    GROUP BY v.blob_digest, f.owner_id
+)
+SELECT COALESCE(e.digest, r.digest)::text AS digest,
+       COALESCE(e.owner_id, r.owner_id)::uuid AS owner_id,
+       COALESCE(r.ref_count, 0)::int AS recorded,
+       COALESCE(e.ref_count, 0)::int AS expected
+FROM expected e
+FULL JOIN blob_refs r ON r.digest = e.digest AND r.owner_id = e.owner_id
+WHERE COALESCE(r.ref_count, 0) <> COALESCE(e.ref_count, 0)
+ORDER BY 1, 2
+`
+
+type GetBlobRefMismatchesRow struct {
+	Digest   string      `json:"digest"`
+	OwnerID  pgtype.UUID `json:"owner_id"`
+	Recorded int32       `json:"recorded"`
+	Expected int32       `json:"expected"`
+}
+
+// Compares blob_refs with the versions that actually use each blob
+func (q *Queries) GetBlobRefMismatches(ctx context.Context) ([]GetBlobRefMismatchesRow, error) {
+	rows, err := q.db.Query(ctx, getBlobRefMismatches)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetBlobRefMismatchesRow{}
+	for rows.Next() {
+		var i GetBlobRefMismatchesRow
+		if err := rows.Scan(
+			&i.Digest,
+			&i.OwnerID,
+			&i.Recorded,
+			&i.Expected,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getComputedStorageUsage = `-- name: GetComputedStorageUsage :many
+SELECT u.id, u.email,
+       COALESCE(u.storage_used, 0)::bigint AS recorded,
+       (
+           COALESCE((
+               SELECT SUM(b.size)
+               FROM blobs b
+               WHERE b.digest IN (
+                   SELECT v.blob_digest
+                   FROM file_versions v
+                   JOIN files f ON f.id = v.file_id
+                   WHERE f.owner_id = u.id
+               )
+           ), 0)
+           + COALESCE((
+               SELECT SUM(v.size)
+               FROM file_versions v
+               JOIN files f ON f.id = v.file_id
+               WHERE f.owner_id = u.id AND v.blob_digest IS NULL
+           ), 0)
+       )::bigint AS computed
+FROM users u
+ORDER BY u.email
+`
+
+type GetComputedStorageUsageRow struct {
+	ID       pgtype.UUID `json:"id"`
+	Email    string      `json:"email"`
+	Recorded int64       `json:"recorded"`
+	Computed int64       `json:"computed"`
+}
+
+// Recomputes storage_used from the versions each user owns: every distinct
+// blob once, plus every version stored before deduplication
+func (q *Queries) GetComputedStorageUsage(ctx context.Context) ([]GetComputedStorageUsageRow, error) {
+	rows, err := q.db.Query(ctx, getComputedStorageUsage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetComputedStorageUsageRow{}
+	for rows.Next() {
+		var i GetComputedStorageUsageRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Email,
+			&i.Recorded,
+			&i.Computed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listBlobsForCheck = `-- name: ListBlobsForCheck :many
+SELECT b.digest, b.size,
+       EXISTS (SELECT 1 FROM file_versions v WHERE v.blob_digest = b.digest) AS in_use
+FROM blobs b
+ORDER BY b.digest
+`
+
+type ListBlobsForCheckRow struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	InUse  bool   `json:"in_use"`
+}
+
+func (q *Queries) ListBlobsForCheck(ctx context.Context) ([]ListBlobsForCheckRow, error) {
+	rows, err := q.db.Query(ctx, listBlobsForCheck)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListBlobsForCheckRow{}
+	for rows.Next() {
+		var i ListBlobsForCheckRow
+		if err := rows.Scan(&i.Digest, &i.Size, &i.InUse); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFilesForCheck = `-- name: ListFilesForCheck :many
+SELECT id, owner_id, name, storage_path, size, thumbnail_path, broken_at
+FROM files
+ORDER BY id
+`
+
+type ListFilesForCheckRow struct {
+	ID            pgtype.UUID      `json:"id"`
+	OwnerID       pgtype.UUID      `json:"owner_id"`
+	Name          string           `json:"name"`
+	StoragePath   string           `json:"storage_path"`
+	Size          int64            `json:"size"`
+	ThumbnailPath pgtype.Text      `json:"thumbnail_path"`
+	BrokenAt      pgtype.Timestamp `json:"broken_at"`
+}
+
+func (q *Queries) ListFilesForCheck(ctx context.Context) ([]ListFilesForCheckRow, error) {
+	rows, err := q.db.Query(ctx, listFilesForCheck)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListFilesForCheckRow{}
+	for rows.Next() {
+		var i ListFilesForCheckRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.Name,
+			&i.StoragePath,
+			&i.Size,
+			&i.ThumbnailPath,
+			&i.BrokenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLegacyVersionsForCheck = `-- name: ListLegacyVersionsForCheck :many
+SELECT v.id, v.file_id, v.storage_path, v.size
+FROM file_versions v
+WHERE v.blob_digest IS NULL
+ORDER BY v.id
+`
+
+type ListLegacyVersionsForCheckRow struct {
+	ID          pgtype.UUID `json:"id"`
+	FileID      pgtype.UUID `json:"file_id"`
+	StoragePath string      `json:"storage_path"`
+	Size        int64       `json:"size"`
+}
+
+// Versions stored before deduplication own their object directly
+func (q *Queries) ListLegacyVersionsForCheck(ctx context.Context) ([]ListLegacyVersionsForCheckRow, error) {
+	rows, err := q.db.Query(ctx, listLegacyVersionsForCheck)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListLegacyVersionsForCheckRow{}
+	for rows.Next() {
+		var i ListLegacyVersionsForCheckRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.FileID,
+			&i.StoragePath,
+			&i.Size,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingUploadSessionIDs = `-- name: ListPendingUploadSessionIDs :many
+SELECT id FROM upload_sessions WHERE status = 'pending'
+`
+
+func (q *Queries) ListPendingUploadSessionIDs(ctx context.Context) ([]pgtype.UUID, error) {
+	rows, err := q.db.Query(ctx, listPendingUploadSessionIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []pgtype.UUID{}
+	for rows.Next() {
+		var id pgtype.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPreviewsForCheck = `-- name: ListPreviewsForCheck :many
+SELECT p.file_id, p.size, p.path, COALESCE(f.version, 1)::int AS file_version
+FROM file_previews p
+JOIN files f ON f.id = p.file_id
+ORDER BY p.file_id, p.size
+`
+
+type ListPreviewsForCheckRow struct {
+	FileID      pgtype.UUID `json:"file_id"`
+	Size        string      `json:"size"`
+	Path        string      `json:"path"`
+	FileVersion int32       `json:"file_version"`
+}
+
+func (q *Queries) ListPreviewsForCheck(ctx context.Context) ([]ListPreviewsForCheckRow, error) {
+	rows, err := q.db.Query(ctx, listPreviewsForCheck)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListPreviewsForCheckRow{}
+	for rows.Next() {
+		var i ListPreviewsForCheckRow
+		if err := rows.Scan(
+			&i.FileID,
+			&i.Size,
+			&i.Path,
+			&i.FileVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setBlobRefCount = `-- name: SetBlobRefCount :exec
+INSERT INTO blob_refs (digest, owner_id, ref_count)
+VALUES ($1, $2, $3)
+ON CONFLICT (digest, owner_id) DO UPDATE SET ref_count = EXCLUDED.ref_count
+`
+
+type SetBlobRefCountParams struct {
+	Digest   string      `json:"digest"`
+	OwnerID  pgtype.UUID `json:"owner_id"`
+	RefCount int32       `json:"ref_count"`
+}
+
+func (q *Queries) SetBlobRefCount(ctx context.Context, arg SetBlobRefCountParams) error {
+	_, err := q.db.Exec(ctx, setBlobRefCount, arg.Digest, arg.OwnerID, arg.RefCount)
+	return err
+}
+
+const setFileBroken = `-- name: SetFileBroken :exec
+UPDATE files
+SET broken_at = CASE WHEN $1::boolean THEN COALESCE(broken_at, NOW()) END
+WHERE id = $2
+`
+
+type SetFileBrokenParams struct {
+	Broken bool        `json:"broken"`
+	ID     pgtype.UUID `json:"id"`
+}
+
+func (q *Queries) SetFileBroken(ctx context.Context, arg SetFileBrokenParams) error {
+	_, err := q.db.Exec(ctx, setFileBroken, arg.Broken, arg.ID)
+	return err
+}
+
+const setUserStorageUsed = `-- name: SetUserStorageUsed :exec
+UPDATE users
+SET storage_used = $2,
+    updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserStorageUsedParams struct {
+	ID          pgtype.UUID `json:"id"`
+	StorageUsed pgtype.Int8 `json:"storage_used"`
+}
+
+func (q *Queries) SetUserStorageUsed(ctx context.Context, arg SetUserStorageUsedParams) error {
+	_, err := q.db.Exec(ctx, setUserStorageUsed, arg.ID, arg.StorageUsed)
+	return err
+}
@@ -0,0 +1,522 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: jobs.sql
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const advanceJobSchedule = `-- name: AdvanceJobSchedule :exec
+UPDATE job_schedules
+SET next_run_at = $2,
+    last_run_at = NOW(),
+    updated_at = NOW()
+WHERE name = $1
+`
+
+type AdvanceJobScheduleParams struct {
+	Name      string           `json:"name"`
+	NextRunAt pgtype.Timestamp `json:"next_run_at"`
+}
+
+func (q *Queries) AdvanceJobSchedule(ctx context.Context, arg AdvanceJobScheduleParams) error {
+	_, err := q.db.Exec(ctx, advanceJobSchedule, arg.Name, arg.NextRunAt)
+	return err
+}
+
+const claimDueSchedules = `-- name: ClaimDueSchedules :many
+SELECT name, kind, payload, spec, next_run_at, last_run_at, updated_at FROM job_schedules
+WHERE name = ANY($1::text[]) AND next_run_at <= NOW()
+ORDER BY next_run_at
+FOR UPDATE SKIP LOCKED
+`
+
+func (q *Queries) ClaimDueSchedules(ctx context.Context, names []string) ([]JobSchedule, error) {
+	rows, err := q.db.Query(ctx, claimDueSchedules, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []JobSchedule{}
+	for rows.Next() {
+		var i JobSchedule
+		if err := rows.Scan(
+			&i.Name,
+			&i.Kind,
+			&i.Payload,
+			&i.Spec,
+			&i.NextRunAt,
+			&i.LastRunAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimJob = `-- name: ClaimJob :one
+UPDATE jobs
+SET status = 'running',
+    attempts = attempts + 1,
+    locked_by = $1::text,
+    locked_at = NOW(),
+    updated_at = NOW()
+WHERE id = (
+    SELECT j.id FROM jobs j
+    WHERE j.kind = ANY($2::text[])
+      AND ((j.status = 'pending' AND j.run_after <= NOW())
+        OR (j.status = 'running' AND j.locked_at < $3::timestamp))
+    ORDER BY j.run_after
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, kind, payload, status, unique_key, attempts, max_attempts, last_error, run_after, locked_by, locked_at, finished_at, created_at, updated_at
+`
+
+type ClaimJobParams struct {
+	Worker      string           `json:"worker"`
+	Kinds       []string         `json:"kinds"`
+	StaleBefore pgtype.Timestamp `json:"stale_before"`
+}
+
+// Takes the next due job of the given kinds, or a running one whose worker
+// stopped heartbeating before stale_before
+func (q *Queries) ClaimJob(ctx context.Context, arg ClaimJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, claimJob, arg.Worker, arg.Kinds, arg.StaleBefore)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.UniqueKey,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.LastError,
+		&i.RunAfter,
+		&i.LockedBy,
+		&i.LockedAt,
+		&i.FinishedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const completeJob = `-- name: CompleteJob :exec
+UPDATE jobs
+SET status = 'succeeded',
+    last_error = NULL,
+    locked_by = NULL,
+    locked_at = NULL,
+    finished_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND attempts = $2 AND status = 'running'
+`
+
+type CompleteJobParams struct {
+	ID       pgtype.UUID `json:"id"`
+	Attempts int32       `json:"attempts"`
+}
+
+// Every outcome query matches the attempt, so a worker whose job was
+// claimed again after it stalled cannot overwrite the newer attempt
+func (q *Queries) CompleteJob(ctx context.Context, arg CompleteJobParams) error {
+	_, err := q.db.Exec(ctx, completeJob, arg.ID, arg.Attempts)
+	return err
+}
+
+const countJobs = `-- name: CountJobs :many
+SELECT kind, status, COUNT(*)::int AS jobs
+FROM jobs
+GROUP BY kind, status
+ORDER BY kind, status
+`
+
+type CountJobsRow struct {
+	Kind   string    `json:"kind"`
+	Status JobStatus `json:"status"`
+	Jobs   int32     `json:"jobs"`
+}
+
+func (q *Queries) CountJobs(ctx context.Context) ([]CountJobsRow, error) {
+	rows, err := q.db.Query(ctx, countJobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CountJobsRow{}
+	for rows.Next() {
+		var i CountJobsRow
+		if err := rows.Scan(&i.Kind, &i.Status, &i.Jobs); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteFinishedJobs = `-- name: DeleteFinishedJobs :execrows
+DELETE FROM jobs
+WHERE (status = 'succeeded' AND finished_at < $1::timestamp)
+   OR (status = 'failed' AND finished_at < $2::timestamp)
+`
+
+type DeleteFinishedJobsParams struct {
+	SucceededBefore pgtype.Timestamp `json:"succeeded_before"`
+	FailedBefore    pgtype.Timestamp `json:"failed_before"`
+}
+
+func (q *Queries) DeleteFinishedJobs(ctx context.Context, arg DeleteFinishedJobsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteFinishedJobs, arg.SucceededBefore, arg.FailedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteSupersededJob = `-- name: DeleteSupersededJob :exec
+DELETE FROM jobs
+WHERE id = $1 AND attempts = $2 AND status = 'running'
+`
+
+type DeleteSupersededJobParams struct {
+	ID       pgtype.UUID `json:"id"`
+	Attempts int32       `json:"attempts"`
+}
+
+// Drops a job whose retry is covered by a pending job with the same key
+func (q *Queries) DeleteSupersededJob(ctx context.Context, arg DeleteSupersededJobParams) error {
+	_, err := q.db.Exec(ctx, deleteSupersededJob, arg.ID, arg.Attempts)
+	return err
+}
+
+const enqueueJob = `-- name: EnqueueJob :one
+INSERT INTO jobs (kind, payload, unique_key, max_attempts, run_after)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL AND status = 'pending' DO UPDATE
+SET payload = EXCLUDED.payload,
+    max_attempts = EXCLUDED.max_attempts,
+    run_after = LEAST(jobs.run_after, EXCLUDED.run_after),
+    attempts = 0,
+    last_error = NULL,
+    updated_at = NOW()
+RETURNING id, kind, payload, status, unique_key, attempts, max_attempts, last_error, run_after, locked_by, locked_at, finished_at, created_at, updated_at
+`
+
+type EnqueueJobParams struct {
+	Kind        string           `json:"kind"`
+	Payload     json.RawMessage  `json:"payload"`
+	UniqueKey   pgtype.Text      `json:"unique_key"`
+	MaxAttempts int32            `json:"max_attempts"`
+	RunAfter    pgtype.Timestamp `json:"run_after"`
+}
+
+// A job with a unique_key that is already pending is updated instead: it
+// takes the new payload and runs no later than the new run_after
+func (q *Queries) EnqueueJob(ctx context.Context, arg EnqueueJobParams) (Job, error) {
+	row := q.db.QueryRow(ctx, enqueueJob,
+		arg.Kind,
+		arg.Payload,
+		arg.UniqueKey,
+		arg.MaxAttempts,
+		arg.RunAfter,
+	)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.UniqueKey,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.LastError,
+		&i.RunAfter,
+		&i.LockedBy,
+		&i.LockedAt,
+		&i.FinishedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const failJob = `-- name: FailJob :exec
+UPDATE jobs
+SET status = 'failed',
+    last_error = $3,
+    locked_by = NULL,
+    locked_at = NULL,
+    finished_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND attempts = $2 AND status = 'running'
+`
+
+type FailJobParams struct {
+	ID        pgtype.UUID `json:"id"`
+	Attempts  int32       `json:"attempts"`
+	LastError pgtype.Text `json:"last_error"`
+}
+
+func (q *Queries) FailJob(ctx context.Context, arg FailJobParams) error {
+	_, err := q.db.Exec(ctx, failJob, arg.ID, arg.Attempts, arg.LastError)
+	return err
+}
+
+const getJob = `-- name: GetJob :one
+SELECT id, kind, payload, status, unique_key, attempts, max_attempts, last_error, run_after, locked_by, locked_at, finished_at, created_at, updated_at FROM jobs WHERE id = $1
+`
+
+func (q *Queries) GetJob(ctx context.Context, id pgtype.UUID) (Job, error) {
+	row := q.db.QueryRow(ctx, getJob, id)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.UniqueKey,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.LastError,
+		&i.RunAfter,
+		&i.LockedBy,
+		&i.LockedAt,
+		&i.FinishedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const hasOpenJob = `-- name: HasOpenJob :one
+SELECT EXISTS (
+    SELECT 1 FROM jobs
+    WHERE unique_key = $1 AND status IN ('pending', 'running')
+)
+`
+
+func (q *Queries) HasOpenJob(ctx context.Context, uniqueKey pgtype.Text) (bool, error) {
+	row := q.db.QueryRow(ctx, hasOpenJob, uniqueKey)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const heartbeatJob = `-- name: HeartbeatJob :exec
+UPDATE jobs SET locked_at = NOW()
+WHERE id = $1 AND attempts = $2 AND status = 'running'
+`
+
+type HeartbeatJobParams struct {
+	ID       pgtype.UUID `json:"id"`
+	Attempts int32       `json:"attempts"`
+}
+
+func (q *Queries) HeartbeatJob(ctx context.Context, arg HeartbeatJobParams) error {
+	_, err := q.db.Exec(ctx, heartbeatJob, arg.ID, arg.Attempts)
+	return err
+}
+
+const listJobSchedules = `-- name: ListJobSchedules :many
+SELECT name, kind, payload, spec, next_run_at, last_run_at, updated_at FROM job_schedules ORDER BY name
+`
+
+func (q *Queries) ListJobSchedules(ctx context.Context) ([]JobSchedule, error) {
+	rows, err := q.db.Query(ctx, listJobSchedules)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []JobSchedule{}
+	for rows.Next() {
+		var i JobSchedule
+		if err := rows.Scan(
+			&i.Name,
+			&i.Kind,
+			&i.Payload,
+			&i.Spec,
+			&i.NextRunAt,
+			&i.LastRunAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listJobs = `-- name: ListJobs :many
+SELECT id, kind, payload, status, unique_key, attempts, max_attempts, last_error, run_after, locked_by, locked_at, finished_at, created_at, updated_at FROM jobs
+WHERE ($1::job_status IS NULL OR status = $1::job_status)
+  AND ($2::text IS NULL OR kind = $2::text)
+ORDER BY created_at DESC
+LIMIT $3
+`
+
+type ListJobsParams struct {
+	Status  NullJobStatus `json:"status"`
+	Kind    pgtype.Text   `json:"kind"`
+	MaxJobs int32         `json:"max_jobs"`
+}
+
+func (q *Queries) ListJobs(ctx context.Context, arg ListJobsParams) ([]Job, error) {
+	rows, err := q.db.Query(ctx, listJobs, arg.Status, arg.Kind, arg.MaxJobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Job{}
+	for rows.Next() {
+		var i Job
+		if err := rows.Scan(
+			&i.ID,
+			&i.Kind,
+			&i.Payload,
+			&i.Status,
+			&i.UniqueKey,
+			&i.Attempts,
+			&i.MaxAttempts,
+			&i.LastError,
+			&i.RunAfter,
+			&i.LockedBy,
+			&i.LockedAt,
+			&i.FinishedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const retryFailedJob = `-- name: RetryFailedJob :one
+UPDATE jobs
+SET status = 'pending',
+    attempts = 0,
+    run_after = NOW(),
+    finished_at = NULL,
+    updated_at = NOW()
+WHERE jobs.id = $1 AND jobs.status = 'failed'
+  AND NOT EXISTS (
+      SELECT 1 FROM jobs p
+      WHERE p.unique_key = jobs.unique_key AND p.status = 'pending'
+  )
+RETURNING id, kind, payload, status, unique_key, attempts, max_attempts, last_error, run_after, locked_by, locked_at, finished_at, created_at, updated_at
+`
+
+// Returns no row when the job is not failed or its key is already pending
+func (q *Queries) RetryFailedJob(ctx context.Context, id pgtype.UUID) (Job, error) {
+	row := q.db.QueryRow(ctx, retryFailedJob, id)
+	var i Job
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.UniqueKey,
+		&i.Attempts,
+		&i.MaxAttempts,
+		&i.LastError,
+		&i.RunAfter,
+		&i.LockedBy,
+		&i.LockedAt,
+		&i.FinishedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const retryJob = `-- name: RetryJob :execrows
+UPDATE jobs
+SET status = 'pending',
+    last_error = $3,
+    run_after = $4,
+    locked_by = NULL,
+    locked_at = NULL,
+    updated_at = NOW()
+WHERE jobs.id = $1 AND jobs.attempts = $2 AND jobs.status = 'running'
+  AND NOT EXISTS (
+      SELECT 1 FROM jobs p
+      WHERE p.unique_key = jobs.unique_key AND p.status = 'pending'
+  )
+`
+
+type RetryJobParams struct {
+	ID        pgtype.UUID      `json:"id"`
+	Attempts  int32            `json:"attempts"`
+	LastError pgtype.Text      `json:"last_error"`
+	RunAfter  pgtype.Timestamp `json:"run_after"`
+}
+
+// Affects no rows when the same key was enqueued again meanwhile
+func (q *Queries) RetryJob(ctx context.Context, arg RetryJobParams) (int64, error) {
+	result, err := q.db.Exec(ctx, retryJob,
+		arg.ID,
+		arg.Attempts,
+		arg.LastError,
+		arg.RunAfter,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const upsertJobSchedule = `-- name: UpsertJobSchedule :exec
+INSERT INTO job_schedules (name, kind, payload, spec, next_run_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (name) DO UPDATE
+SET kind = EXCLUDED.kind,
+    payload = EXCLUDED.payload,
+    next_run_at = CASE WHEN job_schedules.spec = EXCLUDED.spec
+                       THEN job_schedules.next_run_at
+                       ELSE EXCLUDED.next_run_at END,
+    spec = EXCLUDED.spec,
+    updated_at = NOW()
+`
+
+type UpsertJobScheduleParams struct {
+	Name      string           `json:"name"`
+	Kind      string           `json:"kind"`
+	Payload   json.RawMessage  `json:"payload"`
+	Spec      string           `json:"spec"`
+	NextRunAt pgtype.Timestamp `json:"next_run_at"`
+}
+
+// Keeps the planned next run unless the schedule itself changed
+func (q *Queries) UpsertJobSchedule(ctx context.Context, arg UpsertJobScheduleParams) error {
+	_, err := q.db.Exec(ctx, upsertJobSchedule,
+		arg.Name,
+		arg.Kind,
+		arg.Payload,
+		arg.Spec,
+		arg.NextRunAt,
+	)
+	return err
+}
@@ -6,6 +6,7 @@ package database
 
 import (
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 
 	"github.com/jackc/pgx/v5/pgtype"
@@ -147,6 +148,50 @@ func (ns NullItemType) Value() (driver.Value, error) {
 	return string(ns.ItemType), nil
 }
 
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+func (e *JobStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = JobStatus(s)
+	case string:
+		*e = JobStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for JobStatus: %T", src)
+	}
+	return nil
+}
+
+type NullJobStatus struct {
+	JobStatus JobStatus `json:"job_status"`
+	Valid     bool      `json:"valid"` // Valid is true if JobStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullJobStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.JobStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.JobStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullJobStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.JobStatus), nil
+}
+
 type PermissionRole string
 
 const (
@@ -191,6 +236,93 @@ func (ns NullPermissionRole) Value() (driver.Value, error) {
 	return string(ns.PermissionRole), nil
 }
 
+type ShareAccessAction string
+
+const (
+	ShareAccessActionView     ShareAccessAction = "view"
+	ShareAccessActionBrowse   ShareAccessAction = "browse"
+	ShareAccessActionDownload ShareAccessAction = "download"
+	ShareAccessActionArchive  ShareAccessAction = "archive"
+)
+
+func (e *ShareAccessAction) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = ShareAccessAction(s)
+	case string:
+		*e = ShareAccessAction(s)
+	default:
+		return fmt.Errorf("unsupported scan type for ShareAccessAction: %T", src)
+	}
+	return nil
+}
+
+type NullShareAccessAction struct {
+	ShareAccessAction ShareAccessAction `json:"share_access_action"`
+	Valid             bool              `json:"valid"` // Valid is true if ShareAccessAction is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullShareAccessAction) Scan(value interface{}) error {
+	if value == nil {
+		ns.ShareAccessAction, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.ShareAccessAction.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullShareAccessAction) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.ShareAccessAction), nil
+}
+
+type UploadStatus string
+
+const (
+	UploadStatusPending   UploadStatus = "pending"
+	UploadStatusCompleted UploadStatus = "completed"
+	UploadStatusAborted   UploadStatus = "aborted"
+)
+
+func (e *UploadStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = UploadStatus(s)
+	case string:
+		*e = UploadStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for UploadStatus: %T", src)
+	}
+	return nil
+}
+
+type NullUploadStatus struct {
+	UploadStatus UploadStatus `json:"upload_status"`
+	Valid        bool         `json:"valid"` // Valid is true if UploadStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullUploadStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.UploadStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.UploadStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullUploadStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.UploadStatus), nil
+}
+
 type ActivityLog struct {
 	ID           pgtype.UUID      `json:"id"`
 	UserID       pgtype.UUID      `json:"user_id"`
@@ -200,6 +332,18 @@ type ActivityLog struct {
 	CreatedAt    pgtype.Timestamp `json:"created_at"`
 }
 
+type Blob struct {
+	Digest    string           `json:"digest"`
+	Size      int64            `json:"size"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type BlobRef struct {
+	Digest   string      `json:"digest"`
+	OwnerID  pgtype.UUID `json:"owner_id"`
+	RefCount int32       `json:"ref_count"`
+}
+
 type Comment struct {
 	ID        pgtype.UUID      `json:"id"`
 	FileID    pgtype.UUID      `json:"file_id"`
@@ -229,6 +373,26 @@ type File struct {
 	UpdatedAt        pgtype.Timestamp `json:"updated_at"`
 	TrashedAt        pgtype.Timestamp `json:"trashed_at"`
 	LastAccessedAt   pgtype.Timestamp `json:"last_accessed_at"`
+	TrashOperationID pgtype.UUID      `json:"trash_operation_id"`
+	BrokenAt         pgtype.Timestamp `json:"broken_at"`
+}
+
+type FileContent struct {
+	FileID       pgtype.UUID      `json:"file_id"`
+	Version      int32            `json:"version"`
+	Content      string           `json:"content"`
+	SearchVector interface{}      `json:"search_vector"`
+	ExtractedAt  pgtype.Timestamp `json:"extracted_at"`
+}
+
+type FilePreview struct {
+	FileID    pgtype.UUID      `json:"file_id"`
+	Size      string           `json:"size"`
+	Path      string           `json:"path"`
+	Width     int32            `json:"width"`
+	Height    int32            `json:"height"`
+	Version   int32            `json:"version"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
 }
 
 type FileVersion struct {
@@ -239,19 +403,49 @@ type FileVersion struct {
 	Size          int64            `json:"size"`
 	UploadedBy    pgtype.UUID      `json:"uploaded_by"`
 	CreatedAt     pgtype.Timestamp `json:"created_at"`
+	BlobDigest    pgtype.Text      `json:"blob_digest"`
+	IsPinned      bool             `json:"is_pinned"`
 }
 
 type Folder struct {
-	ID             pgtype.UUID      `json:"id"`
-	Name           string           `json:"name"`
-	OwnerID        pgtype.UUID      `json:"owner_id"`
-	ParentFolderID pgtype.UUID      `json:"parent_folder_id"`
-	IsRoot         pgtype.Bool      `json:"is_root"`
-	Status         NullFileStatus   `json:"status"`
-	IsStarred      pgtype.Bool      `json:"is_starred"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
-	TrashedAt      pgtype.Timestamp `json:"trashed_at"`
+	ID               pgtype.UUID      `json:"id"`
+	Name             string           `json:"name"`
+	OwnerID          pgtype.UUID      `json:"owner_id"`
+	ParentFolderID   pgtype.UUID      `json:"parent_folder_id"`
+	IsRoot           pgtype.Bool      `json:"is_root"`
+	Status           NullFileStatus   `json:"status"`
+	IsStarred        pgtype.Bool      `json:"is_starred"`
+	CreatedAt        pgtype.Timestamp `json:"created_at"`
+	UpdatedAt        pgtype.Timestamp `json:"updated_at"`
+	TrashedAt        pgtype.Timestamp `json:"trashed_at"`
+	TrashOperationID pgtype.UUID      `json:"trash_operation_id"`
+}
+
+type Job struct {
+	ID          pgtype.UUID      `json:"id"`
+	Kind        string           `json:"kind"`
+	Payload     json.RawMessage  `json:"payload"`
+	Status      JobStatus        `json:"status"`
+	UniqueKey   pgtype.Text      `json:"unique_key"`
+	Attempts    int32            `json:"attempts"`
+	MaxAttempts int32            `json:"max_attempts"`
+	LastError   pgtype.Text      `json:"last_error"`
+	RunAfter    pgtype.Timestamp `json:"run_after"`
+	LockedBy    pgtype.Text      `json:"locked_by"`
+	LockedAt    pgtype.Timestamp `json:"locked_at"`
+	FinishedAt  pgtype.Timestamp `json:"finished_at"`
+	CreatedAt   pgtype.Timestamp `json:"created_at"`
+	UpdatedAt   pgtype.Timestamp `json:"updated_at"`
+}
+
+type JobSchedule struct {
+	Name      string           `json:"name"`
+	Kind      string           `json:"kind"`
+	Payload   json.RawMessage  `json:"payload"`
+	Spec      string           `json:"spec"`
+	NextRunAt pgtype.Timestamp `json:"next_run_at"`
+	LastRunAt pgtype.Timestamp `json:"last_run_at"`
+	UpdatedAt pgtype.Timestamp `json:"updated_at"`
 }
 
 type Permission struct {
@@ -273,15 +467,65 @@ type Session struct {
 }
 
 type Share struct {
-	ID         pgtype.UUID        `json:"id"`
-	ItemType   ItemType           `json:"item_type"`
-	ItemID     pgtype.UUID        `json:"item_id"`
-	Token      string             `json:"token"`
-	CreatedBy  pgtype.UUID        `json:"created_by"`
-	Permission NullPermissionRole `json:"permission"`
-	ExpiresAt  pgtype.Timestamp   `json:"expires_at"`
-	IsActive   pgtype.Bool        `json:"is_active"`
-	CreatedAt  pgtype.Timestamp   `json:"created_at"`
+	ID            pgtype.UUID        `json:"id"`
+	ItemType      ItemType           `json:"item_type"`
+	ItemID        pgtype.UUID        `json:"item_id"`
+	Token         string             `json:"token"`
+	CreatedBy     pgtype.UUID        `json:"created_by"`
+	Permission    NullPermissionRole `json:"permission"`
+	ExpiresAt     pgtype.Timestamp   `json:"expires_at"`
+	IsActive      pgtype.Bool        `json:"is_active"`
+	CreatedAt     pgtype.Timestamp   `json:"created_at"`
+	PasswordHash  pgtype.Text        `json:"-"`
+	MaxDownloads  pgtype.Int4        `json:"max_downloads"`
+	DownloadCount int32              `json:"download_count"`
+	AllowDownload bool               `json:"allow_download"`
+}
+
+type ShareAccessLog struct {
+	ID         pgtype.UUID       `json:"id"`
+	ShareID    pgtype.UUID       `json:"share_id"`
+	Action     ShareAccessAction `json:"action"`
+	ItemType   ItemType          `json:"item_type"`
+	ItemID     pgtype.UUID       `json:"item_id"`
+	UserID     pgtype.UUID       `json:"user_id"`
+	IpAddress  pgtype.Text       `json:"ip_address"`
+	UserAgent  pgtype.Text       `json:"user_agent"`
+	AccessedAt pgtype.Timestamp  `json:"accessed_at"`
+}
+
+type StorageReservation struct {
+	ID              pgtype.UUID      `json:"id"`
+	UserID          pgtype.UUID      `json:"user_id"`
+	UploadSessionID pgtype.UUID      `json:"upload_session_id"`
+	Bytes           int64            `json:"bytes"`
+	ExpiresAt       pgtype.Timestamp `json:"expires_at"`
+	CreatedAt       pgtype.Timestamp `json:"created_at"`
+}
+
+type TrashOperation struct {
+	ID        pgtype.UUID      `json:"id"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	RootType  ItemType         `json:"root_type"`
+	RootID    pgtype.UUID      `json:"root_id"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type UploadSession struct {
+	ID             pgtype.UUID      `json:"id"`
+	UserID         pgtype.UUID      `json:"user_id"`
+	Filename       string           `json:"filename"`
+	MimeType       string           `json:"mime_type"`
+	ParentFolderID pgtype.UUID      `json:"parent_folder_id"`
+	TotalSize      int64            `json:"total_size"`
+	ReceivedBytes  int64            `json:"received_bytes"`
+	ChunkCount     int32            `json:"chunk_count"`
+	Status         UploadStatus     `json:"status"`
+	FileID         pgtype.UUID      `json:"file_id"`
+	ExpiresAt      pgtype.Timestamp `json:"expires_at"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
+	OnConflict     string           `json:"on_conflict"`
 }
 
 type User struct {
@@ -293,4 +537,15 @@ type User struct {
 	StorageLimit   pgtype.Int8      `json:"storage_limit"`
 	CreatedAt      pgtype.Timestamp `json:"created_at"`
 	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
+	IsAdmin        bool             `json:"is_admin"`
+}
+
+type VersionRetentionPolicy struct {
+	ID        pgtype.UUID      `json:"id"`
+	UserID    pgtype.UUID      `json:"user_id"`
+	FileID    pgtype.UUID      `json:"file_id"`
+	KeepLast  pgtype.Int4      `json:"keep_last"`
+	KeepDays  pgtype.Int4      `json:"keep_days"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+	UpdatedAt pgtype.Timestamp `json:"updated_at"`
 }
@@ -0,0 +1,133 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: previews.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const createFilePreview = `-- name: CreateFilePreview :exec
+INSERT INTO file_previews (file_id, size, path, width, height, version)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type CreateFilePreviewParams struct {
+	FileID  pgtype.UUID `json:"file_id"`
+	Size    string      `json:"size"`
+	Path    string      `json:"path"`
+	Width   int32       `json:"width"`
+	Height  int32       `json:"height"`
+	Version int32       `json:"version"`
+}
+
+func (q *Queries) CreateFilePreview(ctx context.Context, arg CreateFilePreviewParams) error {
+	_, err := q.db.Exec(ctx, createFilePreview,
+		arg.FileID,
+		arg.Size,
+		arg.Path,
+		arg.Width,
+		arg.Height,
+		arg.Version,
+	)
+	return err
+}
+
+const deleteFilePreviews = `-- name: DeleteFilePreviews :exec
+DELETE FROM file_previews WHERE file_id = $1
+`
+
+func (q *Queries) DeleteFilePreviews(ctx context.Context, fileID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteFilePreviews, fileID)
+	return err
+}
+
+const getFilePreview = `-- name: GetFilePreview :one
+SELECT file_id, size, path, width, height, version, created_at FROM file_previews WHERE file_id = $1 AND size = $2
+`
+
+type GetFilePreviewParams struct {
+	FileID pgtype.UUID `json:"file_id"`
+	Size   string      `json:"size"`
+}
+
+func (q *Queries) GetFilePreview(ctx context.Context, arg GetFilePreviewParams) (FilePreview, error) {
+	row := q.db.QueryRow(ctx, getFilePreview, arg.FileID, arg.Size)
+	var i FilePreview
+	err := row.Scan(
+		&i.FileID,
+		&i.Size,
+		&i.Path,
+		&i.Width,
+		&i.Height,
+		&i.Version,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getFilePreviews = `-- name: GetFilePreviews :many
+SELECT file_id, size, path, width, height, version, created_at FROM file_previews WHERE file_id = $1 ORDER BY width
+`
+
+func (q *Queries) GetFilePreviews(ctx context.Context, fileID pgtype.UUID) ([]FilePreview, error) {
+	rows, err := q.db.Query(ctx, getFilePreviews, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []FilePreview{}
+	for rows.Next() {
+		var i FilePreview
+		if err := rows.Scan(
+			&i.FileID,
+			&i.Size,
+			&i.Path,
+			&i.Width,
+			&i.Height,
+			&i.Version,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setFilePreview = `-- name: SetFilePreview :execrows
+UPDATE files
+SET thumbnail_path = $2,
+    preview_available = $3
+WHERE id = $1 AND COALESCE(version, 1) = $4::int
+`
+
+type SetFilePreviewParams struct {
+	ID               pgtype.UUID `json:"id"`
+	ThumbnailPath    pgtype.Text `json:"thumbnail_path"`
+	PreviewAvailable pgtype.Bool `json:"preview_available"`
+	Version          int32       `json:"version"`
+}
+
+// Records the outcome of preview generation without touching updated_at,
+// which tracks the file's content. Affects no rows once the file has moved
+// on to another version.
+func (q *Queries) SetFilePreview(ctx context.Context, arg SetFilePreviewParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setFilePreview,
+		arg.ID,
+		arg.ThumbnailPath,
+		arg.PreviewAvailable,
+		arg.Version,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -11,83 +11,266 @@ import (
 )
 
 type Querier interface {
+	AbortUploadSession(ctx context.Context, id pgtype.UUID) error
+	AdvanceJobSchedule(ctx context.Context, arg AdvanceJobScheduleParams) error
+	AdvanceUploadSession(ctx context.Context, arg AdvanceUploadSessionParams) (int64, error)
+	ClaimDueSchedules(ctx context.Context, names []string) ([]JobSchedule, error)
+	// Takes the next due job of the given kinds, or a running one whose worker
+	// stopped heartbeating before stale_before
+	ClaimJob(ctx context.Context, arg ClaimJobParams) (Job, error)
+	ClearFileThumbnail(ctx context.Context, id pgtype.UUID) error
+	// Every outcome query matches the attempt, so a worker whose job was
+	// claimed again after it stalled cannot overwrite the newer attempt
+	CompleteJob(ctx context.Context, arg CompleteJobParams) error
+	CompleteUploadSession(ctx context.Context, arg CompleteUploadSessionParams) (int64, error)
+	// Counts one download against the link's limit. No row means the limit is used up.
+	ConsumeShareDownload(ctx context.Context, id pgtype.UUID) (int32, error)
+	CountJobs(ctx context.Context) ([]CountJobsRow, error)
 	CreateComment(ctx context.Context, arg CreateCommentParams) (Comment, error)
 	CreateFile(ctx context.Context, arg CreateFileParams) (File, error)
+	CreateFilePreview(ctx context.Context, arg CreateFilePreviewParams) error
 	CreateFileVersion(ctx context.Context, arg CreateFileVersionParams) (FileVersion, error)
 	CreateFolder(ctx context.Context, arg CreateFolderParams) (Folder, error)
 	CreatePermission(ctx context.Context, arg CreatePermissionParams) (Permission, error)
 	CreateSession(ctx context.Context, arg CreateSessionParams) (Session, error)
 	CreateShare(ctx context.Context, arg CreateShareParams) (Share, error)
+	CreateStorageReservation(ctx context.Context, arg CreateStorageReservationParams) (StorageReservation, error)
+	CreateTrashOperation(ctx context.Context, arg CreateTrashOperationParams) (TrashOperation, error)
+	CreateUploadSession(ctx context.Context, arg CreateUploadSessionParams) (UploadSession, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
 	DeactivateShare(ctx context.Context, id pgtype.UUID) error
+	DeleteBlobRef(ctx context.Context, arg DeleteBlobRefParams) error
+	DeleteBlobRefForRepair(ctx context.Context, arg DeleteBlobRefForRepairParams) error
 	DeleteComment(ctx context.Context, id pgtype.UUID) error
 	DeleteExpiredSessions(ctx context.Context) error
+	DeleteExpiredStorageReservations(ctx context.Context) (int64, error)
+	DeleteFileContent(ctx context.Context, fileID pgtype.UUID) error
+	DeleteFilePreviews(ctx context.Context, fileID pgtype.UUID) error
+	DeleteFileRetentionPolicy(ctx context.Context, fileID pgtype.UUID) error
 	DeleteFileVersions(ctx context.Context, fileID pgtype.UUID) error
+	DeleteFinishedJobs(ctx context.Context, arg DeleteFinishedJobsParams) (int64, error)
+	// Removes operations none of whose items are still in trash
+	DeleteFinishedTrashOperations(ctx context.Context) (int64, error)
+	// Deletes a version unless it is pinned or has become the file's current version
+	DeletePrunableFileVersion(ctx context.Context, id pgtype.UUID) (DeletePrunableFileVersionRow, error)
 	DeleteSession(ctx context.Context, token string) error
+	DeleteStorageReservation(ctx context.Context, id pgtype.UUID) error
+	// Drops a job whose retry is covered by a pending job with the same key
+	DeleteSupersededJob(ctx context.Context, arg DeleteSupersededJobParams) error
+	DeleteUnreferencedBlob(ctx context.Context, digest string) (int64, error)
+	DeleteUploadReservation(ctx context.Context, uploadSessionID pgtype.UUID) error
+	DeleteUploadSession(ctx context.Context, id pgtype.UUID) error
+	DeleteUserRetentionPolicy(ctx context.Context, userID pgtype.UUID) error
 	DeleteUserSessions(ctx context.Context, userID pgtype.UUID) error
+	// A job with a unique_key that is already pending is updated instead: it
+	// takes the new payload and runs no later than the new run_after
+	EnqueueJob(ctx context.Context, arg EnqueueJobParams) (Job, error)
+	FailJob(ctx context.Context, arg FailJobParams) error
+	// Lists the active files in folder_id and in every active folder below it
+	GetActiveSubtreeFiles(ctx context.Context, folderID pgtype.UUID) ([]File, error)
+	// Lists folder_id and every active folder below it, parents before children.
+	// ids stops the walk if folders form a cycle.
+	GetActiveSubtreeFolders(ctx context.Context, folderID pgtype.UUID) ([]Folder, error)
 	GetActivityTimeline(ctx context.Context, arg GetActivityTimelineParams) ([]GetActivityTimelineRow, error)
+	GetBlob(ctx context.Context, digest string) (Blob, error)
+	// Compares blob_refs with the versions that actually use each blob
+	GetBlobRefMismatches(ctx context.Context) ([]GetBlobRefMismatchesRow, error)
+	// Lists the names of the active files directly in folder_id, or at the top of
+	// owner_id's drive when folder_id is null, leaving out exclude_id
+	GetChildFileNames(ctx context.Context, arg GetChildFileNamesParams) ([]string, error)
+	// Finds the oldest active folder called name directly in folder_id, or at the
+	// top of owner_id's drive when folder_id is null
+	GetChildFolderByName(ctx context.Context, arg GetChildFolderByNameParams) (Folder, error)
+	// Lists the names of the active folders directly in folder_id, or at the top
+	// of owner_id's drive when folder_id is null, leaving out exclude_id
+	GetChildFolderNames(ctx context.Context, arg GetChildFolderNamesParams) ([]string, error)
 	GetComment(ctx context.Context, id pgtype.UUID) (Comment, error)
 	GetCommentsByUser(ctx context.Context, arg GetCommentsByUserParams) ([]GetCommentsByUserRow, error)
+	// Recomputes storage_used from the versions each user owns: every distinct
+	// blob once, plus every version stored before deduplication
+	GetComputedStorageUsage(ctx context.Context) ([]GetComputedStorageUsageRow, error)
+	// Excerpts of the files' text around the words of a websearch query, for
+	// files whose text matches it
+	GetContentSnippets(ctx context.Context, arg GetContentSnippetsParams) ([]GetContentSnippetsRow, error)
 	GetDashboardActivity(ctx context.Context, arg GetDashboardActivityParams) ([]GetDashboardActivityRow, error)
+	// Resolves the strongest role a user holds on an item. Owning the item or
+	// any ancestor folder makes the user an owner; otherwise the highest grant on
+	// the item or on any ancestor folder applies. UNION (not UNION ALL) stops the
+	// walk if parent_folder_id ever forms a cycle. No row means no access.
+	GetEffectiveRole(ctx context.Context, arg GetEffectiveRoleParams) (PermissionRole, error)
+	GetExpiredUploadSessions(ctx context.Context) ([]UploadSession, error)
 	GetFileActivity(ctx context.Context, arg GetFileActivityParams) ([]GetFileActivityRow, error)
 	GetFileByID(ctx context.Context, id pgtype.UUID) (File, error)
 	GetFileByIDAnyStatus(ctx context.Context, id pgtype.UUID) (File, error)
+	// Files in a folder may belong to other users; owner_id only scopes the top
+	// of a drive
 	GetFileByNameAndFolder(ctx context.Context, arg GetFileByNameAndFolderParams) (File, error)
 	GetFileComments(ctx context.Context, fileID pgtype.UUID) ([]GetFileCommentsRow, error)
+	GetFilePreview(ctx context.Context, arg GetFilePreviewParams) (FilePreview, error)
+	GetFilePreviews(ctx context.Context, fileID pgtype.UUID) ([]FilePreview, error)
+	GetFileRetentionPolicy(ctx context.Context, fileID pgtype.UUID) (VersionRetentionPolicy, error)
 	GetFileVersion(ctx context.Context, id pgtype.UUID) (GetFileVersionRow, error)
 	GetFileVersions(ctx context.Context, fileID pgtype.UUID) ([]GetFileVersionsRow, error)
-	GetFilesByFolder(ctx context.Context, arg GetFilesByFolderParams) ([]File, error)
+	GetFileVersionsForDeletion(ctx context.Context, fileID pgtype.UUID) ([]GetFileVersionsForDeletionRow, error)
+	// Lists every file in the folder whoever owns it; callers check access to the folder
+	GetFilesByFolder(ctx context.Context, parentFolderID pgtype.UUID) ([]File, error)
+	GetFilesByIDs(ctx context.Context, ids []pgtype.UUID) ([]File, error)
 	GetFilesByOwner(ctx context.Context, arg GetFilesByOwnerParams) ([]File, error)
 	GetFilesInTrashOlderThan(ctx context.Context, dollar_1 interface{}) ([]File, error)
+	// Lists folder_id and every folder above it, top-most first, whatever their
+	// status. ids stops the walk if folders form a cycle.
+	GetFolderAncestors(ctx context.Context, folderID pgtype.UUID) ([]Folder, error)
 	GetFolderByID(ctx context.Context, id pgtype.UUID) (Folder, error)
 	GetFolderByIDAnyStatus(ctx context.Context, id pgtype.UUID) (Folder, error)
+	// Lists folder_id and every active folder below it, or every active folder of
+	// owner_id's drive when folder_id is null, parents first, with the number and
+	// total size of the active files directly in each. ids stops the walk if
+	// folders form a cycle.
+	GetFolderTreeStats(ctx context.Context, arg GetFolderTreeStatsParams) ([]GetFolderTreeStatsRow, error)
+	GetFoldersByIDs(ctx context.Context, ids []pgtype.UUID) ([]Folder, error)
 	GetFoldersByOwner(ctx context.Context, ownerID pgtype.UUID) ([]Folder, error)
 	GetFoldersInTrashOlderThan(ctx context.Context, dollar_1 interface{}) ([]Folder, error)
+	// Files that uploads from before uploads ran in one transaction recorded
+	// without finishing: content in the blob store but no version row for it, or
+	// a version number ahead of the newest version row
+	GetInterruptedUploads(ctx context.Context, before pgtype.Timestamp) ([]File, error)
 	GetItemPermissions(ctx context.Context, arg GetItemPermissionsParams) ([]GetItemPermissionsRow, error)
-	GetLatestVersionNumber(ctx context.Context, fileID pgtype.UUID) (interface{}, error)
+	GetJob(ctx context.Context, id pgtype.UUID) (Job, error)
+	GetLatestFileVersion(ctx context.Context, fileID pgtype.UUID) (FileVersion, error)
+	GetLatestVersionNumber(ctx context.Context, fileID pgtype.UUID) (int32, error)
+	// Walks up from folder_id (inclusive) to the first folder that is not in trash.
+	// No row means every ancestor is trashed or deleted.
+	GetNearestActiveAncestor(ctx context.Context, folderID pgtype.UUID) (Folder, error)
+	// Versions outside their effective retention policy. The current version and
+	// pinned versions are never returned. owner_id and file_id optionally narrow the scan.
+	GetPrunableVersions(ctx context.Context, arg GetPrunableVersionsParams) ([]GetPrunableVersionsRow, error)
+	// Reservations of resumable uploads count until their session expires
+	GetQuotaUsage(ctx context.Context, id pgtype.UUID) (GetQuotaUsageRow, error)
 	GetRecentFiles(ctx context.Context, arg GetRecentFilesParams) ([]File, error)
 	GetRecentStorageGrowth(ctx context.Context, ownerID pgtype.UUID) ([]GetRecentStorageGrowthRow, error)
+	// Counts the active files at the top of owner_id's drive and their total size
+	GetRootFileStats(ctx context.Context, ownerID pgtype.UUID) (GetRootFileStatsRow, error)
 	GetRootFiles(ctx context.Context, ownerID pgtype.UUID) ([]File, error)
 	GetRootFolder(ctx context.Context, ownerID pgtype.UUID) (Folder, error)
 	GetRootFolders(ctx context.Context, ownerID pgtype.UUID) ([]Folder, error)
 	GetSessionByToken(ctx context.Context, token string) (GetSessionByTokenRow, error)
+	GetShareAccessLog(ctx context.Context, arg GetShareAccessLogParams) ([]GetShareAccessLogRow, error)
+	GetShareByID(ctx context.Context, id pgtype.UUID) (Share, error)
 	GetShareByToken(ctx context.Context, token string) (Share, error)
 	GetSharedWithMeFiles(ctx context.Context, userID pgtype.UUID) ([]GetSharedWithMeFilesRow, error)
 	GetSharedWithMeFolders(ctx context.Context, userID pgtype.UUID) ([]GetSharedWithMeFoldersRow, error)
-	GetSharesByItem(ctx context.Context, arg GetSharesByItemParams) ([]Share, error)
+	GetSharesByItem(ctx context.Context, arg GetSharesByItemParams) ([]GetSharesByItemRow, error)
 	GetStarredFiles(ctx context.Context, ownerID pgtype.UUID) ([]File, error)
 	GetStarredFolders(ctx context.Context, ownerID pgtype.UUID) ([]Folder, error)
 	GetStorageByFileType(ctx context.Context, ownerID pgtype.UUID) ([]GetStorageByFileTypeRow, error)
 	GetStorageUsage(ctx context.Context, id pgtype.UUID) (GetStorageUsageRow, error)
 	GetSubfolders(ctx context.Context, parentFolderID pgtype.UUID) ([]Folder, error)
+	// Lists every file below folder_id, whatever its status
+	GetSubtreeFilesForDeletion(ctx context.Context, folderID pgtype.UUID) ([]File, error)
+	// Files trashed together with their folder are listed through the folder
 	GetTrashedFiles(ctx context.Context, ownerID pgtype.UUID) ([]File, error)
+	// Subfolders trashed together with their parent are listed through the parent
 	GetTrashedFolders(ctx context.Context, ownerID pgtype.UUID) ([]Folder, error)
+	// Sums the sizes of the given blobs that owner_id holds no reference on,
+	// which is what taking a reference on each would charge the owner
+	GetUnreferencedBlobBytes(ctx context.Context, arg GetUnreferencedBlobBytesParams) (int64, error)
+	GetUploadSession(ctx context.Context, id pgtype.UUID) (UploadSession, error)
 	GetUserActivity(ctx context.Context, arg GetUserActivityParams) ([]GetUserActivityRow, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
 	GetUserByID(ctx context.Context, id pgtype.UUID) (User, error)
 	GetUserPermissionForItem(ctx context.Context, arg GetUserPermissionForItemParams) (Permission, error)
+	GetUserRetentionPolicy(ctx context.Context, userID pgtype.UUID) (VersionRetentionPolicy, error)
 	GetUserStorageStats(ctx context.Context, id pgtype.UUID) (GetUserStorageStatsRow, error)
+	HasOpenJob(ctx context.Context, uniqueKey pgtype.Text) (bool, error)
+	HeartbeatJob(ctx context.Context, arg HeartbeatJobParams) error
+	// Reports whether folder_id is root_id or one of its active descendants.
+	// The walk stops at trashed folders, so their contents are out of reach.
+	IsFolderWithin(ctx context.Context, arg IsFolderWithinParams) (bool, error)
+	ListBlobsForCheck(ctx context.Context) ([]ListBlobsForCheckRow, error)
+	ListFilesForCheck(ctx context.Context) ([]ListFilesForCheckRow, error)
+	ListJobSchedules(ctx context.Context) ([]JobSchedule, error)
+	ListJobs(ctx context.Context, arg ListJobsParams) ([]Job, error)
+	// Versions stored before deduplication own their object directly
+	ListLegacyVersionsForCheck(ctx context.Context) ([]ListLegacyVersionsForCheckRow, error)
+	ListPendingUploadSessionIDs(ctx context.Context) ([]pgtype.UUID, error)
+	ListPreviewsForCheck(ctx context.Context) ([]ListPreviewsForCheckRow, error)
+	LockBlob(ctx context.Context, digest string) (Blob, error)
+	LockFile(ctx context.Context, id pgtype.UUID) (File, error)
+	// Serializes changes to a folder's children; hold it for the rest of the transaction
+	LockFolder(ctx context.Context, id pgtype.UUID) (pgtype.UUID, error)
+	// Serializes folder moves, so two moves cannot each pass the cycle check and
+	// together form a loop; held until the transaction ends
+	LockFolderMoves(ctx context.Context) error
+	// Chunks of a session are accepted one at a time
+	LockUploadSession(ctx context.Context, id pgtype.UUID) (UploadSession, error)
+	// Serializes reservations for a user; hold it for the rest of the transaction
+	LockUserQuota(ctx context.Context, id pgtype.UUID) (pgtype.UUID, error)
 	LogActivity(ctx context.Context, arg LogActivityParams) error
+	LogShareAccess(ctx context.Context, arg LogShareAccessParams) error
 	MoveFile(ctx context.Context, arg MoveFileParams) error
 	MoveFolder(ctx context.Context, arg MoveFolderParams) error
+	// Removes the file row along with the grants and share links that point at it.
+	// Versions, comments and activity go with the row through their foreign keys.
 	PermanentDeleteFile(ctx context.Context, id pgtype.UUID) error
-	PermanentDeleteFolder(ctx context.Context, id pgtype.UUID) error
+	// Removes folder_id and every folder below it, along with the grants and share
+	// links that point at them. Files must be purged first: deleting a folder only
+	// detaches the files still in it.
+	PermanentDeleteFolderTree(ctx context.Context, folderID pgtype.UUID) (int64, error)
+	ReleaseBlobRef(ctx context.Context, arg ReleaseBlobRefParams) (int32, error)
 	RenameFile(ctx context.Context, arg RenameFileParams) error
 	RenameFolder(ctx context.Context, arg RenameFolderParams) error
-	RestoreFile(ctx context.Context, id pgtype.UUID) error
-	RestoreFolder(ctx context.Context, id pgtype.UUID) error
+	// Follows names down from folder_id, or from the top of owner_id's drive when
+	// folder_id is null, to the active folder they lead to. Among folders with
+	// the same name the oldest is taken.
+	ResolveFolderPath(ctx context.Context, arg ResolveFolderPathParams) (Folder, error)
+	RestoreFile(ctx context.Context, arg RestoreFileParams) error
+	RestoreFolder(ctx context.Context, arg RestoreFolderParams) error
+	// Restores the files trashed by operation_id inside folder_id's part of the
+	// operation. Run before RestoreOperationFolders, which clears the folder marks.
+	RestoreOperationFiles(ctx context.Context, arg RestoreOperationFilesParams) (int64, error)
+	// Restores the folders below folder_id that were trashed by operation_id.
+	// folder_id itself is restored with RestoreFolder, which also sets its parent.
+	RestoreOperationFolders(ctx context.Context, arg RestoreOperationFoldersParams) (int64, error)
+	RetainBlobRef(ctx context.Context, arg RetainBlobRefParams) (int32, error)
+	// Returns no row when the job is not failed or its key is already pending
+	RetryFailedJob(ctx context.Context, id pgtype.UUID) (Job, error)
+	// Affects no rows when the same key was enqueued again meanwhile
+	RetryJob(ctx context.Context, arg RetryJobParams) (int64, error)
 	RevokePermission(ctx context.Context, arg RevokePermissionParams) error
 	SearchFilesByName(ctx context.Context, arg SearchFilesByNameParams) ([]File, error)
 	SearchFilesByType(ctx context.Context, arg SearchFilesByTypeParams) ([]File, error)
 	SearchUsersByEmail(ctx context.Context, dollar_1 pgtype.Text) ([]SearchUsersByEmailRow, error)
+	SetBlobRefCount(ctx context.Context, arg SetBlobRefCountParams) error
+	SetFileBroken(ctx context.Context, arg SetFileBrokenParams) error
+	// Stores the text extracted from a version of a file. Affects no rows once
+	// the file has moved on to another version.
+	SetFileContent(ctx context.Context, arg SetFileContentParams) (int64, error)
+	// Records the outcome of preview generation without touching updated_at,
+	// which tracks the file's content. Affects no rows once the file has moved
+	// on to another version.
+	SetFilePreview(ctx context.Context, arg SetFilePreviewParams) (int64, error)
+	SetFileStarred(ctx context.Context, arg SetFileStarredParams) error
+	SetFileVersionPinned(ctx context.Context, arg SetFileVersionPinnedParams) error
+	SetFolderStarred(ctx context.Context, arg SetFolderStarredParams) error
+	SetUserStorageLimit(ctx context.Context, arg SetUserStorageLimitParams) (SetUserStorageLimitRow, error)
+	SetUserStorageUsed(ctx context.Context, arg SetUserStorageUsedParams) error
 	ToggleStarFile(ctx context.Context, id pgtype.UUID) error
 	ToggleStarFolder(ctx context.Context, id pgtype.UUID) error
-	TrashFile(ctx context.Context, id pgtype.UUID) error
-	TrashFolder(ctx context.Context, id pgtype.UUID) error
+	TrashFile(ctx context.Context, arg TrashFileParams) error
+	// Trashes folder_id and every active folder below it as part of operation_id
+	TrashFolderTree(ctx context.Context, arg TrashFolderTreeParams) (int64, error)
+	// Trashes the active files inside the folders trashed by operation_id
+	TrashOperationFiles(ctx context.Context, operationID pgtype.UUID) (int64, error)
 	UpdateComment(ctx context.Context, arg UpdateCommentParams) (Comment, error)
 	UpdateFileStorageAndVersion(ctx context.Context, arg UpdateFileStorageAndVersionParams) error
 	UpdateLastAccessed(ctx context.Context, id pgtype.UUID) error
 	UpdateUserStorage(ctx context.Context, arg UpdateUserStorageParams) error
+	// Inserts the blob row or locks the existing one for the rest of the transaction
+	UpsertBlob(ctx context.Context, arg UpsertBlobParams) (Blob, error)
+	UpsertFileRetentionPolicy(ctx context.Context, arg UpsertFileRetentionPolicyParams) (VersionRetentionPolicy, error)
+	// Keeps the planned next run unless the schedule itself changed
+	UpsertJobSchedule(ctx context.Context, arg UpsertJobScheduleParams) error
+	UpsertUserRetentionPolicy(ctx context.Context, arg UpsertUserRetentionPolicyParams) (VersionRetentionPolicy, error)
 }
 
 var _ Querier = (*Queries)(nil)
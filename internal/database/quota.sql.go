@@ -0,0 +1,153 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: quota.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const createStorageReservation = `-- name: CreateStorageReservation :one
+INSERT INTO storage_reservations (user_id, upload_session_id, bytes, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING id, user_id, upload_session_id, bytes, expires_at, created_at
+`
+
+type CreateStorageReservationParams struct {
+	UserID          pgtype.UUID      `json:"user_id"`
+	UploadSessionID pgtype.UUID      `json:"upload_session_id"`
+	Bytes           int64            `json:"bytes"`
+	ExpiresAt       pgtype.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CreateStorageReservation(ctx context.Context, arg CreateStorageReservationParams) (StorageReservation, error) {
+	row := q.db.QueryRow(ctx, createStorageReservation,
+		arg.UserID,
+		arg.UploadSessionID,
+		arg.Bytes,
+		arg.ExpiresAt,
+	)
+	var i StorageReservation
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.UploadSessionID,
+		&i.Bytes,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteExpiredStorageReservations = `-- name: DeleteExpiredStorageReservations :execrows
+DELETE FROM storage_reservations r
+WHERE r.expires_at < NOW()
+  AND (r.upload_session_id IS NULL OR NOT EXISTS (
+      SELECT 1 FROM upload_sessions s
+      WHERE s.id = r.upload_session_id AND s.expires_at > NOW()
+  ))
+`
+
+func (q *Queries) DeleteExpiredStorageReservations(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredStorageReservations)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteStorageReservation = `-- name: DeleteStorageReservation :exec
+DELETE FROM storage_reservations WHERE id = $1
+`
+
+func (q *Queries) DeleteStorageReservation(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteStorageReservation, id)
+	return err
+}
+
+const deleteUploadReservation = `-- name: DeleteUploadReservation :exec
+DELETE FROM storage_reservations WHERE upload_session_id = $1
+`
+
+func (q *Queries) DeleteUploadReservation(ctx context.Context, uploadSessionID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteUploadReservation, uploadSessionID)
+	return err
+}
+
+const getQuotaUsage = `-- name: GetQuotaUsage :one
+SELECT
+    COALESCE(u.storage_used, 0)::bigint AS storage_used,
+    COALESCE(u.storage_limit, 0)::bigint AS storage_limit,
+    COALESCE((
+        SELECT SUM(r.bytes)
+        FROM storage_reservations r
+        LEFT JOIN upload_sessions s ON s.id = r.upload_session_id
+        WHERE r.user_id = u.id
+          AND COALESCE(s.expires_at, r.expires_at) > NOW()
+    ), 0)::bigint AS storage_reserved
+FROM users u
+WHERE u.id = $1
+`
+
+type GetQuotaUsageRow struct {
+	StorageUsed     int64 `json:"storage_used"`
+	StorageLimit    int64 `json:"storage_limit"`
+	StorageReserved int64 `json:"storage_reserved"`
+}
+
+// Reservations of resumable uploads count until their session expires
+func (q *Queries) GetQuotaUsage(ctx context.Context, id pgtype.UUID) (GetQuotaUsageRow, error) {
+	row := q.db.QueryRow(ctx, getQuotaUsage, id)
+	var i GetQuotaUsageRow
+	err := row.Scan(&i.StorageUsed, &i.StorageLimit, &i.StorageReserved)
+	return i, err
+}
+
+const lockUserQuota = `-- name: LockUserQuota :one
+SELECT id FROM users WHERE id = $1 FOR UPDATE
+`
+
+// Serializes reservations for a user; hold it for the rest of the transaction
+func (q *Queries) LockUserQuota(ctx context.Context, id pgtype.UUID) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, lockUserQuota, id)
+	err := row.Scan(&id)
+	return id, err
+}
+
+const setUserStorageLimit = `-- name: SetUserStorageLimit :one
+UPDATE users
+SET storage_limit = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, email, name, storage_used, storage_limit
+`
+
+type SetUserStorageLimitParams struct {
+	ID           pgtype.UUID `json:"id"`
+	StorageLimit pgtype.Int8 `json:"storage_limit"`
+}
+
+type SetUserStorageLimitRow struct {
+	ID           pgtype.UUID `json:"id"`
+	Email        string      `json:"email"`
+	Name         string      `json:"name"`
+	StorageUsed  pgtype.Int8 `json:"storage_used"`
+	StorageLimit pgtype.Int8 `json:"storage_limit"`
+}
+
+func (q *Queries) SetUserStorageLimit(ctx context.Context, arg SetUserStorageLimitParams) (SetUserStorageLimitRow, error) {
+	row := q.db.QueryRow(ctx, setUserStorageLimit, arg.ID, arg.StorageLimit)
+	var i SetUserStorageLimitRow
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.Name,
+		&i.StorageUsed,
+		&i.StorageLimit,
+	)
+	return i, err
+}
@@ -11,6 +11,22 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const consumeShareDownload = `-- name: ConsumeShareDownload :one
+UPDATE shares
+SET download_count = download_count + 1
+WHERE id = $1
+  AND (max_downloads IS NULL OR download_count < max_downloads)
+RETURNING download_count
+`
+
+// Counts one download against the link's limit. No row means the limit is used up.
+func (q *Queries) ConsumeShareDownload(ctx context.Context, id pgtype.UUID) (int32, error) {
+	row := q.db.QueryRow(ctx, consumeShareDownload, id)
+	var download_count int32
+	err := row.Scan(&download_count)
+	return download_count, err
+}
+
 const createPermission = `-- name: CreatePermission :one
 INSERT INTO permissions (item_type, item_id, user_id, role, granted_by)
 VALUES ($1, $2, $3, $4, $5)
@@ -49,18 +65,24 @@ func (q *Queries) CreatePermission(ctx context.Context, arg CreatePermissionPara
 }
 
 const createShare = `-- name: CreateShare :one
-INSERT INTO shares (item_type, item_id, token, created_by, permission, expires_at)
-VALUES ($1, $2, $3, $4, $5, $6)
-RETURNING id, item_type, item_id, token, created_by, permission, expires_at, is_active, created_at
+INSERT INTO shares (
+    item_type, item_id, token, created_by, permission, expires_at,
+    password_hash, max_downloads, allow_download
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id, item_type, item_id, token, created_by, permission, expires_at, is_active, created_at, password_hash, max_downloads, download_count, allow_download
 `
 
 type CreateShareParams struct {
-	ItemType   ItemType           `json:"item_type"`
-	ItemID     pgtype.UUID        `json:"item_id"`
-	Token      string             `json:"token"`
-	CreatedBy  pgtype.UUID        `json:"created_by"`
-	Permission NullPermissionRole `json:"permission"`
-	ExpiresAt  pgtype.Timestamp   `json:"expires_at"`
+	ItemType      ItemType           `json:"item_type"`
+	ItemID        pgtype.UUID        `json:"item_id"`
+	Token         string             `json:"token"`
+	CreatedBy     pgtype.UUID        `json:"created_by"`
+	Permission    NullPermissionRole `json:"permission"`
+	ExpiresAt     pgtype.Timestamp   `json:"expires_at"`
+	PasswordHash  pgtype.Text        `json:"-"`
+	MaxDownloads  pgtype.Int4        `json:"max_downloads"`
+	AllowDownload bool               `json:"allow_download"`
 }
 
 func (q *Queries) CreateShare(ctx context.Context, arg CreateShareParams) (Share, error) {
@@ -71,6 +93,9 @@ func (q *Queries) CreateShare(ctx context.Context, arg CreateShareParams) (Share
 		arg.CreatedBy,
 		arg.Permission,
 		arg.ExpiresAt,
+		arg.PasswordHash,
+		arg.MaxDownloads,
+		arg.AllowDownload,
 	)
 	var i Share
 	err := row.Scan(
@@ -83,6 +108,10 @@ func (q *Queries) CreateShare(ctx context.Context, arg CreateShareParams) (Share
 		&i.ExpiresAt,
 		&i.IsActive,
 		&i.CreatedAt,
+		&i.PasswordHash,
+		&i.MaxDownloads,
+		&i.DownloadCount,
+		&i.AllowDownload,
 	)
 	return i, err
 }
@@ -96,6 +125,51 @@ func (q *Queries) DeactivateShare(ctx context.Context, id pgtype.UUID) error {
 	return err
 }
 
+const getEffectiveRole = `-- name: GetEffectiveRole :one
+WITH RECURSIVE chain (item_type, item_id, owner_id, parent_id) AS (
+    SELECT 'file'::item_type, f.id, f.owner_id, f.parent_folder_id
+    FROM files f
+    WHERE $2::item_type = 'file' AND f.id = $3::uuid
+    UNION ALL
+    SELECT 'folder'::item_type, fo.id, fo.owner_id, fo.parent_folder_id
+    FROM folders fo
+    WHERE $2::item_type = 'folder' AND fo.id = $3::uuid
+    UNION
+    SELECT 'folder'::item_type, parent.id, parent.owner_id, parent.parent_folder_id
+    FROM folders parent
+    JOIN chain c ON parent.id = c.parent_id
+)
+SELECT MAX(grants.role)::permission_role AS role
+FROM (
+    SELECT 'owner'::permission_role AS role
+    FROM chain
+    WHERE chain.owner_id = $1::uuid
+    UNION ALL
+    SELECT p.role
+    FROM permissions p
+    JOIN chain c ON p.item_type = c.item_type AND p.item_id = c.item_id
+    WHERE p.user_id = $1::uuid
+) grants
+HAVING MAX(grants.role) IS NOT NULL
+`
+
+type GetEffectiveRoleParams struct {
+	UserID   pgtype.UUID `json:"user_id"`
+	ItemType ItemType    `json:"item_type"`
+	ItemID   pgtype.UUID `json:"item_id"`
+}
+
+// Resolves the strongest role a user holds on an item. Owning the item or
+// any ancestor folder makes the user an owner; otherwise the highest grant on
+// the item or on any ancestor folder applies. UNION (not UNION ALL) stops the
+// walk if parent_folder_id ever forms a cycle. No row means no access.
+func (q *Queries) GetEffectiveRole(ctx context.Context, arg GetEffectiveRoleParams) (PermissionRole, error) {
+	row := q.db.QueryRow(ctx, getEffectiveRole, arg.UserID, arg.ItemType, arg.ItemID)
+	var role PermissionRole
+	err := row.Scan(&role)
+	return role, err
+}
+
 const getItemPermissions = `-- name: GetItemPermissions :many
 SELECT p.id, p.item_type, p.item_id, p.user_id, p.role, p.granted_by, p.created_at, u.email, u.name as user_name
 FROM permissions p
@@ -150,8 +224,93 @@ func (q *Queries) GetItemPermissions(ctx context.Context, arg GetItemPermissions
 	return items, nil
 }
 
+const getShareAccessLog = `-- name: GetShareAccessLog :many
+SELECT l.id, l.share_id, l.action, l.item_type, l.item_id, l.user_id, l.ip_address, l.user_agent, l.accessed_at, u.email, u.name AS user_name
+FROM share_access_log l
+LEFT JOIN users u ON l.user_id = u.id
+WHERE l.share_id = $1
+ORDER BY l.accessed_at DESC
+LIMIT $2
+`
+
+type GetShareAccessLogParams struct {
+	ShareID pgtype.UUID `json:"share_id"`
+	Limit   int32       `json:"limit"`
+}
+
+type GetShareAccessLogRow struct {
+	ID         pgtype.UUID       `json:"id"`
+	ShareID    pgtype.UUID       `json:"share_id"`
+	Action     ShareAccessAction `json:"action"`
+	ItemType   ItemType          `json:"item_type"`
+	ItemID     pgtype.UUID       `json:"item_id"`
+	UserID     pgtype.UUID       `json:"user_id"`
+	IpAddress  pgtype.Text       `json:"ip_address"`
+	UserAgent  pgtype.Text       `json:"user_agent"`
+	AccessedAt pgtype.Timestamp  `json:"accessed_at"`
+	Email      pgtype.Text       `json:"email"`
+	UserName   pgtype.Text       `json:"user_name"`
+}
+
+func (q *Queries) GetShareAccessLog(ctx context.Context, arg GetShareAccessLogParams) ([]GetShareAccessLogRow, error) {
+	rows, err := q.db.Query(ctx, getShareAccessLog, arg.ShareID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetShareAccessLogRow{}
+	for rows.Next() {
+		var i GetShareAccessLogRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ShareID,
+			&i.Action,
+			&i.ItemType,
+			&i.ItemID,
+			&i.UserID,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.AccessedAt,
+			&i.Email,
+			&i.UserName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getShareByID = `-- name: GetShareByID :one
+SELECT id, item_type, item_id, token, created_by, permission, expires_at, is_active, created_at, password_hash, max_downloads, download_count, allow_download FROM shares WHERE id = $1
+`
+
+func (q *Queries) GetShareByID(ctx context.Context, id pgtype.UUID) (Share, error) {
+	row := q.db.QueryRow(ctx, getShareByID, id)
+	var i Share
+	err := row.Scan(
+		&i.ID,
+		&i.ItemType,
+		&i.ItemID,
+		&i.Token,
+		&i.CreatedBy,
+		&i.Permission,
+		&i.ExpiresAt,
+		&i.IsActive,
+		&i.CreatedAt,
+		&i.PasswordHash,
+		&i.MaxDownloads,
+		&i.DownloadCount,
+		&i.AllowDownload,
+	)
+	return i, err
+}
+
 const getShareByToken = `-- name: GetShareByToken :one
-SELECT id, item_type, item_id, token, created_by, permission, expires_at, is_active, created_at FROM shares WHERE token = $1 AND is_active = TRUE
+SELECT id, item_type, item_id, token, created_by, permission, expires_at, is_active, created_at, password_hash, max_downloads, download_count, allow_download FROM shares WHERE token = $1 AND is_active = TRUE
 `
 
 func (q *Queries) GetShareByToken(ctx context.Context, token string) (Share, error) {
@@ -167,12 +326,16 @@ func (q *Queries) GetShareByToken(ctx context.Context, token string) (Share, err
 		&i.ExpiresAt,
 		&i.IsActive,
 		&i.CreatedAt,
+		&i.PasswordHash,
+		&i.MaxDownloads,
+		&i.DownloadCount,
+		&i.AllowDownload,
 	)
 	return i, err
 }
 
 const getSharedWithMeFiles = `-- name: GetSharedWithMeFiles :many
-SELECT f.id, f.name, f.original_name, f.mime_type, f.size, f.storage_path, f.owner_id, f.parent_folder_id, f.status, f.is_starred, f.thumbnail_path, f.preview_available, f.version, f.current_version_id, f.created_at, f.updated_at, f.trashed_at, f.last_accessed_at, u.name as owner_name, p.role
+SELECT f.id, f.name, f.original_name, f.mime_type, f.size, f.storage_path, f.owner_id, f.parent_folder_id, f.status, f.is_starred, f.thumbnail_path, f.preview_available, f.version, f.current_version_id, f.created_at, f.updated_at, f.trashed_at, f.last_accessed_at, f.trash_operation_id, f.broken_at, u.name as owner_name, p.role
 FROM files f
 JOIN permissions p ON p.item_type = 'file' AND p.item_id = f.id
 JOIN users u ON f.owner_id = u.id
@@ -198,6 +361,8 @@ type GetSharedWithMeFilesRow struct {
 	UpdatedAt        pgtype.Timestamp `json:"updated_at"`
 	TrashedAt        pgtype.Timestamp `json:"trashed_at"`
 	LastAccessedAt   pgtype.Timestamp `json:"last_accessed_at"`
+	TrashOperationID pgtype.UUID      `json:"trash_operation_id"`
+	BrokenAt         pgtype.Timestamp `json:"broken_at"`
 	OwnerName        string           `json:"owner_name"`
 	Role             PermissionRole   `json:"role"`
 }
@@ -230,6 +395,8 @@ func (q *Queries) GetSharedWithMeFiles(ctx context.Context, userID pgtype.UUID)
 			&i.UpdatedAt,
 			&i.TrashedAt,
 			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
 			&i.OwnerName,
 			&i.Role,
 		); err != nil {
@@ -244,7 +411,7 @@ func (q *Queries) GetSharedWithMeFiles(ctx context.Context, userID pgtype.UUID)
 }
 
 const getSharedWithMeFolders = `-- name: GetSharedWithMeFolders :many
-SELECT fo.id, fo.name, fo.owner_id, fo.parent_folder_id, fo.is_root, fo.status, fo.is_starred, fo.created_at, fo.updated_at, fo.trashed_at, u.name as owner_name, p.role
+SELECT fo.id, fo.name, fo.owner_id, fo.parent_folder_id, fo.is_root, fo.status, fo.is_starred, fo.created_at, fo.updated_at, fo.trashed_at, fo.trash_operation_id, u.name as owner_name, p.role
 FROM folders fo
 JOIN permissions p ON p.item_type = 'folder' AND p.item_id = fo.id
 JOIN users u ON fo.owner_id = u.id
@@ -252,18 +419,19 @@ WHERE p.user_id = $1 AND fo.status = 'active'
 `
 
 type GetSharedWithMeFoldersRow struct {
-	ID             pgtype.UUID      `json:"id"`
-	Name           string           `json:"name"`
-	OwnerID        pgtype.UUID      `json:"owner_id"`
-	ParentFolderID pgtype.UUID      `json:"parent_folder_id"`
-	IsRoot         pgtype.Bool      `json:"is_root"`
-	Status         NullFileStatus   `json:"status"`
-	IsStarred      pgtype.Bool      `json:"is_starred"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
-	TrashedAt      pgtype.Timestamp `json:"trashed_at"`
-	OwnerName      string           `json:"owner_name"`
-	Role           PermissionRole   `json:"role"`
+	ID               pgtype.UUID      `json:"id"`
+	Name             string           `json:"name"`
+	OwnerID          pgtype.UUID      `json:"owner_id"`
+	ParentFolderID   pgtype.UUID      `json:"parent_folder_id"`
+	IsRoot           pgtype.Bool      `json:"is_root"`
+	Status           NullFileStatus   `json:"status"`
+	IsStarred        pgtype.Bool      `json:"is_starred"`
+	CreatedAt        pgtype.Timestamp `json:"created_at"`
+	UpdatedAt        pgtype.Timestamp `json:"updated_at"`
+	TrashedAt        pgtype.Timestamp `json:"trashed_at"`
+	TrashOperationID pgtype.UUID      `json:"trash_operation_id"`
+	OwnerName        string           `json:"owner_name"`
+	Role             PermissionRole   `json:"role"`
 }
 
 func (q *Queries) GetSharedWithMeFolders(ctx context.Context, userID pgtype.UUID) ([]GetSharedWithMeFoldersRow, error) {
@@ -286,6 +454,7 @@ func (q *Queries) GetSharedWithMeFolders(ctx context.Context, userID pgtype.UUID
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.TrashedAt,
+			&i.TrashOperationID,
 			&i.OwnerName,
 			&i.Role,
 		); err != nil {
@@ -300,7 +469,8 @@ func (q *Queries) GetSharedWithMeFolders(ctx context.Context, userID pgtype.UUID
 }
 
 const getSharesByItem = `-- name: GetSharesByItem :many
-SELECT id, item_type, item_id, token, created_by, permission, expires_at, is_active, created_at FROM shares WHERE item_type = $1 AND item_id = $2 AND is_active = TRUE
+SELECT id, item_type, item_id, token, created_by, permission, expires_at, is_active, created_at, password_hash, max_downloads, download_count, allow_download, (password_hash IS NOT NULL)::boolean AS has_password
+FROM shares WHERE item_type = $1 AND item_id = $2 AND is_active = TRUE
 `
 
 type GetSharesByItemParams struct {
@@ -308,15 +478,32 @@ type GetSharesByItemParams struct {
 	ItemID   pgtype.UUID `json:"item_id"`
 }
 
-func (q *Queries) GetSharesByItem(ctx context.Context, arg GetSharesByItemParams) ([]Share, error) {
+type GetSharesByItemRow struct {
+	ID            pgtype.UUID        `json:"id"`
+	ItemType      ItemType           `json:"item_type"`
+	ItemID        pgtype.UUID        `json:"item_id"`
+	Token         string             `json:"token"`
+	CreatedBy     pgtype.UUID        `json:"created_by"`
+	Permission    NullPermissionRole `json:"permission"`
+	ExpiresAt     pgtype.Timestamp   `json:"expires_at"`
+	IsActive      pgtype.Bool        `json:"is_active"`
+	CreatedAt     pgtype.Timestamp   `json:"created_at"`
+	PasswordHash  pgtype.Text        `json:"-"`
+	MaxDownloads  pgtype.Int4        `json:"max_downloads"`
+	DownloadCount int32              `json:"download_count"`
+	AllowDownload bool               `json:"allow_download"`
+	HasPassword   bool               `json:"has_password"`
+}
+
+func (q *Queries) GetSharesByItem(ctx context.Context, arg GetSharesByItemParams) ([]GetSharesByItemRow, error) {
 	rows, err := q.db.Query(ctx, getSharesByItem, arg.ItemType, arg.ItemID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Share{}
+	items := []GetSharesByItemRow{}
 	for rows.Next() {
-		var i Share
+		var i GetSharesByItemRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.ItemType,
@@ -327,6 +514,11 @@ func (q *Queries) GetSharesByItem(ctx context.Context, arg GetSharesByItemParams
 			&i.ExpiresAt,
 			&i.IsActive,
 			&i.CreatedAt,
+			&i.PasswordHash,
+			&i.MaxDownloads,
+			&i.DownloadCount,
+			&i.AllowDownload,
+			&i.HasPassword,
 		); err != nil {
 			return nil, err
 		}
@@ -364,6 +556,64 @@ func (q *Queries) GetUserPermissionForItem(ctx context.Context, arg GetUserPermi
 	return i, err
 }
 
+const isFolderWithin = `-- name: IsFolderWithin :one
+WITH RECURSIVE ancestors (id, parent_folder_id) AS (
+    SELECT f.id, f.parent_folder_id
+    FROM folders f
+    WHERE f.id = $2::uuid AND f.status = 'active'
+    UNION
+    SELECT parent.id, parent.parent_folder_id
+    FROM folders parent
+    JOIN ancestors a ON parent.id = a.parent_folder_id
+    WHERE parent.status = 'active'
+)
+SELECT EXISTS (
+    SELECT 1 FROM ancestors WHERE id = $1::uuid
+)::boolean AS within
+`
+
+type IsFolderWithinParams struct {
+	RootID   pgtype.UUID `json:"root_id"`
+	FolderID pgtype.UUID `json:"folder_id"`
+}
+
+// Reports whether folder_id is root_id or one of its active descendants.
+// The walk stops at trashed folders, so their contents are out of reach.
+func (q *Queries) IsFolderWithin(ctx context.Context, arg IsFolderWithinParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isFolderWithin, arg.RootID, arg.FolderID)
+	var within bool
+	err := row.Scan(&within)
+	return within, err
+}
+
+const logShareAccess = `-- name: LogShareAccess :exec
+INSERT INTO share_access_log (share_id, action, item_type, item_id, user_id, ip_address, user_agent)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type LogShareAccessParams struct {
+	ShareID   pgtype.UUID       `json:"share_id"`
+	Action    ShareAccessAction `json:"action"`
+	ItemType  ItemType          `json:"item_type"`
+	ItemID    pgtype.UUID       `json:"item_id"`
+	UserID    pgtype.UUID       `json:"user_id"`
+	IpAddress pgtype.Text       `json:"ip_address"`
+	UserAgent pgtype.Text       `json:"user_agent"`
+}
+
+func (q *Queries) LogShareAccess(ctx context.Context, arg LogShareAccessParams) error {
+	_, err := q.db.Exec(ctx, logShareAccess,
+		arg.ShareID,
+		arg.Action,
+		arg.ItemType,
+		arg.ItemID,
+		arg.UserID,
+		arg.IpAddress,
+		arg.UserAgent,
+	)
+	return err
+}
+
 const revokePermission = `-- name: RevokePermission :exec
 DELETE FROM permissions
 WHERE item_type = $1 AND item_id = $2 AND user_id = $3
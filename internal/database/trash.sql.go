@@ -0,0 +1,278 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: trash.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const createTrashOperation = `-- name: CreateTrashOperation :one
+INSERT INTO trash_operations (user_id, root_type, root_id)
+VALUES ($1, $2, $3)
+RETURNING id, user_id, root_type, root_id, created_at
+`
+
+type CreateTrashOperationParams struct {
+	UserID   pgtype.UUID `json:"user_id"`
+	RootType ItemType    `json:"root_type"`
+	RootID   pgtype.UUID `json:"root_id"`
+}
+
+func (q *Queries) CreateTrashOperation(ctx context.Context, arg CreateTrashOperationParams) (TrashOperation, error) {
+	row := q.db.QueryRow(ctx, createTrashOperation, arg.UserID, arg.RootType, arg.RootID)
+	var i TrashOperation
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RootType,
+		&i.RootID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteFinishedTrashOperations = `-- name: DeleteFinishedTrashOperations :execrows
+DELETE FROM trash_operations t
+WHERE NOT EXISTS (SELECT 1 FROM files f WHERE f.trash_operation_id = t.id)
+  AND NOT EXISTS (SELECT 1 FROM folders d WHERE d.trash_operation_id = t.id)
+`
+
+// Removes operations none of whose items are still in trash
+func (q *Queries) DeleteFinishedTrashOperations(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteFinishedTrashOperations)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getNearestActiveAncestor = `-- name: GetNearestActiveAncestor :one
+WITH RECURSIVE chain (id, parent_folder_id, status, depth) AS (
+    SELECT f.id, f.parent_folder_id, f.status, 0
+    FROM folders f
+    WHERE f.id = $1::uuid
+    UNION ALL
+    SELECT parent.id, parent.parent_folder_id, parent.status, chain.depth + 1
+    FROM folders parent
+    JOIN chain ON parent.id = chain.parent_folder_id
+    WHERE chain.status <> 'active' AND chain.depth < 1000
+)
+SELECT f.id, f.name, f.owner_id, f.parent_folder_id, f.is_root, f.status, f.is_starred, f.created_at, f.updated_at, f.trashed_at, f.trash_operation_id
+FROM folders f
+JOIN chain ON f.id = chain.id
+WHERE chain.status = 'active'
+ORDER BY chain.depth
+LIMIT 1
+`
+
+// Walks up from folder_id (inclusive) to the first folder that is not in trash.
+// No row means every ancestor is trashed or deleted.
+func (q *Queries) GetNearestActiveAncestor(ctx context.Context, folderID pgtype.UUID) (Folder, error) {
+	row := q.db.QueryRow(ctx, getNearestActiveAncestor, folderID)
+	var i Folder
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.OwnerID,
+		&i.ParentFolderID,
+		&i.IsRoot,
+		&i.Status,
+		&i.IsStarred,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TrashedAt,
+		&i.TrashOperationID,
+	)
+	return i, err
+}
+
+const getSubtreeFilesForDeletion = `-- name: GetSubtreeFilesForDeletion :many
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = $1::uuid
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+)
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
+WHERE parent_folder_id IN (SELECT id FROM tree)
+`
+
+// Lists every file below folder_id, whatever its status
+func (q *Queries) GetSubtreeFilesForDeletion(ctx context.Context, folderID pgtype.UUID) ([]File, error) {
+	rows, err := q.db.Query(ctx, getSubtreeFilesForDeletion, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []File{}
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.OriginalName,
+			&i.MimeType,
+			&i.Size,
+			&i.StoragePath,
+			&i.OwnerID,
+			&i.ParentFolderID,
+			&i.Status,
+			&i.IsStarred,
+			&i.ThumbnailPath,
+			&i.PreviewAvailable,
+			&i.Version,
+			&i.CurrentVersionID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashedAt,
+			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const permanentDeleteFolderTree = `-- name: PermanentDeleteFolderTree :execrows
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = $1::uuid
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+), revoked AS (
+    DELETE FROM permissions WHERE item_type = 'folder' AND item_id IN (SELECT id FROM tree)
+), unlinked AS (
+    DELETE FROM shares WHERE item_type = 'folder' AND item_id IN (SELECT id FROM tree)
+)
+DELETE FROM folders
+WHERE id IN (SELECT id FROM tree)
+`
+
+// Removes folder_id and every folder below it, along with the grants and share
+// links that point at them. Files must be purged first: deleting a folder only
+// detaches the files still in it.
+func (q *Queries) PermanentDeleteFolderTree(ctx context.Context, folderID pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, permanentDeleteFolderTree, folderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const restoreOperationFiles = `-- name: RestoreOperationFiles :execrows
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = $2::uuid AND f.trash_operation_id = $1::uuid
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.trash_operation_id = $1::uuid
+)
+UPDATE files
+SET status = 'active', trashed_at = NULL, trash_operation_id = NULL
+WHERE trash_operation_id = $1::uuid
+  AND parent_folder_id IN (SELECT id FROM tree)
+`
+
+type RestoreOperationFilesParams struct {
+	OperationID pgtype.UUID `json:"operation_id"`
+	FolderID    pgtype.UUID `json:"folder_id"`
+}
+
+// Restores the files trashed by operation_id inside folder_id's part of the
+// operation. Run before RestoreOperationFolders, which clears the folder marks.
+func (q *Queries) RestoreOperationFiles(ctx context.Context, arg RestoreOperationFilesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, restoreOperationFiles, arg.OperationID, arg.FolderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const restoreOperationFolders = `-- name: RestoreOperationFolders :execrows
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = $1::uuid AND f.trash_operation_id = $2::uuid
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.trash_operation_id = $2::uuid
+)
+UPDATE folders
+SET status = 'active', trashed_at = NULL, trash_operation_id = NULL
+WHERE id IN (SELECT id FROM tree) AND id <> $1::uuid
+`
+
+type RestoreOperationFoldersParams struct {
+	FolderID    pgtype.UUID `json:"folder_id"`
+	OperationID pgtype.UUID `json:"operation_id"`
+}
+
+// Restores the folders below folder_id that were trashed by operation_id.
+// folder_id itself is restored with RestoreFolder, which also sets its parent.
+func (q *Queries) RestoreOperationFolders(ctx context.Context, arg RestoreOperationFoldersParams) (int64, error) {
+	result, err := q.db.Exec(ctx, restoreOperationFolders, arg.FolderID, arg.OperationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const trashFolderTree = `-- name: TrashFolderTree :execrows
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = $2::uuid AND f.status = 'active'
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.status = 'active'
+)
+UPDATE folders
+SET status = 'trashed', trashed_at = NOW(), trash_operation_id = $1::uuid
+WHERE id IN (SELECT id FROM tree)
+`
+
+type TrashFolderTreeParams struct {
+	OperationID pgtype.UUID `json:"operation_id"`
+	FolderID    pgtype.UUID `json:"folder_id"`
+}
+
+// Trashes folder_id and every active folder below it as part of operation_id
+func (q *Queries) TrashFolderTree(ctx context.Context, arg TrashFolderTreeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, trashFolderTree, arg.OperationID, arg.FolderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const trashOperationFiles = `-- name: TrashOperationFiles :execrows
+UPDATE files
+SET status = 'trashed', trashed_at = NOW(), trash_operation_id = $1::uuid
+WHERE status = 'active'
+  AND parent_folder_id IN (
+      SELECT id FROM folders WHERE trash_operation_id = $1::uuid
+  )
+`
+
+// Trashes the active files inside the folders trashed by operation_id
+func (q *Queries) TrashOperationFiles(ctx context.Context, operationID pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, trashOperationFiles, operationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
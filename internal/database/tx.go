@@ -0,0 +1,28 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TxStarter is implemented by *pgxpool.Pool, *pgx.Conn and pgx.Tx (where Begin opens a savepoint)
+type TxStarter interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// ExecTx runs fn with queries bound to a new transaction. The transaction is
+// committed when fn returns nil and rolled back otherwise.
+func ExecTx(ctx context.Context, db TxStarter, fn func(*Queries) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(New(tx)); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
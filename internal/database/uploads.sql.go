@@ -0,0 +1,276 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: uploads.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const abortUploadSession = `-- name: AbortUploadSession :exec
+UPDATE upload_sessions
+SET status = 'aborted', updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) AbortUploadSession(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, abortUploadSession, id)
+	return err
+}
+
+const advanceUploadSession = `-- name: AdvanceUploadSession :execrows
+UPDATE upload_sessions
+SET received_bytes = received_bytes + $1,
+    chunk_count = chunk_count + 1,
+    expires_at = $2,
+    updated_at = NOW()
+WHERE id = $3
+  AND status = 'pending'
+  AND received_bytes = $4
+`
+
+type AdvanceUploadSessionParams struct {
+	ChunkSize      int64            `json:"chunk_size"`
+	ExpiresAt      pgtype.Timestamp `json:"expires_at"`
+	ID             pgtype.UUID      `json:"id"`
+	ExpectedOffset int64            `json:"expected_offset"`
+}
+
+func (q *Queries) AdvanceUploadSession(ctx context.Context, arg AdvanceUploadSessionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, advanceUploadSession,
+		arg.ChunkSize,
+		arg.ExpiresAt,
+		arg.ID,
+		arg.ExpectedOffset,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const completeUploadSession = `-- name: CompleteUploadSession :execrows
+UPDATE upload_sessions
+SET status = 'completed', file_id = $2, updated_at = NOW()
+WHERE id = $1
+  AND status = 'pending'
+`
+
+type CompleteUploadSessionParams struct {
+	ID     pgtype.UUID `json:"id"`
+	FileID pgtype.UUID `json:"file_id"`
+}
+
+func (q *Queries) CompleteUploadSession(ctx context.Context, arg CompleteUploadSessionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, completeUploadSession, arg.ID, arg.FileID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const createUploadSession = `-- name: CreateUploadSession :one
+INSERT INTO upload_sessions (user_id, filename, mime_type, parent_folder_id, total_size, expires_at, on_conflict)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, user_id, filename, mime_type, parent_folder_id, total_size, received_bytes, chunk_count, status, file_id, expires_at, created_at, updated_at, on_conflict
+`
+
+type CreateUploadSessionParams struct {
+	UserID         pgtype.UUID      `json:"user_id"`
+	Filename       string           `json:"filename"`
+	MimeType       string           `json:"mime_type"`
+	ParentFolderID pgtype.UUID      `json:"parent_folder_id"`
+	TotalSize      int64            `json:"total_size"`
+	ExpiresAt      pgtype.Timestamp `json:"expires_at"`
+	OnConflict     string           `json:"on_conflict"`
+}
+
+func (q *Queries) CreateUploadSession(ctx context.Context, arg CreateUploadSessionParams) (UploadSession, error) {
+	row := q.db.QueryRow(ctx, createUploadSession,
+		arg.UserID,
+		arg.Filename,
+		arg.MimeType,
+		arg.ParentFolderID,
+		arg.TotalSize,
+		arg.ExpiresAt,
+		arg.OnConflict,
+	)
+	var i UploadSession
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Filename,
+		&i.MimeType,
+		&i.ParentFolderID,
+		&i.TotalSize,
+		&i.ReceivedBytes,
+		&i.ChunkCount,
+		&i.Status,
+		&i.FileID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OnConflict,
+	)
+	return i, err
+}
+
+const deleteUploadSession = `-- name: DeleteUploadSession :exec
+DELETE FROM upload_sessions WHERE id = $1
+`
+
+func (q *Queries) DeleteUploadSession(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteUploadSession, id)
+	return err
+}
+
+const getExpiredUploadSessions = `-- name: GetExpiredUploadSessions :many
+SELECT id, user_id, filename, mime_type, parent_folder_id, total_size, received_bytes, chunk_count, status, file_id, expires_at, created_at, updated_at, on_conflict FROM upload_sessions
+WHERE expires_at < NOW()
+ORDER BY expires_at ASC
+`
+
+func (q *Queries) GetExpiredUploadSessions(ctx context.Context) ([]UploadSession, error) {
+	rows, err := q.db.Query(ctx, getExpiredUploadSessions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []UploadSession{}
+	for rows.Next() {
+		var i UploadSession
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Filename,
+			&i.MimeType,
+			&i.ParentFolderID,
+			&i.TotalSize,
+			&i.ReceivedBytes,
+			&i.ChunkCount,
+			&i.Status,
+			&i.FileID,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.OnConflict,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getInterruptedUploads = `-- name: GetInterruptedUploads :many
+SELECT id, name, original_name, mime_type, size, storage_path, owner_id, parent_folder_id, status, is_starred, thumbnail_path, preview_available, version, current_version_id, created_at, updated_at, trashed_at, last_accessed_at, trash_operation_id, broken_at FROM files
+WHERE updated_at < $1
+  AND storage_path LIKE 'blobs/%'
+  AND COALESCE(version, 1) > COALESCE(
+      (SELECT MAX(fv.version_number) FROM file_versions fv WHERE fv.file_id = files.id), 0
+  )
+`
+
+// Files that uploads from before uploads ran in one transaction recorded
+// without finishing: content in the blob store but no version row for it, or
+// a version number ahead of the newest version row
+func (q *Queries) GetInterruptedUploads(ctx context.Context, before pgtype.Timestamp) ([]File, error) {
+	rows, err := q.db.Query(ctx, getInterruptedUploads, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []File{}
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.OriginalName,
+			&i.MimeType,
+			&i.Size,
+			&i.StoragePath,
+			&i.OwnerID,
+			&i.ParentFolderID,
+			&i.Status,
+			&i.IsStarred,
+			&i.ThumbnailPath,
+			&i.PreviewAvailable,
+			&i.Version,
+			&i.CurrentVersionID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TrashedAt,
+			&i.LastAccessedAt,
+			&i.TrashOperationID,
+			&i.BrokenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUploadSession = `-- name: GetUploadSession :one
+SELECT id, user_id, filename, mime_type, parent_folder_id, total_size, received_bytes, chunk_count, status, file_id, expires_at, created_at, updated_at, on_conflict FROM upload_sessions WHERE id = $1
+`
+
+func (q *Queries) GetUploadSession(ctx context.Context, id pgtype.UUID) (UploadSession, error) {
+	row := q.db.QueryRow(ctx, getUploadSession, id)
+	var i UploadSession
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Filename,
+		&i.MimeType,
+		&i.ParentFolderID,
+		&i.TotalSize,
+		&i.ReceivedBytes,
+		&i.ChunkCount,
+		&i.Status,
+		&i.FileID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OnConflict,
+	)
+	return i, err
+}
+
+const lockUploadSession = `-- name: LockUploadSession :one
+SELECT id, user_id, filename, mime_type, parent_folder_id, total_size, received_bytes, chunk_count, status, file_id, expires_at, created_at, updated_at, on_conflict FROM upload_sessions WHERE id = $1 FOR UPDATE
+`
+
+// Chunks of a session are accepted one at a time
+func (q *Queries) LockUploadSession(ctx context.Context, id pgtype.UUID) (UploadSession, error) {
+	row := q.db.QueryRow(ctx, lockUploadSession, id)
+	var i UploadSession
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Filename,
+		&i.MimeType,
+		&i.ParentFolderID,
+		&i.TotalSize,
+		&i.ReceivedBytes,
+		&i.ChunkCount,
+		&i.Status,
+		&i.FileID,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OnConflict,
+	)
+	return i, err
+}
@@ -14,7 +14,7 @@ import (
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (email, hashed_password, name)
 VALUES ($1, $2, $3)
-RETURNING id, email, hashed_password, name, storage_used, storage_limit, created_at, updated_at
+RETURNING id, email, hashed_password, name, storage_used, storage_limit, created_at, updated_at, is_admin
 `
 
 type CreateUserParams struct {
@@ -35,6 +35,7 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.StorageLimit,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.IsAdmin,
 	)
 	return i, err
 }
@@ -56,7 +57,7 @@ func (q *Queries) GetStorageUsage(ctx context.Context, id pgtype.UUID) (GetStora
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, hashed_password, name, storage_used, storage_limit, created_at, updated_at FROM users WHERE email = $1
+SELECT id, email, hashed_password, name, storage_used, storage_limit, created_at, updated_at, is_admin FROM users WHERE email = $1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -71,12 +72,13 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.StorageLimit,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.IsAdmin,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, email, hashed_password, name, storage_used, storage_limit, created_at, updated_at FROM users WHERE id = $1
+SELECT id, email, hashed_password, name, storage_used, storage_limit, created_at, updated_at, is_admin FROM users WHERE id = $1
 `
 
 func (q *Queries) GetUserByID(ctx context.Context, id pgtype.UUID) (User, error) {
@@ -91,6 +93,7 @@ func (q *Queries) GetUserByID(ctx context.Context, id pgtype.UUID) (User, error)
 		&i.StorageLimit,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.IsAdmin,
 	)
 	return i, err
 }
@@ -12,9 +12,9 @@ import (
 )
 
 const createFileVersion = `-- name: CreateFileVersion :one
-INSERT INTO file_versions (file_id, version_number, storage_path, size, uploaded_by)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, file_id, version_number, storage_path, size, uploaded_by, created_at
+INSERT INTO file_versions (file_id, version_number, storage_path, size, uploaded_by, blob_digest)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, file_id, version_number, storage_path, size, uploaded_by, created_at, blob_digest, is_pinned
 `
 
 type CreateFileVersionParams struct {
@@ -23,6 +23,7 @@ type CreateFileVersionParams struct {
 	StoragePath   string      `json:"storage_path"`
 	Size          int64       `json:"size"`
 	UploadedBy    pgtype.UUID `json:"uploaded_by"`
+	BlobDigest    pgtype.Text `json:"blob_digest"`
 }
 
 func (q *Queries) CreateFileVersion(ctx context.Context, arg CreateFileVersionParams) (FileVersion, error) {
@@ -32,6 +33,7 @@ func (q *Queries) CreateFileVersion(ctx context.Context, arg CreateFileVersionPa
 		arg.StoragePath,
 		arg.Size,
 		arg.UploadedBy,
+		arg.BlobDigest,
 	)
 	var i FileVersion
 	err := row.Scan(
@@ -42,6 +44,8 @@ func (q *Queries) CreateFileVersion(ctx context.Context, arg CreateFileVersionPa
 		&i.Size,
 		&i.UploadedBy,
 		&i.CreatedAt,
+		&i.BlobDigest,
+		&i.IsPinned,
 	)
 	return i, err
 }
@@ -56,8 +60,34 @@ func (q *Queries) DeleteFileVersions(ctx context.Context, fileID pgtype.UUID) er
 	return err
 }
 
+const deletePrunableFileVersion = `-- name: DeletePrunableFileVersion :one
+DELETE FROM file_versions fv
+WHERE fv.id = $1
+  AND NOT fv.is_pinned
+  AND NOT EXISTS (
+      SELECT 1 FROM files f
+      WHERE f.id = fv.file_id
+        AND (f.version = fv.version_number OR f.current_version_id = fv.id)
+  )
+RETURNING fv.storage_path, fv.size, fv.blob_digest
+`
+
+type DeletePrunableFileVersionRow struct {
+	StoragePath string      `json:"storage_path"`
+	Size        int64       `json:"size"`
+	BlobDigest  pgtype.Text `json:"blob_digest"`
+}
+
+// Deletes a version unless it is pinned or has become the file's current version
+func (q *Queries) DeletePrunableFileVersion(ctx context.Context, id pgtype.UUID) (DeletePrunableFileVersionRow, error) {
+	row := q.db.QueryRow(ctx, deletePrunableFileVersion, id)
+	var i DeletePrunableFileVersionRow
+	err := row.Scan(&i.StoragePath, &i.Size, &i.BlobDigest)
+	return i, err
+}
+
 const getFileVersion = `-- name: GetFileVersion :one
-SELECT fv.id, fv.file_id, fv.version_number, fv.storage_path, fv.size, fv.uploaded_by, fv.created_at, u.name as uploader_name
+SELECT fv.id, fv.file_id, fv.version_number, fv.storage_path, fv.size, fv.uploaded_by, fv.created_at, fv.blob_digest, fv.is_pinned, u.name as uploader_name
 FROM file_versions fv
 JOIN users u ON fv.uploaded_by = u.id
 WHERE fv.id = $1
@@ -71,6 +101,8 @@ type GetFileVersionRow struct {
 	Size          int64            `json:"size"`
 	UploadedBy    pgtype.UUID      `json:"uploaded_by"`
 	CreatedAt     pgtype.Timestamp `json:"created_at"`
+	BlobDigest    pgtype.Text      `json:"blob_digest"`
+	IsPinned      bool             `json:"is_pinned"`
 	UploaderName  string           `json:"uploader_name"`
 }
 
@@ -85,13 +117,15 @@ func (q *Queries) GetFileVersion(ctx context.Context, id pgtype.UUID) (GetFileVe
 		&i.Size,
 		&i.UploadedBy,
 		&i.CreatedAt,
+		&i.BlobDigest,
+		&i.IsPinned,
 		&i.UploaderName,
 	)
 	return i, err
 }
 
 const getFileVersions = `-- name: GetFileVersions :many
-SELECT fv.id, fv.file_id, fv.version_number, fv.storage_path, fv.size, fv.uploaded_by, fv.created_at, u.name as uploader_name, u.email as uploader_email
+SELECT fv.id, fv.file_id, fv.version_number, fv.storage_path, fv.size, fv.uploaded_by, fv.created_at, fv.blob_digest, fv.is_pinned, u.name as uploader_name, u.email as uploader_email
 FROM file_versions fv
 JOIN users u ON fv.uploaded_by = u.id
 WHERE fv.file_id = $1
@@ -106,6 +140,8 @@ type GetFileVersionsRow struct {
 	Size          int64            `json:"size"`
 	UploadedBy    pgtype.UUID      `json:"uploaded_by"`
 	CreatedAt     pgtype.Timestamp `json:"created_at"`
+	BlobDigest    pgtype.Text      `json:"blob_digest"`
+	IsPinned      bool             `json:"is_pinned"`
 	UploaderName  string           `json:"uploader_name"`
 	UploaderEmail string           `json:"uploader_email"`
 }
@@ -127,6 +163,8 @@ func (q *Queries) GetFileVersions(ctx context.Context, fileID pgtype.UUID) ([]Ge
 			&i.Size,
 			&i.UploadedBy,
 			&i.CreatedAt,
+			&i.BlobDigest,
+			&i.IsPinned,
 			&i.UploaderName,
 			&i.UploaderEmail,
 		); err != nil {
@@ -140,15 +178,94 @@ func (q *Queries) GetFileVersions(ctx context.Context, fileID pgtype.UUID) ([]Ge
 	return items, nil
 }
 
+const getFileVersionsForDeletion = `-- name: GetFileVersionsForDeletion :many
+SELECT id, storage_path, size, blob_digest
+FROM file_versions
+WHERE file_id = $1
+ORDER BY blob_digest
+`
+
+type GetFileVersionsForDeletionRow struct {
+	ID          pgtype.UUID `json:"id"`
+	StoragePath string      `json:"storage_path"`
+	Size        int64       `json:"size"`
+	BlobDigest  pgtype.Text `json:"blob_digest"`
+}
+
+func (q *Queries) GetFileVersionsForDeletion(ctx context.Context, fileID pgtype.UUID) ([]GetFileVersionsForDeletionRow, error) {
+	rows, err := q.db.Query(ctx, getFileVersionsForDeletion, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetFileVersionsForDeletionRow{}
+	for rows.Next() {
+		var i GetFileVersionsForDeletionRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.StoragePath,
+			&i.Size,
+			&i.BlobDigest,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestFileVersion = `-- name: GetLatestFileVersion :one
+SELECT id, file_id, version_number, storage_path, size, uploaded_by, created_at, blob_digest, is_pinned FROM file_versions
+WHERE file_id = $1
+ORDER BY version_number DESC
+LIMIT 1
+`
+
+func (q *Queries) GetLatestFileVersion(ctx context.Context, fileID pgtype.UUID) (FileVersion, error) {
+	row := q.db.QueryRow(ctx, getLatestFileVersion, fileID)
+	var i FileVersion
+	err := row.Scan(
+		&i.ID,
+		&i.FileID,
+		&i.VersionNumber,
+		&i.StoragePath,
+		&i.Size,
+		&i.UploadedBy,
+		&i.CreatedAt,
+		&i.BlobDigest,
+		&i.IsPinned,
+	)
+	return i, err
+}
+
 const getLatestVersionNumber = `-- name: GetLatestVersionNumber :one
-SELECT COALESCE(MAX(version_number), 0) as latest_version
+SELECT COALESCE(MAX(version_number), 0)::int AS latest_version
 FROM file_versions
 WHERE file_id = $1
 `
 
-func (q *Queries) GetLatestVersionNumber(ctx context.Context, fileID pgtype.UUID) (interface{}, error) {
+func (q *Queries) GetLatestVersionNumber(ctx context.Context, fileID pgtype.UUID) (int32, error) {
 	row := q.db.QueryRow(ctx, getLatestVersionNumber, fileID)
-	var latest_version interface{}
+	var latest_version int32
 	err := row.Scan(&latest_version)
 	return latest_version, err
 }
+
+const setFileVersionPinned = `-- name: SetFileVersionPinned :exec
+UPDATE file_versions
+SET is_pinned = $2
+WHERE id = $1
+`
+
+type SetFileVersionPinnedParams struct {
+	ID       pgtype.UUID `json:"id"`
+	IsPinned bool        `json:"is_pinned"`
+}
+
+func (q *Queries) SetFileVersionPinned(ctx context.Context, arg SetFileVersionPinnedParams) error {
+	_, err := q.db.Exec(ctx, setFileVersionPinned, arg.ID, arg.IsPinned)
+	return err
+}
@@ -10,15 +10,18 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/shri771/gdrive/internal/database"
 	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
 )
 
 type ActivityHandler struct {
-	queries *database.Queries
+	queries     *database.Queries
+	permissions *services.PermissionService
 }
 
-func NewActivityHandler(queries *database.Queries) *ActivityHandler {
+func NewActivityHandler(queries *database.Queries, permissions *services.PermissionService) *ActivityHandler {
 	return &ActivityHandler{
-		queries: queries,
+		queries:     queries,
+		permissions: permissions,
 	}
 }
 
@@ -56,7 +59,7 @@ func (h *ActivityHandler) GetUserActivity(w http.ResponseWriter, r *http.Request
 
 // GetFileActivity returns activity for a specific file
 func (h *ActivityHandler) GetFileActivity(w http.ResponseWriter, r *http.Request) {
-	_, ok := middleware.GetUserFromContext(r.Context())
+	session, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -73,6 +76,12 @@ func (h *ActivityHandler) GetFileActivity(w http.ResponseWriter, r *http.Request
 		respondWithError(w, http.StatusBadRequest, "invalid file_id")
 		return
 	}
+	fileID := pgtype.UUID{Bytes: parsedUUID, Valid: true}
+
+	// Viewers and above can see a file's activity
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, fileID, services.ActionRead) {
+		return
+	}
 
 	// Get limit from query params, default to 20
 	limitStr := r.URL.Query().Get("limit")
@@ -86,7 +95,7 @@ func (h *ActivityHandler) GetFileActivity(w http.ResponseWriter, r *http.Request
 
 	// Get file activity
 	activities, err := h.queries.GetFileActivity(r.Context(), database.GetFileActivityParams{
-		FileID: pgtype.UUID{Bytes: parsedUUID, Valid: true},
+		FileID: fileID,
 		Limit:  limit,
 	})
 	if err != nil {
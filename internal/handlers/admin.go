@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+// AdminHandler serves the administrator-only endpoints
+type AdminHandler struct {
+	queries *database.Queries
+	jobs    *services.JobQueue
+}
+
+func NewAdminHandler(queries *database.Queries, jobs *services.JobQueue) *AdminHandler {
+	return &AdminHandler{queries: queries, jobs: jobs}
+}
+
+type SetStorageLimitRequest struct {
+	StorageLimit int64 `json:"storage_limit"`
+}
+
+// SetStorageLimit changes a user's storage quota. Lowering it below what the
+// user already stores keeps their files but rejects further uploads.
+func (h *AdminHandler) SetStorageLimit(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	var req SetStorageLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.StorageLimit < 0 {
+		respondWithError(w, http.StatusBadRequest, "storage_limit must not be negative")
+		return
+	}
+
+	user, err := h.queries.SetUserStorageLimit(r.Context(), database.SetUserStorageLimitParams{
+		ID:           pgtype.UUID{Bytes: userID, Valid: true},
+		StorageLimit: pgtype.Int8{Int64: req.StorageLimit, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		fmt.Printf("failed to set storage limit: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to set storage limit")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// ListJobs returns background jobs, newest first, optionally filtered by
+// status and kind
+func (h *AdminHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	params := database.ListJobsParams{MaxJobs: 100}
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		switch database.JobStatus(status) {
+		case database.JobStatusPending, database.JobStatusRunning, database.JobStatusSucceeded, database.JobStatusFailed:
+			params.Status = database.NullJobStatus{JobStatus: database.JobStatus(status), Valid: true}
+		default:
+			respondWithError(w, http.StatusBadRequest, "status must be pending, running, succeeded or failed")
+			return
+		}
+	}
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		params.Kind = pgtype.Text{String: kind, Valid: true}
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err == nil && parsedLimit > 0 && parsedLimit <= 500 {
+			params.MaxJobs = int32(parsedLimit)
+		}
+	}
+
+	jobs, err := h.queries.ListJobs(r.Context(), params)
+	if err != nil {
+		fmt.Printf("failed to list jobs: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to list jobs")
+		return
+	}
+	if jobs == nil {
+		jobs = []database.Job{}
+	}
+
+	respondWithJSON(w, http.StatusOK, jobs)
+}
+
+// JobSummary counts jobs by kind and status and lists the recurring schedules
+func (h *AdminHandler) JobSummary(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.queries.CountJobs(r.Context())
+	if err != nil {
+		fmt.Printf("failed to count jobs: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to count jobs")
+		return
+	}
+	if counts == nil {
+		counts = []database.CountJobsRow{}
+	}
+
+	schedules, err := h.queries.ListJobSchedules(r.Context())
+	if err != nil {
+		fmt.Printf("failed to list job schedules: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to list job schedules")
+		return
+	}
+	if schedules == nil {
+		schedules = []database.JobSchedule{}
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"counts":    counts,
+		"schedules": schedules,
+	})
+}
+
+// RetryJob queues a failed job again with its attempts reset
+func (h *AdminHandler) RetryJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid job ID")
+		return
+	}
+
+	job, err := h.jobs.Retry(r.Context(), pgtype.UUID{Bytes: jobID, Valid: true})
+	if errors.Is(err, services.ErrJobNotFound) {
+		respondWithError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if errors.Is(err, services.ErrJobNotFailed) {
+		respondWithError(w, http.StatusConflict, "only failed jobs can be retried")
+		return
+	}
+	if err != nil {
+		fmt.Printf("failed to retry job: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to retry job")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
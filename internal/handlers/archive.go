@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+// maxArchiveItems bounds how many items a selection archive may name
+const maxArchiveItems = 1000
+
+type ArchiveHandler struct {
+	queries     *database.Queries
+	permissions *services.PermissionService
+	archives    *services.ArchiveService
+}
+
+func NewArchiveHandler(queries *database.Queries, permissions *services.PermissionService, archives *services.ArchiveService) *ArchiveHandler {
+	return &ArchiveHandler{
+		queries:     queries,
+		permissions: permissions,
+		archives:    archives,
+	}
+}
+
+type ArchiveItem struct {
+	Type database.ItemType `json:"type"`
+	ID   uuid.UUID         `json:"id"`
+}
+
+type ArchiveRequest struct {
+	Items []ArchiveItem `json:"items"`
+	// Format is zip (the default) or tar.gz
+	Format string `json:"format"`
+	// Name of the archive without extension; defaults to "download"
+	Name string `json:"name"`
+}
+
+// DownloadFolder streams the contents of a folder the user can view as an
+// archive
+func (h *ArchiveHandler) DownloadFolder(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	folderID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid folder ID")
+		return
+	}
+
+	format, err := services.ParseArchiveFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	folder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+
+	// Viewers and above can download
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folder.ID, services.ActionRead) {
+		return
+	}
+
+	plan := services.NewArchivePlan()
+	if err := h.archives.AddFolder(r.Context(), plan, folder, false); err != nil {
+		fmt.Printf("failed to list archive contents: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to get folder contents")
+		return
+	}
+
+	streamArchive(w, r, h.archives, format, folder.Name, plan)
+}
+
+// DownloadSelection streams a selection of files and folders the user can
+// view as one archive. Folders become directories at the top of it.
+func (h *ArchiveHandler) DownloadSelection(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req ArchiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if len(req.Items) == 0 {
+		respondWithError(w, http.StatusBadRequest, "items are required")
+		return
+	}
+	if len(req.Items) > maxArchiveItems {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("at most %d items can be downloaded at once", maxArchiveItems))
+		return
+	}
+
+	format, err := services.ParseArchiveFormat(req.Format)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "download"
+	}
+
+	// Everything is checked before the response starts
+	plan := services.NewArchivePlan()
+	seen := make(map[uuid.UUID]bool, len(req.Items))
+	for _, item := range req.Items {
+		if seen[item.ID] {
+			continue
+		}
+		seen[item.ID] = true
+		itemID := pgtype.UUID{Bytes: item.ID, Valid: true}
+
+		switch item.Type {
+		case database.ItemTypeFile:
+			file, err := h.queries.GetFileByID(r.Context(), itemID)
+			if err != nil {
+				respondWithError(w, http.StatusNotFound, "file not found")
+				return
+			}
+			if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionRead) {
+				return
+			}
+			plan.AddFile(file)
+
+		case database.ItemTypeFolder:
+			folder, err := h.queries.GetFolderByID(r.Context(), itemID)
+			if err != nil {
+				respondWithError(w, http.StatusNotFound, "folder not found")
+				return
+			}
+			if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folder.ID, services.ActionRead) {
+				return
+			}
+			if err := h.archives.AddFolder(r.Context(), plan, folder, true); err != nil {
+				fmt.Printf("failed to list archive contents: %v\n", err)
+				respondWithError(w, http.StatusInternalServerError, "failed to get folder contents")
+				return
+			}
+
+		default:
+			respondWithError(w, http.StatusBadRequest, "item type must be file or folder")
+			return
+		}
+	}
+
+	streamArchive(w, r, h.archives, format, name, plan)
+}
+
+// streamArchive writes plan as the response, an attachment called name
+func streamArchive(w http.ResponseWriter, r *http.Request, archives *services.ArchiveService, format services.ArchiveFormat, name string, plan *services.ArchivePlan) {
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": format.Filename(name)}))
+
+	// The response is committed from here on; errors can only cut the archive short
+	if err := archives.Write(r.Context(), w, format, plan); err != nil {
+		fmt.Printf("failed to stream archive: %v\n", err)
+	}
+}
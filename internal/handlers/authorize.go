@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+// authorize checks that userID may perform action on the item, inheriting
+// access from parent folders, and writes the error response if not
+func authorize(
+	w http.ResponseWriter,
+	r *http.Request,
+	permissions *services.PermissionService,
+	userID pgtype.UUID,
+	itemType database.ItemType,
+	itemID pgtype.UUID,
+	action services.Action,
+) bool {
+	allowed, err := permissions.Can(r.Context(), userID, itemType, itemID, action)
+	if err != nil {
+		fmt.Printf("failed to check %s permission: %v\n", action, err)
+		respondWithError(w, http.StatusInternalServerError, "failed to check permissions")
+		return false
+	}
+
+	if !allowed {
+		respondWithError(w, http.StatusForbidden, "forbidden")
+		return false
+	}
+
+	return true
+}
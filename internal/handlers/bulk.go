@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+type BulkHandler struct {
+	bulk *services.BulkService
+}
+
+func NewBulkHandler(bulk *services.BulkService) *BulkHandler {
+	return &BulkHandler{bulk: bulk}
+}
+
+type BulkRequest struct {
+	Items []services.BulkItem `json:"items"`
+	// Mode is atomic (the default) or best_effort
+	Mode string `json:"mode"`
+	// FolderID is the destination of a move; empty moves to the root
+	FolderID string `json:"folder_id"`
+	// Destination is where a restore puts items whose parent is in trash
+	Destination string `json:"destination"`
+	// OnConflict is what a move does with names taken in the destination:
+	// fail (the default) or rename
+	OnConflict string `json:"on_conflict"`
+}
+
+// BulkItemResponse is the outcome of one item. For failed items, Error and
+// Code are what the single-item endpoint would have responded with; skipped
+// items only carry the reason in Error.
+type BulkItemResponse struct {
+	Type   database.ItemType   `json:"type"`
+	ID     uuid.UUID           `json:"id"`
+	Name   string              `json:"name,omitempty"`
+	Status services.BulkStatus `json:"status"`
+	Error  string              `json:"error,omitempty"`
+	Code   int                 `json:"code,omitempty"`
+}
+
+type BulkResponse struct {
+	Action         services.BulkAction `json:"action"`
+	Committed      bool                `json:"committed"`
+	Applied        int                 `json:"applied"`
+	Skipped        int                 `json:"skipped"`
+	Failed         int                 `json:"failed"`
+	RolledBack     int                 `json:"rolled_back"`
+	BytesReclaimed int64               `json:"bytes_reclaimed,omitempty"`
+	Items          []BulkItemResponse  `json:"items"`
+}
+
+// Apply runs one action (trash, restore, move, star, unstar or delete) on a
+// list of files and folders in a single transaction
+func (h *BulkHandler) Apply(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	bulkReq := services.BulkRequest{
+		Action:      services.BulkAction(chi.URLParam(r, "action")),
+		Mode:        services.BulkMode(req.Mode),
+		Items:       req.Items,
+		Destination: services.RestoreDestination(req.Destination),
+		OnConflict:  services.CollisionPolicy(req.OnConflict),
+	}
+	if req.FolderID != "" {
+		folderID, err := uuid.Parse(req.FolderID)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid folder_id")
+			return
+		}
+		bulkReq.FolderID = &folderID
+	}
+
+	result, err := h.bulk.Run(r.Context(), session.UserID, bulkReq)
+	switch {
+	case errors.Is(err, services.ErrInvalidBulk):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, services.ErrDestinationNotFound):
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return
+	case errors.Is(err, services.ErrForbidden):
+		respondWithError(w, http.StatusForbidden, "forbidden")
+		return
+	case err != nil:
+		fmt.Printf("failed to run bulk %s: %v\n", bulkReq.Action, err)
+		respondWithError(w, http.StatusInternalServerError, "failed to apply changes")
+		return
+	}
+
+	resp := BulkResponse{
+		Action:         bulkReq.Action,
+		Committed:      result.Committed,
+		Applied:        result.Applied,
+		Skipped:        result.Skipped,
+		Failed:         result.Failed,
+		RolledBack:     result.RolledBack,
+		BytesReclaimed: result.BytesReclaimed,
+		Items:          make([]BulkItemResponse, 0, len(result.Items)),
+	}
+	for _, item := range result.Items {
+		itemResp := BulkItemResponse{
+			Type:   item.Type,
+			ID:     item.ID,
+			Name:   item.Name,
+			Status: item.Status,
+		}
+		switch {
+		case item.Status == services.BulkSkipped:
+			itemResp.Error = item.Err.Error()
+		case item.Err != nil:
+			itemResp.Code, itemResp.Error = bulkItemError(item.Type, item.Err)
+		}
+		resp.Items = append(resp.Items, itemResp)
+	}
+
+	// An atomic request that was rolled back changed nothing
+	status := http.StatusOK
+	if !result.Committed {
+		status = http.StatusConflict
+	}
+	respondWithJSON(w, status, resp)
+}
+
+// bulkItemError maps why an item failed to a status code and message
+func bulkItemError(itemType database.ItemType, err error) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrItemNotFound):
+		return http.StatusNotFound, fmt.Sprintf("%s not found", itemType)
+	case errors.Is(err, services.ErrForbidden):
+		return http.StatusForbidden, "forbidden"
+	case errors.Is(err, services.ErrRootFolder),
+		errors.Is(err, services.ErrMoveIntoItself),
+		errors.Is(err, services.ErrMoveIntoDescendant):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, services.ErrParentTrashed),
+		errors.Is(err, services.ErrNameTaken),
+		errors.Is(err, services.ErrBulkRolledBack):
+		return http.StatusConflict, err.Error()
+	}
+	fmt.Printf("failed to apply bulk change to %s: %v\n", itemType, err)
+	return http.StatusInternalServerError, "internal error"
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/shri771/gdrive/internal/database"
 	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -18,14 +19,16 @@ type CommentHub interface {
 }
 
 type CommentHandler struct {
-	queries *database.Queries
-	hub     CommentHub
+	queries     *database.Queries
+	permissions *services.PermissionService
+	hub         CommentHub
 }
 
-func NewCommentHandler(queries *database.Queries, hub CommentHub) *CommentHandler {
+func NewCommentHandler(queries *database.Queries, permissions *services.PermissionService, hub CommentHub) *CommentHandler {
 	return &CommentHandler{
-		queries: queries,
-		hub:     hub,
+		queries:     queries,
+		permissions: permissions,
+		hub:         hub,
 	}
 }
 
@@ -82,26 +85,8 @@ func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user has access (owner or has been shared with)
-	hasAccess := file.OwnerID.Bytes == session.UserID.Bytes
-	if !hasAccess {
-		// Check if file has been shared with user
-		perms, err := h.queries.GetItemPermissions(ctx, database.GetItemPermissionsParams{
-			ItemID:   pgtype.UUID{Bytes: fileID, Valid: true},
-			ItemType: database.ItemTypeFile,
-		})
-		if err == nil {
-			for _, perm := range perms {
-				if perm.UserID.Bytes == session.UserID.Bytes {
-					hasAccess = true
-					break
-				}
-			}
-		}
-	}
-
-	if !hasAccess {
-		respondWithError(w, http.StatusForbidden, "access denied")
+	// Commenters and above can comment
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionComment) {
 		return
 	}
 
@@ -165,25 +150,8 @@ func (h *CommentHandler) GetFileComments(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Check if user has access
-	hasAccess := file.OwnerID.Bytes == session.UserID.Bytes
-	if !hasAccess {
-		perms, err := h.queries.GetItemPermissions(ctx, database.GetItemPermissionsParams{
-			ItemID:   pgtype.UUID{Bytes: fileID, Valid: true},
-			ItemType: database.ItemTypeFile,
-		})
-		if err == nil {
-			for _, perm := range perms {
-				if perm.UserID.Bytes == session.UserID.Bytes {
-					hasAccess = true
-					break
-				}
-			}
-		}
-	}
-
-	if !hasAccess {
-		respondWithError(w, http.StatusForbidden, "access denied")
+	// Viewers and above can read comments
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionRead) {
 		return
 	}
 
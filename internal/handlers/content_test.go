@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+func TestContentETag(t *testing.T) {
+	id := pgtype.UUID{Bytes: uuid.MustParse("3f2a9c1b-5d4e-4f60-8a71-92b3c4d5e6f7"), Valid: true}
+	tests := []struct {
+		version int32
+		suffix  string
+		want    string
+	}{
+		{1, "", `"3f2a9c1b-5d4e-4f60-8a71-92b3c4d5e6f7-v1"`},
+		{12, "", `"3f2a9c1b-5d4e-4f60-8a71-92b3c4d5e6f7-v12"`},
+		{3, "-thumb-" + string(services.PreviewSmall), `"3f2a9c1b-5d4e-4f60-8a71-92b3c4d5e6f7-v3-thumb-small"`},
+		{3, "-thumb-" + string(services.PreviewLarge), `"3f2a9c1b-5d4e-4f60-8a71-92b3c4d5e6f7-v3-thumb-large"`},
+	}
+	for _, tt := range tests {
+		if got := contentETag(id, tt.version, tt.suffix); got != tt.want {
+			t.Errorf("contentETag(v%d, %q) = %s, want %s", tt.version, tt.suffix, got, tt.want)
+		}
+	}
+}
+
+func TestServeContent(t *testing.T) {
+	const body = "0123456789abcdefghij"
+	modTime := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	etag := contentETag(pgtype.UUID{Bytes: uuid.New(), Valid: true}, 2, "")
+
+	tests := []struct {
+		name         string
+		method       string
+		header       map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{name: "full", status: http.StatusOK, body: body},
+		{name: "head", method: http.MethodHead, status: http.StatusOK},
+		{name: "range", header: map[string]string{"Range": "bytes=5-9"}, status: http.StatusPartialContent, body: "56789", contentRange: "bytes 5-9/20"},
+		{name: "open range", header: map[string]string{"Range": "bytes=15-"}, status: http.StatusPartialContent, body: "fghij", contentRange: "bytes 15-19/20"},
+		{name: "suffix range", header: map[string]string{"Range": "bytes=-3"}, status: http.StatusPartialContent, body: "hij", contentRange: "bytes 17-19/20"},
+		{name: "head range", method: http.MethodHead, header: map[string]string{"Range": "bytes=0-3"}, status: http.StatusPartialContent, contentRange: "bytes 0-3/20"},
+		{name: "range past end", header: map[string]string{"Range": "bytes=20-"}, status: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */20"},
+		{name: "if-none-match", header: map[string]string{"If-None-Match": etag}, status: http.StatusNotModified},
+		{name: "if-none-match list", header: map[string]string{"If-None-Match": `"other", ` + etag}, status: http.StatusNotModified},
+		{name: "if-none-match any", header: map[string]string{"If-None-Match": "*"}, status: http.StatusNotModified},
+		{name: "if-none-match weak", header: map[string]string{"If-None-Match": "W/" + etag}, status: http.StatusNotModified},
+		{name: "head if-none-match", method: http.MethodHead, header: map[string]string{"If-None-Match": etag}, status: http.StatusNotModified},
+		{name: "if-none-match stale", header: map[string]string{"If-None-Match": `"other"`}, status: http.StatusOK, body: body},
+		{name: "if-modified-since", header: map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)}, status: http.StatusNotModified},
+		{name: "if-modified-since older", header: map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)}, status: http.StatusOK, body: body},
+		// If-None-Match wins over If-Modified-Since
+		{name: "if-none-match stale with if-modified-since", header: map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modTime.Format(http.TimeFormat)}, status: http.StatusOK, body: body},
+		{name: "if-range current", header: map[string]string{"Range": "bytes=0-1", "If-Range": etag}, status: http.StatusPartialContent, body: "01", contentRange: "bytes 0-1/20"},
+		// A range of content that has changed since is answered in full
+		{name: "if-range stale", header: map[string]string{"Range": "bytes=0-1", "If-Range": `"other"`}, status: http.StatusOK, body: body},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/api/files/x/download", nil)
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+
+			served := pgtype.Timestamp{Time: modTime, Valid: true}
+			// Share links count every download that is not answered with 304
+			if got := notModified(r, etag, served); got != (tt.status == http.StatusNotModified) {
+				t.Errorf("notModified = %v for status %d", got, tt.status)
+			}
+			serveContent(w, r, strings.NewReader(body), "report final.txt", "text/plain", etag, served)
+
+			resp := w.Result()
+			got, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if cr := resp.Header.Get("Content-Range"); cr != tt.contentRange {
+				t.Errorf("Content-Range %q, want %q", cr, tt.contentRange)
+			}
+			// Errors carry a plain text message and none of the content's headers
+			if resp.StatusCode >= 400 {
+				return
+			}
+			if string(got) != tt.body {
+				t.Errorf("body %q, want %q", got, tt.body)
+			}
+			if resp.Header.Get("ETag") != etag {
+				t.Errorf("ETag %q, want %q", resp.Header.Get("ETag"), etag)
+			}
+			if tt.status == http.StatusOK || tt.status == http.StatusPartialContent {
+				if got := resp.Header.Get("Accept-Ranges"); got != "bytes" {
+					t.Errorf("Accept-Ranges %q, want bytes", got)
+				}
+				if got := resp.Header.Get("Last-Modified"); got != modTime.Format(http.TimeFormat) {
+					t.Errorf("Last-Modified %q, want %q", got, modTime.Format(http.TimeFormat))
+				}
+				if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename="report final.txt"` {
+					t.Errorf("Content-Disposition %q", got)
+				}
+			}
+			if tt.status == http.StatusOK && resp.Header.Get("Content-Length") != "20" {
+				t.Errorf("Content-Length %q, want 20", resp.Header.Get("Content-Length"))
+			}
+		})
+	}
+}
+
+func TestServeContentMultipleRanges(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Range", "bytes=0-1,5-6")
+	w := httptest.NewRecorder()
+
+	serveContent(w, r, strings.NewReader("0123456789"), "", "text/plain", `"x-v1"`, pgtype.Timestamp{})
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status %d, want 206", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "multipart/byteranges; boundary=") {
+		t.Errorf("Content-Type %q, want multipart/byteranges", ct)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	for _, part := range []string{"Content-Range: bytes 0-1/10\r\n", "Content-Range: bytes 5-6/10\r\n", "\r\n\r\n01\r\n", "\r\n\r\n56\r\n"} {
+		if !strings.Contains(string(got), part) {
+			t.Errorf("body does not contain %q:\n%s", part, got)
+		}
+	}
+	// Thumbnails are served without a download name
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		t.Errorf("Content-Disposition %q, want none", cd)
+	}
+}
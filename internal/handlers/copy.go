@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+type CopyHandler struct {
+	queries     *database.Queries
+	permissions *services.PermissionService
+	copies      *services.CopyService
+}
+
+func NewCopyHandler(queries *database.Queries, permissions *services.PermissionService, copies *services.CopyService) *CopyHandler {
+	return &CopyHandler{
+		queries:     queries,
+		permissions: permissions,
+		copies:      copies,
+	}
+}
+
+type CopyRequest struct {
+	// FolderID is the destination: omitted copies next to the original when
+	// the user can write there and to the top of their drive otherwise; an
+	// empty string copies to the top of their drive
+	FolderID *string `json:"folder_id"`
+	// Name of the copy; omitted keeps the original's
+	Name string `json:"name"`
+}
+
+type CopyFolderResponse struct {
+	Folder        database.Folder `json:"folder"`
+	FoldersCopied int64           `json:"folders_copied"`
+	FilesCopied   int64           `json:"files_copied"`
+	BytesCharged  int64           `json:"bytes_charged"`
+}
+
+// CopyFile copies a file the user can view
+func (h *CopyHandler) CopyFile(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	fileID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid file ID")
+		return
+	}
+
+	file, err := h.queries.GetFileByID(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	// Viewers and above can copy
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionRead) {
+		return
+	}
+
+	target, ok := h.copyTarget(w, r, session.UserID, file.ParentFolderID)
+	if !ok {
+		return
+	}
+
+	result, err := h.copies.CopyFile(r.Context(), session.UserID, file, target)
+	if err != nil {
+		respondWithCopyError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, result.File)
+}
+
+// CopyFolder copies a folder the user can view, with everything in it
+func (h *CopyHandler) CopyFolder(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	folderID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid folder ID")
+		return
+	}
+
+	folder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+
+	// Viewers and above can copy
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folder.ID, services.ActionRead) {
+		return
+	}
+
+	if folder.IsRoot.Bool {
+		respondWithError(w, http.StatusBadRequest, "cannot copy root folder")
+		return
+	}
+
+	target, ok := h.copyTarget(w, r, session.UserID, folder.ParentFolderID)
+	if !ok {
+		return
+	}
+
+	result, err := h.copies.CopyFolder(r.Context(), session.UserID, folder, target)
+	if err != nil {
+		respondWithCopyError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, CopyFolderResponse{
+		Folder:        *result.Folder,
+		FoldersCopied: result.Folders,
+		FilesCopied:   result.Files,
+		BytesCharged:  result.BytesCharged,
+	})
+}
+
+// copyTarget reads where the copy goes and checks that the user can write
+// there, writing the error response if not. parentID is where the original is.
+func (h *CopyHandler) copyTarget(w http.ResponseWriter, r *http.Request, userID pgtype.UUID, parentID pgtype.UUID) (services.CopyTarget, bool) {
+	var req CopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return services.CopyTarget{}, false
+	}
+	target := services.CopyTarget{Name: req.Name}
+
+	if req.FolderID == nil {
+		// Next to the original if the user may add to that folder
+		if parentID.Valid {
+			allowed, err := h.permissions.Can(r.Context(), userID, database.ItemTypeFolder, parentID, services.ActionWrite)
+			if err != nil {
+				fmt.Printf("failed to check write permission: %v\n", err)
+				respondWithError(w, http.StatusInternalServerError, "failed to check permissions")
+				return target, false
+			}
+			if allowed {
+				target.FolderID = parentID
+			}
+		}
+		return target, true
+	}
+
+	if *req.FolderID == "" {
+		return target, true
+	}
+	folderID, err := uuid.Parse(*req.FolderID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid folder_id")
+		return target, false
+	}
+	folder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return target, false
+	}
+	// Editors and above can add to the destination
+	if !authorize(w, r, h.permissions, userID, database.ItemTypeFolder, folder.ID, services.ActionWrite) {
+		return target, false
+	}
+	target.FolderID = folder.ID
+	return target, true
+}
+
+// respondWithCopyError answers a copy that could not be made
+func respondWithCopyError(w http.ResponseWriter, err error) {
+	var quotaErr *services.QuotaError
+	switch {
+	case errors.As(err, &quotaErr):
+		respondWithQuotaError(w, err)
+	case errors.Is(err, services.ErrItemNotFound):
+		respondWithError(w, http.StatusNotFound, "folder not found")
+	case errors.Is(err, services.ErrDestinationNotFound):
+		respondWithError(w, http.StatusNotFound, "folder not found")
+	case errors.Is(err, services.ErrCopyTooLarge):
+		respondWithError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, services.ErrContentMissing),
+		errors.Is(err, services.ErrNameTaken):
+		respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		fmt.Printf("failed to copy: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to copy")
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+type ExtractResponse struct {
+	Folder         database.Folder `json:"folder"`
+	FoldersCreated int             `json:"folders_created"`
+	FilesCreated   int             `json:"files_created"`
+	Bytes          int64           `json:"bytes"`
+}
+
+// extractUpload unpacks an uploaded zip, tar or tar.gz archive into a new
+// folder in folderID named after the archive. The archive is copied to a
+// temporary file under held, the reservation the request body was read
+// under; then every entry is checked, and the extracted size reserved in the
+// owner's quota in place of held, before anything is stored. policy applies
+// to entries the archive holds more than once.
+func (h *FilesHandler) extractUpload(
+	w http.ResponseWriter,
+	r *http.Request,
+	userID pgtype.UUID,
+	ownerID pgtype.UUID,
+	folderID pgtype.UUID,
+	part *multipart.Part,
+	held database.StorageReservation,
+	policy services.CollisionPolicy,
+) {
+	archive, err := os.CreateTemp("", "gdrive-archive-*")
+	if err != nil {
+		log.Printf("failed to create archive file: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to read archive")
+		return
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	size, err := io.Copy(archive, part)
+	if err != nil {
+		log.Printf("failed to read archive: %v", err)
+		respondWithError(w, http.StatusBadRequest, "failed to read archive")
+		return
+	}
+	h.quota.Release(r.Context(), held.ID)
+
+	listing, err := services.ScanArchive(archive, size)
+	if errors.Is(err, services.ErrInvalidArchive) || errors.Is(err, services.ErrUnsafeArchive) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("failed to read archive: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to read archive")
+		return
+	}
+
+	if listing.Bytes > 0 {
+		reservation, err := h.quota.Reserve(r.Context(), ownerID, listing.Bytes, pgtype.UUID{}, time.Time{})
+		if err != nil {
+			respondWithQuotaError(w, err)
+			return
+		}
+		defer h.quota.Release(r.Context(), reservation.ID)
+	}
+
+	folder, err := h.paths.CreateFolder(r.Context(), ownerID, folderID, archiveFolderName(part.FileName()), services.CollisionRename)
+	if err != nil {
+		respondWithFolderPathError(w, err)
+		return
+	}
+
+	resp := ExtractResponse{Folder: folder}
+	folders := h.paths.NewFolderBuilder(ownerID, folder.ID)
+	err = services.WalkArchive(archive, size, func(entry services.ExtractedEntry) error {
+		parentID, err := folders.MkdirAll(r.Context(), entry.Dirs)
+		if err != nil || entry.Name == "" {
+			return err
+		}
+
+		mimeType := mime.TypeByExtension(path.Ext(entry.Name))
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		_, err = h.uploads.Store(r.Context(), services.Upload{
+			UserID:   userID,
+			FolderID: parentID,
+			Name:     entry.Name,
+			MimeType: mimeType,
+			Content:  entry.Content,
+			Policy:   policy,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store %s: %w", path.Join(append(entry.Dirs, entry.Name)...), err)
+		}
+		resp.FilesCreated++
+		resp.Bytes += entry.Size
+		return nil
+	})
+	resp.FoldersCreated = folders.Created + 1
+	if err != nil {
+		// What was extracted so far stays in the new folder
+		log.Printf("failed to extract archive into folder %s: %v", uuid.UUID(folder.ID.Bytes), err)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("extraction stopped after %d files", resp.FilesCreated))
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+// archiveFolderName is the name of the folder an archive is extracted to: the
+// archive's name without its extension
+func archiveFolderName(filename string) string {
+	name := filename
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			name = name[:len(name)-len(ext)]
+			break
+		}
+	}
+	if name == "" {
+		return filename
+	}
+	return name
+}
+
+// respondWithFolderPathError answers a relative path whose folders could not
+// be created
+func respondWithFolderPathError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrDestinationNotFound) {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+	log.Printf("failed to create folders: %v", err)
+	respondWithError(w, http.StatusInternalServerError, "failed to create folders")
+}
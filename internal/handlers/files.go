@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
-	"strconv"
+	"net/url"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -18,14 +22,33 @@ import (
 type FilesHandler struct {
 	queries        *database.Queries
 	storageService *services.StorageService
-	db             database.DBTX
+	uploads        *services.UploadService
+	permissions    *services.PermissionService
+	trash          *services.TrashService
+	quota          *services.QuotaService
+	previews       *services.PreviewService
+	paths          *services.PathService
 }
 
-func NewFilesHandler(queries *database.Queries, storageService *services.StorageService, db database.DBTX) *FilesHandler {
+func NewFilesHandler(
+	queries *database.Queries,
+	storageService *services.StorageService,
+	uploads *services.UploadService,
+	permissions *services.PermissionService,
+	trash *services.TrashService,
+	quota *services.QuotaService,
+	previews *services.PreviewService,
+	paths *services.PathService,
+) *FilesHandler {
 	return &FilesHandler{
 		queries:        queries,
 		storageService: storageService,
-		db:             db,
+		uploads:        uploads,
+		permissions:    permissions,
+		trash:          trash,
+		quota:          quota,
+		previews:       previews,
+		paths:          paths,
 	}
 }
 
@@ -38,22 +61,26 @@ func (h *FilesHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse multipart form (max 500MB)
-	if err := r.ParseMultipartForm(500 << 20); err != nil {
+	// The body is streamed: its length bounds the file's size, which is
+	// reserved before the file is read
+	if r.ContentLength < 0 {
+		respondWithError(w, http.StatusLengthRequired, "content length required")
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "failed to parse form")
 		return
 	}
-
-	// Get file from form
-	file, header, err := r.FormFile("file")
+	form, file, err := readUploadForm(reader)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "no file provided")
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	defer file.Close()
 
 	// Get folder ID (optional)
-	folderIDStr := r.FormValue("folder_id")
+	folderIDStr := form.Get("folder_id")
 	var folderID pgtype.UUID
 	if folderIDStr != "" {
 		parsedUUID, err := uuid.Parse(folderIDStr)
@@ -62,159 +89,134 @@ func (h *FilesHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		folderID = pgtype.UUID{Bytes: parsedUUID, Valid: true}
-	}
 
-	// Determine if preview is available (simple check)
-	mimeType := header.Header.Get("Content-Type")
-	previewAvailable := false
-	if mimeType == "application/pdf" || (len(mimeType) >= 6 && mimeType[:6] == "image/") {
-		previewAvailable = true
+		// Editors and above can upload into a folder
+		if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folderID, services.ActionWrite) {
+			return
+		}
 	}
 
-	// Check if file with same name exists in the same folder
-	existingFile, err := h.queries.GetFileByNameAndFolder(r.Context(), database.GetFileByNameAndFolderParams{
-		OwnerID:        session.UserID,
-		Name:           header.Filename,
-		ParentFolderID: folderID,
-	})
-
-	var dbFile database.File
-	var versionNumber int32 = 1
-	var fileID uuid.UUID
-	fileExists := err == nil
+	mimeType := file.Header.Get("Content-Type")
 
-	if fileExists {
-		// File exists - create new version
-		dbFile = existingFile
-		fileID = uuid.UUID(dbFile.ID.Bytes)
-
-		// Get latest version number
-		latestVersionRaw, err := h.queries.GetLatestVersionNumber(r.Context(), dbFile.ID)
-		if err != nil {
-			// If no versions exist, start at 1
-			versionNumber = 1
-		} else {
-			// Type assert the result (it's an int64 from PostgreSQL)
-			if latestVersion, ok := latestVersionRaw.(int64); ok {
-				versionNumber = int32(latestVersion) + 1
-			} else if latestVersion, ok := latestVersionRaw.(int32); ok {
-				versionNumber = latestVersion + 1
-			} else {
-				versionNumber = 1
-			}
-		}
-	} else {
-		// New file - create new record
-		fileID = uuid.New()
+	// An upload whose name is taken becomes a new version of that file unless
+	// the caller asks otherwise
+	policy, ok := collisionPolicy(w, form.Get("on_conflict"), services.CollisionReplace)
+	if !ok {
+		return
 	}
 
-	// Save file to storage
-	storagePath, err := h.storageService.SaveFile(
-		uuid.UUID(session.UserID.Bytes),
-		fileID,
-		file,
-		header.Filename,
-		int(versionNumber),
-	)
+	// Hold the space in the owner's quota before writing anything to storage
+	ownerID, err := h.uploadOwner(r.Context(), session.UserID, folderID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+	reservation, err := h.quota.Reserve(r.Context(), ownerID, r.ContentLength, pgtype.UUID{}, time.Time{})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("failed to save file: %v", err))
+		respondWithQuotaError(w, err)
 		return
 	}
+	defer h.quota.Release(r.Context(), reservation.ID)
 
-	// Generate thumbnail if it's an image
-	var thumbnailPath pgtype.Text
-	if len(mimeType) >= 6 && mimeType[:6] == "image/" {
-		thumbPath, err := h.storageService.GenerateThumbnail(storagePath, mimeType, fileID)
-		if err != nil {
-			// Log error but don't fail the upload
-			fmt.Printf("failed to generate thumbnail: %v\n", err)
-		} else {
-			thumbnailPath = pgtype.Text{String: thumbPath, Valid: true}
-		}
+	// Archives can be unpacked into a new folder instead of stored as is
+	if form.Get("extract") == "true" {
+		h.extractUpload(w, r, session.UserID, ownerID, folderID, file, reservation, policy)
+		return
 	}
 
-	// Create or update file record in database
-	if fileExists {
-		// Update existing file with new version
-		err = h.queries.UpdateFileStorageAndVersion(r.Context(), database.UpdateFileStorageAndVersionParams{
-			ID:               dbFile.ID,
-			StoragePath:      storagePath,
-			Size:             header.Size,
-			MimeType:         mimeType,
-			Version:           pgtype.Int4{Int32: versionNumber, Valid: true},
-			CurrentVersionID: pgtype.UUID{Valid: false}, // Will be set after creating version
-		})
+	// A relative path such as "a/b/c.txt" places the file in the folders it
+	// names below folder_id, creating the ones that do not exist yet
+	filename := file.FileName()
+	if relativePath := form.Get("relative_path"); relativePath != "" {
+		var dirs []string
+		dirs, filename, err = services.SplitRelativePath(relativePath)
 		if err != nil {
-			h.storageService.DeleteFile(storagePath)
-			respondWithError(w, http.StatusInternalServerError, "failed to update file record")
+			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-	} else {
-		// Create new file record
-		dbFile, err = h.queries.CreateFile(r.Context(), database.CreateFileParams{
-			Name:             header.Filename,
-			OriginalName:     header.Filename,
-			MimeType:         mimeType,
-			Size:             header.Size,
-			StoragePath:      storagePath,
-			OwnerID:          session.UserID,
-			ParentFolderID:   folderID,
-			PreviewAvailable: pgtype.Bool{Bool: previewAvailable, Valid: true},
-			ThumbnailPath:    thumbnailPath,
-		})
+		folderID, err = h.paths.NewFolderBuilder(ownerID, folderID).MkdirAll(r.Context(), dirs)
 		if err != nil {
-			// Cleanup: delete the uploaded file
-			h.storageService.DeleteFile(storagePath)
-			respondWithError(w, http.StatusInternalServerError, "failed to create file record")
+			respondWithFolderPathError(w, err)
 			return
 		}
 	}
 
-	// Create version record
-	versionRecord, err := h.queries.CreateFileVersion(r.Context(), database.CreateFileVersionParams{
-		FileID:        dbFile.ID,
-		VersionNumber: versionNumber,
-		StoragePath:   storagePath,
-		Size:          header.Size,
-		UploadedBy:    session.UserID,
+	dbFile, err := h.uploads.Store(r.Context(), services.Upload{
+		UserID:   session.UserID,
+		FolderID: folderID,
+		Name:     filename,
+		MimeType: mimeType,
+		Content:  file,
+		Policy:   policy,
 	})
 	if err != nil {
-		fmt.Printf("failed to create version record: %v\n", err)
-	} else {
-		// Update current_version_id if it's a new version
-		if fileExists {
-			err = h.queries.UpdateFileStorageAndVersion(r.Context(), database.UpdateFileStorageAndVersionParams{
-				ID:               dbFile.ID,
-				StoragePath:      storagePath,
-				Size:             header.Size,
-				MimeType:         mimeType,
-				Version:           pgtype.Int4{Int32: versionNumber, Valid: true},
-				CurrentVersionID: versionRecord.ID,
-			})
-			if err != nil {
-				fmt.Printf("failed to update current_version_id: %v\n", err)
-			}
+		respondWithUploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dbFile)
+}
+
+// maxUploadFormBytes bounds the form fields sent with an uploaded file
+const maxUploadFormBytes = 64 << 10
+
+// readUploadForm reads the form fields of a multipart upload up to its "file"
+// part, which is returned unread. Fields must come before the file, so the
+// upload is placed and its space reserved without buffering the file.
+func readUploadForm(reader *multipart.Reader) (url.Values, *multipart.Part, error) {
+	form := url.Values{}
+	remaining := int64(maxUploadFormBytes)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, nil, errors.New("no file provided")
+		}
+		if err != nil {
+			return nil, nil, errors.New("failed to parse form")
 		}
+		if part.FormName() == "file" {
+			return form, part, nil
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, remaining+1))
+		part.Close()
+		if err != nil {
+			return nil, nil, errors.New("failed to parse form")
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			return nil, nil, errors.New("form fields too large")
+		}
+		form.Add(part.FormName(), string(value))
 	}
+}
 
-	// Update user storage
-	if err := h.queries.UpdateUserStorage(r.Context(), database.UpdateUserStorageParams{
-		ID:          session.UserID,
-		StorageUsed: pgtype.Int8{Int64: header.Size, Valid: true},
-	}); err != nil {
-		// Log error but don't fail the request
-		fmt.Printf("failed to update storage: %v\n", err)
+// respondWithUploadError answers an upload the upload service could not record
+func respondWithUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrNameTaken), errors.Is(err, services.ErrUploadSessionClosed):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrDestinationNotFound):
+		respondWithError(w, http.StatusNotFound, "folder not found")
+	default:
+		fmt.Printf("failed to store upload: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to save file")
 	}
+}
 
-	// Log activity
-	h.queries.LogActivity(r.Context(), database.LogActivityParams{
-		UserID:       session.UserID,
-		FileID:       dbFile.ID,
-		ActivityType: database.ActivityTypeUpload,
-	})
+// uploadOwner returns who owns, and is charged for, content userID uploads
+// into folderID: the folder's owner, or userID for their own root
+func (h *FilesHandler) uploadOwner(ctx context.Context, userID pgtype.UUID, folderID pgtype.UUID) (pgtype.UUID, error) {
+	if !folderID.Valid {
+		return userID, nil
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(dbFile)
+	folder, err := h.queries.GetFolderByID(ctx, folderID)
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	return folder.OwnerID, nil
 }
 
 // GetFiles returns files in a folder or root files
@@ -239,10 +241,13 @@ func (h *FilesHandler) GetFiles(w http.ResponseWriter, r *http.Request) {
 			respondWithError(w, http.StatusBadRequest, "invalid folder_id")
 			return
 		}
-		files, err = h.queries.GetFilesByFolder(r.Context(), database.GetFilesByFolderParams{
-			OwnerID:        session.UserID,
-			ParentFolderID: pgtype.UUID{Bytes: folderID, Valid: true},
-		})
+		parentID := pgtype.UUID{Bytes: folderID, Valid: true}
+
+		// Viewers and above can list a folder
+		if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, parentID, services.ActionRead) {
+			return
+		}
+		files, err = h.queries.GetFilesByFolder(r.Context(), parentID)
 	}
 
 	if err != nil {
@@ -333,14 +338,19 @@ func (h *FilesHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check ownership
-	if dbFile.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Viewers and above can download
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, dbFile.ID, services.ActionRead) {
+		return
+	}
+
+	// The consistency checker found the content missing or damaged
+	if dbFile.BrokenAt.Valid {
+		respondWithError(w, http.StatusGone, "file content is missing from storage")
 		return
 	}
 
 	// Open file from storage
-	file, err := h.storageService.GetFile(dbFile.StoragePath)
+	file, err := h.storageService.GetFile(r.Context(), dbFile.StoragePath)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed to open file")
 		return
@@ -350,13 +360,8 @@ func (h *FilesHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 	// Update last accessed
 	h.queries.UpdateLastAccessed(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true})
 
-	// Set headers for download
-	w.Header().Set("Content-Type", dbFile.MimeType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", dbFile.Name))
-	w.Header().Set("Content-Length", strconv.FormatInt(dbFile.Size, 10))
-
-	// Stream file
-	io.Copy(w, file)
+	// Stream file, honouring Range and conditional headers
+	serveContent(w, r, file, dbFile.Name, dbFile.MimeType, contentETag(dbFile.ID, dbFile.Version.Int32, ""), dbFile.UpdatedAt)
 }
 
 // DeleteFile moves a file to trash
@@ -381,13 +386,14 @@ func (h *FilesHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbFile.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Editors and above can move files to trash
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, dbFile.ID, services.ActionWrite) {
 		return
 	}
 
 	// Move to trash
-	if err := h.queries.TrashFile(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true}); err != nil {
+	if err := h.trash.TrashFile(r.Context(), session.UserID, dbFile); err != nil {
+		fmt.Printf("failed to trash file: %v\n", err)
 		respondWithError(w, http.StatusInternalServerError, "failed to delete file")
 		return
 	}
@@ -427,14 +433,30 @@ func (h *FilesHandler) RestoreFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbFile.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Editors and above can restore files
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, dbFile.ID, services.ActionWrite) {
+		return
+	}
+
+	dest, ok := restoreDestination(w, r)
+	if !ok {
+		return
+	}
+
+	if dbFile.Status.FileStatus != database.FileStatusTrashed {
+		respondWithError(w, http.StatusBadRequest, "file is not in trash")
+		return
+	}
+
+	// Return to the original folder, or where the caller chose if it is still in trash
+	parentID, ok := resolveRestoreParent(w, r, h.trash, h.permissions, session.UserID, dbFile.ParentFolderID, dest)
+	if !ok {
 		return
 	}
 
 	// Restore file
-	if err := h.queries.RestoreFile(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true}); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to restore file")
+	if err := h.trash.RestoreFile(r.Context(), dbFile, parentID); err != nil {
+		respondWithNameError(w, err, "failed to restore file")
 		return
 	}
 
@@ -473,29 +495,29 @@ func (h *FilesHandler) PermanentDeleteFile(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if dbFile.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Only owners can delete permanently
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, dbFile.ID, services.ActionDelete) {
 		return
 	}
 
-	// Permanently delete file
-	if err := h.queries.PermanentDeleteFile(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true}); err != nil {
+	// Release the file's content and refund the owner's storage; blobs shared
+	// with other files stay in storage
+	reclaimed, err := h.trash.PurgeFile(r.Context(), dbFile)
+	if err != nil {
+		fmt.Printf("failed to permanently delete file: %v\n", err)
 		respondWithError(w, http.StatusInternalServerError, "failed to permanently delete file")
 		return
 	}
 
-	// Note: Could also delete physical file from storage here
-	// if needed: h.storageService.DeleteFile(dbFile.StoragePath)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "file permanently deleted",
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":         "file permanently deleted",
+		"bytes_reclaimed": reclaimed,
 	})
 }
 
 // ToggleStar toggles the starred status of a file
 func (h *FilesHandler) ToggleStar(w http.ResponseWriter, r *http.Request) {
-	_, ok := middleware.GetUserFromContext(r.Context())
+	session, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -508,6 +530,18 @@ func (h *FilesHandler) ToggleStar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Get file to check access
+	dbFile, err := h.queries.GetFileByID(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	// Stars are shared by everyone with access, so changing one needs editor
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, dbFile.ID, services.ActionWrite) {
+		return
+	}
+
 	// Toggle star
 	if err := h.queries.ToggleStarFile(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true}); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed to toggle star")
@@ -520,245 +554,16 @@ func (h *FilesHandler) ToggleStar(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// SearchFiles searches files with advanced filters
-func (h *FilesHandler) SearchFiles(w http.ResponseWriter, r *http.Request) {
-	session, _ := middleware.GetUserFromContext(r.Context())
-
-	// Parse query parameters
-	query := r.URL.Query().Get("q")
-	fileType := r.URL.Query().Get("fileType")
-	owner := r.URL.Query().Get("owner")
-	folderId := r.URL.Query().Get("folderId")
-	dateModifiedType := r.URL.Query().Get("dateModifiedType")
-	dateModifiedStart := r.URL.Query().Get("dateModifiedStart")
-	dateModifiedEnd := r.URL.Query().Get("dateModifiedEnd")
-	isStarred := r.URL.Query().Get("isStarred")
-	status := r.URL.Query().Get("status")
-	if status == "" {
-		status = "active"
-	}
-
-	// Build dynamic query
-	sqlQuery := `SELECT * FROM files WHERE 1=1`
-	args := []interface{}{}
-	argCount := 1
-
-	// Owner filter
-	if owner == "me" || owner == "" || owner == "anyone" {
-		sqlQuery += fmt.Sprintf(" AND owner_id = $%d", argCount)
-		args = append(args, session.UserID)
-		argCount++
-	}
-
-	// Text search
-	if query != "" {
-		sqlQuery += fmt.Sprintf(" AND to_tsvector('english', name) @@ plainto_tsquery('english', $%d)", argCount)
-		args = append(args, query)
-		argCount++
-	}
-
-	// File type filter
-	if fileType != "" && fileType != "folder" {
-		mimePattern := getMimeTypePattern(fileType)
-		if mimePattern != "" {
-			sqlQuery += fmt.Sprintf(" AND mime_type LIKE $%d", argCount)
-			args = append(args, mimePattern+"%")
-			argCount++
-		}
-	}
-
-	// Folder filter
-	if folderId != "" {
-		folderUUID, err := uuid.Parse(folderId)
-		if err == nil {
-			sqlQuery += fmt.Sprintf(" AND parent_folder_id = $%d", argCount)
-			args = append(args, folderUUID)
-			argCount++
-		}
-	}
-
-	// Date modified filter
-	if dateModifiedType != "" {
-		dateFilter := getDateFilter(dateModifiedType, dateModifiedStart, dateModifiedEnd)
-		if dateFilter != "" {
-			sqlQuery += " AND " + dateFilter
-		}
-	}
-
-	// Starred filter
-	if isStarred == "true" {
-		sqlQuery += " AND is_starred = TRUE"
-	} else if isStarred == "false" {
-		sqlQuery += " AND is_starred = FALSE"
-	}
-
-	// Status filter
-	if status != "" && status != "all" {
-		sqlQuery += fmt.Sprintf(" AND status = $%d", argCount)
-		args = append(args, status)
-		argCount++
-	}
-
-	sqlQuery += " ORDER BY updated_at DESC LIMIT 100"
-
-	// Search response structure
-	type SearchResponse struct {
-		Files   []database.File   `json:"files"`
-		Folders []database.Folder `json:"folders"`
-	}
-
-	response := SearchResponse{
-		Files:   []database.File{},
-		Folders: []database.Folder{},
-	}
-
-	// Search files (if not exclusively searching for folders)
-	if fileType != "folder" {
-		rows, err := h.db.Query(r.Context(), sqlQuery, args...)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "failed to search files")
-			return
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var file database.File
-			err := rows.Scan(
-				&file.ID, &file.Name, &file.OriginalName, &file.MimeType,
-				&file.Size, &file.StoragePath, &file.OwnerID, &file.ParentFolderID,
-				&file.Status, &file.IsStarred, &file.ThumbnailPath, &file.PreviewAvailable,
-				&file.Version, &file.CurrentVersionID, &file.CreatedAt, &file.UpdatedAt,
-				&file.TrashedAt, &file.LastAccessedAt,
-			)
-			if err != nil {
-				respondWithError(w, http.StatusInternalServerError, "failed to parse file results")
-				return
-			}
-			response.Files = append(response.Files, file)
-		}
-	}
-
-	// Search folders (if fileType is "folder" or not specified)
-	if fileType == "" || fileType == "folder" {
-		folderQuery := `SELECT * FROM folders WHERE 1=1`
-		folderArgs := []interface{}{}
-		folderArgCount := 1
-
-		// Owner filter
-		if owner == "me" || owner == "" || owner == "anyone" {
-			folderQuery += fmt.Sprintf(" AND owner_id = $%d", folderArgCount)
-			folderArgs = append(folderArgs, session.UserID)
-			folderArgCount++
-		}
-
-		// Text search
-		if query != "" {
-			folderQuery += fmt.Sprintf(" AND to_tsvector('english', name) @@ plainto_tsquery('english', $%d)", folderArgCount)
-			folderArgs = append(folderArgs, query)
-			folderArgCount++
-		}
-
-		// Folder location filter
-		if folderId != "" {
-			folderUUID, err := uuid.Parse(folderId)
-			if err == nil {
-				folderQuery += fmt.Sprintf(" AND parent_folder_id = $%d", folderArgCount)
-				folderArgs = append(folderArgs, folderUUID)
-				folderArgCount++
-			}
-		}
-
-		// Date modified filter
-		if dateModifiedType != "" {
-			dateFilter := getDateFilter(dateModifiedType, dateModifiedStart, dateModifiedEnd)
-			if dateFilter != "" {
-				folderQuery += " AND " + dateFilter
-			}
-		}
-
-		// Starred filter
-		if isStarred == "true" {
-			folderQuery += " AND is_starred = TRUE"
-		} else if isStarred == "false" {
-			folderQuery += " AND is_starred = FALSE"
-		}
-
-		// Status filter
-		if status != "" && status != "all" {
-			folderQuery += fmt.Sprintf(" AND status = $%d", folderArgCount)
-			folderArgs = append(folderArgs, status)
-			folderArgCount++
-		}
-
-		folderQuery += " AND is_root = FALSE ORDER BY updated_at DESC LIMIT 100"
-
-		folderRows, err := h.db.Query(r.Context(), folderQuery, folderArgs...)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "failed to search folders")
-			return
-		}
-		defer folderRows.Close()
-
-		for folderRows.Next() {
-			var folder database.Folder
-			err := folderRows.Scan(
-				&folder.ID, &folder.Name, &folder.OwnerID, &folder.ParentFolderID,
-				&folder.IsRoot, &folder.Status, &folder.IsStarred,
-				&folder.CreatedAt, &folder.UpdatedAt, &folder.TrashedAt,
-			)
-			if err != nil {
-				respondWithError(w, http.StatusInternalServerError, "failed to parse folder results")
-				return
-			}
-			response.Folders = append(response.Folders, folder)
-		}
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-// getMimeTypePattern returns MIME type pattern for file type
-func getMimeTypePattern(fileType string) string {
-	patterns := map[string]string{
-		"image":       "image/",
-		"video":       "video/",
-		"audio":       "audio/",
-		"pdf":         "application/pdf",
-		"document":    "application/vnd.openxmlformats-officedocument.wordprocessingml",
-		"spreadsheet": "application/vnd.openxmlformats-officedocument.spreadsheetml",
-		"archive":     "application/zip",
-	}
-	return patterns[fileType]
-}
-
-// getDateFilter returns SQL date filter based on type
-func getDateFilter(dateType, startDate, endDate string) string {
-	switch dateType {
-	case "today":
-		return "updated_at >= CURRENT_DATE"
-	case "yesterday":
-		return "updated_at >= CURRENT_DATE - INTERVAL '1 day' AND updated_at < CURRENT_DATE"
-	case "last7days":
-		return "updated_at >= CURRENT_DATE - INTERVAL '7 days'"
-	case "last30days":
-		return "updated_at >= CURRENT_DATE - INTERVAL '30 days'"
-	case "thisYear":
-		return "updated_at >= DATE_TRUNC('year', CURRENT_DATE)"
-	case "custom":
-		if startDate != "" && endDate != "" {
-			return fmt.Sprintf("updated_at >= '%s' AND updated_at <= '%s'", startDate, endDate)
-		}
-	}
-	return ""
-}
-
 type RenameFileRequest struct {
 	NewName string `json:"new_name"`
+	// OnConflict is fail (the default) or rename
+	OnConflict string `json:"on_conflict,omitempty"`
 }
 
 type MoveFileRequest struct {
 	FolderID string `json:"folder_id"` // Can be empty for root
+	// OnConflict is fail (the default) or rename
+	OnConflict string `json:"on_conflict,omitempty"`
 }
 
 // RenameFile renames a file
@@ -787,6 +592,11 @@ func (h *FilesHandler) RenameFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policy, ok := collisionPolicy(w, req.OnConflict, services.CollisionFail)
+	if !ok {
+		return
+	}
+
 	// Get file to check ownership
 	dbFile, err := h.queries.GetFileByID(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true})
 	if err != nil {
@@ -794,17 +604,15 @@ func (h *FilesHandler) RenameFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbFile.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Editors and above can rename
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, dbFile.ID, services.ActionWrite) {
 		return
 	}
 
 	// Rename file in database
-	if err := h.queries.RenameFile(r.Context(), database.RenameFileParams{
-		ID:   pgtype.UUID{Bytes: fileID, Valid: true},
-		Name: req.NewName,
-	}); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to rename file")
+	name, err := h.paths.RenameFile(r.Context(), dbFile, req.NewName, policy)
+	if err != nil {
+		respondWithNameError(w, err, "failed to rename file")
 		return
 	}
 
@@ -813,11 +621,12 @@ func (h *FilesHandler) RenameFile(w http.ResponseWriter, r *http.Request) {
 		UserID:       session.UserID,
 		FileID:       pgtype.UUID{Bytes: fileID, Valid: true},
 		ActivityType: database.ActivityTypeRename,
-		Details:      json.RawMessage(fmt.Sprintf(`{"old_name": "%s", "new_name": "%s"}`, dbFile.Name, req.NewName)),
+		Details:      json.RawMessage(fmt.Sprintf(`{"old_name": "%s", "new_name": "%s"}`, dbFile.Name, name)),
 	})
 
 	respondWithJSON(w, http.StatusOK, map[string]string{
 		"message": "file renamed successfully",
+		"name":    name,
 	})
 }
 
@@ -842,6 +651,11 @@ func (h *FilesHandler) MoveFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policy, ok := collisionPolicy(w, req.OnConflict, services.CollisionFail)
+	if !ok {
+		return
+	}
+
 	// Get file to check ownership
 	dbFile, err := h.queries.GetFileByID(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true})
 	if err != nil {
@@ -849,8 +663,8 @@ func (h *FilesHandler) MoveFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbFile.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Editors and above can move
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, dbFile.ID, services.ActionWrite) {
 		return
 	}
 
@@ -864,14 +678,15 @@ func (h *FilesHandler) MoveFile(w http.ResponseWriter, r *http.Request) {
 		}
 		folderID = pgtype.UUID{Bytes: parsedUUID, Valid: true}
 
-		// Verify folder exists and user owns it
+		// Verify folder exists
 		folder, err := h.queries.GetFolderByID(r.Context(), folderID)
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, "folder not found")
 			return
 		}
-		if folder.OwnerID != session.UserID {
-			respondWithError(w, http.StatusForbidden, "forbidden")
+
+		// The destination folder must be writable too
+		if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folder.ID, services.ActionWrite) {
 			return
 		}
 	} else {
@@ -879,22 +694,22 @@ func (h *FilesHandler) MoveFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Move file in database
-	if err := h.queries.MoveFile(r.Context(), database.MoveFileParams{
-		ID:             pgtype.UUID{Bytes: fileID, Valid: true},
-		ParentFolderID: folderID,
-	}); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to move file")
+	name, err := h.paths.MoveFile(r.Context(), dbFile, folderID, policy)
+	if err != nil {
+		respondWithNameError(w, err, "failed to move file")
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, map[string]string{
 		"message": "file moved successfully",
+		"name":    name,
 	})
 }
 
-// GetThumbnail serves a file thumbnail
+// GetThumbnail serves a file thumbnail in the requested size (small, medium or
+// large). While previews are being generated a placeholder is served with 202.
 func (h *FilesHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
-	_, ok := middleware.GetUserFromContext(r.Context())
+	session, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -907,6 +722,12 @@ func (h *FilesHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	size, ok := services.ParsePreviewSize(r.URL.Query().Get("size"))
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "size must be small, medium or large")
+		return
+	}
+
 	// Get file from database
 	dbFile, err := h.queries.GetFileByID(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true})
 	if err != nil {
@@ -914,25 +735,53 @@ func (h *FilesHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Viewers and above can see thumbnails
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, dbFile.ID, services.ActionRead) {
+		return
+	}
+
+	serveThumbnail(w, r, h.previews, h.storageService, dbFile, size)
+}
+
+// serveThumbnail streams file's thumbnail in size, or a placeholder with 202
+// while its previews are being generated
+func serveThumbnail(w http.ResponseWriter, r *http.Request, previews *services.PreviewService, storageService *services.StorageService, dbFile database.File, size services.PreviewSize) {
+	thumbnailPath, pending, err := previews.Thumbnail(r.Context(), dbFile, size)
+	if err != nil {
+		fmt.Printf("failed to look up thumbnail: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to get thumbnail")
+		return
+	}
+
+	if pending {
+		placeholder, err := services.PreviewPlaceholder(size, dbFile.Name)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to draw placeholder")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(placeholder)
+		return
+	}
+
 	// Check if thumbnail exists
-	if !dbFile.ThumbnailPath.Valid || dbFile.ThumbnailPath.String == "" {
+	if thumbnailPath == "" {
 		respondWithError(w, http.StatusNotFound, "thumbnail not available")
 		return
 	}
 
 	// Open thumbnail from storage
-	thumbnailPath := dbFile.ThumbnailPath.String
-	file, err := h.storageService.GetFile(thumbnailPath)
+	file, err := storageService.GetThumbnail(r.Context(), thumbnailPath)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed to open thumbnail")
 		return
 	}
 	defer file.Close()
 
-	// Set headers for thumbnail
-	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Cache-Control", "public, max-age=31536000")
-
-	// Stream thumbnail
-	io.Copy(w, file)
+	// Stream thumbnail, honouring Range and conditional headers
+	w.Header().Set("Cache-Control", "private, max-age=31536000")
+	serveContent(w, r, file, "", "image/jpeg", contentETag(dbFile.ID, dbFile.Version.Int32, "-thumb-"+string(size)), dbFile.UpdatedAt)
 }
\ No newline at end of file
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+// uploadBody writes a multipart form of fields, in order; a field named
+// "file" is written as a file part
+func uploadBody(t *testing.T, fields ...[2]string) *multipart.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, field := range fields {
+		var err error
+		if field[0] == "file" {
+			var part io.Writer
+			part, err = w.CreateFormFile("file", "notes.txt")
+			if err == nil {
+				_, err = io.WriteString(part, field[1])
+			}
+		} else {
+			err = w.WriteField(field[0], field[1])
+		}
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", field[0], err)
+		}
+	}
+	w.Close()
+	return multipart.NewReader(&buf, w.Boundary())
+}
+
+func TestReadUploadForm(t *testing.T) {
+	form, file, err := readUploadForm(uploadBody(t,
+		[2]string{"folder_id", "3f2a9c1b-5d4e-4f60-8a71-92b3c4d5e6f7"},
+		[2]string{"on_conflict", "rename"},
+		[2]string{"file", "hello"},
+		[2]string{"extract", "true"},
+	))
+	if err != nil {
+		t.Fatalf("readUploadForm: %v", err)
+	}
+	if form.Get("folder_id") != "3f2a9c1b-5d4e-4f60-8a71-92b3c4d5e6f7" || form.Get("on_conflict") != "rename" {
+		t.Errorf("form = %v", form)
+	}
+	// Fields after the file are not read
+	if form.Has("extract") {
+		t.Errorf("field after the file was read: %v", form)
+	}
+	if file.FileName() != "notes.txt" {
+		t.Errorf("file name %q, want notes.txt", file.FileName())
+	}
+	if content, _ := io.ReadAll(file); string(content) != "hello" {
+		t.Errorf("file content %q, want hello", content)
+	}
+}
+
+func TestReadUploadFormErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields [][2]string
+		want   string
+	}{
+		{"no file", [][2]string{{"folder_id", "x"}}, "no file provided"},
+		{"empty", nil, "no file provided"},
+		{"large fields", [][2]string{{"relative_path", strings.Repeat("a/", maxUploadFormBytes/2)}, {"x", "y"}, {"file", "hello"}}, "form fields too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readUploadForm(uploadBody(t, tt.fields...))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("readUploadForm = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -9,21 +11,30 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/shri771/gdrive/internal/database"
 	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
 )
 
 type FoldersHandler struct {
-	queries *database.Queries
+	queries     *database.Queries
+	permissions *services.PermissionService
+	trash       *services.TrashService
+	paths       *services.PathService
 }
 
-func NewFoldersHandler(queries *database.Queries) *FoldersHandler {
+func NewFoldersHandler(queries *database.Queries, permissions *services.PermissionService, trash *services.TrashService, paths *services.PathService) *FoldersHandler {
 	return &FoldersHandler{
-		queries: queries,
+		queries:     queries,
+		permissions: permissions,
+		trash:       trash,
+		paths:       paths,
 	}
 }
 
 type CreateFolderRequest struct {
 	Name           string `json:"name"`
 	ParentFolderID string `json:"parent_folder_id,omitempty"`
+	// OnConflict is fail (the default) or rename
+	OnConflict string `json:"on_conflict,omitempty"`
 }
 
 // CreateFolder creates a new folder
@@ -45,6 +56,12 @@ func (h *FoldersHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policy, ok := collisionPolicy(w, req.OnConflict, services.CollisionFail)
+	if !ok {
+		return
+	}
+
+	ownerID := session.UserID
 	var parentFolderID pgtype.UUID
 	if req.ParentFolderID != "" {
 		parsedUUID, err := uuid.Parse(req.ParentFolderID)
@@ -53,16 +70,24 @@ func (h *FoldersHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		parentFolderID = pgtype.UUID{Bytes: parsedUUID, Valid: true}
+
+		// Editors and above can create subfolders
+		if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, parentFolderID, services.ActionWrite) {
+			return
+		}
+
+		// Subfolders belong to the owner of the parent, like uploaded files
+		parentFolder, err := h.queries.GetFolderByID(r.Context(), parentFolderID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "parent folder not found")
+			return
+		}
+		ownerID = parentFolder.OwnerID
 	}
 
-	folder, err := h.queries.CreateFolder(r.Context(), database.CreateFolderParams{
-		Name:           req.Name,
-		OwnerID:        session.UserID,
-		ParentFolderID: parentFolderID,
-		IsRoot:         pgtype.Bool{Bool: false, Valid: true},
-	})
+	folder, err := h.paths.CreateFolder(r.Context(), ownerID, parentFolderID, req.Name, policy)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to create folder")
+		respondWithNameError(w, err, "failed to create folder")
 		return
 	}
 
@@ -92,7 +117,13 @@ func (h *FoldersHandler) GetFolders(w http.ResponseWriter, r *http.Request) {
 			respondWithError(w, http.StatusBadRequest, "invalid folder_id")
 			return
 		}
-		folders, err = h.queries.GetSubfolders(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
+		parentID := pgtype.UUID{Bytes: folderID, Valid: true}
+
+		// Viewers and above can list a folder
+		if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, parentID, services.ActionRead) {
+			return
+		}
+		folders, err = h.queries.GetSubfolders(r.Context(), parentID)
 	}
 
 	if err != nil {
@@ -143,8 +174,8 @@ func (h *FoldersHandler) GetFolderByIDHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if folder.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Viewers and above can see the folder
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folder.ID, services.ActionRead) {
 		return
 	}
 
@@ -154,10 +185,14 @@ func (h *FoldersHandler) GetFolderByIDHandler(w http.ResponseWriter, r *http.Req
 
 type RenameFolderRequest struct {
 	NewName string `json:"new_name"`
+	// OnConflict is fail (the default) or rename
+	OnConflict string `json:"on_conflict,omitempty"`
 }
 
 type MoveFolderRequest struct {
 	ParentFolderID string `json:"parent_folder_id"` // Can be empty for root
+	// OnConflict is fail (the default) or rename
+	OnConflict string `json:"on_conflict,omitempty"`
 }
 
 // RenameFolder renames a folder
@@ -186,24 +221,27 @@ func (h *FoldersHandler) RenameFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get folder to check ownership
+	policy, ok := collisionPolicy(w, req.OnConflict, services.CollisionFail)
+	if !ok {
+		return
+	}
+
+	// Get folder to check access
 	dbFolder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "folder not found")
 		return
 	}
 
-	if dbFolder.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Editors and above can rename
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, dbFolder.ID, services.ActionWrite) {
 		return
 	}
 
 	// Rename folder in database
-	if err := h.queries.RenameFolder(r.Context(), database.RenameFolderParams{
-		ID:   pgtype.UUID{Bytes: folderID, Valid: true},
-		Name: req.NewName,
-	}); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to rename folder")
+	name, err := h.paths.RenameFolder(r.Context(), dbFolder, req.NewName, policy)
+	if err != nil {
+		respondWithNameError(w, err, "failed to rename folder")
 		return
 	}
 
@@ -211,6 +249,7 @@ func (h *FoldersHandler) RenameFolder(w http.ResponseWriter, r *http.Request) {
 
 	respondWithJSON(w, http.StatusOK, map[string]string{
 		"message": "folder renamed successfully",
+		"name":    name,
 	})
 }
 
@@ -235,15 +274,20 @@ func (h *FoldersHandler) MoveFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get folder to check ownership
+	policy, ok := collisionPolicy(w, req.OnConflict, services.CollisionFail)
+	if !ok {
+		return
+	}
+
+	// Get folder to check access
 	dbFolder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "folder not found")
 		return
 	}
 
-	if dbFolder.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Editors and above can move
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, dbFolder.ID, services.ActionWrite) {
 		return
 	}
 
@@ -263,37 +307,30 @@ func (h *FoldersHandler) MoveFolder(w http.ResponseWriter, r *http.Request) {
 		}
 		parentFolderID = pgtype.UUID{Bytes: parsedUUID, Valid: true}
 
-		// Verify parent folder exists and user owns it
+		// Verify parent folder exists
 		parentFolder, err := h.queries.GetFolderByID(r.Context(), parentFolderID)
 		if err != nil {
 			respondWithError(w, http.StatusNotFound, "parent folder not found")
 			return
 		}
-		if parentFolder.OwnerID != session.UserID {
-			respondWithError(w, http.StatusForbidden, "forbidden")
-			return
-		}
-
-		// Can't move folder into itself
-		if parsedUUID == folderID {
-			respondWithError(w, http.StatusBadRequest, "cannot move folder into itself")
+		// The destination folder must be writable too
+		if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, parentFolder.ID, services.ActionWrite) {
 			return
 		}
 	} else {
 		parentFolderID = pgtype.UUID{Valid: false} // Move to root
 	}
 
-	// Move folder in database
-	if err := h.queries.MoveFolder(r.Context(), database.MoveFolderParams{
-		ID:             pgtype.UUID{Bytes: folderID, Valid: true},
-		ParentFolderID: parentFolderID,
-	}); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to move folder")
+	// Move folder in database; the destination must not be the folder or below it
+	name, err := h.paths.MoveFolder(r.Context(), dbFolder, parentFolderID, policy)
+	if err != nil {
+		respondWithNameError(w, err, "failed to move folder")
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, map[string]string{
 		"message": "folder moved successfully",
+		"name":    name,
 	})
 }
 
@@ -330,15 +367,15 @@ func (h *FoldersHandler) ToggleStarFolder(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Get folder to check ownership
+	// Get folder to check access
 	dbFolder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "folder not found")
 		return
 	}
 
-	if dbFolder.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Stars are shared by everyone with access, so changing one needs editor
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, dbFolder.ID, services.ActionWrite) {
 		return
 	}
 
@@ -368,15 +405,15 @@ func (h *FoldersHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get folder to check ownership
+	// Get folder to check access
 	dbFolder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "folder not found")
 		return
 	}
 
-	if dbFolder.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Editors and above can move folders to trash
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, dbFolder.ID, services.ActionWrite) {
 		return
 	}
 
@@ -386,14 +423,18 @@ func (h *FoldersHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Trash folder
-	if err := h.queries.TrashFolder(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true}); err != nil {
+	// Trash the folder together with everything still active below it
+	folders, files, err := h.trash.TrashFolder(r.Context(), session.UserID, dbFolder)
+	if err != nil {
+		fmt.Printf("failed to trash folder: %v\n", err)
 		respondWithError(w, http.StatusInternalServerError, "failed to trash folder")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{
-		"message": "folder moved to trash",
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":         "folder moved to trash",
+		"folders_trashed": folders,
+		"files_trashed":   files,
 	})
 }
 
@@ -412,26 +453,45 @@ func (h *FoldersHandler) RestoreFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get folder to check ownership (can be trashed)
+	// Get folder to check access (can be trashed)
 	dbFolder, err := h.queries.GetFolderByIDAnyStatus(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "folder not found")
 		return
 	}
 
-	if dbFolder.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Editors and above can restore folders
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, dbFolder.ID, services.ActionWrite) {
 		return
 	}
 
-	// Restore folder
-	if err := h.queries.RestoreFolder(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true}); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "failed to restore folder")
+	dest, ok := restoreDestination(w, r)
+	if !ok {
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{
-		"message": "folder restored",
+	if dbFolder.Status.FileStatus != database.FileStatusTrashed {
+		respondWithError(w, http.StatusBadRequest, "folder is not in trash")
+		return
+	}
+
+	// Return to the original parent, or where the caller chose if it is still in trash
+	parentID, ok := resolveRestoreParent(w, r, h.trash, h.permissions, session.UserID, dbFolder.ParentFolderID, dest)
+	if !ok {
+		return
+	}
+
+	// Restore the folder and the items trashed along with it
+	folders, files, err := h.trash.RestoreFolder(r.Context(), dbFolder, parentID)
+	if err != nil {
+		respondWithNameError(w, err, "failed to restore folder")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":          "folder restored",
+		"folders_restored": folders,
+		"files_restored":   files,
 	})
 }
 
@@ -468,25 +528,64 @@ func (h *FoldersHandler) PermanentDeleteFolder(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// Get folder to check ownership (can be trashed)
+	// Get folder to check access (can be trashed)
 	dbFolder, err := h.queries.GetFolderByIDAnyStatus(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "folder not found")
 		return
 	}
 
-	if dbFolder.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Only owners can delete permanently
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, dbFolder.ID, services.ActionDelete) {
 		return
 	}
 
-	// Permanently delete folder
-	if err := h.queries.PermanentDeleteFolder(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true}); err != nil {
+	// Can't delete root folder
+	if dbFolder.IsRoot.Bool {
+		respondWithError(w, http.StatusBadRequest, "cannot delete root folder")
+		return
+	}
+
+	// Delete every file below the folder from storage, then the folders themselves
+	result, err := h.trash.PurgeFolder(r.Context(), dbFolder)
+	if err != nil {
+		fmt.Printf("failed to permanently delete folder: %v\n", err)
 		respondWithError(w, http.StatusInternalServerError, "failed to permanently delete folder")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{
-		"message": "folder permanently deleted",
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message":         "folder permanently deleted",
+		"folders_deleted": result.Folders,
+		"files_deleted":   result.Files,
+		"bytes_reclaimed": result.BytesReclaimed,
 	})
-}
\ No newline at end of file
+}
+
+// collisionPolicy reads an on_conflict value, answering 400 if it is invalid
+func collisionPolicy(w http.ResponseWriter, value string, fallback services.CollisionPolicy) (services.CollisionPolicy, bool) {
+	policy, err := services.ParseCollisionPolicy(value, fallback)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return policy, true
+}
+
+// respondWithNameError answers a create, rename or move that failed, with
+// msg for unexpected errors
+func respondWithNameError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, services.ErrNameTaken):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrReplaceUnsupported),
+		errors.Is(err, services.ErrMoveIntoItself),
+		errors.Is(err, services.ErrMoveIntoDescendant):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrDestinationNotFound):
+		respondWithError(w, http.StatusNotFound, "folder not found")
+	default:
+		fmt.Printf("%s: %v\n", msg, err)
+		respondWithError(w, http.StatusInternalServerError, msg)
+	}
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+type PathsHandler struct {
+	queries     *database.Queries
+	permissions *services.PermissionService
+	paths       *services.PathService
+}
+
+func NewPathsHandler(queries *database.Queries, permissions *services.PermissionService, paths *services.PathService) *PathsHandler {
+	return &PathsHandler{
+		queries:     queries,
+		permissions: permissions,
+		paths:       paths,
+	}
+}
+
+// AncestorsResponse is the breadcrumb trail of an item
+type AncestorsResponse struct {
+	// Ancestors are the folders above the item, top-most first
+	Ancestors []database.Folder `json:"ancestors"`
+	// Path joins the names of the ancestors and the item
+	Path string `json:"path"`
+	// Truncated is set when folders above the first ancestor exist but are
+	// not visible to the user, as for items shared from another drive
+	Truncated bool `json:"truncated"`
+}
+
+type ResolvePathResponse struct {
+	Type   database.ItemType `json:"type"`
+	File   *database.File    `json:"file,omitempty"`
+	Folder *database.Folder  `json:"folder,omitempty"`
+}
+
+// FileAncestors returns the folders above a file
+func (h *PathsHandler) FileAncestors(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	fileID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid file ID")
+		return
+	}
+
+	file, err := h.queries.GetFileByID(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionRead) {
+		return
+	}
+
+	h.respondWithAncestors(w, r, session.UserID, file.ParentFolderID, file.Name)
+}
+
+// FolderAncestors returns the folders above a folder
+func (h *PathsHandler) FolderAncestors(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	folderID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid folder ID")
+		return
+	}
+
+	folder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folder.ID, services.ActionRead) {
+		return
+	}
+
+	h.respondWithAncestors(w, r, session.UserID, folder.ParentFolderID, folder.Name)
+}
+
+// respondWithAncestors answers with the chain of folders ending at parentID.
+// Access is inherited downwards, so once one folder of the chain is visible
+// every folder below it is too; the ones above the first visible folder are
+// left out.
+func (h *PathsHandler) respondWithAncestors(w http.ResponseWriter, r *http.Request, userID pgtype.UUID, parentID pgtype.UUID, name string) {
+	resp := AncestorsResponse{Ancestors: []database.Folder{}}
+
+	if parentID.Valid {
+		chain, err := h.paths.Ancestors(r.Context(), parentID)
+		if err != nil {
+			fmt.Printf("failed to get ancestors: %v\n", err)
+			respondWithError(w, http.StatusInternalServerError, "failed to get ancestors")
+			return
+		}
+
+		visible := len(chain)
+		for i, folder := range chain {
+			allowed, err := h.permissions.Can(r.Context(), userID, database.ItemTypeFolder, folder.ID, services.ActionRead)
+			if err != nil {
+				fmt.Printf("failed to check read permission: %v\n", err)
+				respondWithError(w, http.StatusInternalServerError, "failed to check permissions")
+				return
+			}
+			if allowed {
+				visible = i
+				break
+			}
+		}
+		resp.Ancestors = chain[visible:]
+		resp.Truncated = visible > 0
+	}
+
+	names := make([]string, 0, len(resp.Ancestors)+1)
+	for _, folder := range resp.Ancestors {
+		names = append(names, folder.Name)
+	}
+	resp.Path = "/" + strings.Join(append(names, name), "/")
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// ResolvePath finds the file or folder at a path such as /Projects/2025/Q3,
+// from the top of the user's drive or from the folder given by folder_id
+func (h *PathsHandler) ResolvePath(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	itemPath := r.URL.Query().Get("path")
+	if itemPath == "" {
+		respondWithError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+
+	var base *database.Folder
+	if folderIDStr := r.URL.Query().Get("folder_id"); folderIDStr != "" {
+		folderID, err := uuid.Parse(folderIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid folder_id")
+			return
+		}
+		folder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "folder not found")
+			return
+		}
+		// Everything below a visible folder is visible too
+		if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folder.ID, services.ActionRead) {
+			return
+		}
+		base = &folder
+	}
+
+	folder, file, err := h.paths.ResolvePath(r.Context(), session.UserID, base, itemPath)
+	switch {
+	case errors.Is(err, services.ErrInvalidPath):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, services.ErrPathNotFound):
+		respondWithError(w, http.StatusNotFound, "path not found")
+		return
+	case err != nil:
+		fmt.Printf("failed to resolve path: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to resolve path")
+		return
+	}
+
+	if folder != nil {
+		respondWithJSON(w, http.StatusOK, ResolvePathResponse{Type: database.ItemTypeFolder, Folder: folder})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ResolvePathResponse{Type: database.ItemTypeFile, File: file})
+}
+
+// RootTree returns every folder of the user's drive as a tree
+func (h *PathsHandler) RootTree(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	depth, ok := treeDepth(w, r)
+	if !ok {
+		return
+	}
+
+	tree, err := h.paths.Tree(r.Context(), session.UserID, nil, depth)
+	if err != nil {
+		fmt.Printf("failed to get folder tree: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to get folder tree")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tree)
+}
+
+// FolderTree returns a folder and every folder below it as a tree
+func (h *PathsHandler) FolderTree(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	folderID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid folder ID")
+		return
+	}
+
+	depth, ok := treeDepth(w, r)
+	if !ok {
+		return
+	}
+
+	folder, err := h.queries.GetFolderByID(r.Context(), pgtype.UUID{Bytes: folderID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folder.ID, services.ActionRead) {
+		return
+	}
+
+	tree, err := h.paths.Tree(r.Context(), folder.OwnerID, &folder, depth)
+	if errors.Is(err, services.ErrItemNotFound) {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return
+	}
+	if err != nil {
+		fmt.Printf("failed to get folder tree: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to get folder tree")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tree)
+}
+
+// treeDepth reads the optional depth query parameter; without it the whole
+// tree is returned
+func treeDepth(w http.ResponseWriter, r *http.Request) (int, bool) {
+	depthStr := r.URL.Query().Get("depth")
+	if depthStr == "" {
+		return -1, true
+	}
+	depth, err := strconv.Atoi(depthStr)
+	if err != nil || depth < 0 {
+		respondWithError(w, http.StatusBadRequest, "depth must be a non-negative number")
+		return 0, false
+	}
+	return depth, true
+}
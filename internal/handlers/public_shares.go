@@ -0,0 +1,560 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+// PublicShareHandler serves share links to visitors without an account
+type PublicShareHandler struct {
+	queries        *database.Queries
+	storageService *services.StorageService
+	authService    *services.AuthService
+	archives       *services.ArchiveService
+	previews       *services.PreviewService
+	// Wrong passwords are limited per client address and per link
+	unlockByAddress *services.AttemptLimiter
+	unlockByLink    *services.AttemptLimiter
+	// The requests of one download count once against max_downloads
+	downloads *services.DownloadTracker
+}
+
+func NewPublicShareHandler(queries *database.Queries, storageService *services.StorageService, authService *services.AuthService, archives *services.ArchiveService, previews *services.PreviewService) *PublicShareHandler {
+	byAddress, byLink := services.NewUnlockLimiters()
+	return &PublicShareHandler{
+		queries:         queries,
+		storageService:  storageService,
+		authService:     authService,
+		archives:        archives,
+		previews:        previews,
+		unlockByAddress: byAddress,
+		unlockByLink:    byLink,
+		downloads:       services.NewShareDownloadTracker(),
+	}
+}
+
+// shareAccessHeader carries the token returned by UnlockShare
+const shareAccessHeader = "X-Share-Access"
+
+// PublicFile is the part of a file's metadata shown to link visitors
+type PublicFile struct {
+	ID        pgtype.UUID      `json:"id"`
+	Name      string           `json:"name"`
+	MimeType  string           `json:"mime_type"`
+	Size      int64            `json:"size"`
+	UpdatedAt pgtype.Timestamp `json:"updated_at"`
+}
+
+// PublicFolder is the part of a folder's metadata shown to link visitors
+type PublicFolder struct {
+	ID        pgtype.UUID      `json:"id"`
+	Name      string           `json:"name"`
+	UpdatedAt pgtype.Timestamp `json:"updated_at"`
+}
+
+type PublicShareResponse struct {
+	ItemType           database.ItemType       `json:"item_type"`
+	Permission         database.PermissionRole `json:"permission"`
+	ExpiresAt          pgtype.Timestamp        `json:"expires_at"`
+	AllowDownload      bool                    `json:"allow_download"`
+	DownloadsRemaining *int32                  `json:"downloads_remaining"` // null when unlimited
+	File               *PublicFile             `json:"file,omitempty"`
+	Folder             *PublicFolder           `json:"folder,omitempty"`
+}
+
+type UnlockShareRequest struct {
+	Password string `json:"password"`
+}
+
+type UnlockShareResponse struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type PublicFolderListing struct {
+	Folder  PublicFolder   `json:"folder"`
+	Folders []PublicFolder `json:"folders"`
+	Files   []PublicFile   `json:"files"`
+}
+
+// GetShare returns the metadata of the shared file or folder
+func (h *PublicShareHandler) GetShare(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.resolveShare(w, r)
+	if !ok {
+		return
+	}
+
+	response := PublicShareResponse{
+		ItemType:      share.ItemType,
+		Permission:    share.Permission.PermissionRole,
+		ExpiresAt:     share.ExpiresAt,
+		AllowDownload: share.AllowDownload,
+	}
+	if share.MaxDownloads.Valid {
+		remaining := max(share.MaxDownloads.Int32-share.DownloadCount, 0)
+		response.DownloadsRemaining = &remaining
+	}
+
+	if share.ItemType == database.ItemTypeFile {
+		file, err := h.queries.GetFileByID(r.Context(), share.ItemID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "shared item not found")
+			return
+		}
+		publicFile := toPublicFile(file)
+		response.File = &publicFile
+	} else {
+		folder, err := h.queries.GetFolderByID(r.Context(), share.ItemID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "shared item not found")
+			return
+		}
+		publicFolder := toPublicFolder(folder)
+		response.Folder = &publicFolder
+	}
+
+	h.logAccess(r, share, database.ShareAccessActionView, share.ItemType, share.ItemID)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// UnlockShare checks the password of a protected share link and returns a
+// short-lived access token to send with later requests. Clients and links
+// that send too many wrong passwords are refused for a while.
+func (h *PublicShareHandler) UnlockShare(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.lookupShare(w, r)
+	if !ok {
+		return
+	}
+
+	if !share.PasswordHash.Valid {
+		respondWithError(w, http.StatusBadRequest, "share link is not password protected")
+		return
+	}
+
+	address := clientAddress(r)
+	linkKey := uuid.UUID(share.ID.Bytes).String()
+	if !allowAttempt(w, h.unlockByAddress, address) || !allowAttempt(w, h.unlockByLink, linkKey) {
+		return
+	}
+
+	var req UnlockShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.authService.CheckPassword(share.PasswordHash.String, req.Password); err != nil {
+		h.unlockByAddress.Fail(address)
+		h.unlockByLink.Fail(linkKey)
+		respondWithError(w, http.StatusUnauthorized, "incorrect password")
+		return
+	}
+
+	// Access never outlives the link itself
+	expiresAt := time.Now().Add(services.ShareAccessDuration)
+	if share.ExpiresAt.Valid && share.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = share.ExpiresAt.Time
+	}
+
+	respondWithJSON(w, http.StatusOK, UnlockShareResponse{
+		AccessToken: h.authService.SignShareAccess(uuid.UUID(share.ID.Bytes), share.PasswordHash.String, expiresAt),
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// BrowseShare lists the contents of a shared folder or of any folder below it
+func (h *PublicShareHandler) BrowseShare(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.resolveShare(w, r)
+	if !ok {
+		return
+	}
+
+	folder, ok := h.sharedFolder(w, r, share)
+	if !ok {
+		return
+	}
+
+	subfolders, err := h.queries.GetSubfolders(r.Context(), folder.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to get folders")
+		return
+	}
+
+	files, err := h.queries.GetFilesByFolder(r.Context(), folder.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to get files")
+		return
+	}
+
+	listing := PublicFolderListing{
+		Folder:  toPublicFolder(folder),
+		Folders: make([]PublicFolder, 0, len(subfolders)),
+		Files:   make([]PublicFile, 0, len(files)),
+	}
+	for _, subfolder := range subfolders {
+		listing.Folders = append(listing.Folders, toPublicFolder(subfolder))
+	}
+	for _, file := range files {
+		listing.Files = append(listing.Files, toPublicFile(file))
+	}
+
+	h.logAccess(r, share, database.ShareAccessActionBrowse, database.ItemTypeFolder, folder.ID)
+
+	respondWithJSON(w, http.StatusOK, listing)
+}
+
+// DownloadShare streams the shared file, or a file inside a shared folder
+// given by the file_id query parameter
+func (h *PublicShareHandler) DownloadShare(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.resolveShare(w, r)
+	if !ok {
+		return
+	}
+
+	if !checkDownloadable(w, share) {
+		return
+	}
+
+	dbFile, ok := h.sharedFile(w, r, share)
+	if !ok {
+		return
+	}
+
+	// Open file from storage
+	file, err := h.storageService.GetFile(r.Context(), dbFile.StoragePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to open file")
+		return
+	}
+	defer file.Close()
+
+	// HEAD requests only probe the file, and a 304 sends none of it. The
+	// other requests a client makes for the same content, such as the ranges
+	// a media player fetches, continue its download and count once.
+	etag := contentETag(dbFile.ID, dbFile.Version.Int32, "")
+	download := uuid.UUID(share.ID.Bytes).String() + " " + clientAddress(r) + " " + etag
+	if r.Method == http.MethodGet && !notModified(r, etag, dbFile.UpdatedAt) && !h.downloads.Continues(download) {
+		if !h.consumeDownload(w, r, share) {
+			return
+		}
+		h.downloads.Start(download)
+		h.logAccess(r, share, database.ShareAccessActionDownload, database.ItemTypeFile, dbFile.ID)
+	}
+
+	serveContent(w, r, file, dbFile.Name, dbFile.MimeType, etag, dbFile.UpdatedAt)
+}
+
+// PreviewShare serves the thumbnail or preview of the shared file, or of a
+// file inside a shared folder given by the file_id query parameter. Previews
+// are shown on view-only links too and do not count as downloads.
+func (h *PublicShareHandler) PreviewShare(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.resolveShare(w, r)
+	if !ok {
+		return
+	}
+
+	size, ok := services.ParsePreviewSize(r.URL.Query().Get("size"))
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "size must be small, medium or large")
+		return
+	}
+
+	dbFile, ok := h.sharedFile(w, r, share)
+	if !ok {
+		return
+	}
+
+	h.logAccess(r, share, database.ShareAccessActionView, database.ItemTypeFile, dbFile.ID)
+
+	serveThumbnail(w, r, h.previews, h.storageService, dbFile, size)
+}
+
+// DownloadShareArchive streams a shared folder, or a folder below it given
+// by the folder_id query parameter, as a zip or tar.gz archive
+func (h *PublicShareHandler) DownloadShareArchive(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.resolveShare(w, r)
+	if !ok {
+		return
+	}
+
+	if share.ItemType != database.ItemTypeFolder {
+		respondWithError(w, http.StatusBadRequest, "only shared folders can be downloaded as an archive")
+		return
+	}
+
+	if !checkDownloadable(w, share) {
+		return
+	}
+
+	format, err := services.ParseArchiveFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	folder, ok := h.sharedFolder(w, r, share)
+	if !ok {
+		return
+	}
+
+	plan := services.NewArchivePlan()
+	if err := h.archives.AddFolder(r.Context(), plan, folder, false); err != nil {
+		fmt.Printf("failed to list archive contents: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to get folder contents")
+		return
+	}
+
+	// The whole archive counts as one download
+	if !h.consumeDownload(w, r, share) {
+		return
+	}
+	h.logAccess(r, share, database.ShareAccessActionArchive, database.ItemTypeFolder, folder.ID)
+
+	streamArchive(w, r, h.archives, format, folder.Name, plan)
+}
+
+// resolveShare loads the share link from the token URL parameter and checks
+// that it is usable: active, unexpired, granting at least view access and,
+// for protected links, unlocked. Writes the error response if not.
+func (h *PublicShareHandler) resolveShare(w http.ResponseWriter, r *http.Request) (database.Share, bool) {
+	share, ok := h.lookupShare(w, r)
+	if !ok {
+		return database.Share{}, false
+	}
+
+	if share.PasswordHash.Valid {
+		token := r.Header.Get(shareAccessHeader)
+		if token == "" {
+			token = r.URL.Query().Get("access")
+		}
+		if !h.authService.VerifyShareAccess(uuid.UUID(share.ID.Bytes), share.PasswordHash.String, token) {
+			respondWithError(w, http.StatusUnauthorized, "password required")
+			return database.Share{}, false
+		}
+	}
+
+	return share, true
+}
+
+// lookupShare is resolveShare without the password check
+func (h *PublicShareHandler) lookupShare(w http.ResponseWriter, r *http.Request) (database.Share, bool) {
+	share, err := h.queries.GetShareByToken(r.Context(), chi.URLParam(r, "token"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "share link not found")
+		return database.Share{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to get share link")
+		return database.Share{}, false
+	}
+
+	if share.ExpiresAt.Valid && share.ExpiresAt.Time.Before(time.Now()) {
+		respondWithError(w, http.StatusGone, "share link expired")
+		return database.Share{}, false
+	}
+
+	if !share.Permission.Valid || !services.RoleAllows(share.Permission.PermissionRole, services.ActionRead) {
+		respondWithError(w, http.StatusForbidden, "forbidden")
+		return database.Share{}, false
+	}
+
+	return share, true
+}
+
+// consumeDownload counts one download against the link's limit, writing the
+// error response once the limit is used up
+func (h *PublicShareHandler) consumeDownload(w http.ResponseWriter, r *http.Request, share database.Share) bool {
+	_, err := h.queries.ConsumeShareDownload(r.Context(), share.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusGone, "download limit reached")
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to count download")
+		return false
+	}
+
+	return true
+}
+
+// checkDownloadable rejects view-only links and links whose download limit
+// is already used up. consumeDownload makes the authoritative check.
+func checkDownloadable(w http.ResponseWriter, share database.Share) bool {
+	if !share.AllowDownload {
+		respondWithError(w, http.StatusForbidden, "downloads are disabled for this link")
+		return false
+	}
+
+	if share.MaxDownloads.Valid && share.DownloadCount >= share.MaxDownloads.Int32 {
+		respondWithError(w, http.StatusGone, "download limit reached")
+		return false
+	}
+
+	return true
+}
+
+// sharedFolder returns the folder named by the folder_id query parameter, or
+// the shared folder itself, after checking that it lies inside the share.
+// Folders outside the share are reported as not found.
+func (h *PublicShareHandler) sharedFolder(w http.ResponseWriter, r *http.Request, share database.Share) (database.Folder, bool) {
+	if share.ItemType != database.ItemTypeFolder {
+		respondWithError(w, http.StatusBadRequest, "shared item is not a folder")
+		return database.Folder{}, false
+	}
+
+	folderID := share.ItemID
+	if folderIDStr := r.URL.Query().Get("folder_id"); folderIDStr != "" {
+		parsedUUID, err := uuid.Parse(folderIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid folder_id")
+			return database.Folder{}, false
+		}
+		folderID = pgtype.UUID{Bytes: parsedUUID, Valid: true}
+	}
+
+	within, err := h.queries.IsFolderWithin(r.Context(), database.IsFolderWithinParams{
+		RootID:   share.ItemID,
+		FolderID: folderID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to resolve folder")
+		return database.Folder{}, false
+	}
+	if !within {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return database.Folder{}, false
+	}
+
+	folder, err := h.queries.GetFolderByID(r.Context(), folderID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "folder not found")
+		return database.Folder{}, false
+	}
+
+	return folder, true
+}
+
+// sharedFile returns the shared file, or for a folder share the file named by
+// the file_id query parameter after checking that it lies inside the share.
+// Files outside the share are reported as not found.
+func (h *PublicShareHandler) sharedFile(w http.ResponseWriter, r *http.Request, share database.Share) (database.File, bool) {
+	fileID := share.ItemID
+	if fileIDStr := r.URL.Query().Get("file_id"); fileIDStr != "" {
+		parsedUUID, err := uuid.Parse(fileIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid file_id")
+			return database.File{}, false
+		}
+		fileID = pgtype.UUID{Bytes: parsedUUID, Valid: true}
+	} else if share.ItemType == database.ItemTypeFolder {
+		respondWithError(w, http.StatusBadRequest, "file_id is required for shared folders")
+		return database.File{}, false
+	}
+
+	file, err := h.queries.GetFileByID(r.Context(), fileID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return database.File{}, false
+	}
+
+	if share.ItemType == database.ItemTypeFile {
+		if file.ID != share.ItemID {
+			respondWithError(w, http.StatusNotFound, "file not found")
+			return database.File{}, false
+		}
+		return file, true
+	}
+
+	if !file.ParentFolderID.Valid {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return database.File{}, false
+	}
+
+	within, err := h.queries.IsFolderWithin(r.Context(), database.IsFolderWithinParams{
+		RootID:   share.ItemID,
+		FolderID: file.ParentFolderID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to resolve file")
+		return database.File{}, false
+	}
+	if !within {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return database.File{}, false
+	}
+
+	return file, true
+}
+
+// logAccess records a use of the share link. Visitors who are signed in are
+// recorded by user; everyone else by address and user agent.
+func (h *PublicShareHandler) logAccess(r *http.Request, share database.Share, action database.ShareAccessAction, itemType database.ItemType, itemID pgtype.UUID) {
+	var userID pgtype.UUID
+	if session, ok := middleware.GetUserFromContext(r.Context()); ok {
+		userID = session.UserID
+	}
+
+	ipAddress := clientAddress(r)
+	if err := h.queries.LogShareAccess(r.Context(), database.LogShareAccessParams{
+		ShareID:   share.ID,
+		Action:    action,
+		ItemType:  itemType,
+		ItemID:    itemID,
+		UserID:    userID,
+		IpAddress: pgtype.Text{String: ipAddress, Valid: ipAddress != ""},
+		UserAgent: pgtype.Text{String: r.UserAgent(), Valid: r.UserAgent() != ""},
+	}); err != nil {
+		fmt.Printf("failed to log share access: %v\n", err)
+	}
+}
+
+// allowAttempt checks key against limiter, writing 429 with Retry-After if
+// it has used up its attempts
+func allowAttempt(w http.ResponseWriter, limiter *services.AttemptLimiter, key string) bool {
+	ok, retryAfter := limiter.Allow(key)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		respondWithError(w, http.StatusTooManyRequests, "too many incorrect passwords, try again later")
+	}
+	return ok
+}
+
+// clientAddress returns the IP address of the client, without the port
+func clientAddress(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func toPublicFile(file database.File) PublicFile {
+	return PublicFile{
+		ID:        file.ID,
+		Name:      file.Name,
+		MimeType:  file.MimeType,
+		Size:      file.Size,
+		UpdatedAt: file.UpdatedAt,
+	}
+}
+
+func toPublicFolder(folder database.Folder) PublicFolder {
+	return PublicFolder{
+		ID:        folder.ID,
+		Name:      folder.Name,
+		UpdatedAt: folder.UpdatedAt,
+	}
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+type RetentionPolicyRequest struct {
+	KeepLast *int32 `json:"keep_last"`
+	KeepDays *int32 `json:"keep_days"`
+}
+
+// PinVersion exempts a version from retention pruning
+func (h *VersionsHandler) PinVersion(w http.ResponseWriter, r *http.Request) {
+	h.setVersionPinned(w, r, true)
+}
+
+// UnpinVersion makes a version subject to retention pruning again
+func (h *VersionsHandler) UnpinVersion(w http.ResponseWriter, r *http.Request) {
+	h.setVersionPinned(w, r, false)
+}
+
+func (h *VersionsHandler) setVersionPinned(w http.ResponseWriter, r *http.Request, pinned bool) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	versionID, err := uuid.Parse(chi.URLParam(r, "versionId"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid version ID")
+		return
+	}
+
+	// Get version
+	version, err := h.queries.GetFileVersion(r.Context(), pgtype.UUID{Bytes: versionID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "version not found")
+		return
+	}
+
+	// Get file to check access
+	file, err := h.queries.GetFileByID(r.Context(), version.FileID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	// Editors and above can pin versions
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionWrite) {
+		return
+	}
+
+	if err := h.queries.SetFileVersionPinned(r.Context(), database.SetFileVersionPinnedParams{
+		ID:       version.ID,
+		IsPinned: pinned,
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to update version")
+		return
+	}
+
+	message := "version unpinned"
+	if pinned {
+		message = "version pinned"
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"message": message,
+	})
+}
+
+// GetRetentionPolicy returns the user's default retention policy
+func (h *VersionsHandler) GetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	policy, err := h.queries.GetUserRetentionPolicy(r.Context(), session.UserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "no retention policy set")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to get retention policy")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+// SetRetentionPolicy creates or replaces the user's default retention policy
+func (h *VersionsHandler) SetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	keepLast, keepDays, ok := decodeRetentionPolicy(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := h.queries.UpsertUserRetentionPolicy(r.Context(), database.UpsertUserRetentionPolicyParams{
+		UserID:   session.UserID,
+		KeepLast: keepLast,
+		KeepDays: keepDays,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to save retention policy")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+// DeleteRetentionPolicy removes the user's default policy so every version is kept
+func (h *VersionsHandler) DeleteRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if err := h.queries.DeleteUserRetentionPolicy(r.Context(), session.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to delete retention policy")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"message": "retention policy deleted",
+	})
+}
+
+// GetFileRetentionPolicy returns the retention policy that overrides the user default for one file
+func (h *VersionsHandler) GetFileRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	file, ok := h.getOwnFile(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := h.queries.GetFileRetentionPolicy(r.Context(), file.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "no retention policy set")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to get retention policy")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+// SetFileRetentionPolicy creates or replaces the retention policy of one file
+func (h *VersionsHandler) SetFileRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	file, ok := h.getOwnFile(w, r)
+	if !ok {
+		return
+	}
+
+	keepLast, keepDays, ok := decodeRetentionPolicy(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := h.queries.UpsertFileRetentionPolicy(r.Context(), database.UpsertFileRetentionPolicyParams{
+		UserID:   file.OwnerID,
+		FileID:   file.ID,
+		KeepLast: keepLast,
+		KeepDays: keepDays,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to save retention policy")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, policy)
+}
+
+// DeleteFileRetentionPolicy removes a file's policy so the user default applies again
+func (h *VersionsHandler) DeleteFileRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	file, ok := h.getOwnFile(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.queries.DeleteFileRetentionPolicy(r.Context(), file.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to delete retention policy")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"message": "retention policy deleted",
+	})
+}
+
+// PreviewRetention lists the versions the next pruning run would delete (dry run)
+func (h *VersionsHandler) PreviewRetention(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	// Optional file filter
+	var fileID pgtype.UUID
+	if fileIDStr := r.URL.Query().Get("file_id"); fileIDStr != "" {
+		parsedUUID, err := uuid.Parse(fileIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid file_id")
+			return
+		}
+		fileID = pgtype.UUID{Bytes: parsedUUID, Valid: true}
+	}
+
+	limit := int32(100)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = int32(parsed)
+	}
+
+	versions, err := h.retentionService.PreviewPrune(r.Context(), session.UserID, fileID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to preview retention")
+		return
+	}
+
+	var totalSize int64
+	for _, version := range versions {
+		totalSize += version.Size
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"versions":   versions,
+		"count":      len(versions),
+		"total_size": totalSize,
+	})
+}
+
+// getOwnFile loads the file from the fileId URL parameter and checks that
+// the current user is an owner of it, writing the error response if not
+func (h *VersionsHandler) getOwnFile(w http.ResponseWriter, r *http.Request) (database.File, bool) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return database.File{}, false
+	}
+
+	fileID, err := uuid.Parse(chi.URLParam(r, "fileId"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid file ID")
+		return database.File{}, false
+	}
+
+	file, err := h.queries.GetFileByID(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return database.File{}, false
+	}
+
+	// Retention deletes versions, so only owners can change it
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionDelete) {
+		return database.File{}, false
+	}
+
+	return file, true
+}
+
+// decodeRetentionPolicy reads a policy body; omitted or null limits mean "no limit"
+func decodeRetentionPolicy(w http.ResponseWriter, r *http.Request) (keepLast, keepDays pgtype.Int4, ok bool) {
+	var req RetentionPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return keepLast, keepDays, false
+	}
+
+	if req.KeepLast != nil {
+		if *req.KeepLast <= 0 {
+			respondWithError(w, http.StatusBadRequest, "keep_last must be greater than zero")
+			return keepLast, keepDays, false
+		}
+		keepLast = pgtype.Int4{Int32: *req.KeepLast, Valid: true}
+	}
+
+	if req.KeepDays != nil {
+		if *req.KeepDays <= 0 {
+			respondWithError(w, http.StatusBadRequest, "keep_days must be greater than zero")
+			return keepLast, keepDays, false
+		}
+		keepDays = pgtype.Int4{Int32: *req.KeepDays, Valid: true}
+	}
+
+	return keepLast, keepDays, true
+}
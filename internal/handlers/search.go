@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+type SearchHandler struct {
+	search *services.SearchService
+}
+
+func NewSearchHandler(search *services.SearchService) *SearchHandler {
+	return &SearchHandler{search: search}
+}
+
+// Search runs a search written in the query language, returning files and
+// folders as one stream paged with a cursor
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	query, err := services.ParseSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts := services.SearchOptions{
+		Sort:   r.URL.Query().Get("sort"),
+		Limit:  50,
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+	switch order := r.URL.Query().Get("order"); order {
+	case "":
+	case "asc", "desc":
+		descending := order == "desc"
+		opts.Descending = &descending
+	default:
+		respondWithError(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit < 1 || parsedLimit > 200 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		opts.Limit = int32(parsedLimit)
+	}
+
+	page, err := h.search.Search(r.Context(), session.UserID, query, opts)
+	if errors.Is(err, services.ErrInvalidSearch) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("failed to search: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to search")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// SearchFiles searches files and folders with the filters of the web
+// client's advanced search form, returning up to 100 of each
+func (h *SearchHandler) SearchFiles(w http.ResponseWriter, r *http.Request) {
+	session, _ := middleware.GetUserFromContext(r.Context())
+
+	query, err := legacySearchQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Files and folders are searched apart so each gets up to 100 results
+	searches := []services.SearchQuery{query}
+	if len(query.Types) == 0 {
+		files, folders := query, query
+		files.Types = []string{"file"}
+		folders.Types = []string{"folder"}
+		searches = []services.SearchQuery{files, folders}
+	}
+	var items []services.SearchItem
+	for _, q := range searches {
+		page, err := h.search.Search(r.Context(), session.UserID, q, services.SearchOptions{Limit: 100})
+		if errors.Is(err, services.ErrInvalidSearch) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			fmt.Printf("failed to search: %v\n", err)
+			respondWithError(w, http.StatusInternalServerError, "failed to search files")
+			return
+		}
+		items = append(items, page.Items...)
+	}
+
+	// A file result carries its relevance and, for content matches, an
+	// HTML-escaped excerpt with the matched words in <mark>
+	type FileResult struct {
+		database.File
+		Rank    float32 `json:"rank,omitempty"`
+		Snippet string  `json:"snippet,omitempty"`
+	}
+
+	// Search response structure
+	type SearchResponse struct {
+		Files   []FileResult      `json:"files"`
+		Folders []database.Folder `json:"folders"`
+	}
+
+	response := SearchResponse{
+		Files:   []FileResult{},
+		Folders: []database.Folder{},
+	}
+	for _, item := range items {
+		if item.File != nil {
+			response.Files = append(response.Files, FileResult{File: *item.File, Rank: item.Rank, Snippet: item.Snippet})
+		} else if item.Folder != nil {
+			response.Folders = append(response.Folders, *item.Folder)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// legacySearchQuery reads the parameters of the advanced search form
+func legacySearchQuery(r *http.Request) (services.SearchQuery, error) {
+	params := r.URL.Query()
+	query := services.NewSearchQuery()
+	query.Text = params.Get("q")
+
+	switch fileType := params.Get("fileType"); fileType {
+	case "":
+	case "folder", "pdf", "image", "video", "audio", "document", "spreadsheet", "presentation", "archive", "text":
+		query.Types = []string{fileType}
+	default:
+		query.Types = []string{"file"}
+	}
+
+	switch owner := params.Get("owner"); owner {
+	case "", "me":
+		query.Owner = "me"
+	case "anyone":
+		query.Owner = ""
+	case "notme":
+		query.Owner = "notme"
+	default:
+		return query, errors.New("owner must be me, anyone or notme")
+	}
+
+	if folderID, err := uuid.Parse(params.Get("folderId")); err == nil {
+		query.Folder = &folderID
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	switch params.Get("dateModifiedType") {
+	case "today":
+		query.NarrowModified(today, time.Time{})
+	case "yesterday":
+		query.NarrowModified(today.AddDate(0, 0, -1), today)
+	case "last7days":
+		query.NarrowModified(today.AddDate(0, 0, -7), time.Time{})
+	case "last30days":
+		query.NarrowModified(today.AddDate(0, 0, -30), time.Time{})
+	case "thisYear":
+		query.NarrowModified(time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.Local), time.Time{})
+	case "custom":
+		start, end := params.Get("dateModifiedStart"), params.Get("dateModifiedEnd")
+		if start == "" || end == "" {
+			break
+		}
+		from, _, err := services.ParseSearchDate(start)
+		if err != nil {
+			return query, fmt.Errorf("invalid dateModifiedStart: %v", err)
+		}
+		to, dateOnly, err := services.ParseSearchDate(end)
+		if err != nil {
+			return query, fmt.Errorf("invalid dateModifiedEnd: %v", err)
+		}
+		// The end date is included
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		} else {
+			to = to.Add(time.Microsecond)
+		}
+		query.NarrowModified(from, to)
+	}
+
+	switch params.Get("isStarred") {
+	case "true":
+		starred := true
+		query.Starred = &starred
+	case "false":
+		starred := false
+		query.Starred = &starred
+	}
+
+	switch status := params.Get("status"); status {
+	case "":
+	case "active", "trashed", "all":
+		query.Status = status
+	default:
+		return query, errors.New("status must be active, trashed or all")
+	}
+	return query, nil
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -16,12 +18,14 @@ import (
 type SharingHandler struct {
 	queries     *database.Queries
 	authService *services.AuthService
+	permissions *services.PermissionService
 }
 
-func NewSharingHandler(queries *database.Queries, authService *services.AuthService) *SharingHandler {
+func NewSharingHandler(queries *database.Queries, authService *services.AuthService, permissions *services.PermissionService) *SharingHandler {
 	return &SharingHandler{
 		queries:     queries,
 		authService: authService,
+		permissions: permissions,
 	}
 }
 
@@ -86,7 +90,10 @@ func (h *SharingHandler) ShareItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Verify that the current user owns the item or has permission to share it
+	// Only owners can share
+	if !authorize(w, r, h.permissions, session.UserID, itemType, pgtype.UUID{Bytes: itemID, Valid: true}, services.ActionShare) {
+		return
+	}
 
 	// Create permission
 	permission, err := h.queries.CreatePermission(r.Context(), database.CreatePermissionParams{
@@ -116,7 +123,7 @@ func (h *SharingHandler) ShareItem(w http.ResponseWriter, r *http.Request) {
 
 // GetItemPermissions returns all permissions for a file/folder
 func (h *SharingHandler) GetItemPermissions(w http.ResponseWriter, r *http.Request) {
-	_, ok := middleware.GetUserFromContext(r.Context())
+	session, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -148,6 +155,11 @@ func (h *SharingHandler) GetItemPermissions(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Viewers and above can see who has access
+	if !authorize(w, r, h.permissions, session.UserID, dbItemType, pgtype.UUID{Bytes: itemUUID, Valid: true}, services.ActionRead) {
+		return
+	}
+
 	// Get permissions
 	permissions, err := h.queries.GetItemPermissions(r.Context(), database.GetItemPermissionsParams{
 		ItemType: dbItemType,
@@ -207,6 +219,11 @@ func (h *SharingHandler) RevokePermission(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Only owners can revoke access
+	if !authorize(w, r, h.permissions, session.UserID, itemType, pgtype.UUID{Bytes: itemID, Valid: true}, services.ActionShare) {
+		return
+	}
+
 	// Revoke permission
 	if err := h.queries.RevokePermission(r.Context(), database.RevokePermissionParams{
 		ItemType: itemType,
@@ -232,12 +249,18 @@ func (h *SharingHandler) RevokePermission(w http.ResponseWriter, r *http.Request
 	})
 }
 
+// maxShareLinkHours caps expires_in at ten years
+const maxShareLinkHours = 10 * 365 * 24
+
 // CreateShareLinkRequest represents the request to create a share link
 type CreateShareLinkRequest struct {
-	ItemType   string  `json:"item_type"`
-	ItemID     string  `json:"item_id"`
-	Permission string  `json:"permission"` // "viewer", "commenter", "editor"
-	ExpiresIn  *int64  `json:"expires_in"` // Optional: hours until expiration
+	ItemType      string  `json:"item_type"`
+	ItemID        string  `json:"item_id"`
+	Permission    string  `json:"permission"`     // "viewer", "commenter", "editor"
+	ExpiresIn     *int64  `json:"expires_in"`     // Optional: hours until expiration
+	Password      *string `json:"password"`       // Optional: visitors must enter it first
+	MaxDownloads  *int32  `json:"max_downloads"`  // Optional: downloads before the link stops serving content
+	AllowDownload *bool   `json:"allow_download"` // Optional: false makes the link view-only (default true)
 }
 
 // CreateShareLink generates a shareable link for a file/folder
@@ -280,6 +303,20 @@ func (h *SharingHandler) CreateShareLink(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Validate link controls
+	if req.ExpiresIn != nil && (*req.ExpiresIn <= 0 || *req.ExpiresIn > maxShareLinkHours) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in must be between 1 and %d hours", maxShareLinkHours))
+		return
+	}
+	if req.Password != nil && *req.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "password must not be empty")
+		return
+	}
+	if req.MaxDownloads != nil && *req.MaxDownloads <= 0 {
+		respondWithError(w, http.StatusBadRequest, "max_downloads must be greater than zero")
+		return
+	}
+
 	// Parse item ID
 	itemID, err := uuid.Parse(req.ItemID)
 	if err != nil {
@@ -287,6 +324,11 @@ func (h *SharingHandler) CreateShareLink(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Only owners can create share links
+	if !authorize(w, r, h.permissions, session.UserID, itemType, pgtype.UUID{Bytes: itemID, Valid: true}, services.ActionShare) {
+		return
+	}
+
 	// Generate random token
 	token, err := h.authService.GenerateRandomToken(32)
 	if err != nil {
@@ -297,18 +339,41 @@ func (h *SharingHandler) CreateShareLink(w http.ResponseWriter, r *http.Request)
 	// Calculate expiration
 	var expiresAt pgtype.Timestamp
 	if req.ExpiresIn != nil {
-		// expiresAt = time.Now().Add(time.Duration(*req.ExpiresIn) * time.Hour)
-		// For now, we'll leave it null (no expiration)
+		expiresAt = pgtype.Timestamp{Time: time.Now().Add(time.Duration(*req.ExpiresIn) * time.Hour), Valid: true}
+	}
+
+	// Hash the password like account passwords
+	var passwordHash pgtype.Text
+	if req.Password != nil {
+		hashed, err := h.authService.HashPassword(*req.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "failed to hash password")
+			return
+		}
+		passwordHash = pgtype.Text{String: hashed, Valid: true}
+	}
+
+	var maxDownloads pgtype.Int4
+	if req.MaxDownloads != nil {
+		maxDownloads = pgtype.Int4{Int32: *req.MaxDownloads, Valid: true}
+	}
+
+	allowDownload := true
+	if req.AllowDownload != nil {
+		allowDownload = *req.AllowDownload
 	}
 
 	// Create share link
 	shareLink, err := h.queries.CreateShare(r.Context(), database.CreateShareParams{
-		ItemType:   itemType,
-		ItemID:     pgtype.UUID{Bytes: itemID, Valid: true},
-		Token:      token,
-		CreatedBy:  session.UserID,
-		Permission: database.NullPermissionRole{PermissionRole: permission, Valid: true},
-		ExpiresAt:  expiresAt,
+		ItemType:      itemType,
+		ItemID:        pgtype.UUID{Bytes: itemID, Valid: true},
+		Token:         token,
+		CreatedBy:     session.UserID,
+		Permission:    database.NullPermissionRole{PermissionRole: permission, Valid: true},
+		ExpiresAt:     expiresAt,
+		PasswordHash:  passwordHash,
+		MaxDownloads:  maxDownloads,
+		AllowDownload: allowDownload,
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("failed to create share link: %v", err))
@@ -320,7 +385,7 @@ func (h *SharingHandler) CreateShareLink(w http.ResponseWriter, r *http.Request)
 
 // GetShareLinks returns all active share links for an item
 func (h *SharingHandler) GetShareLinks(w http.ResponseWriter, r *http.Request) {
-	_, ok := middleware.GetUserFromContext(r.Context())
+	session, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -352,6 +417,11 @@ func (h *SharingHandler) GetShareLinks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only owners can see share links
+	if !authorize(w, r, h.permissions, session.UserID, dbItemType, pgtype.UUID{Bytes: itemUUID, Valid: true}, services.ActionShare) {
+		return
+	}
+
 	// Get share links
 	links, err := h.queries.GetSharesByItem(r.Context(), database.GetSharesByItemParams{
 		ItemType: dbItemType,
@@ -367,7 +437,7 @@ func (h *SharingHandler) GetShareLinks(w http.ResponseWriter, r *http.Request) {
 
 // DeactivateShareLink disables a share link
 func (h *SharingHandler) DeactivateShareLink(w http.ResponseWriter, r *http.Request) {
-	_, ok := middleware.GetUserFromContext(r.Context())
+	session, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -380,8 +450,20 @@ func (h *SharingHandler) DeactivateShareLink(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Get link to check access to the shared item
+	share, err := h.queries.GetShareByID(r.Context(), pgtype.UUID{Bytes: linkID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "share link not found")
+		return
+	}
+
+	// Only owners can deactivate share links
+	if !authorize(w, r, h.permissions, session.UserID, share.ItemType, share.ItemID, services.ActionShare) {
+		return
+	}
+
 	// Deactivate the link
-	if err := h.queries.DeactivateShare(r.Context(), pgtype.UUID{Bytes: linkID, Valid: true}); err != nil {
+	if err := h.queries.DeactivateShare(r.Context(), share.ID); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "failed to deactivate link")
 		return
 	}
@@ -391,6 +473,54 @@ func (h *SharingHandler) DeactivateShareLink(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// GetShareLinkAccess returns who used a share link, newest first
+func (h *SharingHandler) GetShareLinkAccess(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	linkID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid link ID")
+		return
+	}
+
+	limit := int32(100)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = int32(parsed)
+	}
+
+	// Get link to check access to the shared item
+	share, err := h.queries.GetShareByID(r.Context(), pgtype.UUID{Bytes: linkID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "share link not found")
+		return
+	}
+
+	// Only owners can see who used a link
+	if !authorize(w, r, h.permissions, session.UserID, share.ItemType, share.ItemID, services.ActionShare) {
+		return
+	}
+
+	entries, err := h.queries.GetShareAccessLog(r.Context(), database.GetShareAccessLogParams{
+		ShareID: share.ID,
+		Limit:   limit,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to get share link access")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
 // GetSharedWithMe returns all items shared with the current user
 func (h *SharingHandler) GetSharedWithMe(w http.ResponseWriter, r *http.Request) {
 	session, ok := middleware.GetUserFromContext(r.Context())
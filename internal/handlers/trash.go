@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+// ParentTrashedResponse is returned with 409 when restoring an item whose
+// parent folder is still in trash, listing the destinations it can go to
+type ParentTrashedResponse struct {
+	Message               string           `json:"message"`
+	ParentFolderID        pgtype.UUID      `json:"parent_folder_id"`
+	NearestActiveAncestor *database.Folder `json:"nearest_active_ancestor"`
+	Options               []string         `json:"options"`
+}
+
+// restoreDestination reads the optional destination query parameter of a restore
+func restoreDestination(w http.ResponseWriter, r *http.Request) (services.RestoreDestination, bool) {
+	dest := services.RestoreDestination(r.URL.Query().Get("destination"))
+	switch dest {
+	case services.RestoreInPlace, services.RestoreToRoot, services.RestoreAncestor:
+		return dest, true
+	default:
+		respondWithError(w, http.StatusBadRequest, "destination must be root or ancestor")
+		return "", false
+	}
+}
+
+// resolveRestoreParent picks the folder a trashed item returns to and checks
+// that the user may add items there. When the original parent is still in
+// trash and no destination was chosen it responds 409 with the options.
+func resolveRestoreParent(
+	w http.ResponseWriter,
+	r *http.Request,
+	trash *services.TrashService,
+	permissions *services.PermissionService,
+	userID pgtype.UUID,
+	parentID pgtype.UUID,
+	dest services.RestoreDestination,
+) (pgtype.UUID, bool) {
+	target, err := trash.RestoreParent(r.Context(), parentID, dest)
+	if errors.Is(err, services.ErrParentTrashed) {
+		resp := ParentTrashedResponse{
+			Message:        "parent folder is in trash",
+			ParentFolderID: parentID,
+			Options:        []string{string(services.RestoreToRoot), string(services.RestoreAncestor)},
+		}
+		ancestor, ok, err := trash.NearestActiveAncestor(r.Context(), parentID)
+		if err != nil {
+			fmt.Printf("failed to find active ancestor: %v\n", err)
+		} else if ok {
+			resp.NearestActiveAncestor = &ancestor
+		}
+		respondWithJSON(w, http.StatusConflict, resp)
+		return pgtype.UUID{}, false
+	}
+	if err != nil {
+		fmt.Printf("failed to resolve restore destination: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to resolve restore destination")
+		return pgtype.UUID{}, false
+	}
+
+	// Moving into a different folder needs the same access as any other move
+	if target.Valid && target != parentID {
+		if !authorize(w, r, permissions, userID, database.ItemTypeFolder, target, services.ActionWrite) {
+			return pgtype.UUID{}, false
+		}
+	}
+
+	return target, true
+}
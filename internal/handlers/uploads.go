@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
+)
+
+// uploadSessionTTL is how long an upload session stays resumable after its last chunk
+const uploadSessionTTL = 24 * time.Hour
+
+type CreateUploadSessionRequest struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type,omitempty"`
+	FolderID string `json:"folder_id,omitempty"`
+	// RelativePath such as "a/b/c.txt" places the file in folders below
+	// FolderID, which are created when the session starts; it replaces Filename
+	RelativePath string `json:"relative_path,omitempty"`
+	// OnConflict is replace (the default), rename or fail, applied when the
+	// upload completes
+	OnConflict string `json:"on_conflict,omitempty"`
+}
+
+// CreateUploadSession starts a resumable upload
+func (h *FilesHandler) CreateUploadSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req CreateUploadSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var dirs []string
+	if req.RelativePath != "" {
+		var err error
+		dirs, req.Filename, err = services.SplitRelativePath(req.RelativePath)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if req.Filename == "" {
+		respondWithError(w, http.StatusBadRequest, "filename is required")
+		return
+	}
+
+	if req.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "size must be greater than zero")
+		return
+	}
+
+	policy, ok := collisionPolicy(w, req.OnConflict, services.CollisionReplace)
+	if !ok {
+		return
+	}
+
+	// Get folder ID (optional); uploads into a folder are charged to its owner
+	var folderID pgtype.UUID
+	ownerID := session.UserID
+	if req.FolderID != "" {
+		parsedUUID, err := uuid.Parse(req.FolderID)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid folder_id")
+			return
+		}
+		folderID = pgtype.UUID{Bytes: parsedUUID, Valid: true}
+
+		// Verify folder exists
+		folder, err := h.queries.GetFolderByID(r.Context(), folderID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "folder not found")
+			return
+		}
+
+		// Editors and above can upload into a folder
+		if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, folder.ID, services.ActionWrite) {
+			return
+		}
+		ownerID = folder.OwnerID
+	}
+
+	// Fall back to the extension when the client does not send a type
+	mimeType := req.MimeType
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(req.Filename))
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	if len(dirs) > 0 {
+		var err error
+		folderID, err = h.paths.NewFolderBuilder(ownerID, folderID).MkdirAll(r.Context(), dirs)
+		if err != nil {
+			respondWithFolderPathError(w, err)
+			return
+		}
+	}
+
+	expiresAt := time.Now().Add(uploadSessionTTL)
+	upload, err := h.queries.CreateUploadSession(r.Context(), database.CreateUploadSessionParams{
+		UserID:         session.UserID,
+		Filename:       req.Filename,
+		MimeType:       mimeType,
+		ParentFolderID: folderID,
+		TotalSize:      req.Size,
+		ExpiresAt:      pgtype.Timestamp{Time: expiresAt, Valid: true},
+		OnConflict:     string(policy),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to create upload session")
+		return
+	}
+
+	// Hold the whole size for as long as the session stays resumable
+	if _, err := h.quota.Reserve(r.Context(), ownerID, req.Size, upload.ID, expiresAt); err != nil {
+		if err := h.queries.DeleteUploadSession(r.Context(), upload.ID); err != nil {
+			fmt.Printf("failed to delete upload session: %v\n", err)
+		}
+		respondWithQuotaError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, upload)
+}
+
+// GetUploadSession returns the progress of a resumable upload so clients know where to resume
+func (h *FilesHandler) GetUploadSession(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.getOwnUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, upload)
+}
+
+// UploadChunk appends a byte range to a resumable upload.
+// The range is given as "Content-Range: bytes {start}-{end}/{total}" and must
+// start exactly where the previous chunk ended.
+func (h *FilesHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.getOwnUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	if upload.Status != database.UploadStatusPending {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("upload session is %s", upload.Status))
+		return
+	}
+
+	if upload.ExpiresAt.Time.Before(time.Now()) {
+		respondWithError(w, http.StatusGone, "upload session expired")
+		return
+	}
+
+	start, end, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if total != upload.TotalSize || end >= total {
+		respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "range does not match upload size")
+		return
+	}
+
+	if start != upload.ReceivedBytes {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("expected chunk starting at byte %d", upload.ReceivedBytes))
+		return
+	}
+
+	chunkSize := end - start + 1
+	expiresAt := time.Now().Add(uploadSessionTTL)
+	upload, err = h.uploads.AppendChunk(r.Context(), upload.ID, start, r.Body, chunkSize, expiresAt)
+	var incomplete *services.IncompleteChunkError
+	switch {
+	case errors.As(err, &incomplete):
+		respondWithError(w, http.StatusBadRequest, incomplete.Error())
+		return
+	case errors.Is(err, services.ErrChunkOutOfOrder):
+		respondWithError(w, http.StatusConflict, "chunk was already received")
+		return
+	case errors.Is(err, services.ErrUploadSessionClosed):
+		respondWithError(w, http.StatusConflict, "upload session is no longer pending")
+		return
+	case err != nil:
+		fmt.Printf("failed to store chunk: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to store chunk")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, upload)
+}
+
+// CompleteUpload assembles the staged chunks into a file (or a new version of an existing file)
+func (h *FilesHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	upload, ok := h.getOwnUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	// Completing twice returns the same file so clients can safely retry
+	if upload.Status == database.UploadStatusCompleted {
+		dbFile, err := h.queries.GetFileByIDAnyStatus(r.Context(), upload.FileID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, dbFile)
+		return
+	}
+
+	if upload.Status != database.UploadStatusPending {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("upload session is %s", upload.Status))
+		return
+	}
+
+	if upload.ReceivedBytes != upload.TotalSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("upload incomplete: received %d of %d bytes", upload.ReceivedBytes, upload.TotalSize))
+		return
+	}
+
+	// Access to the folder may have been revoked since the session was created
+	if upload.ParentFolderID.Valid {
+		if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFolder, upload.ParentFolderID, services.ActionWrite) {
+			return
+		}
+	}
+
+	uploadID := uuid.UUID(upload.ID.Bytes)
+	content := h.storageService.OpenUploadChunks(r.Context(), uploadID, upload.ChunkCount)
+	defer content.Close()
+
+	// The session is marked completed in the transaction that records the file
+	dbFile, err := h.uploads.Store(r.Context(), services.Upload{
+		UserID:    session.UserID,
+		FolderID:  upload.ParentFolderID,
+		Name:      upload.Filename,
+		MimeType:  upload.MimeType,
+		Content:   content,
+		Policy:    services.CollisionPolicy(upload.OnConflict),
+		SessionID: upload.ID,
+	})
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	// The content is charged to storage_used now
+	h.quota.ReleaseUpload(r.Context(), upload.ID)
+
+	if err := h.storageService.DeleteUploadChunks(r.Context(), uploadID, upload.ChunkCount); err != nil {
+		fmt.Printf("failed to delete staged chunks: %v\n", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, dbFile)
+}
+
+// AbortUpload cancels a resumable upload and discards its staged chunks
+func (h *FilesHandler) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.getOwnUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	if upload.Status != database.UploadStatusPending {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("upload session is %s", upload.Status))
+		return
+	}
+
+	if err := h.queries.AbortUploadSession(r.Context(), upload.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to abort upload session")
+		return
+	}
+
+	h.quota.ReleaseUpload(r.Context(), upload.ID)
+
+	if err := h.storageService.DeleteUploadChunks(r.Context(), uuid.UUID(upload.ID.Bytes), upload.ChunkCount); err != nil {
+		fmt.Printf("failed to delete staged chunks: %v\n", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"message": "upload aborted",
+	})
+}
+
+// QuotaExceededResponse explains why an upload was turned away
+type QuotaExceededResponse struct {
+	Message         string `json:"message"`
+	Requested       int64  `json:"requested"`
+	StorageUsed     int64  `json:"storage_used"`
+	StorageReserved int64  `json:"storage_reserved"`
+	StorageLimit    int64  `json:"storage_limit"`
+	Available       int64  `json:"available"`
+}
+
+// respondWithQuotaError answers a failed reservation: 413 when the upload is
+// larger than the whole quota, 507 when the quota is currently too full
+func respondWithQuotaError(w http.ResponseWriter, err error) {
+	var quotaErr *services.QuotaError
+	if !errors.As(err, &quotaErr) {
+		fmt.Printf("failed to reserve storage: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to reserve storage")
+		return
+	}
+
+	status := http.StatusInsufficientStorage
+	message := "storage quota exceeded"
+	if quotaErr.TooLarge() {
+		status = http.StatusRequestEntityTooLarge
+		message = "file exceeds storage limit"
+	}
+
+	respondWithJSON(w, status, QuotaExceededResponse{
+		Message:         message,
+		Requested:       quotaErr.Requested,
+		StorageUsed:     quotaErr.Used,
+		StorageReserved: quotaErr.Reserved,
+		StorageLimit:    quotaErr.Limit,
+		Available:       quotaErr.Available(),
+	})
+}
+
+// getOwnUploadSession loads the upload session from the URL and checks that
+// it belongs to the current user, writing the error response if not
+func (h *FilesHandler) getOwnUploadSession(w http.ResponseWriter, r *http.Request) (database.UploadSession, bool) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return database.UploadSession{}, false
+	}
+
+	uploadID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid upload ID")
+		return database.UploadSession{}, false
+	}
+
+	upload, err := h.queries.GetUploadSession(r.Context(), pgtype.UUID{Bytes: uploadID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "upload session not found")
+		return database.UploadSession{}, false
+	}
+
+	if upload.UserID != session.UserID {
+		respondWithError(w, http.StatusForbidden, "forbidden")
+		return database.UploadSession{}, false
+	}
+
+	return upload, true
+}
+
+// parseContentRange parses a "bytes {start}-{end}/{total}" header
+func parseContentRange(header string) (start, end, total int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("Content-Range header must be of the form 'bytes start-end/total'")
+	}
+
+	rangePart, totalPart, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("Content-Range header is missing the total size")
+	}
+
+	startPart, endPart, ok := strings.Cut(rangePart, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("Content-Range header is missing the byte range")
+	}
+
+	if start, err = strconv.ParseInt(startPart, 10, 64); err != nil || start < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range start")
+	}
+	if end, err = strconv.ParseInt(endPart, 10, 64); err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range end")
+	}
+	if total, err = strconv.ParseInt(totalPart, 10, 64); err != nil || total <= 0 {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range total")
+	}
+
+	return start, end, total, nil
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -9,15 +11,30 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/shri771/gdrive/internal/database"
 	"github.com/shri771/gdrive/internal/middleware"
+	"github.com/shri771/gdrive/internal/services"
 )
 
 type VersionsHandler struct {
-	queries *database.Queries
+	queries          *database.Queries
+	storageService   *services.StorageService
+	uploads          *services.UploadService
+	retentionService *services.RetentionService
+	permissions      *services.PermissionService
 }
 
-func NewVersionsHandler(queries *database.Queries) *VersionsHandler {
+func NewVersionsHandler(
+	queries *database.Queries,
+	storageService *services.StorageService,
+	uploads *services.UploadService,
+	retentionService *services.RetentionService,
+	permissions *services.PermissionService,
+) *VersionsHandler {
 	return &VersionsHandler{
-		queries: queries,
+		queries:          queries,
+		storageService:   storageService,
+		uploads:          uploads,
+		retentionService: retentionService,
+		permissions:      permissions,
 	}
 }
 
@@ -36,15 +53,15 @@ func (h *VersionsHandler) GetFileVersions(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Check if user owns the file
+	// Get file to check access
 	file, err := h.queries.GetFileByID(r.Context(), pgtype.UUID{Bytes: fileID, Valid: true})
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "file not found")
 		return
 	}
 
-	if file.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Viewers and above can see version history
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionRead) {
 		return
 	}
 
@@ -81,18 +98,131 @@ func (h *VersionsHandler) GetFileVersion(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Check if user owns the file
+	// Get file to check access
 	file, err := h.queries.GetFileByID(r.Context(), version.FileID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "file not found")
 		return
 	}
 
-	if file.OwnerID != session.UserID {
-		respondWithError(w, http.StatusForbidden, "forbidden")
+	// Viewers and above can see version history
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionRead) {
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(version)
 }
+
+// DownloadVersion streams the content of a specific version of a file
+func (h *VersionsHandler) DownloadVersion(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	versionIDStr := chi.URLParam(r, "versionId")
+	versionID, err := uuid.Parse(versionIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid version ID")
+		return
+	}
+
+	// Get version
+	version, err := h.queries.GetFileVersion(r.Context(), pgtype.UUID{Bytes: versionID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "version not found")
+		return
+	}
+
+	// Get file to check access
+	file, err := h.queries.GetFileByID(r.Context(), version.FileID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	// Viewers and above can download old versions
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionRead) {
+		return
+	}
+
+	// Open version content from storage
+	content, err := h.storageService.GetFile(r.Context(), version.StoragePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "failed to open file")
+		return
+	}
+	defer content.Close()
+
+	// Stream version, honouring Range and conditional headers
+	serveContent(w, r, content, file.Name, file.MimeType, contentETag(file.ID, version.VersionNumber, ""), version.CreatedAt)
+}
+
+// RestoreVersion makes an old version current again by adding a new version
+// with the same content. The old content is shared, not copied.
+func (h *VersionsHandler) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+	session, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	versionIDStr := chi.URLParam(r, "versionId")
+	versionID, err := uuid.Parse(versionIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid version ID")
+		return
+	}
+
+	// Get version
+	version, err := h.queries.GetFileVersion(r.Context(), pgtype.UUID{Bytes: versionID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "version not found")
+		return
+	}
+
+	// Get file to check access
+	file, err := h.queries.GetFileByID(r.Context(), version.FileID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	// Editors and above can restore versions
+	if !authorize(w, r, h.permissions, session.UserID, database.ItemTypeFile, file.ID, services.ActionWrite) {
+		return
+	}
+
+	restored, err := h.uploads.RestoreVersion(r.Context(), session.UserID, file, database.FileVersion{
+		ID:            version.ID,
+		FileID:        version.FileID,
+		VersionNumber: version.VersionNumber,
+		StoragePath:   version.StoragePath,
+		Size:          version.Size,
+		UploadedBy:    version.UploadedBy,
+		CreatedAt:     version.CreatedAt,
+		BlobDigest:    version.BlobDigest,
+		IsPinned:      version.IsPinned,
+	})
+	var quotaErr *services.QuotaError
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrVersionCurrent):
+		respondWithError(w, http.StatusConflict, "version is already current")
+		return
+	case errors.Is(err, services.ErrContentMissing):
+		respondWithError(w, http.StatusGone, "file content is missing from storage")
+		return
+	case errors.As(err, &quotaErr):
+		respondWithQuotaError(w, err)
+		return
+	default:
+		fmt.Printf("failed to restore version: %v\n", err)
+		respondWithError(w, http.StatusInternalServerError, "failed to restore version")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, restored)
+}
@@ -17,22 +17,7 @@ func AuthMiddleware(queries *database.Queries) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Get token from cookie or Authorization header
-			token := ""
-
-			// Try cookie first
-			cookie, err := r.Cookie("session_token")
-			if err == nil {
-				token = cookie.Value
-			}
-
-			// Try Authorization header if no cookie
-			if token == "" {
-				token = r.Header.Get("Authorization")
-				if len(token) > 7 && token[:7] == "Bearer " {
-					token = token[7:]
-				}
-			}
-
+			token := sessionToken(r)
 			if token == "" {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusUnauthorized)
@@ -60,6 +45,54 @@ func AuthMiddleware(queries *database.Queries) func(http.Handler) http.Handler {
 	}
 }
 
+// OptionalAuthMiddleware adds the user to the context when the request
+// carries a valid session token and lets every request through otherwise.
+// Used by public routes that want to know who is calling if they can.
+func OptionalAuthMiddleware(queries *database.Queries) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token := sessionToken(r); token != "" {
+				if session, err := queries.GetSessionByToken(r.Context(), token); err == nil {
+					r = r.WithContext(context.WithValue(r.Context(), UserContextKey, &session))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AdminMiddleware lets only administrators through. It must run after AuthMiddleware.
+func AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := GetUserFromContext(r.Context())
+		if !ok || !session.IsAdmin {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "forbidden: admin access required",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionToken reads the session token from the cookie or the Authorization header
+func sessionToken(r *http.Request) string {
+	// Try cookie first
+	if cookie, err := r.Cookie("session_token"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	// Try Authorization header if no cookie
+	token := r.Header.Get("Authorization")
+	if len(token) > 7 && token[:7] == "Bearer " {
+		token = token[7:]
+	}
+	return token
+}
+
 // GetUserFromContext retrieves user info from the request context
 func GetUserFromContext(ctx context.Context) (*database.GetSessionByTokenRow, bool) {
 	user, ok := ctx.Value(UserContextKey).(*database.GetSessionByTokenRow)
@@ -10,7 +10,7 @@ func CORS(next http.Handler) http.Handler {
 		// Allow requests from the React dev server
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:1573")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Content-Range")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		// Handle preflight requests
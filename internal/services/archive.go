@@ -0,0 +1,301 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// ArchiveFormat is the container an archive is streamed in
+type ArchiveFormat string
+
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+// ErrInvalidArchiveFormat is returned for formats other than zip and tar.gz
+var ErrInvalidArchiveFormat = errors.New("format must be zip or tar.gz")
+
+// ParseArchiveFormat reads a format name; empty means zip
+func ParseArchiveFormat(format string) (ArchiveFormat, error) {
+	switch ArchiveFormat(format) {
+	case "", ArchiveZip:
+		return ArchiveZip, nil
+	case ArchiveTarGz, "tgz":
+		return ArchiveTarGz, nil
+	}
+	return "", ErrInvalidArchiveFormat
+}
+
+func (f ArchiveFormat) ContentType() string {
+	if f == ArchiveTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// Filename returns the name of an archive called name
+func (f ArchiveFormat) Filename(name string) string {
+	return name + "." + string(f)
+}
+
+// ArchiveEntry is a directory or a file in an archive
+type ArchiveEntry struct {
+	// Name is the slash-separated path inside the archive; directories end in "/"
+	Name     string
+	Modified time.Time
+	// File is nil for directories
+	File *database.File
+}
+
+// ArchiveService lays out files and folders as archive entries and streams
+// them without buffering the archive
+type ArchiveService struct {
+	queries *database.Queries
+	storage *StorageService
+}
+
+func NewArchiveService(queries *database.Queries, storage *StorageService) *ArchiveService {
+	return &ArchiveService{
+		queries: queries,
+		storage: storage,
+	}
+}
+
+// ArchivePlan collects the entries of one archive. Each folder keeps the
+// structure below it; two items that would get the same name in a directory
+// are told apart with a " (n)" suffix. Trashed items and files whose content
+// is known to be missing are left out.
+type ArchivePlan struct {
+	Entries []ArchiveEntry
+	names   map[string]bool
+}
+
+func NewArchivePlan() *ArchivePlan {
+	return &ArchivePlan{names: make(map[string]bool)}
+}
+
+// AddFile adds a file at the top of the archive
+func (p *ArchivePlan) AddFile(file database.File) {
+	if file.Status.FileStatus != database.FileStatusActive || file.BrokenAt.Valid {
+		return
+	}
+	p.Entries = append(p.Entries, ArchiveEntry{
+		Name:     p.fileName("", file.Name),
+		Modified: file.UpdatedAt.Time,
+		File:     &file,
+	})
+}
+
+// AddFolder adds everything below folder. With asDirectory the folder itself
+// becomes a directory at the top of the archive; otherwise its contents are.
+func (s *ArchiveService) AddFolder(ctx context.Context, p *ArchivePlan, folder database.Folder, asDirectory bool) error {
+	folders, err := s.queries.GetActiveSubtreeFolders(ctx, folder.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get folders: %w", err)
+	}
+	files, err := s.queries.GetActiveSubtreeFiles(ctx, folder.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get files: %w", err)
+	}
+	if len(folders) == 0 {
+		return nil
+	}
+
+	// Folders come parents first, so every parent has its directory already
+	dirs := make(map[pgtype.UUID]string, len(folders))
+	if asDirectory {
+		dirs[folder.ID] = p.dirName("", folder.Name)
+		p.Entries = append(p.Entries, ArchiveEntry{Name: dirs[folder.ID], Modified: folder.UpdatedAt.Time})
+	} else {
+		dirs[folder.ID] = ""
+	}
+	for _, dir := range folders[1:] {
+		parent, ok := dirs[dir.ParentFolderID]
+		if !ok {
+			continue
+		}
+		dirs[dir.ID] = p.dirName(parent, dir.Name)
+		p.Entries = append(p.Entries, ArchiveEntry{Name: dirs[dir.ID], Modified: dir.UpdatedAt.Time})
+	}
+
+	for _, file := range files {
+		parent, ok := dirs[file.ParentFolderID]
+		if !ok || file.BrokenAt.Valid {
+			continue
+		}
+		p.Entries = append(p.Entries, ArchiveEntry{
+			Name:     p.fileName(parent, file.Name),
+			Modified: file.UpdatedAt.Time,
+			File:     &file,
+		})
+	}
+	return nil
+}
+
+// dirName reserves a unique name for a directory called name in dir
+func (p *ArchivePlan) dirName(dir, name string) string {
+	base := dir + archiveName(name)
+	candidate := base
+	for n := 1; p.names[candidate]; n++ {
+		candidate = fmt.Sprintf("%s (%d)", base, n)
+	}
+	p.names[candidate] = true
+	return candidate + "/"
+}
+
+// fileName reserves a unique name for a file called name in dir, numbering
+// it before the extension
+func (p *ArchivePlan) fileName(dir, name string) string {
+	name = archiveName(name)
+	ext := path.Ext(name)
+	base := dir + strings.TrimSuffix(name, ext)
+	candidate := dir + name
+	for n := 1; p.names[candidate]; n++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	p.names[candidate] = true
+	return candidate
+}
+
+// archiveName makes a file or folder name safe as one component of an entry
+// name. Names are user-supplied, so separators and dot segments are
+// neutralised to keep every entry inside the archive root.
+func archiveName(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+	return name
+}
+
+// Write streams the entries of plan to w. The response is usually committed
+// by then, so a failure can only cut the archive short. Zip archives switch
+// to Zip64 records on their own once a file or the archive outgrows 4 GiB or
+// 65535 entries.
+func (s *ArchiveService) Write(ctx context.Context, w io.Writer, format ArchiveFormat, plan *ArchivePlan) error {
+	var archive archiveWriter
+	if format == ArchiveTarGz {
+		archive = newTarGzWriter(w)
+	} else {
+		archive = &zipWriter{zip.NewWriter(w)}
+	}
+
+	for _, entry := range plan.Entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.File == nil {
+			if err := archive.dir(entry.Name, entry.Modified); err != nil {
+				return fmt.Errorf("failed to write archive: %w", err)
+			}
+			continue
+		}
+		if err := s.writeFile(ctx, archive, entry); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return nil
+}
+
+func (s *ArchiveService) writeFile(ctx context.Context, archive archiveWriter, entry ArchiveEntry) error {
+	content, err := s.storage.GetFile(ctx, entry.File.StoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for archive: %w", entry.File.StoragePath, err)
+	}
+	defer content.Close()
+
+	// The stored size is authoritative: tar needs it before the content
+	size, err := s.storage.GetFileSize(ctx, entry.File.StoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s for archive: %w", entry.File.StoragePath, err)
+	}
+
+	if err := archive.file(entry.Name, size, entry.Modified, content); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+type archiveWriter interface {
+	dir(name string, modified time.Time) error
+	file(name string, size int64, modified time.Time, content io.Reader) error
+	Close() error
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (z *zipWriter) dir(name string, modified time.Time) error {
+	_, err := z.CreateHeader(&zip.FileHeader{Name: name, Modified: modified})
+	return err
+}
+
+func (z *zipWriter) file(name string, size int64, modified time.Time, content io.Reader) error {
+	entry, err := z.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+type tarGzWriter struct {
+	gz  *gzip.Writer
+	tar *tar.Writer
+}
+
+func newTarGzWriter(w io.Writer) *tarGzWriter {
+	gz := gzip.NewWriter(w)
+	return &tarGzWriter{gz: gz, tar: tar.NewWriter(gz)}
+}
+
+func (t *tarGzWriter) dir(name string, modified time.Time) error {
+	return t.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     0o755,
+		ModTime:  modified,
+	})
+}
+
+func (t *tarGzWriter) file(name string, size int64, modified time.Time, content io.Reader) error {
+	if err := t.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modified,
+	}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(t.tar, content, size)
+	return err
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tar.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
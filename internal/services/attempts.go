@@ -0,0 +1,93 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// unlockAttemptsPerAddress is how many wrong share link passwords one
+	// client address may send per unlockAttemptWindow, across all links
+	unlockAttemptsPerAddress = 10
+	// unlockAttemptsPerLink is how many wrong passwords one link accepts per
+	// unlockAttemptWindow, from all addresses together
+	unlockAttemptsPerLink = 50
+	unlockAttemptWindow   = 15 * time.Minute
+)
+
+// AttemptLimiter counts failed attempts per key over a sliding window and
+// refuses further attempts from a key that has used up its limit. Counts are
+// kept in memory, so each server limits the attempts it sees.
+type AttemptLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	failures  map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewAttemptLimiter(limit int, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{
+		limit:    limit,
+		window:   window,
+		now:      time.Now,
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// NewUnlockLimiters returns the limiters for share link passwords: one keyed
+// by client address and one keyed by link
+func NewUnlockLimiters() (byAddress, byLink *AttemptLimiter) {
+	return NewAttemptLimiter(unlockAttemptsPerAddress, unlockAttemptWindow),
+		NewAttemptLimiter(unlockAttemptsPerLink, unlockAttemptWindow)
+}
+
+// Allow reports whether key may make another attempt. If not, retryAfter is
+// how long until its oldest counted failure leaves the window.
+func (l *AttemptLimiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	failures := l.recent(key, now)
+	if len(failures) < l.limit {
+		return true, 0
+	}
+	return false, failures[0].Add(l.window).Sub(now)
+}
+
+// Fail counts a failed attempt by key
+func (l *AttemptLimiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.failures[key] = append(l.recent(key, now), now)
+
+	// Keys that stopped failing are dropped once a window, so the map only
+	// holds keys seen recently
+	if now.Sub(l.lastSweep) >= l.window {
+		for other := range l.failures {
+			l.recent(other, now)
+		}
+		l.lastSweep = now
+	}
+}
+
+// recent drops key's failures that are outside the window and returns the rest
+func (l *AttemptLimiter) recent(key string, now time.Time) []time.Time {
+	failures := l.failures[key]
+	i := 0
+	for i < len(failures) && now.Sub(failures[i]) >= l.window {
+		i++
+	}
+	failures = failures[i:]
+	if len(failures) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = failures
+	return failures
+}
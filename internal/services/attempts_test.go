@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewAttemptLimiter(3, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	for i := range 3 {
+		if ok, _ := limiter.Allow("a"); !ok {
+			t.Fatalf("attempt %d refused", i+1)
+		}
+		limiter.Fail("a")
+		now = now.Add(10 * time.Second)
+	}
+
+	ok, retryAfter := limiter.Allow("a")
+	if ok || retryAfter != 30*time.Second {
+		t.Errorf("Allow after limit = %v, %v; want false, 30s", ok, retryAfter)
+	}
+	if ok, _ := limiter.Allow("b"); !ok {
+		t.Errorf("other key refused")
+	}
+
+	// The oldest failure leaves the window
+	now = now.Add(30 * time.Second)
+	if ok, _ := limiter.Allow("a"); !ok {
+		t.Errorf("attempt refused after the oldest failure expired")
+	}
+	limiter.Fail("a")
+	if ok, _ := limiter.Allow("a"); ok {
+		t.Errorf("attempt allowed with the window full again")
+	}
+
+	// Keys with no recent failures are swept
+	now = now.Add(2 * time.Minute)
+	limiter.Fail("b")
+	if _, kept := limiter.failures["a"]; kept {
+		t.Errorf("stale key was not swept")
+	}
+}
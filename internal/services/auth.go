@@ -1,14 +1,23 @@
 package services
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ShareAccessDuration is how long a password-protected share link stays
+// unlocked after the visitor enters the password
+const ShareAccessDuration = time.Hour
+
 type AuthService struct {
 	jwtSecret         string
 	sessionDuration   time.Duration
@@ -66,3 +75,32 @@ func (a *AuthService) GenerateRandomToken(size int) (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// SignShareAccess returns a token proving that the visitor entered the
+// password of a share link. The token names its own expiry and is bound to
+// the current password hash, so changing the password revokes it.
+func (a *AuthService) SignShareAccess(shareID uuid.UUID, passwordHash string, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + a.shareAccessMAC(shareID, passwordHash, expiry)
+}
+
+// VerifyShareAccess checks a token issued by SignShareAccess
+func (a *AuthService) VerifyShareAccess(shareID uuid.UUID, passwordHash string, token string) bool {
+	expiry, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() >= unix {
+		return false
+	}
+
+	return hmac.Equal([]byte(mac), []byte(a.shareAccessMAC(shareID, passwordHash, expiry)))
+}
+
+func (a *AuthService) shareAccessMAC(shareID uuid.UUID, passwordHash string, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(a.jwtSecret))
+	fmt.Fprintf(mac, "share-access:%s:%s:%s", shareID, expiry, passwordHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
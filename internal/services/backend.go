@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrObjectNotFound is returned by a Backend when a key does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend is the object store StorageService delegates to. Keys are
+// slash-separated paths relative to the backend root, for example
+// "user_{uuid}/{file_uuid}/v1_report.pdf".
+type Backend interface {
+	// Put streams r into key, replacing any existing object, and returns the number of bytes written
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+
+	// Get opens key for reading; the reader supports seeking so callers can serve byte ranges
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+
+	// Delete removes key; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+
+	// Stat returns the size and modification time of key
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+
+	// Rename moves oldKey to newKey, replacing newKey if it exists
+	Rename(ctx context.Context, oldKey, newKey string) error
+
+	// List calls fn for every object whose key starts with prefix, in no
+	// particular order. An error returned by fn stops the listing.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
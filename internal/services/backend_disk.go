@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DiskBackend stores objects as plain files below a root directory.
+// This is the default backend and keeps the historical STORAGE_PATH layout.
+type DiskBackend struct {
+	root string
+}
+
+func NewDiskBackend(root string) *DiskBackend {
+	return &DiskBackend{root: root}
+}
+
+func (d *DiskBackend) fullPath(key string) string {
+	return filepath.Join(d.root, filepath.FromSlash(key))
+}
+
+// partialPrefix is where Put writes objects before renaming them into
+// place. It is on the same filesystem as their keys, so the rename is atomic;
+// files left in it by a crash are removed by StorageService.RemoveAbandoned.
+const partialPrefix = ".partial/"
+
+// Put writes r to a temporary file, syncs it and renames it over key, so a
+// crash leaves either the old object or the complete new one
+func (d *DiskBackend) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	fullPath := d.fullPath(key)
+
+	// Create directories if they don't exist
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+	partialDir := d.fullPath(partialPrefix)
+	if err := os.MkdirAll(partialDir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	dst, err := os.CreateTemp(partialDir, "put-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	written := false
+	defer func() {
+		if !written {
+			dst.Close()
+			os.Remove(dst.Name())
+		}
+	}()
+
+	n, err := io.Copy(dst, r)
+	if err != nil {
+		return n, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := dst.Sync(); err != nil {
+		return n, fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return n, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Chmod(dst.Name(), 0644); err != nil {
+		return n, fmt.Errorf("failed to set file mode: %w", err)
+	}
+
+	if err := os.Rename(dst.Name(), fullPath); err != nil {
+		return n, fmt.Errorf("failed to move file into place: %w", err)
+	}
+	written = true
+
+	if err := syncDir(filepath.Dir(fullPath)); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (d *DiskBackend) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	file, err := os.Open(d.fullPath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	return file, nil
+}
+
+func (d *DiskBackend) Delete(ctx context.Context, key string) error {
+	fullPath := d.fullPath(key)
+
+	if err := os.Remove(fullPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	d.pruneEmptyDirs(filepath.Dir(fullPath))
+	return nil
+}
+
+func (d *DiskBackend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := os.Stat(d.fullPath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	return ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (d *DiskBackend) Rename(ctx context.Context, oldKey, newKey string) error {
+	oldFullPath := d.fullPath(oldKey)
+	newFullPath := d.fullPath(newKey)
+
+	// Create destination directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(newFullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	if err := os.Rename(oldFullPath, newFullPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrObjectNotFound, oldKey)
+		}
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	if err := syncDir(filepath.Dir(newFullPath)); err != nil {
+		return err
+	}
+
+	d.pruneEmptyDirs(filepath.Dir(oldFullPath))
+	return nil
+}
+
+func (d *DiskBackend) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	root := filepath.Clean(d.root)
+	err := filepath.WalkDir(root, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			// Skip directories that cannot contain a matching key
+			if key != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// syncDir flushes a directory's entries, making a rename into it durable
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer f.Close()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// pruneEmptyDirs removes now-empty directories between dir and the backend root
+func (d *DiskBackend) pruneEmptyDirs(dir string) {
+	root := filepath.Clean(d.root)
+	for dir = filepath.Clean(dir); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,621 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3PartSize is the buffer size for multipart uploads. Streams that fit in
+	// one part are sent with a single PutObject request.
+	s3PartSize = 16 << 20
+
+	// s3MaxCopySize is the largest object CopyObject accepts; bigger objects
+	// are copied part by part
+	s3MaxCopySize = 5 << 30
+
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3EmptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Config configures an S3-compatible backend (AWS S3, MinIO, Ceph RGW, ...)
+type S3Config struct {
+	Endpoint        string // e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Prefix          string // optional key prefix inside the bucket, e.g. "uploads/"
+	PathStyle       bool   // address the bucket as endpoint/bucket instead of bucket.endpoint
+}
+
+// S3Backend stores objects in an S3-compatible bucket using signature V4
+type S3Backend struct {
+	endpoint *url.URL
+	config   S3Config
+	client   *http.Client
+}
+
+func NewS3Backend(config S3Config) (*S3Backend, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, fmt.Errorf("s3 credentials are required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", config.Endpoint)
+	}
+
+	return &S3Backend{
+		endpoint: endpoint,
+		config:   config,
+		client:   &http.Client{},
+	}, nil
+}
+
+// WithPrefix returns a backend on the same bucket whose keys live under prefix
+func (b *S3Backend) WithPrefix(prefix string) *S3Backend {
+	clone := *b
+	clone.config.Prefix = prefix
+	return &clone
+}
+
+func (b *S3Backend) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Small object: a single request
+		resp, err := b.do(ctx, http.MethodPut, key, nil, nil, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	return b.putMultipart(ctx, key, buf, r)
+}
+
+// putMultipart uploads a stream larger than one part. first holds the part
+// that has already been read from r.
+func (b *S3Backend) putMultipart(ctx context.Context, key string, first []byte, r io.Reader) (int64, error) {
+	uploadID, err := b.createMultipartUpload(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	var parts []s3CompletedPart
+	var total int64
+	buf := first
+	for partNumber := 1; ; partNumber++ {
+		etag, err := b.uploadPart(ctx, key, uploadID, partNumber, buf)
+		if err != nil {
+			b.abortMultipartUpload(key, uploadID)
+			return total, err
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: partNumber, ETag: etag})
+		total += int64(len(buf))
+
+		buf = first[:cap(first)]
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			b.abortMultipartUpload(key, uploadID)
+			return total, fmt.Errorf("failed to read upload: %w", err)
+		}
+		buf = buf[:n]
+	}
+
+	if err := b.completeMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		b.abortMultipartUpload(key, uploadID)
+		return total, err
+	}
+
+	return total, nil
+}
+
+func (b *S3Backend) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	info, err := b.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Object{ctx: ctx, backend: b, key: key, size: info.Size}, nil
+}
+
+func (b *S3Backend) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3Backend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := b.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+
+	info := ObjectInfo{Key: key, Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+// Rename copies the object server-side and deletes the original, as S3 has no rename
+func (b *S3Backend) Rename(ctx context.Context, oldKey, newKey string) error {
+	info, err := b.Stat(ctx, oldKey)
+	if err != nil {
+		return err
+	}
+
+	if info.Size > s3MaxCopySize {
+		err = b.copyMultipart(ctx, oldKey, newKey, info.Size)
+	} else {
+		err = b.copyObject(ctx, oldKey, newKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	return b.Delete(ctx, oldKey)
+}
+
+// List pages through ListObjectsV2 below the backend prefix
+func (b *S3Backend) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	// Listing is a bucket-level request, so sign it without the key prefix
+	bucket := b.WithPrefix("")
+
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {b.config.Prefix + prefix},
+	}
+	for {
+		resp, err := bucket.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse list response: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			info := ObjectInfo{
+				Key:     strings.TrimPrefix(object.Key, b.config.Prefix),
+				Size:    object.Size,
+				ModTime: object.LastModified,
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (b *S3Backend) copyObject(ctx context.Context, srcKey, dstKey string) error {
+	header := http.Header{"X-Amz-Copy-Source": {b.copySource(srcKey)}}
+	resp, err := b.do(ctx, http.MethodPut, dstKey, nil, header, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// CopyObject can report a failure in a 200 response body
+	return checkS3CopyResult(resp.Body)
+}
+
+func (b *S3Backend) copyMultipart(ctx context.Context, srcKey, dstKey string, size int64) error {
+	uploadID, err := b.createMultipartUpload(ctx, dstKey)
+	if err != nil {
+		return err
+	}
+
+	var parts []s3CompletedPart
+	const copyPartSize = 1 << 30
+	for partNumber, offset := 1, int64(0); offset < size; partNumber, offset = partNumber+1, offset+copyPartSize {
+		end := min(offset+copyPartSize, size) - 1
+		query := url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {uploadID},
+		}
+		header := http.Header{
+			"X-Amz-Copy-Source":       {b.copySource(srcKey)},
+			"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", offset, end)},
+		}
+		resp, err := b.do(ctx, http.MethodPut, dstKey, query, header, nil, 0)
+		if err != nil {
+			b.abortMultipartUpload(dstKey, uploadID)
+			return err
+		}
+
+		var result struct {
+			ETag string `xml:"ETag"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			b.abortMultipartUpload(dstKey, uploadID)
+			return fmt.Errorf("failed to parse copy part response: %w", err)
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: partNumber, ETag: result.ETag})
+	}
+
+	if err := b.completeMultipartUpload(ctx, dstKey, uploadID, parts); err != nil {
+		b.abortMultipartUpload(dstKey, uploadID)
+		return err
+	}
+	return nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (b *S3Backend) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	resp, err := b.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse multipart upload response: %w", err)
+	}
+	return result.UploadID, nil
+}
+
+func (b *S3Backend) uploadPart(ctx context.Context, key, uploadID string, partNumber int, data []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(partNumber)},
+		"uploadId":   {uploadID},
+	}
+	resp, err := b.do(ctx, http.MethodPut, key, query, nil, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (b *S3Backend) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []s3CompletedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Like CopyObject, completion errors can arrive with a 200 status
+	return checkS3CopyResult(resp.Body)
+}
+
+// abortMultipartUpload discards uploaded parts; it runs on a fresh context so
+// it still happens when the request context was cancelled
+func (b *S3Backend) abortMultipartUpload(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := b.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, 0)
+	if err != nil {
+		fmt.Printf("failed to abort multipart upload %s: %v\n", uploadID, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (b *S3Backend) copySource(key string) string {
+	return "/" + b.config.Bucket + "/" + s3URIEncode(b.config.Prefix+key, false)
+}
+
+// do sends a signed request for key and returns the response when the status is 2xx.
+// A 404 is reported as ErrObjectNotFound; other failures carry the S3 error code.
+func (b *S3Backend) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	req, err := b.newRequest(ctx, method, key, query, header, body, contentLength)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", method, key, err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&s3Err)
+	if s3Err.Code == "" {
+		s3Err.Code = resp.Status
+	}
+	return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, s3Err.Code, s3Err.Message)
+}
+
+func (b *S3Backend) newRequest(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, contentLength int64) (*http.Request, error) {
+	u := *b.endpoint
+	objectPath := "/" + b.config.Prefix + key
+	if b.config.PathStyle {
+		objectPath = "/" + b.config.Bucket + objectPath
+	} else {
+		u.Host = b.config.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(b.endpoint.Path, "/") + objectPath
+	u.RawPath = s3URIEncode(u.Path, false)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = contentLength
+	}
+
+	payloadHash := s3EmptyPayload
+	if body != nil {
+		payloadHash = s3UnsignedPayload
+	}
+	b.sign(req, payloadHash, time.Now().UTC())
+
+	return req, nil
+}
+
+// sign adds an AWS signature V4 Authorization header to req
+func (b *S3Backend) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign the host and every x-amz-* header
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+b.config.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, b.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.config.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3URIEncode percent-encodes s the way signature V4 expects: everything
+// except unreserved characters, and '/' unless encodeSlash is set
+func s3URIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// s3CanonicalQuery encodes query sorted by key, as required for signing
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, s3URIEncode(key, true)+"="+s3URIEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// checkS3CopyResult reports an <Error> document returned with a 200 status
+func checkS3CopyResult(body io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read s3 response: %w", err)
+	}
+
+	var s3Err struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.XMLName.Local == "Error" {
+		return fmt.Errorf("s3: %s: %s", s3Err.Code, s3Err.Message)
+	}
+	return nil
+}
+
+// s3Object is a seekable reader over an object. Each read after a seek
+// issues a ranged GET starting at the current offset.
+type s3Object struct {
+	ctx     context.Context
+	backend *S3Backend
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", o.offset)}}
+		resp, err := o.backend.do(o.ctx, http.MethodGet, o.key, nil, header, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = o.offset + offset
+	case io.SeekEnd:
+		target = o.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if target < 0 {
+		return 0, fmt.Errorf("negative position: %d", target)
+	}
+
+	if target != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = target
+	return target, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory stand-in for an S3-compatible server with path-style
+// addressing. It implements the requests S3Backend sends and records them.
+type fakeS3 struct {
+	t        *testing.T
+	bucket   string
+	pageSize int
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	nextID   int
+	requests []string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Backend) {
+	fake := &fakeS3{
+		t:        t,
+		bucket:   "drive",
+		pageSize: 2,
+		objects:  make(map[string][]byte),
+		uploads:  make(map[string]map[int][]byte),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	backend, err := NewS3Backend(S3Config{
+		Endpoint:        server.URL,
+		Region:          "test-1",
+		Bucket:          fake.bucket,
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Prefix:          "uploads/",
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	return fake, backend
+}
+
+// count returns how many recorded requests start with prefix, e.g. "PUT copy"
+func (f *fakeS3) count(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, request := range f.requests {
+		if strings.HasPrefix(request, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/test-1/s3/aws4_request") ||
+		r.Header.Get("X-Amz-Date") == "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		f.t.Errorf("unsigned request %s %s: %q", r.Method, r.URL, auth)
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	bucketPath := "/" + f.bucket
+	if r.URL.Path == bucketPath || r.URL.Path == bucketPath+"/" {
+		f.list(w, r)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, bucketPath+"/")
+	if !ok {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.requests = append(f.requests, "POST uploads "+key)
+		f.nextID++
+		uploadID := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.requests = append(f.requests, "PUT part "+key)
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			http.Error(w, "NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		data, _ := io.ReadAll(r.Body)
+		parts[partNumber] = data
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, partNumber))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.requests = append(f.requests, "POST complete "+key)
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			http.Error(w, "NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		var complete struct {
+			Parts []s3CompletedPart `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			http.Error(w, "MalformedXML", http.StatusBadRequest)
+			return
+		}
+		var object []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, part.PartNumber) {
+				fmt.Fprintf(w, "<Error><Code>InvalidPart</Code><Message>part %d</Message></Error>", part.PartNumber)
+				return
+			}
+			object = append(object, parts[part.PartNumber]...)
+		}
+		f.objects[key] = object
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.requests = append(f.requests, "DELETE upload "+key)
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.requests = append(f.requests, "PUT copy "+key)
+		source, _ := strings.CutPrefix(r.Header.Get("X-Amz-Copy-Source"), bucketPath+"/")
+		data, ok := f.objects[source]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		f.objects[key] = bytes.Clone(data)
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+
+	case r.Method == http.MethodPut:
+		f.requests = append(f.requests, "PUT "+key)
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+
+	case r.Method == http.MethodHead:
+		f.requests = append(f.requests, "HEAD "+key)
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat))
+
+	case r.Method == http.MethodGet:
+		f.requests = append(f.requests, "GET "+key)
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		start := 0
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), "-"))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		w.Write(data[start:])
+
+	case r.Method == http.MethodDelete:
+		f.requests = append(f.requests, "DELETE "+key)
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+// list answers ListObjectsV2 in pages of pageSize keys
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		http.Error(w, "InvalidArgument", http.StatusBadRequest)
+		return
+	}
+	f.requests = append(f.requests, "LIST "+query.Get("prefix"))
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := min(start+f.pageSize, len(keys))
+
+	fmt.Fprint(w, "<ListBucketResult>")
+	for _, key := range keys[start:end] {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>2025-01-02T03:04:05.000Z</LastModified></Contents>", key, len(f.objects[key]))
+	}
+	if end < len(keys) {
+		fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+	} else {
+		fmt.Fprint(w, "<IsTruncated>false</IsTruncated>")
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func TestS3BackendPutAndGet(t *testing.T) {
+	fake, backend := newFakeS3(t)
+	ctx := context.Background()
+
+	content := []byte("hello, object storage")
+	key := "user 1/report (final).txt"
+	n, err := backend.Put(ctx, key, bytes.NewReader(content))
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("Put = %d, %v", n, err)
+	}
+	if fake.count("PUT "+"uploads/"+key) != 1 || fake.count("POST uploads") != 0 {
+		t.Errorf("small object was not sent in one request: %v", fake.requests)
+	}
+
+	info, err := backend.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != key || info.Size != int64(len(content)) || !info.ModTime.Equal(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	object, err := backend.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer object.Close()
+
+	read, err := io.ReadAll(object)
+	if err != nil || !bytes.Equal(read, content) {
+		t.Fatalf("read %q, %v", read, err)
+	}
+
+	// Seeking issues a ranged GET from the new offset
+	if pos, err := object.Seek(7, io.SeekStart); err != nil || pos != 7 {
+		t.Fatalf("Seek = %d, %v", pos, err)
+	}
+	read, err = io.ReadAll(object)
+	if err != nil || string(read) != "object storage" {
+		t.Errorf("read after seek %q, %v", read, err)
+	}
+	if pos, err := object.Seek(-7, io.SeekEnd); err != nil || pos != int64(len(content))-7 {
+		t.Fatalf("Seek from end = %d, %v", pos, err)
+	}
+	read, err = io.ReadAll(object)
+	if err != nil || string(read) != "storage" {
+		t.Errorf("read after seek from end %q, %v", read, err)
+	}
+	if fake.count("GET ") != 3 {
+		t.Errorf("expected one GET per read position, got %v", fake.requests)
+	}
+
+	if _, err := backend.Stat(ctx, "missing"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Stat of missing key = %v, want ErrObjectNotFound", err)
+	}
+	if _, err := backend.Get(ctx, "missing"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Get of missing key = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestS3BackendMultipartPut(t *testing.T) {
+	fake, backend := newFakeS3(t)
+	ctx := context.Background()
+
+	content := make([]byte, 2*s3PartSize+1000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	n, err := backend.Put(ctx, "big.bin", bytes.NewReader(content))
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("Put = %d, %v", n, err)
+	}
+
+	if fake.count("POST uploads") != 1 || fake.count("PUT part") != 3 || fake.count("POST complete") != 1 {
+		t.Errorf("unexpected multipart requests: %v", fake.requests)
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("%d multipart uploads left open", len(fake.uploads))
+	}
+	if !bytes.Equal(fake.objects["uploads/big.bin"], content) {
+		t.Errorf("assembled object differs from the upload")
+	}
+
+	// A stream of exactly one part still fits in a single request
+	exact := content[:s3PartSize]
+	if _, err := backend.Put(ctx, "exact.bin", bytes.NewReader(exact)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !bytes.Equal(fake.objects["uploads/exact.bin"], exact) {
+		t.Errorf("stored object differs from the upload")
+	}
+}
+
+func TestS3BackendRenameAndDelete(t *testing.T) {
+	fake, backend := newFakeS3(t)
+	ctx := context.Background()
+
+	if _, err := backend.Put(ctx, ".staging/blobs/abc", strings.NewReader("staged")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := backend.Rename(ctx, ".staging/blobs/abc", "blobs/ab/c0/abc"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+
+	if fake.count("PUT copy uploads/blobs/ab/c0/abc") != 1 || fake.count("DELETE uploads/.staging/blobs/abc") != 1 {
+		t.Errorf("rename was not a copy then a delete: %v", fake.requests)
+	}
+	if _, ok := fake.objects["uploads/.staging/blobs/abc"]; ok {
+		t.Errorf("old key still exists")
+	}
+	if string(fake.objects["uploads/blobs/ab/c0/abc"]) != "staged" {
+		t.Errorf("new key holds %q", fake.objects["uploads/blobs/ab/c0/abc"])
+	}
+
+	if err := backend.Rename(ctx, "missing", "elsewhere"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Rename of missing key = %v, want ErrObjectNotFound", err)
+	}
+
+	if err := backend.Delete(ctx, "blobs/ab/c0/abc"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("objects left after delete: %v", fake.objects)
+	}
+	if err := backend.Delete(ctx, "blobs/ab/c0/abc"); err != nil {
+		t.Errorf("Delete of missing key = %v", err)
+	}
+}
+
+func TestS3BackendList(t *testing.T) {
+	fake, backend := newFakeS3(t)
+	ctx := context.Background()
+
+	keys := []string{"blobs/aa/01", "blobs/aa/02", "blobs/bb/03", "blobs/cc/04", "blobs/cc/05", ".staging/x"}
+	for _, key := range keys {
+		if _, err := backend.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	// Outside the backend's prefix
+	fake.objects["thumbnails/blobs/zz"] = []byte("thumbnail")
+
+	var listed []string
+	err := backend.List(ctx, "blobs/", func(object ObjectInfo) error {
+		if object.Size != int64(len(object.Key)) {
+			t.Errorf("%s has size %d", object.Key, object.Size)
+		}
+		listed = append(listed, object.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	want := []string{"blobs/aa/01", "blobs/aa/02", "blobs/bb/03", "blobs/cc/04", "blobs/cc/05"}
+	if strings.Join(listed, ",") != strings.Join(want, ",") {
+		t.Errorf("listed %v, want %v", listed, want)
+	}
+	if pages := fake.count("LIST uploads/blobs/"); pages != 3 {
+		t.Errorf("listed %d pages, want 3", pages)
+	}
+
+	// An error from fn stops the listing
+	stop := errors.New("stop")
+	calls := 0
+	err = backend.List(ctx, "", func(ObjectInfo) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("List = %v after %d calls, want stop after 1", err, calls)
+	}
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// BlobService keeps the reference counts of content-addressed blobs.
+// Every file version holds one reference on its blob for the file's owner.
+// An owner is charged for a blob once, when they take their first reference,
+// and the blob is unlinked when the last reference of any owner is released.
+//
+// All changes to a blob's references happen with its blobs row locked, so a
+// release can never unlink content that a concurrent upload is about to reuse.
+type BlobService struct {
+	db      database.TxStarter
+	storage *StorageService
+}
+
+func NewBlobService(db database.TxStarter, storage *StorageService) *BlobService {
+	return &BlobService{
+		db:      db,
+		storage: storage,
+	}
+}
+
+// StoredBlob describes content saved through Store
+type StoredBlob struct {
+	Path   string
+	Digest string
+	Size   int64
+}
+
+// Store hashes content while writing it to storage, deduplicates it against
+// existing blobs and takes one reference on it for ownerID
+func (s *BlobService) Store(ctx context.Context, ownerID pgtype.UUID, content io.Reader) (StoredBlob, error) {
+	staged, err := s.storage.StageBlob(ctx, content)
+	if err != nil {
+		return StoredBlob{}, err
+	}
+
+	var blobPath string
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		blob, err := q.UpsertBlob(ctx, database.UpsertBlobParams{
+			Digest: staged.Digest,
+			Size:   staged.Size,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record blob: %w", err)
+		}
+
+		if err := retainBlob(ctx, q, blob, ownerID); err != nil {
+			return err
+		}
+
+		blobPath, err = s.storage.CommitBlob(ctx, staged)
+		return err
+	})
+	if err != nil {
+		s.storage.DiscardBlob(ctx, staged)
+		return StoredBlob{}, err
+	}
+
+	return StoredBlob{
+		Path:   blobPath,
+		Digest: staged.Digest,
+		Size:   staged.Size,
+	}, nil
+}
+
+// Retain takes another reference on an existing blob for ownerID.
+// q may be bound to the caller's transaction so the reference is recorded
+// atomically with the row that holds it.
+func (s *BlobService) Retain(ctx context.Context, q *database.Queries, digest string, ownerID pgtype.UUID) error {
+	blob, err := q.LockBlob(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("failed to lock blob %s: %w", digest, err)
+	}
+
+	return retainBlob(ctx, q, blob, ownerID)
+}
+
+// Release drops one reference on a blob held by ownerID
+func (s *BlobService) Release(ctx context.Context, digest string, ownerID pgtype.UUID) error {
+	return database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		_, unreferenced, err := s.release(ctx, q, digest, ownerID)
+		if err != nil || !unreferenced {
+			return err
+		}
+		return s.unlink(ctx, digest)
+	})
+}
+
+// ReleasedContent describes what ReleaseFile freed
+type ReleasedContent struct {
+	// Bytes is how much was refunded to the owner's storage_used. Blobs the
+	// owner still references from other files are not counted.
+	Bytes int64
+
+	// unreferenced are the blobs whose last reference was dropped
+	unreferenced []string
+	legacyPaths  []string
+}
+
+// add merges what another ReleaseFile freed
+func (r *ReleasedContent) add(other ReleasedContent) {
+	r.Bytes += other.Bytes
+	r.unreferenced = append(r.unreferenced, other.unreferenced...)
+	r.legacyPaths = append(r.legacyPaths, other.legacyPaths...)
+}
+
+// ReleaseFile deletes the version rows of a file and drops the blob
+// references they held. Blobs still used by other files are left in place.
+// q must be bound to a transaction, and UnlinkBlobs must be called in it
+// before it commits: unreferenced blobs are unlinked while their rows are
+// locked. Versions stored before deduplication own their object, which
+// RemoveLegacyObjects deletes once the transaction commits.
+func (s *BlobService) ReleaseFile(ctx context.Context, q *database.Queries, file database.File) (ReleasedContent, error) {
+	var released ReleasedContent
+
+	versions, err := q.GetFileVersionsForDeletion(ctx, file.ID)
+	if err != nil {
+		return released, fmt.Errorf("failed to get file versions: %w", err)
+	}
+
+	if err := q.DeleteFileVersions(ctx, file.ID); err != nil {
+		return released, fmt.Errorf("failed to delete file versions: %w", err)
+	}
+
+	// Versions are ordered by digest so concurrent releases lock blobs in the same order
+	for _, version := range versions {
+		if version.BlobDigest.Valid {
+			refunded, unreferenced, err := s.release(ctx, q, version.BlobDigest.String, file.OwnerID)
+			if err != nil {
+				return released, err
+			}
+			released.Bytes += refunded
+			if unreferenced {
+				released.unreferenced = append(released.unreferenced, version.BlobDigest.String)
+			}
+			continue
+		}
+
+		released.legacyPaths = append(released.legacyPaths, version.StoragePath)
+		if err := q.UpdateUserStorage(ctx, database.UpdateUserStorageParams{
+			ID:          file.OwnerID,
+			StorageUsed: pgtype.Int8{Int64: -version.Size, Valid: true},
+		}); err != nil {
+			return released, fmt.Errorf("failed to update storage: %w", err)
+		}
+		released.Bytes += version.Size
+	}
+
+	// Files without any version rows still own their legacy object
+	if len(versions) == 0 && file.StoragePath != "" && !strings.HasPrefix(file.StoragePath, "blobs/") {
+		released.legacyPaths = append(released.legacyPaths, file.StoragePath)
+	}
+
+	return released, nil
+}
+
+// UnlinkBlobs deletes the objects of the blobs ReleaseFile dropped the last
+// reference to. It must run in the transaction that released them, which
+// still holds their row locks; a failure has to roll the release back.
+func (s *BlobService) UnlinkBlobs(ctx context.Context, released ReleasedContent) error {
+	for _, digest := range released.unreferenced {
+		if err := s.unlink(ctx, digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveLegacyObjects deletes the pre-deduplication objects freed by ReleaseFile
+func (s *BlobService) RemoveLegacyObjects(ctx context.Context, released ReleasedContent) {
+	for _, legacyPath := range released.legacyPaths {
+		if err := s.storage.DeleteFile(ctx, legacyPath); err != nil {
+			fmt.Printf("Warning: failed to delete file from storage: %s, error: %v\n", legacyPath, err)
+		}
+	}
+}
+
+// ReleaseVersion deletes a single version row and drops the blob reference it
+// held. Pinned versions and the file's current version are left alone.
+// Returns: (deleted, error)
+func (s *BlobService) ReleaseVersion(ctx context.Context, versionID pgtype.UUID, ownerID pgtype.UUID) (bool, error) {
+	var legacyPath string
+	deleted := false
+
+	err := database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		version, err := q.DeletePrunableFileVersion(ctx, versionID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete version: %w", err)
+		}
+		deleted = true
+
+		if version.BlobDigest.Valid {
+			_, unreferenced, err := s.release(ctx, q, version.BlobDigest.String, ownerID)
+			if err != nil || !unreferenced {
+				return err
+			}
+			return s.unlink(ctx, version.BlobDigest.String)
+		}
+
+		legacyPath = version.StoragePath
+		if err := q.UpdateUserStorage(ctx, database.UpdateUserStorageParams{
+			ID:          ownerID,
+			StorageUsed: pgtype.Int8{Int64: -version.Size, Valid: true},
+		}); err != nil {
+			return fmt.Errorf("failed to update storage: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if legacyPath != "" {
+		if err := s.storage.DeleteFile(ctx, legacyPath); err != nil {
+			fmt.Printf("Warning: failed to delete file from storage: %s, error: %v\n", legacyPath, err)
+		}
+	}
+
+	return deleted, nil
+}
+
+// release drops ownerID's reference on a blob and deletes the blobs row once
+// no owner references it. unreferenced reports that the row was deleted; its
+// object must then be unlinked before the transaction commits, while the row
+// lock is still held.
+func (s *BlobService) release(ctx context.Context, q *database.Queries, digest string, ownerID pgtype.UUID) (refunded int64, unreferenced bool, err error) {
+	blob, err := q.LockBlob(ctx, digest)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to lock blob %s: %w", digest, err)
+	}
+
+	refCount, err := q.ReleaseBlobRef(ctx, database.ReleaseBlobRefParams{
+		Digest:  digest,
+		OwnerID: ownerID,
+	})
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		// The owner held no reference; nothing to refund
+	case err != nil:
+		return 0, false, fmt.Errorf("failed to release blob %s: %w", digest, err)
+	case refCount == 0:
+		if err := q.DeleteBlobRef(ctx, database.DeleteBlobRefParams{
+			Digest:  digest,
+			OwnerID: ownerID,
+		}); err != nil {
+			return 0, false, fmt.Errorf("failed to delete blob reference: %w", err)
+		}
+
+		if err := q.UpdateUserStorage(ctx, database.UpdateUserStorageParams{
+			ID:          ownerID,
+			StorageUsed: pgtype.Int8{Int64: -blob.Size, Valid: true},
+		}); err != nil {
+			return 0, false, fmt.Errorf("failed to update storage: %w", err)
+		}
+		refunded = blob.Size
+	}
+
+	deleted, err := q.DeleteUnreferencedBlob(ctx, digest)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to delete blob %s: %w", digest, err)
+	}
+
+	return refunded, deleted > 0, nil
+}
+
+// unlink deletes a blob's object. Callers hold the lock of its deleted row,
+// so a failure rolls the release back.
+func (s *BlobService) unlink(ctx context.Context, digest string) error {
+	if err := s.storage.DeleteFile(ctx, BlobPath(digest)); err != nil {
+		return fmt.Errorf("failed to unlink blob %s: %w", digest, err)
+	}
+	return nil
+}
+
+// retainBlob adds a reference for ownerID on a locked blob row and charges
+// the owner if it is their first reference
+func retainBlob(ctx context.Context, q *database.Queries, blob database.Blob, ownerID pgtype.UUID) error {
+	refCount, err := q.RetainBlobRef(ctx, database.RetainBlobRefParams{
+		Digest:  blob.Digest,
+		OwnerID: ownerID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retain blob %s: %w", blob.Digest, err)
+	}
+
+	if refCount == 1 {
+		if err := q.UpdateUserStorage(ctx, database.UpdateUserStorageParams{
+			ID:          ownerID,
+			StorageUsed: pgtype.Int8{Int64: blob.Size, Valid: true},
+		}); err != nil {
+			return fmt.Errorf("failed to update storage: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,603 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// MaxBulkItems is how many items one bulk request may change
+const MaxBulkItems = 1000
+
+// BulkAction is the change a bulk request applies to every item
+type BulkAction string
+
+const (
+	BulkTrash   BulkAction = "trash"
+	BulkRestore BulkAction = "restore"
+	BulkMove    BulkAction = "move"
+	BulkStar    BulkAction = "star"
+	BulkUnstar  BulkAction = "unstar"
+	// BulkDelete deletes items permanently
+	BulkDelete BulkAction = "delete"
+)
+
+// BulkMode chooses what a bulk request does when some items fail
+type BulkMode string
+
+const (
+	// BulkAtomic applies every item or, when any fails, none
+	BulkAtomic BulkMode = "atomic"
+	// BulkBestEffort applies the items that succeed and reports the rest
+	BulkBestEffort BulkMode = "best_effort"
+)
+
+// BulkStatus is the outcome of one item of a bulk request
+type BulkStatus string
+
+const (
+	BulkApplied BulkStatus = "applied"
+	// BulkSkipped items were already in the requested state, such as files
+	// trashed together with a folder earlier in the same request
+	BulkSkipped BulkStatus = "skipped"
+	BulkFailed  BulkStatus = "failed"
+	// BulkRolledBack items succeeded but were undone because another item
+	// of an atomic request failed
+	BulkRolledBack BulkStatus = "rolled_back"
+)
+
+var (
+	// ErrInvalidBulk is wrapped by errors describing a malformed bulk request
+	ErrInvalidBulk = errors.New("invalid bulk request")
+	// ErrItemNotFound is returned for items that do not exist, or are not
+	// in a state the action applies to
+	ErrItemNotFound = errors.New("item not found")
+	// ErrForbidden is returned when the user's role does not allow the action
+	ErrForbidden = errors.New("forbidden")
+	// ErrRootFolder is returned when trying to move or delete a root folder
+	ErrRootFolder = errors.New("cannot change root folder")
+	// ErrMoveIntoItself is returned when a folder is moved into itself
+	ErrMoveIntoItself = errors.New("cannot move folder into itself")
+	// ErrMoveIntoDescendant is returned when a folder is moved below itself
+	ErrMoveIntoDescendant = errors.New("cannot move folder into one of its subfolders")
+	// ErrDestinationNotFound is returned when a move's destination folder
+	// does not exist or is in trash
+	ErrDestinationNotFound = errors.New("destination folder not found")
+	// ErrAlreadyTrashed is the reason items are skipped when trashing
+	ErrAlreadyTrashed = errors.New("item is already in trash")
+	// ErrAlreadyDeleted is the reason items deleted together with a folder
+	// earlier in the same request are skipped
+	ErrAlreadyDeleted = errors.New("item was deleted with its folder")
+	// ErrBulkRolledBack is the reason of items undone by an atomic request
+	ErrBulkRolledBack = errors.New("not applied because another item failed")
+)
+
+// BulkItem identifies a file or folder in a bulk request
+type BulkItem struct {
+	Type database.ItemType `json:"type"`
+	ID   uuid.UUID         `json:"id"`
+}
+
+// BulkRequest applies one action to a list of files and folders
+type BulkRequest struct {
+	Action BulkAction
+	// Mode defaults to BulkAtomic
+	Mode  BulkMode
+	Items []BulkItem
+	// FolderID is where BulkMove moves items; nil moves them to the root
+	FolderID *uuid.UUID
+	// OnConflict is what BulkMove does with items whose name is taken in
+	// the destination; it defaults to CollisionFail
+	OnConflict CollisionPolicy
+	// Destination is where BulkRestore restores items whose parent is in trash
+	Destination RestoreDestination
+}
+
+// BulkItemResult is the outcome of one item
+type BulkItemResult struct {
+	BulkItem
+	Name   string
+	Status BulkStatus
+	// Err is why the item failed or was skipped
+	Err error
+}
+
+// BulkResult reports what a bulk request did. Items are in request order,
+// without duplicates.
+type BulkResult struct {
+	// Committed is false when an atomic request was rolled back
+	Committed                            bool
+	Applied, Skipped, Failed, RolledBack int
+	Items                                []BulkItemResult
+	// BytesReclaimed is how much BulkDelete refunded to the owners' storage
+	BytesReclaimed int64
+}
+
+// BulkService applies trash, restore, move, star and permanent delete to
+// many files and folders at once. A request runs in a single transaction
+// and every item in a savepoint of it, so one failing item is undone alone;
+// atomic requests then roll the whole transaction back. Each request that
+// changes anything is logged as one activity entry.
+type BulkService struct {
+	db          database.TxStarter
+	storage     *StorageService
+	blobService *BlobService
+}
+
+func NewBulkService(db database.TxStarter, storage *StorageService, blobService *BlobService) *BulkService {
+	return &BulkService{
+		db:          db,
+		storage:     storage,
+		blobService: blobService,
+	}
+}
+
+// bulkRun is the state of one request. Its queries and services are bound to
+// the request's transaction; inside an item's savepoint they run in the
+// savepoint too.
+type bulkRun struct {
+	req         BulkRequest
+	userID      pgtype.UUID
+	q           *database.Queries
+	trash       *TrashService
+	permissions *PermissionService
+	// destination is the folder of a move
+	destination pgtype.UUID
+	// existed holds the items present before a BulkDelete started
+	existed map[BulkItem]bool
+	purged  purgedContent
+}
+
+// Run applies req for userID. Errors about the request as a whole, such as
+// ErrInvalidBulk or a move destination the user cannot write to, are
+// returned; errors of single items are reported in the result.
+func (s *BulkService) Run(ctx context.Context, userID pgtype.UUID, req BulkRequest) (BulkResult, error) {
+	items, err := validateBulkRequest(&req)
+	if err != nil {
+		return BulkResult{}, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return BulkResult{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	q := database.New(tx)
+	run := &bulkRun{
+		req:         req,
+		userID:      userID,
+		q:           q,
+		trash:       NewTrashService(tx, q, s.storage, s.blobService),
+		permissions: NewPermissionService(q),
+	}
+	if err := run.prepare(ctx, items); err != nil {
+		return BulkResult{}, err
+	}
+
+	result := BulkResult{Items: make([]BulkItemResult, 0, len(items))}
+	for _, item := range items {
+		res := BulkItemResult{BulkItem: item}
+		var purged purgedContent
+		err := database.ExecTx(ctx, tx, func(*database.Queries) error {
+			var err error
+			res.Name, res.Status, purged, err = run.apply(ctx, item)
+			if res.Status == BulkSkipped {
+				res.Err = err
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			res.Status, res.Err = BulkFailed, err
+		} else if res.Status == BulkApplied {
+			run.purged.add(purged)
+		}
+		result.Items = append(result.Items, res)
+	}
+
+	for _, res := range result.Items {
+		switch res.Status {
+		case BulkApplied:
+			result.Applied++
+		case BulkSkipped:
+			result.Skipped++
+		case BulkFailed:
+			result.Failed++
+		}
+	}
+
+	// An atomic request with a failure changes nothing
+	if req.Mode == BulkAtomic && result.Failed > 0 {
+		for i := range result.Items {
+			if result.Items[i].Status == BulkApplied {
+				result.Items[i].Status, result.Items[i].Err = BulkRolledBack, ErrBulkRolledBack
+			}
+		}
+		result.RolledBack, result.Applied = result.Applied, 0
+		return result, nil
+	}
+
+	if result.Applied > 0 {
+		if err := run.logActivity(ctx, result.Items); err != nil {
+			return BulkResult{}, err
+		}
+	}
+
+	// Unlink released blobs while their rows are still locked, as PurgeFile does
+	if err := s.blobService.UnlinkBlobs(ctx, run.purged.released); err != nil {
+		return BulkResult{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return BulkResult{}, err
+	}
+	result.Committed = true
+
+	run.trash.removePurged(ctx, run.purged)
+	result.BytesReclaimed = run.purged.released.Bytes
+	return result, nil
+}
+
+// validateBulkRequest checks a request, fills in its defaults and returns its
+// items without duplicates
+func validateBulkRequest(req *BulkRequest) ([]BulkItem, error) {
+	switch req.Action {
+	case BulkTrash, BulkRestore, BulkMove, BulkStar, BulkUnstar, BulkDelete:
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidBulk, req.Action)
+	}
+
+	switch req.Mode {
+	case "":
+		req.Mode = BulkAtomic
+	case BulkAtomic, BulkBestEffort:
+	default:
+		return nil, fmt.Errorf("%w: mode must be atomic or best_effort", ErrInvalidBulk)
+	}
+
+	switch req.Destination {
+	case RestoreInPlace, RestoreToRoot, RestoreAncestor:
+	default:
+		return nil, fmt.Errorf("%w: destination must be root or ancestor", ErrInvalidBulk)
+	}
+
+	switch req.OnConflict {
+	case "":
+		req.OnConflict = CollisionFail
+	case CollisionFail, CollisionRename:
+	default:
+		return nil, fmt.Errorf("%w: on_conflict must be fail or rename", ErrInvalidBulk)
+	}
+
+	if len(req.Items) == 0 {
+		return nil, fmt.Errorf("%w: no items", ErrInvalidBulk)
+	}
+	if len(req.Items) > MaxBulkItems {
+		return nil, fmt.Errorf("%w: at most %d items", ErrInvalidBulk, MaxBulkItems)
+	}
+
+	seen := make(map[BulkItem]bool, len(req.Items))
+	items := make([]BulkItem, 0, len(req.Items))
+	for _, item := range req.Items {
+		if item.Type != database.ItemTypeFile && item.Type != database.ItemTypeFolder {
+			return nil, fmt.Errorf("%w: item type must be file or folder", ErrInvalidBulk)
+		}
+		if !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// prepare checks the destination of a move and records which items exist
+// before a permanent delete
+func (r *bulkRun) prepare(ctx context.Context, items []BulkItem) error {
+	switch r.req.Action {
+	case BulkMove:
+		if r.req.FolderID == nil {
+			return nil
+		}
+		folder, err := r.q.GetFolderByID(ctx, pgtype.UUID{Bytes: *r.req.FolderID, Valid: true})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrDestinationNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get destination folder: %w", err)
+		}
+		// The destination folder must be writable too
+		if err := r.authorize(ctx, database.ItemTypeFolder, folder.ID, ActionWrite); err != nil {
+			return err
+		}
+		r.destination = folder.ID
+
+	case BulkDelete:
+		var fileIDs, folderIDs []pgtype.UUID
+		for _, item := range items {
+			id := pgtype.UUID{Bytes: item.ID, Valid: true}
+			if item.Type == database.ItemTypeFile {
+				fileIDs = append(fileIDs, id)
+			} else {
+				folderIDs = append(folderIDs, id)
+			}
+		}
+		files, err := r.q.GetFilesByIDs(ctx, fileIDs)
+		if err != nil {
+			return fmt.Errorf("failed to get files: %w", err)
+		}
+		folders, err := r.q.GetFoldersByIDs(ctx, folderIDs)
+		if err != nil {
+			return fmt.Errorf("failed to get folders: %w", err)
+		}
+		r.existed = make(map[BulkItem]bool, len(files)+len(folders))
+		for _, file := range files {
+			r.existed[BulkItem{Type: database.ItemTypeFile, ID: file.ID.Bytes}] = true
+		}
+		for _, folder := range folders {
+			r.existed[BulkItem{Type: database.ItemTypeFolder, ID: folder.ID.Bytes}] = true
+		}
+	}
+	return nil
+}
+
+// authorize returns ErrForbidden unless the user may perform action on the item
+func (r *bulkRun) authorize(ctx context.Context, itemType database.ItemType, itemID pgtype.UUID, action Action) error {
+	allowed, err := r.permissions.Can(ctx, r.userID, itemType, itemID, action)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// apply changes one item, checking access the same way as the single-item
+// endpoints. Skipped items return BulkSkipped with the reason as the error.
+func (r *bulkRun) apply(ctx context.Context, item BulkItem) (name string, status BulkStatus, purged purgedContent, err error) {
+	id := pgtype.UUID{Bytes: item.ID, Valid: true}
+	if item.Type == database.ItemTypeFile {
+		file, err := r.q.GetFileByIDAnyStatus(ctx, id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			if r.existed[item] {
+				return "", BulkSkipped, purged, ErrAlreadyDeleted
+			}
+			return "", BulkFailed, purged, ErrItemNotFound
+		}
+		if err != nil {
+			return "", BulkFailed, purged, fmt.Errorf("failed to get file: %w", err)
+		}
+		status, purged, err = r.applyFile(ctx, &file)
+		return file.Name, status, purged, err
+	}
+
+	folder, err := r.q.GetFolderByIDAnyStatus(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if r.existed[item] {
+			return "", BulkSkipped, purged, ErrAlreadyDeleted
+		}
+		return "", BulkFailed, purged, ErrItemNotFound
+	}
+	if err != nil {
+		return "", BulkFailed, purged, fmt.Errorf("failed to get folder: %w", err)
+	}
+	status, purged, err = r.applyFolder(ctx, &folder)
+	return folder.Name, status, purged, err
+}
+
+// applyFile changes one file. A file renamed by the move's collision policy
+// gets its new name.
+func (r *bulkRun) applyFile(ctx context.Context, file *database.File) (BulkStatus, purgedContent, error) {
+	var purged purgedContent
+	trashed := file.Status.FileStatus == database.FileStatusTrashed
+
+	action := ActionWrite
+	if r.req.Action == BulkDelete {
+		action = ActionDelete
+	}
+	if err := r.authorize(ctx, database.ItemTypeFile, file.ID, action); err != nil {
+		return BulkFailed, purged, err
+	}
+
+	switch r.req.Action {
+	case BulkTrash:
+		if trashed {
+			return BulkSkipped, purged, ErrAlreadyTrashed
+		}
+		if err := r.trash.TrashFile(ctx, r.userID, *file); err != nil {
+			return BulkFailed, purged, err
+		}
+
+	case BulkRestore:
+		if !trashed {
+			return BulkSkipped, purged, ErrNotTrashed
+		}
+		parentID, err := r.restoreParent(ctx, file.ParentFolderID)
+		if err != nil {
+			return BulkFailed, purged, err
+		}
+		if err := r.trash.RestoreFile(ctx, *file, parentID); err != nil {
+			return BulkFailed, purged, err
+		}
+
+	case BulkMove:
+		if trashed {
+			return BulkFailed, purged, ErrItemNotFound
+		}
+		name, err := moveFile(ctx, r.q, *file, r.destination, r.req.OnConflict)
+		if err != nil {
+			return BulkFailed, purged, err
+		}
+		file.Name = name
+
+	case BulkStar, BulkUnstar:
+		if trashed {
+			return BulkFailed, purged, ErrItemNotFound
+		}
+		if err := r.q.SetFileStarred(ctx, database.SetFileStarredParams{
+			ID:        file.ID,
+			IsStarred: pgtype.Bool{Bool: r.req.Action == BulkStar, Valid: true},
+		}); err != nil {
+			return BulkFailed, purged, fmt.Errorf("failed to star file: %w", err)
+		}
+
+	case BulkDelete:
+		var err error
+		purged, err = r.trash.purgeFileRows(ctx, r.q, *file)
+		if err != nil {
+			return BulkFailed, purged, err
+		}
+	}
+	return BulkApplied, purged, nil
+}
+
+// applyFolder changes one folder, like applyFile
+func (r *bulkRun) applyFolder(ctx context.Context, folder *database.Folder) (BulkStatus, purgedContent, error) {
+	var purged purgedContent
+	// Folders without a status predate the column default and count as active
+	trashed := folder.Status.Valid && folder.Status.FileStatus == database.FileStatusTrashed
+
+	action := ActionWrite
+	if r.req.Action == BulkDelete {
+		action = ActionDelete
+	}
+	if err := r.authorize(ctx, database.ItemTypeFolder, folder.ID, action); err != nil {
+		return BulkFailed, purged, err
+	}
+
+	if folder.IsRoot.Bool && r.req.Action != BulkStar && r.req.Action != BulkUnstar {
+		return BulkFailed, purged, ErrRootFolder
+	}
+
+	switch r.req.Action {
+	case BulkTrash:
+		if trashed {
+			return BulkSkipped, purged, ErrAlreadyTrashed
+		}
+		if _, _, err := r.trash.TrashFolder(ctx, r.userID, *folder); err != nil {
+			return BulkFailed, purged, err
+		}
+
+	case BulkRestore:
+		if !trashed {
+			return BulkSkipped, purged, ErrNotTrashed
+		}
+		parentID, err := r.restoreParent(ctx, folder.ParentFolderID)
+		if err != nil {
+			return BulkFailed, purged, err
+		}
+		if _, _, err := r.trash.RestoreFolder(ctx, *folder, parentID); err != nil {
+			return BulkFailed, purged, err
+		}
+
+	case BulkMove:
+		if trashed {
+			return BulkFailed, purged, ErrItemNotFound
+		}
+		name, err := moveFolder(ctx, r.q, *folder, r.destination, r.req.OnConflict)
+		if err != nil {
+			return BulkFailed, purged, err
+		}
+		folder.Name = name
+
+	case BulkStar, BulkUnstar:
+		if trashed {
+			return BulkFailed, purged, ErrItemNotFound
+		}
+		if err := r.q.SetFolderStarred(ctx, database.SetFolderStarredParams{
+			ID:        folder.ID,
+			IsStarred: pgtype.Bool{Bool: r.req.Action == BulkStar, Valid: true},
+		}); err != nil {
+			return BulkFailed, purged, fmt.Errorf("failed to star folder: %w", err)
+		}
+
+	case BulkDelete:
+		var err error
+		_, purged, err = r.trash.purgeFolderRows(ctx, r.q, *folder)
+		if err != nil {
+			return BulkFailed, purged, err
+		}
+	}
+	return BulkApplied, purged, nil
+}
+
+// restoreParent picks the folder a trashed item returns to and checks that
+// the user may add items there
+func (r *bulkRun) restoreParent(ctx context.Context, parentID pgtype.UUID) (pgtype.UUID, error) {
+	target, err := r.trash.RestoreParent(ctx, parentID, r.req.Destination)
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+
+	// Moving into a different folder needs the same access as any other move
+	if target.Valid && target != parentID {
+		if err := r.authorize(ctx, database.ItemTypeFolder, target, ActionWrite); err != nil {
+			return pgtype.UUID{}, err
+		}
+	}
+	return target, nil
+}
+
+// bulkActivity is the details of the activity entry of a bulk request
+type bulkActivity struct {
+	Bulk      bool               `json:"bulk"`
+	Action    BulkAction         `json:"action"`
+	Count     int                `json:"count"`
+	FolderID  *uuid.UUID         `json:"folder_id,omitempty"`
+	Permanent bool               `json:"permanent,omitempty"`
+	Items     []bulkActivityItem `json:"items"`
+}
+
+type bulkActivityItem struct {
+	Type database.ItemType `json:"type"`
+	ID   uuid.UUID         `json:"id"`
+	Name string            `json:"name"`
+}
+
+// bulkActivityTypes is the activity type each action is logged as
+var bulkActivityTypes = map[BulkAction]database.ActivityType{
+	BulkTrash:   database.ActivityTypeDelete,
+	BulkRestore: database.ActivityTypeRestore,
+	BulkMove:    database.ActivityTypeMove,
+	BulkStar:    database.ActivityTypeStar,
+	BulkUnstar:  database.ActivityTypeUnstar,
+	BulkDelete:  database.ActivityTypeDelete,
+}
+
+// logActivity records the applied items as one activity entry
+func (r *bulkRun) logActivity(ctx context.Context, results []BulkItemResult) error {
+	details := bulkActivity{
+		Bulk:      true,
+		Action:    r.req.Action,
+		Permanent: r.req.Action == BulkDelete,
+		Items:     []bulkActivityItem{},
+	}
+	if r.req.Action == BulkMove {
+		details.FolderID = r.req.FolderID
+	}
+	for _, res := range results {
+		if res.Status == BulkApplied {
+			details.Items = append(details.Items, bulkActivityItem{Type: res.Type, ID: res.ID, Name: res.Name})
+		}
+	}
+	details.Count = len(details.Items)
+
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	if err := r.q.LogActivity(ctx, database.LogActivityParams{
+		UserID:       r.userID,
+		ActivityType: bulkActivityTypes[r.req.Action],
+		Details:      data,
+	}); err != nil {
+		return fmt.Errorf("failed to log activity: %w", err)
+	}
+	return nil
+}
@@ -3,21 +3,27 @@ package services
 import (
 	"context"
 	"fmt"
-	"os"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/shri771/gdrive/internal/database"
 )
 
 type CleanupService struct {
-	queries *database.Queries
-	db      database.DBTX
+	queries        *database.Queries
+	db             database.DBTX
+	storageService *StorageService
+	blobService    *BlobService
+	trashService   *TrashService
 }
 
-func NewCleanupService(queries *database.Queries, db database.DBTX) *CleanupService {
+func NewCleanupService(queries *database.Queries, db database.DBTX, storageService *StorageService, blobService *BlobService, trashService *TrashService) *CleanupService {
 	return &CleanupService{
-		queries: queries,
-		db:      db,
+		queries:        queries,
+		db:             db,
+		storageService: storageService,
+		blobService:    blobService,
+		trashService:   trashService,
 	}
 }
 
@@ -37,75 +43,113 @@ func (s *CleanupService) CleanupTrash(ctx context.Context, daysInTrash int32) (f
 
 	filesDeleted = 0
 	foldersDeleted = 0
+	var reclaimed int64
 
-	// Delete files permanently and remove from storage
+	// Delete files permanently and remove their content from storage
 	for _, file := range files {
-		// Delete physical file from storage
-		if file.StoragePath != "" {
-			if err := os.Remove(file.StoragePath); err != nil {
-				// Log error but continue
-				fmt.Printf("Warning: failed to delete file from storage: %s, error: %v\n", file.StoragePath, err)
-			}
-		}
-
-		// Delete thumbnail if exists
-		if file.ThumbnailPath.Valid && file.ThumbnailPath.String != "" {
-			if err := os.Remove(file.ThumbnailPath.String); err != nil {
-				fmt.Printf("Warning: failed to delete thumbnail: %s, error: %v\n", file.ThumbnailPath.String, err)
-			}
-		}
-
-		// Mark file as permanently deleted in database
-		if err := s.queries.PermanentDeleteFile(ctx, file.ID); err != nil {
+		bytes, err := s.trashService.PurgeFile(ctx, file)
+		if err != nil {
 			fmt.Printf("Warning: failed to permanently delete file %s: %v\n", file.ID.Bytes, err)
 			continue
 		}
 
 		filesDeleted++
+		reclaimed += bytes
 	}
 
-	// Delete folders permanently
+	// Delete folders permanently along with anything still below them. A folder
+	// already purged with an ancestor earlier in the loop deletes nothing.
 	for _, folder := range folders {
-		// Mark folder as permanently deleted in database
-		if err := s.queries.PermanentDeleteFolder(ctx, folder.ID); err != nil {
+		result, err := s.trashService.PurgeFolder(ctx, folder)
+		filesDeleted += int(result.Files)
+		reclaimed += result.BytesReclaimed
+		if err != nil {
 			fmt.Printf("Warning: failed to permanently delete folder %s: %v\n", folder.ID.Bytes, err)
 			continue
 		}
 
-		foldersDeleted++
+		foldersDeleted += int(result.Folders)
+	}
+
+	if reclaimed > 0 {
+		fmt.Printf("Trash cleanup reclaimed %d bytes\n", reclaimed)
+	}
+
+	// Restored and purged items no longer point at their operation
+	if _, err := s.queries.DeleteFinishedTrashOperations(ctx); err != nil {
+		fmt.Printf("Warning: failed to delete finished trash operations: %v\n", err)
 	}
 
 	return filesDeleted, foldersDeleted, nil
 }
 
-// StartCleanupScheduler starts a background goroutine that runs cleanup daily
-func (s *CleanupService) StartCleanupScheduler(ctx context.Context, daysInTrash int32, interval time.Duration) {
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+// CleanupUploadSessions removes expired resumable upload sessions and their staged
+// chunks, and drops storage reservations that were never released
+func (s *CleanupService) CleanupUploadSessions(ctx context.Context) (int, error) {
+	sessions, err := s.queries.GetExpiredUploadSessions(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get expired upload sessions: %w", err)
+	}
+
+	deleted := 0
+	for _, session := range sessions {
+		if err := s.storageService.DeleteUploadChunks(ctx, uuid.UUID(session.ID.Bytes), session.ChunkCount); err != nil {
+			fmt.Printf("Warning: failed to delete staged chunks for upload %s: %v\n", session.ID.Bytes, err)
+			continue
+		}
 
-		// Run cleanup immediately on start
+		if err := s.queries.DeleteUploadSession(ctx, session.ID); err != nil {
+			fmt.Printf("Warning: failed to delete upload session %s: %v\n", session.ID.Bytes, err)
+			continue
+		}
+
+		deleted++
+	}
+
+	// Reservations left behind by uploads that never released them
+	if _, err := s.queries.DeleteExpiredStorageReservations(ctx); err != nil {
+		fmt.Printf("Warning: failed to delete expired storage reservations: %v\n", err)
+	}
+
+	return deleted, nil
+}
+
+// RegisterJobs schedules trash cleanup daily, and the removal of expired
+// upload and login sessions hourly
+func (s *CleanupService) RegisterJobs(jobs *JobQueue, daysInTrash int32) error {
+	jobs.Register("trash.cleanup", func(ctx context.Context, job database.Job) error {
 		files, folders, err := s.CleanupTrash(ctx, daysInTrash)
 		if err != nil {
-			fmt.Printf("Error during initial cleanup: %v\n", err)
-		} else {
-			fmt.Printf("Initial cleanup completed: %d files, %d folders deleted\n", files, folders)
+			return err
 		}
+		fmt.Printf("Trash cleanup completed: %d files, %d folders deleted\n", files, folders)
+		return nil
+	}, JobOptions{Timeout: time.Hour})
 
-		for {
-			select {
-			case <-ctx.Done():
-				fmt.Println("Cleanup scheduler stopped")
-				return
-			case <-ticker.C:
-				files, folders, err := s.CleanupTrash(ctx, daysInTrash)
-				if err != nil {
-					fmt.Printf("Error during scheduled cleanup: %v\n", err)
-				} else {
-					fmt.Printf("Scheduled cleanup completed: %d files, %d folders deleted\n", files, folders)
-				}
-			}
+	jobs.Register("uploads.cleanup", func(ctx context.Context, job database.Job) error {
+		uploads, err := s.CleanupUploadSessions(ctx)
+		if err != nil {
+			return err
+		}
+		if uploads > 0 {
+			fmt.Printf("Cleanup removed %d expired upload sessions\n", uploads)
+		}
+		return nil
+	}, JobOptions{})
+
+	jobs.Register("sessions.cleanup", func(ctx context.Context, job database.Job) error {
+		if err := s.queries.DeleteExpiredSessions(ctx); err != nil {
+			return fmt.Errorf("failed to delete expired sessions: %w", err)
 		}
-	}()
+		return nil
+	}, JobOptions{})
+
+	if err := jobs.Schedule("trash.cleanup", "@daily", "trash.cleanup", nil); err != nil {
+		return err
+	}
+	if err := jobs.Schedule("uploads.cleanup", "@hourly", "uploads.cleanup", nil); err != nil {
+		return err
+	}
+	return jobs.Schedule("sessions.cleanup", "@hourly", "sessions.cleanup", nil)
 }
 
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxContentText is how much extracted text is kept per file; the
+	// tsvector built from it must stay under PostgreSQL's 1MB limit
+	maxContentText = 512 << 10
+	// maxContentSourceBytes bounds the PDFs and documents read into memory
+	// for extraction
+	maxContentSourceBytes = 64 << 20
+	// maxOOXMLPartBytes bounds how far a single document part may inflate
+	maxOOXMLPartBytes = 64 << 20
+)
+
+// errUnreadable means the content could not be parsed; retrying will not help
+var errUnreadable = errors.New("content cannot be read")
+
+type contentKind int
+
+const (
+	contentNone contentKind = iota
+	contentText
+	contentPDF
+	contentOOXML
+)
+
+// ooxmlMimeTypes are the Word, Excel and PowerPoint formats text is extracted from
+var ooxmlMimeTypes = map[string]bool{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+}
+
+var ooxmlExtensions = map[string]bool{".docx": true, ".xlsx": true, ".pptx": true}
+
+// contentKindOf decides how text is extracted from a file
+func contentKindOf(mimeType, name string) contentKind {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	ext := strings.ToLower(path.Ext(name))
+
+	switch {
+	case ooxmlMimeTypes[mimeType], ooxmlExtensions[ext]:
+		return contentOOXML
+	case mimeType == "application/pdf", ext == ".pdf":
+		return contentPDF
+	case previewKindOf(mimeType, name) == previewText:
+		return contentText
+	}
+	return contentNone
+}
+
+// extractText returns the searchable text of a file, at most maxContentText
+// bytes of it. Types without text yield an empty string; content that cannot
+// be parsed wraps errUnreadable.
+func extractText(content io.Reader, mimeType, name string) (string, error) {
+	switch contentKindOf(mimeType, name) {
+	case contentText:
+		return extractPlainText(content)
+	case contentPDF:
+		data, err := readContentSource(content)
+		if err != nil {
+			return "", err
+		}
+		text, err := pdfText(data, maxContentText)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errUnreadable, err)
+		}
+		return cleanContentText(text), nil
+	case contentOOXML:
+		data, err := readContentSource(content)
+		if err != nil {
+			return "", err
+		}
+		return ooxmlText(data)
+	}
+	return "", nil
+}
+
+func readContentSource(content io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(content, maxContentSourceBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxContentSourceBytes {
+		return nil, fmt.Errorf("%w: file is too large", errUnreadable)
+	}
+	return data, nil
+}
+
+// extractPlainText reads the start of a text file. Content that is not
+// UTF-8 is taken to be binary and yields no text.
+func extractPlainText(content io.Reader) (string, error) {
+	head := make([]byte, maxContentText)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	head = head[:n]
+
+	// The read may have cut the last character in half
+	if n == maxContentText {
+		for i := 1; i < utf8.UTFMax && !utf8.Valid(head); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+	if !utf8.Valid(head) || bytes.IndexByte(head, 0) >= 0 {
+		return "", nil
+	}
+	return cleanContentText(string(head)), nil
+}
+
+// ooxmlText reads the text of a Word document, the shared strings of a
+// spreadsheet or the slides of a presentation
+func ooxmlText(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnreadable, err)
+	}
+
+	var parts []*zip.File
+	var slides []*zip.File
+	for _, f := range archive.File {
+		switch {
+		case f.Name == "word/document.xml", f.Name == "xl/sharedStrings.xml":
+			parts = append(parts, f)
+		case strings.HasPrefix(f.Name, "ppt/slides/slide") && strings.HasSuffix(f.Name, ".xml"):
+			slides = append(slides, f)
+		}
+	}
+	// slide10.xml comes after slide9.xml
+	sort.Slice(slides, func(i, j int) bool {
+		return slideNumber(slides[i].Name) < slideNumber(slides[j].Name)
+	})
+	parts = append(parts, slides...)
+	if len(parts) == 0 {
+		return "", fmt.Errorf("%w: no document text found", errUnreadable)
+	}
+
+	var out strings.Builder
+	for _, part := range parts {
+		if out.Len() >= maxContentText {
+			break
+		}
+		r, err := part.Open()
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errUnreadable, err)
+		}
+		err = ooxmlPartText(io.LimitReader(r, maxOOXMLPartBytes), &out)
+		r.Close()
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errUnreadable, err)
+		}
+	}
+	return cleanContentText(out.String()), nil
+}
+
+func slideNumber(name string) int {
+	n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "ppt/slides/slide"), ".xml"))
+	return n
+}
+
+// ooxmlPartText writes the text runs of a document part. Every OOXML
+// vocabulary keeps text in <t> elements; paragraphs (<p>) and shared strings
+// (<si>) end a line, tabs and table cells separate words.
+func ooxmlPartText(r io.Reader, out *strings.Builder) error {
+	decoder := xml.NewDecoder(r)
+	inText := false
+	for out.Len() < maxContentText {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab", "br":
+				out.WriteByte(' ')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p", "si":
+				out.WriteByte('\n')
+			case "tc", "c":
+				out.WriteByte(' ')
+			}
+		case xml.CharData:
+			if inText {
+				out.Write(t)
+			}
+		}
+	}
+	return nil
+}
+
+// cleanContentText drops control characters and invalid UTF-8, and cuts the
+// text to maxContentText on a character boundary
+func cleanContentText(text string) string {
+	text = strings.ToValidUTF8(text, "")
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, text)
+
+	if len(text) > maxContentText {
+		cut := maxContentText
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,399 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	// maxPDFPages bounds the pages text is extracted from
+	maxPDFPages = 5000
+	// maxPDFOperands bounds the operands kept while reading a content stream
+	maxPDFOperands = 64
+)
+
+// winAnsiHigh maps the bytes 0x80-0x9f of WinAnsiEncoding, the encoding simple
+// fonts without a ToUnicode map almost always use; other bytes are Latin-1
+var winAnsiHigh = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// pdfFont maps the codes a font's strings are written in to text
+type pdfFont struct {
+	// codeBytes is the length of one character code
+	codeBytes int
+	toUnicode map[uint32]string
+}
+
+// pdfText extracts the text drawn by the pages of a PDF, stopping after about
+// limit bytes. Text is read in content stream order, which for most writers
+// is reading order. Composite fonts without a ToUnicode map cannot be read.
+func pdfText(data []byte, limit int) (string, error) {
+	doc, catalog, err := openPDF(data)
+	if err != nil {
+		return "", err
+	}
+
+	e := &pdfTextExtractor{doc: doc, limit: limit, fonts: make(map[pdfRef]*pdfFont)}
+	visited := make(map[pdfRef]bool)
+	e.walkPages(catalog["Pages"], nil, visited, 0)
+	return strings.TrimSpace(e.out.String()), nil
+}
+
+type pdfTextExtractor struct {
+	doc   *pdfDocument
+	out   strings.Builder
+	limit int
+	pages int
+	fonts map[pdfRef]*pdfFont
+}
+
+func (e *pdfTextExtractor) full() bool {
+	return e.out.Len() >= e.limit || e.pages >= maxPDFPages
+}
+
+// walkPages visits the page tree in order, passing inherited resources down
+func (e *pdfTextExtractor) walkPages(value any, resources any, visited map[pdfRef]bool, depth int) {
+	if ref, ok := value.(pdfRef); ok {
+		if visited[ref] {
+			return
+		}
+		visited[ref] = true
+	}
+	node := e.doc.dict(value)
+	if node == nil || depth > 32 || e.full() {
+		return
+	}
+	if r, ok := node["Resources"]; ok {
+		resources = r
+	}
+
+	if node["Type"] == pdfName("Page") {
+		e.pages++
+		e.page(node, e.doc.dict(resources))
+		return
+	}
+	kids, _ := e.doc.resolve(node["Kids"]).([]any)
+	for _, kid := range kids {
+		e.walkPages(kid, resources, visited, depth+1)
+	}
+}
+
+// page writes the text of one page's content streams
+func (e *pdfTextExtractor) page(page, resources pdfDict) {
+	var streams []any
+	switch contents := e.doc.resolve(page["Contents"]).(type) {
+	case pdfStream:
+		streams = []any{contents}
+	case []any:
+		streams = contents
+	}
+
+	var content []byte
+	for _, value := range streams {
+		stream, ok := e.doc.resolve(value).(pdfStream)
+		if !ok {
+			continue
+		}
+		data, filter, err := e.doc.decode(stream)
+		if err != nil || filter != "" {
+			continue
+		}
+		// Streams of one page may split an operator between them
+		content = append(append(content, data...), '\n')
+	}
+
+	e.content(content, resources, 0)
+	e.separate('\n')
+}
+
+// content interprets the text operators of a content stream. Form XObjects
+// drawn with Do are read in place.
+func (e *pdfTextExtractor) content(data []byte, resources pdfDict, depth int) {
+	fonts := e.doc.dict(resources["Font"])
+	var font *pdfFont
+	var operands []any
+
+	p := &pdfParser{data: data}
+	for !e.full() {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return
+		}
+
+		c := p.data[p.pos]
+		if c == '/' || c == '<' || c == '[' || c == '(' || c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9') {
+			value, err := p.value(0)
+			if err != nil {
+				p.pos++
+				operands = operands[:0]
+				continue
+			}
+			if len(operands) < maxPDFOperands {
+				operands = append(operands, value)
+			}
+			continue
+		}
+
+		op := string(p.token())
+		if op == "" {
+			// A stray delimiter such as ) or ]
+			p.pos++
+			continue
+		}
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[len(operands)-2].(pdfName); ok {
+					font = e.font(fonts[name])
+				}
+			}
+		case "Tj":
+			e.show(font, lastOperand(operands))
+		case "'", "\"":
+			e.separate('\n')
+			e.show(font, lastOperand(operands))
+		case "TJ":
+			array, _ := lastOperand(operands).([]any)
+			for _, item := range array {
+				// A large negative adjustment is how many writers space words
+				if n, ok := item.(float64); ok && n < -200 {
+					e.separate(' ')
+				}
+				e.show(font, item)
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if ty, ok := operands[len(operands)-1].(float64); ok && ty != 0 {
+					e.separate('\n')
+				} else {
+					e.separate(' ')
+				}
+			}
+		case "T*", "Tm", "ET":
+			e.separate('\n')
+		case "Do":
+			name, _ := lastOperand(operands).(pdfName)
+			form, ok := e.doc.resolve(e.doc.dict(resources["XObject"])[name]).(pdfStream)
+			if ok && form.dict["Subtype"] == pdfName("Form") && depth < 3 {
+				if data, filter, err := e.doc.decode(form); err == nil && filter == "" {
+					formResources := e.doc.dict(form.dict["Resources"])
+					if formResources == nil {
+						formResources = resources
+					}
+					e.content(data, formResources, depth+1)
+				}
+			}
+		case "BI":
+			// Inline image data is binary; skip to its end marker
+			if end := bytes.Index(p.data[p.pos:], []byte("EI")); end >= 0 {
+				p.pos += end + 2
+			} else {
+				return
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func lastOperand(operands []any) any {
+	if len(operands) == 0 {
+		return nil
+	}
+	return operands[len(operands)-1]
+}
+
+// show writes a string drawn in font
+func (e *pdfTextExtractor) show(font *pdfFont, value any) {
+	s, ok := value.(pdfString)
+	if !ok || font == nil {
+		return
+	}
+
+	for i := 0; i+font.codeBytes <= len(s); i += font.codeBytes {
+		var code uint32
+		for _, b := range []byte(s[i : i+font.codeBytes]) {
+			code = code<<8 | uint32(b)
+		}
+
+		if text, ok := font.toUnicode[code]; ok {
+			e.write(text)
+		} else if font.codeBytes == 1 {
+			e.write(string(winAnsiRune(byte(code))))
+		}
+	}
+}
+
+func winAnsiRune(b byte) rune {
+	if b >= 0x80 && b < 0xa0 {
+		if r := winAnsiHigh[b-0x80]; r != 0 {
+			return r
+		}
+		return ' '
+	}
+	return rune(b)
+}
+
+// write appends text, keeping only printable characters
+func (e *pdfTextExtractor) write(text string) {
+	for _, r := range text {
+		switch {
+		case r == utf8.RuneError, r < ' ' && r != '\n' && r != '\t':
+			continue
+		case r == '\t':
+			e.separate(' ')
+		default:
+			e.out.WriteRune(r)
+		}
+	}
+}
+
+// separate ends the current word or line unless the text already ends in
+// whitespace
+func (e *pdfTextExtractor) separate(sep byte) {
+	n := e.out.Len()
+	if n == 0 {
+		return
+	}
+	last := e.out.String()[n-1]
+	if last == '\n' || (last == ' ' && sep == ' ') {
+		return
+	}
+	e.out.WriteByte(sep)
+}
+
+// font reads the encoding of a font resource, caching fonts shared by pages
+func (e *pdfTextExtractor) font(value any) *pdfFont {
+	ref, isRef := value.(pdfRef)
+	if isRef {
+		if font, ok := e.fonts[ref]; ok {
+			return font
+		}
+	}
+
+	dict := e.doc.dict(value)
+	if dict == nil {
+		return nil
+	}
+	font := &pdfFont{codeBytes: 1}
+	if dict["Subtype"] == pdfName("Type0") {
+		font.codeBytes = 2
+	}
+	if cmap, ok := e.doc.resolve(dict["ToUnicode"]).(pdfStream); ok {
+		if data, filter, err := e.doc.decode(cmap); err == nil && filter == "" {
+			font.toUnicode, font.codeBytes = parseToUnicode(data, font.codeBytes)
+		}
+	}
+
+	if isRef {
+		e.fonts[ref] = font
+	}
+	return font
+}
+
+// parseToUnicode reads the bfchar and bfrange mappings of a ToUnicode CMap.
+// The length of the source codes overrides the code length the font implies.
+func parseToUnicode(data []byte, codeBytes int) (map[uint32]string, int) {
+	mapping := make(map[uint32]string)
+	p := &pdfParser{data: data}
+	mode := ""
+	var values []any
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			break
+		}
+		c := p.data[p.pos]
+		if c == '<' || c == '[' || c == '/' || c == '(' || c == '+' || c == '-' || (c >= '0' && c <= '9') {
+			value, err := p.value(0)
+			if err != nil {
+				p.pos++
+				continue
+			}
+			if mode != "" {
+				values = append(values, value)
+			}
+			continue
+		}
+
+		token := string(p.token())
+		if token == "" {
+			p.pos++
+			continue
+		}
+		switch token {
+		case "beginbfchar", "beginbfrange":
+			mode = token
+			values = values[:0]
+		case "endbfchar":
+			for i := 0; i+1 < len(values); i += 2 {
+				src, ok1 := values[i].(pdfString)
+				dst, ok2 := values[i+1].(pdfString)
+				if ok1 && ok2 && len(src) > 0 {
+					codeBytes = len(src)
+					mapping[cmapCode(src)] = utf16Text(dst)
+				}
+			}
+			mode = ""
+		case "endbfrange":
+			for i := 0; i+2 < len(values); i += 3 {
+				lo, ok1 := values[i].(pdfString)
+				hi, ok2 := values[i+1].(pdfString)
+				if !ok1 || !ok2 || len(lo) == 0 {
+					continue
+				}
+				codeBytes = len(lo)
+				first, last := cmapCode(lo), cmapCode(hi)
+				if last < first || last-first > 0xffff {
+					continue
+				}
+
+				switch dst := values[i+2].(type) {
+				case pdfString:
+					// Consecutive codes map to consecutive text, incrementing
+					// the last code unit
+					units := utf16Units(dst)
+					if len(units) == 0 {
+						continue
+					}
+					for code := first; code <= last; code++ {
+						mapping[code] = string(utf16.Decode(units))
+						units[len(units)-1]++
+					}
+				case []any:
+					for j, item := range dst {
+						if s, ok := item.(pdfString); ok && first+uint32(j) <= last {
+							mapping[first+uint32(j)] = utf16Text(s)
+						}
+					}
+				}
+			}
+			mode = ""
+		}
+	}
+	return mapping, min(max(codeBytes, 1), 4)
+}
+
+func cmapCode(s pdfString) uint32 {
+	var code uint32
+	for _, b := range []byte(s) {
+		code = code<<8 | uint32(b)
+	}
+	return code
+}
+
+func utf16Units(s pdfString) []uint16 {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	return units
+}
+
+func utf16Text(s pdfString) string {
+	return string(utf16.Decode(utf16Units(s)))
+}
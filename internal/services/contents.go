@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+const (
+	// contentJobKind is the job that extracts a file's text for search
+	contentJobKind = "content.extract"
+	// contentJobTimeout bounds extracting one file
+	contentJobTimeout = 5 * time.Minute
+	// maxContentAttempts is how often a job is tried before it is failed
+	maxContentAttempts = 3
+)
+
+// ContentService extracts the text of text files, PDFs and Office documents
+// into file_contents so search can match what files contain. Extraction runs
+// as a content.extract job after every upload and version restore.
+type ContentService struct {
+	queries *database.Queries
+	storage *StorageService
+	jobs    *JobQueue
+}
+
+func NewContentService(queries *database.Queries, storage *StorageService, jobs *JobQueue) *ContentService {
+	s := &ContentService{
+		queries: queries,
+		storage: storage,
+		jobs:    jobs,
+	}
+	jobs.Register(contentJobKind, s.extract, JobOptions{
+		MaxAttempts: maxContentAttempts,
+		Timeout:     contentJobTimeout,
+	})
+	return s
+}
+
+// contentJob is the payload of a content.extract job
+type contentJob struct {
+	FileID  uuid.UUID `json:"file_id"`
+	Version int32     `json:"version"`
+}
+
+// Enqueue queues text extraction for a file's new content. Files of a type
+// without text only lose the text of their previous content.
+func (s *ContentService) Enqueue(ctx context.Context, fileID pgtype.UUID, version int32, mimeType, name string) {
+	if contentKindOf(mimeType, name) == contentNone {
+		if err := s.queries.DeleteFileContent(ctx, fileID); err != nil {
+			fmt.Printf("Warning: failed to delete content of file %s: %v\n", fileID.Bytes, err)
+		}
+		return
+	}
+
+	if _, err := s.jobs.Enqueue(ctx, contentJobKind,
+		contentJob{FileID: fileID.Bytes, Version: version},
+		EnqueueOptions{UniqueKey: contentJobKey(fileID)}); err != nil {
+		fmt.Printf("Warning: failed to queue text extraction of file %s: %v\n", fileID.Bytes, err)
+	}
+}
+
+func contentJobKey(fileID pgtype.UUID) string {
+	return "content:" + uuid.UUID(fileID.Bytes).String()
+}
+
+// contentJobParams queues text extraction for a version of a file in the
+// caller's transaction, as Enqueue does
+func contentJobParams(fileID pgtype.UUID, version int32) database.EnqueueJobParams {
+	payload, _ := json.Marshal(contentJob{FileID: fileID.Bytes, Version: version})
+	return database.EnqueueJobParams{
+		Kind:        contentJobKind,
+		Payload:     payload,
+		UniqueKey:   pgtype.Text{String: contentJobKey(fileID), Valid: true},
+		MaxAttempts: maxContentAttempts,
+		RunAfter:    pgtype.Timestamp{Time: time.Now(), Valid: true},
+	}
+}
+
+// extract reads a file's text and stores it for its version. Content that
+// cannot be parsed is stored as empty text so the previous version's text
+// stops matching.
+func (s *ContentService) extract(ctx context.Context, row database.Job) error {
+	var job contentJob
+	if err := json.Unmarshal(row.Payload, &job); err != nil {
+		return Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+	fileID := pgtype.UUID{Bytes: job.FileID, Valid: true}
+
+	file, err := s.queries.GetFileByIDAnyStatus(ctx, fileID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Deleted meanwhile
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get file: %w", err)
+	}
+	if file.BrokenAt.Valid {
+		return Permanent(errors.New("content is missing from storage"))
+	}
+
+	content, err := s.storage.GetFile(ctx, file.StoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	text, err := extractText(content, file.MimeType, file.Name)
+	content.Close()
+	if errors.Is(err, errUnreadable) {
+		fmt.Printf("Warning: cannot extract text of file %s: %v\n", job.FileID, err)
+		text = ""
+	} else if err != nil {
+		return err
+	}
+
+	// No rows means a newer version was uploaded meanwhile and has been queued
+	if _, err := s.queries.SetFileContent(ctx, database.SetFileContentParams{
+		FileID:  fileID,
+		Version: job.Version,
+		Content: text,
+	}); err != nil {
+		return fmt.Errorf("failed to store content: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// maxCopyItems bounds how many files and folders one copy may create
+const maxCopyItems = 10000
+
+var (
+	// ErrCopyTooLarge is returned when a folder holds more than maxCopyItems items
+	ErrCopyTooLarge = fmt.Errorf("folder has more than %d items to copy", maxCopyItems)
+	// ErrContentMissing is returned when a file's content is not in storage
+	ErrContentMissing = errors.New("file content is missing from storage")
+)
+
+// CopyTarget is where a copy is placed
+type CopyTarget struct {
+	// FolderID is the destination folder; invalid means the top of the
+	// user's own drive
+	FolderID pgtype.UUID
+	// Name of the copy; empty keeps the original's. A name already used in
+	// the destination becomes "Copy of <name>".
+	Name string
+}
+
+// CopyResult describes a finished copy
+type CopyResult struct {
+	File   *database.File
+	Folder *database.Folder
+	// Folders and Files count everything created, the copied folder included
+	Folders int64
+	Files   int64
+	// BytesCharged is how much the destination owner's storage_used grew
+	BytesCharged int64
+}
+
+// CopyService duplicates files and folders without moving their content:
+// a copy references the same blobs as its original. Only files stored before
+// deduplication have their bytes copied, into the blob store. A copy belongs
+// to the owner of the folder it is placed in, who is charged for the blobs
+// they do not reference yet, within their quota. Callers check that the user
+// may read the original and write to the destination.
+type CopyService struct {
+	db      database.TxStarter
+	queries *database.Queries
+	storage *StorageService
+	jobs    *JobQueue
+}
+
+func NewCopyService(db database.TxStarter, queries *database.Queries, storage *StorageService, jobs *JobQueue) *CopyService {
+	return &CopyService{
+		db:      db,
+		queries: queries,
+		storage: storage,
+		jobs:    jobs,
+	}
+}
+
+// copyPlan is what one copy creates: folders parents first, and the files in them
+type copyPlan struct {
+	userID  pgtype.UUID
+	target  CopyTarget
+	folders []database.Folder
+	files   []database.File
+}
+
+// CopyFile copies a file's current version. The copy starts at version 1.
+func (s *CopyService) CopyFile(ctx context.Context, userID pgtype.UUID, file database.File, target CopyTarget) (CopyResult, error) {
+	if target.Name == "" {
+		target.Name = file.Name
+	}
+	return s.copy(ctx, copyPlan{
+		userID: userID,
+		target: target,
+		files:  []database.File{file},
+	})
+}
+
+// CopyFolder copies a folder and every active file and folder below it
+func (s *CopyService) CopyFolder(ctx context.Context, userID pgtype.UUID, folder database.Folder, target CopyTarget) (CopyResult, error) {
+	if target.Name == "" {
+		target.Name = folder.Name
+	}
+
+	folders, err := s.queries.GetActiveSubtreeFolders(ctx, folder.ID)
+	if err != nil {
+		return CopyResult{}, fmt.Errorf("failed to get folders: %w", err)
+	}
+	if len(folders) == 0 {
+		return CopyResult{}, ErrItemNotFound
+	}
+	files, err := s.queries.GetActiveSubtreeFiles(ctx, folder.ID)
+	if err != nil {
+		return CopyResult{}, fmt.Errorf("failed to get files: %w", err)
+	}
+	if len(folders)+len(files) > maxCopyItems {
+		return CopyResult{}, ErrCopyTooLarge
+	}
+
+	return s.copy(ctx, copyPlan{
+		userID:  userID,
+		target:  target,
+		folders: folders,
+		files:   files,
+	})
+}
+
+// copy creates everything in plan, charging the destination's owner, in one
+// transaction
+func (s *CopyService) copy(ctx context.Context, plan copyPlan) (CopyResult, error) {
+	var result CopyResult
+
+	ownerID := plan.userID
+	if plan.target.FolderID.Valid {
+		destination, err := s.queries.GetFolderByID(ctx, plan.target.FolderID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return result, ErrDestinationNotFound
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to get folder: %w", err)
+		}
+		ownerID = destination.OwnerID
+	}
+
+	// Content stored before deduplication is copied into the blob store first;
+	// everything else already has a digest
+	digests := make(map[pgtype.UUID]string, len(plan.files))
+	staged := make(map[string]StagedBlob)
+	defer func() {
+		for _, blob := range staged {
+			s.storage.DiscardBlob(ctx, blob)
+		}
+	}()
+	for _, file := range plan.files {
+		if file.BrokenAt.Valid {
+			return result, fmt.Errorf("%w: %s", ErrContentMissing, file.Name)
+		}
+		if digest, ok := blobDigest(file.StoragePath); ok {
+			digests[file.ID] = digest
+			continue
+		}
+
+		blob, err := s.stageLegacy(ctx, file)
+		if err != nil {
+			return result, err
+		}
+		digests[file.ID] = blob.Digest
+		if _, ok := staged[blob.Digest]; ok {
+			s.storage.DiscardBlob(ctx, blob)
+			continue
+		}
+		staged[blob.Digest] = blob
+	}
+
+	sorted := make([]string, 0, len(digests))
+	for _, digest := range digests {
+		sorted = append(sorted, digest)
+	}
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	err := database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		// Blob rows are locked before the owner's row, in digest order, as
+		// uploads and releases do
+		blobs := make(map[string]database.Blob, len(sorted))
+		for _, digest := range sorted {
+			var blob database.Blob
+			var err error
+			if stagedBlob, ok := staged[digest]; ok {
+				blob, err = q.UpsertBlob(ctx, database.UpsertBlobParams{
+					Digest: digest,
+					Size:   stagedBlob.Size,
+				})
+			} else {
+				blob, err = q.LockBlob(ctx, digest)
+				if errors.Is(err, pgx.ErrNoRows) {
+					return fmt.Errorf("%w: blob %s", ErrContentMissing, digest)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("failed to lock blob %s: %w", digest, err)
+			}
+			blobs[digest] = blob
+		}
+
+		if _, err := q.LockUserQuota(ctx, ownerID); err != nil {
+			return fmt.Errorf("failed to lock quota: %w", err)
+		}
+		usage, err := q.GetQuotaUsage(ctx, ownerID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota usage: %w", err)
+		}
+		charged, err := q.GetUnreferencedBlobBytes(ctx, database.GetUnreferencedBlobBytesParams{
+			Digests: sorted,
+			OwnerID: ownerID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get blob sizes: %w", err)
+		}
+		if err := checkQuota(usage, charged); err != nil {
+			return err
+		}
+		result.BytesCharged = charged
+
+		// Hold the destination's names until the copy is recorded
+		if err := lockChildren(ctx, q, ownerID, plan.target.FolderID); err != nil {
+			return err
+		}
+		// Files and folders have separate names, as in every other writer
+		itemType := database.ItemTypeFile
+		if len(plan.folders) > 0 {
+			itemType = database.ItemTypeFolder
+		}
+		taken, err := childNames(ctx, q, itemType, ownerID, plan.target.FolderID, pgtype.UUID{})
+		if err != nil {
+			return err
+		}
+		name := copyName(taken, plan.target.Name)
+
+		// Folders are listed parents first, so each parent is copied before
+		// its children. The first one is the folder being copied.
+		parents := make(map[pgtype.UUID]pgtype.UUID, len(plan.folders))
+		for i, folder := range plan.folders {
+			params := database.CreateFolderParams{
+				Name:           folder.Name,
+				OwnerID:        ownerID,
+				ParentFolderID: parents[folder.ParentFolderID],
+				IsRoot:         pgtype.Bool{Bool: false, Valid: true},
+			}
+			if i == 0 {
+				params.Name, params.ParentFolderID = name, plan.target.FolderID
+			}
+			created, err := q.CreateFolder(ctx, params)
+			if err != nil {
+				return fmt.Errorf("failed to create folder: %w", err)
+			}
+			parents[folder.ID] = created.ID
+			if i == 0 {
+				result.Folder = &created
+			}
+			result.Folders++
+		}
+
+		for _, file := range plan.files {
+			parentID, fileName := parents[file.ParentFolderID], file.Name
+			if len(plan.folders) == 0 {
+				parentID, fileName = plan.target.FolderID, name
+			}
+			created, err := s.copyFile(ctx, q, plan.userID, ownerID, file, blobs[digests[file.ID]], parentID, fileName)
+			if err != nil {
+				return err
+			}
+			if len(plan.folders) == 0 {
+				result.File = &created
+			}
+			result.Files++
+
+			// Every blob row is already locked, so references can be taken in any order
+			if err := retainBlob(ctx, q, blobs[digests[file.ID]], ownerID); err != nil {
+				return err
+			}
+		}
+
+		activity := database.LogActivityParams{
+			UserID:       plan.userID,
+			ActivityType: database.ActivityTypeUpload,
+		}
+		if result.Folder != nil {
+			activity.Details, _ = json.Marshal(map[string]any{
+				"copied_from_folder": uuid.UUID(plan.folders[0].ID.Bytes).String(),
+				"folder_id":          uuid.UUID(result.Folder.ID.Bytes).String(),
+				"folders":            result.Folders,
+				"files":              result.Files,
+			})
+		} else {
+			activity.FileID = result.File.ID
+			activity.Details, _ = json.Marshal(map[string]any{
+				"copied_from": uuid.UUID(plan.files[0].ID.Bytes).String(),
+			})
+		}
+		if err := q.LogActivity(ctx, activity); err != nil {
+			return fmt.Errorf("failed to log activity: %w", err)
+		}
+
+		// Staged content moves to its address last, as in BlobService.Store
+		for digest, blob := range staged {
+			if _, err := s.storage.CommitBlob(ctx, blob); err != nil {
+				return fmt.Errorf("failed to store blob %s: %w", digest, err)
+			}
+			delete(staged, digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return CopyResult{}, err
+	}
+
+	if result.Files > 0 {
+		s.jobs.notify()
+	}
+	return result, nil
+}
+
+// copyFile records a copy of file that uses blob as its only version
+func (s *CopyService) copyFile(ctx context.Context, q *database.Queries, userID, ownerID pgtype.UUID, file database.File, blob database.Blob, parentID pgtype.UUID, name string) (database.File, error) {
+	blobPath := BlobPath(blob.Digest)
+	created, err := q.CreateFile(ctx, database.CreateFileParams{
+		Name:             name,
+		OriginalName:     file.OriginalName,
+		MimeType:         file.MimeType,
+		Size:             blob.Size,
+		StoragePath:      blobPath,
+		OwnerID:          ownerID,
+		ParentFolderID:   parentID,
+		PreviewAvailable: pgtype.Bool{Bool: false, Valid: true},
+	})
+	if err != nil {
+		return created, nameError(err, "failed to create file")
+	}
+
+	version, err := q.CreateFileVersion(ctx, database.CreateFileVersionParams{
+		FileID:        created.ID,
+		VersionNumber: 1,
+		StoragePath:   blobPath,
+		Size:          blob.Size,
+		UploadedBy:    userID,
+		BlobDigest:    pgtype.Text{String: blob.Digest, Valid: true},
+	})
+	if err != nil {
+		return created, fmt.Errorf("failed to create file version: %w", err)
+	}
+	if err := q.UpdateFileStorageAndVersion(ctx, database.UpdateFileStorageAndVersionParams{
+		ID:               created.ID,
+		StoragePath:      blobPath,
+		Size:             blob.Size,
+		MimeType:         created.MimeType,
+		Version:          pgtype.Int4{Int32: 1, Valid: true},
+		CurrentVersionID: version.ID,
+	}); err != nil {
+		return created, fmt.Errorf("failed to update file: %w", err)
+	}
+	created.Version = pgtype.Int4{Int32: 1, Valid: true}
+	created.CurrentVersionID = version.ID
+
+	// Thumbnails and the searchable text are generated for the copy like for
+	// an upload, once the transaction commits
+	if _, err := q.EnqueueJob(ctx, previewJobParams(created.ID, 1)); err != nil {
+		return created, fmt.Errorf("failed to queue preview: %w", err)
+	}
+	if contentKindOf(created.MimeType, created.Name) != contentNone {
+		if _, err := q.EnqueueJob(ctx, contentJobParams(created.ID, 1)); err != nil {
+			return created, fmt.Errorf("failed to queue text extraction: %w", err)
+		}
+	}
+	return created, nil
+}
+
+// stageLegacy copies the content of a file stored before deduplication to a
+// staged blob
+func (s *CopyService) stageLegacy(ctx context.Context, file database.File) (StagedBlob, error) {
+	content, err := s.storage.GetFile(ctx, file.StoragePath)
+	if errors.Is(err, ErrObjectNotFound) {
+		return StagedBlob{}, fmt.Errorf("%w: %s", ErrContentMissing, file.Name)
+	}
+	if err != nil {
+		return StagedBlob{}, fmt.Errorf("failed to open %s: %w", file.StoragePath, err)
+	}
+	defer content.Close()
+
+	return s.storage.StageBlob(ctx, content)
+}
+
+// blobDigest returns the digest of a storage path in the blob store
+func blobDigest(storagePath string) (string, bool) {
+	if !strings.HasPrefix(storagePath, "blobs/") {
+		return "", false
+	}
+	return path.Base(storagePath), true
+}
+
+// copyName returns name, or "Copy of name" when the destination already
+// holds an item of the same kind called name, numbered until it is unique
+func copyName(taken []string, name string) string {
+	if !slices.Contains(taken, name) {
+		return name
+	}
+	candidate := "Copy of " + name
+	for n := 2; slices.Contains(taken, candidate); n++ {
+		candidate = fmt.Sprintf("Copy of %s (%d)", name, n)
+	}
+	return candidate
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+func TestCopyName(t *testing.T) {
+	tests := []struct {
+		taken []string
+		name  string
+		want  string
+	}{
+		{nil, "report.pdf", "report.pdf"},
+		{[]string{"other.pdf"}, "report.pdf", "report.pdf"},
+		{[]string{"report.pdf"}, "report.pdf", "Copy of report.pdf"},
+		{[]string{"report.pdf", "Copy of report.pdf"}, "report.pdf", "Copy of report.pdf (2)"},
+		{[]string{"report.pdf", "Copy of report.pdf", "Copy of report.pdf (2)", "Copy of report.pdf (3)"}, "report.pdf", "Copy of report.pdf (4)"},
+		// A free numbered name is not reused ahead of the plain copy name
+		{[]string{"report.pdf", "Copy of report.pdf (2)"}, "report.pdf", "Copy of report.pdf"},
+	}
+	for _, tt := range tests {
+		if got := copyName(tt.taken, tt.name); got != tt.want {
+			t.Errorf("copyName(%q, %q) = %q, want %q", tt.taken, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCopyNaming(t *testing.T) {
+	f := newFsckFixture(t)
+	ctx := context.Background()
+	content := "notes"
+	file, _ := f.addFile("notes.txt", content, &content)
+
+	folder := func(name string) database.Folder {
+		t.Helper()
+		folder, err := f.queries.CreateFolder(ctx, database.CreateFolderParams{
+			Name:    name,
+			OwnerID: f.owner.ID,
+			IsRoot:  pgtype.Bool{Bool: false, Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("failed to create folder: %v", err)
+		}
+		return folder
+	}
+	// Folder names do not take names from file copies
+	folder("Copy of notes.txt")
+	plans := folder("Plans")
+
+	copies := NewCopyService(f.db, f.queries, NewStorageService(f.files, f.thumbnails), NewJobQueue(f.db, f.queries))
+	for _, want := range []string{"Copy of notes.txt", "Copy of notes.txt (2)"} {
+		result, err := copies.CopyFile(ctx, f.owner.ID, file, CopyTarget{})
+		if err != nil {
+			t.Fatalf("CopyFile: %v", err)
+		}
+		if result.File.Name != want {
+			t.Errorf("copy named %q, want %q", result.File.Name, want)
+		}
+	}
+
+	result, err := copies.CopyFolder(ctx, f.owner.ID, plans, CopyTarget{})
+	if err != nil {
+		t.Fatalf("CopyFolder: %v", err)
+	}
+	if result.Folder.Name != "Copy of Plans" {
+		t.Errorf("folder copy named %q, want %q", result.Folder.Name, "Copy of Plans")
+	}
+
+	// A name given for the copy is kept when it is free
+	result, err = copies.CopyFile(ctx, f.owner.ID, file, CopyTarget{Name: "Plans"})
+	if err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	if result.File.Name != "Plans" {
+		t.Errorf("copy named %q, want %q", result.File.Name, "Plans")
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed schedule: either a fixed interval ("@every 30m") or
+// the five cron fields minute, hour, day of month, month and day of week,
+// evaluated in UTC. Each field is a bit set of the values it allows.
+type cronSpec struct {
+	every                         time.Duration
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCronSpec parses "@every <duration>", one of @hourly, @daily, @weekly
+// and @monthly, or five cron fields supporting *, lists, ranges and steps
+func parseCronSpec(spec string) (cronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || every < time.Minute {
+			return cronSpec{}, fmt.Errorf("invalid interval in schedule %q", spec)
+		}
+		return cronSpec{every: every}, nil
+	}
+	if expanded, ok := cronDescriptors[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return cronSpec{}, fmt.Errorf("schedule %q must have 5 fields", spec)
+	}
+
+	var c cronSpec
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return cronSpec{}, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return cronSpec{}, err
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return cronSpec{}, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return cronSpec{}, err
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return cronSpec{}, err
+	}
+	// 7 is another name for Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	// As in cron, a day field starting with * does not restrict the other
+	c.domRestricted = !strings.HasPrefix(fields[2], "*")
+	c.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// parseCronField parses one field into the set of values it allows
+func parseCronField(field string, low, high int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in cron field %q", field)
+			}
+			step = n
+		}
+
+		from, to := low, high
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			a, b, _ := strings.Cut(rangePart, "-")
+			var errA, errB error
+			from, errA = strconv.Atoi(a)
+			to, errB = strconv.Atoi(b)
+			if errA != nil || errB != nil {
+				return 0, fmt.Errorf("invalid range in cron field %q", field)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in cron field %q", field)
+			}
+			from = n
+			// "5/15" runs from 5 to the end; a bare "5" is just 5
+			if !hasStep {
+				to = n
+			}
+		}
+		if from < low || to > high || from > to {
+			return 0, fmt.Errorf("cron field %q is out of range %d-%d", field, low, high)
+		}
+
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// next returns the first time after t the schedule fires, in t's location
+func (c cronSpec) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+
+	loc := t.Location()
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	// Five years covers every valid combination, including 29 February
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t.In(loc)
+		}
+	}
+	return limit.In(loc)
+}
+
+// dayMatches applies cron's rule that when both day fields are restricted a
+// day matching either of them fires
+func (c cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domRestricted && c.dowRestricted:
+		return dom || dow
+	case c.domRestricted:
+		return dom
+	case c.dowRestricted:
+		return dow
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// shareDownloadWindow is how long after its last request a client's download
+// of a file through a share link stays open for more requests
+const shareDownloadWindow = 30 * time.Minute
+
+// DownloadTracker remembers recent downloads by key, so that the requests
+// making up one download, such as the ranges a media player or download
+// manager fetches one after another, are counted once. Downloads are kept in
+// memory, so each server tracks the requests it sees.
+type DownloadTracker struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	lastSeen  map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewDownloadTracker(window time.Duration) *DownloadTracker {
+	return &DownloadTracker{
+		window:   window,
+		now:      time.Now,
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// NewShareDownloadTracker returns the tracker for downloads through share
+// links, keyed by link, client address and content
+func NewShareDownloadTracker() *DownloadTracker {
+	return NewDownloadTracker(shareDownloadWindow)
+}
+
+// Continues reports whether a request for key continues a download started
+// within the window. If so the window is extended from now.
+func (t *DownloadTracker) Continues(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	last, ok := t.lastSeen[key]
+	if !ok || now.Sub(last) >= t.window {
+		return false
+	}
+	t.lastSeen[key] = now
+	return true
+}
+
+// Start records a new download of key, once it has been counted
+func (t *DownloadTracker) Start(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.lastSeen[key] = now
+
+	// Finished downloads are dropped once a window, so the map only holds
+	// keys seen recently
+	if now.Sub(t.lastSweep) >= t.window {
+		for other, last := range t.lastSeen {
+			if now.Sub(last) >= t.window {
+				delete(t.lastSeen, other)
+			}
+		}
+		t.lastSweep = now
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownloadTracker(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewDownloadTracker(time.Minute)
+	tracker.now = func() time.Time { return now }
+
+	if tracker.Continues("a") {
+		t.Fatalf("unknown key continues a download")
+	}
+	tracker.Start("a")
+
+	// Each request extends the download
+	for i := range 5 {
+		now = now.Add(50 * time.Second)
+		if !tracker.Continues("a") {
+			t.Fatalf("request %d after %v did not continue the download", i+1, 50*time.Second)
+		}
+	}
+	if tracker.Continues("b") {
+		t.Errorf("other key continues a download")
+	}
+
+	now = now.Add(time.Minute)
+	if tracker.Continues("a") {
+		t.Errorf("download continued after the window")
+	}
+
+	// Keys with no recent requests are swept
+	tracker.Start("b")
+	if _, kept := tracker.lastSeen["a"]; kept {
+		t.Errorf("stale key was not swept")
+	}
+}
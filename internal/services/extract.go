@@ -0,0 +1,186 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxExtractEntries bounds how many files and folders one archive may unpack to
+const maxExtractEntries = 10000
+
+var (
+	// ErrInvalidArchive is returned for uploads that are not a readable zip,
+	// tar or tar.gz archive
+	ErrInvalidArchive = errors.New("file is not a zip, tar or tar.gz archive")
+	// ErrUnsafeArchive is returned for archives with an entry that would land
+	// outside the folder it is extracted to
+	ErrUnsafeArchive = errors.New("archive entry escapes the extraction folder")
+)
+
+// UploadedArchive is an uploaded archive that can be read more than once
+type UploadedArchive interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// ArchiveListing summarises an archive checked by ScanArchive
+type ArchiveListing struct {
+	Files int
+	Dirs  int
+	// Bytes is the total size of the files once extracted
+	Bytes int64
+}
+
+// ExtractedEntry is a folder or regular file read from an archive. Links and
+// other special entries are skipped.
+type ExtractedEntry struct {
+	Dirs []string
+	// Name is empty for a folder, whose path is Dirs
+	Name    string
+	Size    int64
+	Content io.Reader
+}
+
+// ScanArchive checks every entry of an uploaded archive before anything is
+// extracted: names must stay inside the extraction folder, and the number of
+// entries is bounded. The sizes it sums are the ones the archive declares,
+// which extraction enforces.
+func ScanArchive(archive UploadedArchive, size int64) (ArchiveListing, error) {
+	var listing ArchiveListing
+	err := WalkArchive(archive, size, func(entry ExtractedEntry) error {
+		if entry.Name == "" {
+			listing.Dirs++
+		} else {
+			listing.Files++
+			listing.Bytes += entry.Size
+		}
+		if listing.Files+listing.Dirs > maxExtractEntries {
+			return fmt.Errorf("%w: more than %d entries", ErrInvalidArchive, maxExtractEntries)
+		}
+		return nil
+	})
+	return listing, err
+}
+
+// WalkArchive calls fn for each folder and regular file of an uploaded zip,
+// tar or tar.gz archive, in archive order. Content is only valid during the
+// call, and reading it fails if the entry holds more than its declared size.
+func WalkArchive(archive UploadedArchive, size int64, fn func(ExtractedEntry) error) error {
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(archive, header)
+	header = header[:n]
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return walkZip(archive, size, fn)
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(archive)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		defer gz.Close()
+		return walkTar(gz, fn)
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return walkTar(archive, fn)
+	}
+	return ErrInvalidArchive
+}
+
+func walkZip(archive io.ReaderAt, size int64, fn func(ExtractedEntry) error) error {
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	for _, file := range reader.File {
+		mode := file.Mode()
+		isDir := mode.IsDir() || strings.HasSuffix(file.Name, "/")
+		if !isDir && !mode.IsRegular() {
+			continue
+		}
+		entry, err := extractedEntry(file.Name, isDir)
+		if err != nil {
+			return err
+		}
+		if isDir {
+			if err := fn(entry); err != nil {
+				return err
+			}
+			continue
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, file.Name, err)
+		}
+		entry.Size = int64(file.UncompressedSize64)
+		entry.Content = content
+		err = fn(entry)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(archive io.Reader, fn func(ExtractedEntry) error) error {
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		isDir := header.Typeflag == tar.TypeDir
+		if !isDir && header.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, err := extractedEntry(header.Name, isDir)
+		if err != nil {
+			return err
+		}
+		if !isDir {
+			entry.Size = header.Size
+			entry.Content = reader
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// extractedEntry splits an entry name into the folders and name it is
+// extracted to. Names that are absolute or climb out with ".." are refused
+// rather than cleaned, since the archive was built to misplace them.
+func extractedEntry(name string, isDir bool) (ExtractedEntry, error) {
+	if isDir && (name == "./" || name == ".") {
+		// The archive's own root entry
+		return ExtractedEntry{}, nil
+	}
+	dirs, base, err := SplitRelativePath(name)
+	if err != nil {
+		return ExtractedEntry{}, fmt.Errorf("%w: %q", ErrUnsafeArchive, name)
+	}
+	if isDir {
+		return ExtractedEntry{Dirs: append(dirs, base)}, nil
+	}
+	return ExtractedEntry{Dirs: dirs, Name: base}, nil
+}
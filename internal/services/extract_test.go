@@ -0,0 +1,276 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"testing"
+)
+
+// archiveEntry is an entry written into a test archive
+type archiveEntry struct {
+	name string
+	body string
+	// typ is tar.TypeReg, tar.TypeDir or tar.TypeSymlink
+	typ byte
+}
+
+func fileEntry(name, body string) archiveEntry {
+	return archiveEntry{name: name, body: body, typ: tar.TypeReg}
+}
+
+func dirEntry(name string) archiveEntry {
+	return archiveEntry{name: name, typ: tar.TypeDir}
+}
+
+func linkEntry(name, target string) archiveEntry {
+	return archiveEntry{name: name, body: target, typ: tar.TypeSymlink}
+}
+
+func zipArchive(t *testing.T, entries ...archiveEntry) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Store}
+		switch entry.typ {
+		case tar.TypeDir:
+			header.SetMode(fs.ModeDir | 0o755)
+		case tar.TypeSymlink:
+			header.SetMode(fs.ModeSymlink | 0o777)
+		default:
+			header.SetMode(0o644)
+		}
+		content, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", entry.name, err)
+		}
+		if _, err := io.WriteString(content, entry.body); err != nil {
+			t.Fatalf("failed to write %s: %v", entry.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func tarArchive(t *testing.T, compress bool, entries ...archiveEntry) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		out = gz
+	}
+	w := tar.NewWriter(out)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typ, Mode: 0o644, Format: tar.FormatUSTAR}
+		switch entry.typ {
+		case tar.TypeReg:
+			header.Size = int64(len(entry.body))
+		case tar.TypeSymlink:
+			header.Linkname = entry.body
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatalf("failed to add %s: %v", entry.name, err)
+		}
+		if entry.typ == tar.TypeReg {
+			if _, err := io.WriteString(w, entry.body); err != nil {
+				t.Fatalf("failed to write %s: %v", entry.name, err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatalf("failed to close gzip: %v", err)
+		}
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+// archiveFormats builds the same entries as each supported format
+var archiveFormats = []struct {
+	name  string
+	build func(t *testing.T, entries ...archiveEntry) *bytes.Reader
+}{
+	{"zip", zipArchive},
+	{"tar", func(t *testing.T, entries ...archiveEntry) *bytes.Reader { return tarArchive(t, false, entries...) }},
+	{"tar.gz", func(t *testing.T, entries ...archiveEntry) *bytes.Reader { return tarArchive(t, true, entries...) }},
+}
+
+// walk lists what WalkArchive yields: folders as "path/" and files as
+// "path=content"
+func walk(archive *bytes.Reader) ([]string, error) {
+	var listed []string
+	err := WalkArchive(archive, archive.Size(), func(entry ExtractedEntry) error {
+		if entry.Name == "" {
+			listed = append(listed, path.Join(entry.Dirs...)+"/")
+			return nil
+		}
+		content, err := io.ReadAll(entry.Content)
+		if err != nil {
+			return err
+		}
+		if int64(len(content)) != entry.Size {
+			return fmt.Errorf("%s: read %d bytes, declared %d", entry.Name, len(content), entry.Size)
+		}
+		listed = append(listed, path.Join(append(entry.Dirs, entry.Name)...)+"="+string(content))
+		return nil
+	})
+	return listed, err
+}
+
+func TestWalkArchive(t *testing.T) {
+	entries := []archiveEntry{
+		dirEntry("./"),
+		dirEntry("docs/"),
+		fileEntry("docs/readme.txt", "hello"),
+		fileEntry("./a/b/notes.txt", "notes"),
+		fileEntry("empty.txt", ""),
+		// Links are skipped rather than followed or stored
+		linkEntry("docs/passwd", "/etc/passwd"),
+		linkEntry("up", "../.."),
+	}
+	want := []string{"/", "docs/", "docs/readme.txt=hello", "a/b/notes.txt=notes", "empty.txt="}
+
+	for _, format := range archiveFormats {
+		t.Run(format.name, func(t *testing.T) {
+			got, err := walk(format.build(t, entries...))
+			if err != nil {
+				t.Fatalf("WalkArchive: %v", err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWalkArchiveUnsafeNames(t *testing.T) {
+	names := []string{
+		"../evil.txt",
+		"docs/../../evil.txt",
+		"/etc/cron.d/evil",
+		`..\evil.txt`,
+		`docs\..\..\evil.txt`,
+		`\evil.txt`,
+		`C:\evil.txt`,
+	}
+	for _, format := range archiveFormats {
+		for _, name := range names {
+			t.Run(format.name+"/"+name, func(t *testing.T) {
+				archive := format.build(t, fileEntry("ok.txt", "ok"), fileEntry(name, "evil"))
+				if _, err := ScanArchive(archive, archive.Size()); !errors.Is(err, ErrUnsafeArchive) {
+					t.Errorf("ScanArchive = %v, want ErrUnsafeArchive", err)
+				}
+			})
+		}
+		t.Run(format.name+"/dir", func(t *testing.T) {
+			archive := format.build(t, dirEntry("../outside/"))
+			if _, err := ScanArchive(archive, archive.Size()); !errors.Is(err, ErrUnsafeArchive) {
+				t.Errorf("ScanArchive = %v, want ErrUnsafeArchive", err)
+			}
+		})
+	}
+}
+
+func TestScanArchive(t *testing.T) {
+	archive := zipArchive(t, dirEntry("docs/"), fileEntry("docs/a.txt", "abc"), fileEntry("b.txt", "de"), linkEntry("link", "b.txt"))
+	listing, err := ScanArchive(archive, archive.Size())
+	if err != nil {
+		t.Fatalf("ScanArchive: %v", err)
+	}
+	if want := (ArchiveListing{Files: 2, Dirs: 1, Bytes: 5}); listing != want {
+		t.Errorf("got %+v, want %+v", listing, want)
+	}
+}
+
+func TestScanArchiveEntryLimit(t *testing.T) {
+	entries := make([]archiveEntry, maxExtractEntries)
+	for i := range entries {
+		entries[i] = dirEntry(fmt.Sprintf("d%d/", i))
+	}
+
+	for _, format := range archiveFormats {
+		t.Run(format.name, func(t *testing.T) {
+			archive := format.build(t, entries...)
+			if _, err := ScanArchive(archive, archive.Size()); err != nil {
+				t.Fatalf("ScanArchive at the limit: %v", err)
+			}
+
+			archive = format.build(t, append(entries, fileEntry("one-more.txt", "x"))...)
+			if _, err := ScanArchive(archive, archive.Size()); !errors.Is(err, ErrInvalidArchive) {
+				t.Errorf("ScanArchive over the limit = %v, want ErrInvalidArchive", err)
+			}
+		})
+	}
+}
+
+// A zip entry holding more than the size it declares, which quota was
+// reserved for, fails when read. Tar entries cannot: the reader stops at the
+// declared size.
+func TestWalkZipOversizedEntry(t *testing.T) {
+	body := []byte("much more content than declared")
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	content, err := w.CreateRaw(&zip.FileHeader{
+		Name:               "bomb.txt",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(body),
+		CompressedSize64:   uint64(len(body)),
+		UncompressedSize64: 4,
+	})
+	if err != nil {
+		t.Fatalf("failed to add entry: %v", err)
+	}
+	content.Write(body)
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+
+	archive := bytes.NewReader(buf.Bytes())
+	listing, err := ScanArchive(archive, archive.Size())
+	if err != nil || listing.Bytes != 4 {
+		t.Fatalf("ScanArchive = %+v, %v; want the declared 4 bytes", listing, err)
+	}
+	if _, err := walk(archive); !errors.Is(err, zip.ErrFormat) {
+		t.Errorf("reading an entry past its declared size = %v, want zip.ErrFormat", err)
+	}
+}
+
+func TestWalkArchiveInvalid(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("compressed, but not a tar"))
+	w.Close()
+	var zipped bytes.Buffer
+	zipArchive(t, fileEntry("a.txt", "abc")).WriteTo(&zipped)
+	truncated := zipped.Bytes()
+
+	for name, content := range map[string][]byte{
+		"empty":     nil,
+		"text":      []byte("just some text"),
+		"truncated": truncated[:len(truncated)/2],
+		"gzip":      gz.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			archive := bytes.NewReader(content)
+			if _, err := ScanArchive(archive, archive.Size()); !errors.Is(err, ErrInvalidArchive) {
+				t.Errorf("ScanArchive = %v, want ErrInvalidArchive", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,480 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// quarantinePrefix is where repairs move orphaned objects instead of deleting them
+const quarantinePrefix = "quarantine/"
+
+// FsckIssueKind classifies a problem found by the consistency checker
+type FsckIssueKind string
+
+const (
+	// FsckMissingObject is a blob or legacy version whose object is not in storage
+	FsckMissingObject FsckIssueKind = "missing_object"
+	// FsckSizeMismatch is an object whose size differs from the recorded size
+	FsckSizeMismatch FsckIssueKind = "size_mismatch"
+	// FsckBrokenFile is a file whose current content is missing or damaged
+	FsckBrokenFile FsckIssueKind = "broken_file"
+	// FsckMissingThumbnail is a thumbnail_path without an object
+	FsckMissingThumbnail FsckIssueKind = "missing_thumbnail"
+	// FsckOrphanObject is an object in file storage that nothing refers to
+	FsckOrphanObject FsckIssueKind = "orphan_object"
+	// FsckOrphanThumbnail is an object in thumbnail storage that no file refers to
+	FsckOrphanThumbnail FsckIssueKind = "orphan_thumbnail"
+	// FsckUnusedBlob is a blob row that no version refers to
+	FsckUnusedBlob FsckIssueKind = "unused_blob"
+	// FsckRefCountMismatch is a blob_refs count that differs from the versions using the blob
+	FsckRefCountMismatch FsckIssueKind = "ref_count_mismatch"
+	// FsckStorageUsedMismatch is a storage_used that differs from the recomputed usage
+	FsckStorageUsedMismatch FsckIssueKind = "storage_used_mismatch"
+)
+
+// FsckIssue is one problem found by the consistency checker
+type FsckIssue struct {
+	Kind     FsckIssueKind `json:"kind"`
+	Key      string        `json:"key,omitempty"`
+	FileID   string        `json:"file_id,omitempty"`
+	UserID   string        `json:"user_id,omitempty"`
+	Expected int64         `json:"expected,omitempty"`
+	Actual   int64         `json:"actual,omitempty"`
+	Detail   string        `json:"detail,omitempty"`
+	Repaired bool          `json:"repaired"`
+	// RepairError is set when a repair was attempted and failed
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// FsckUsage is a user's recorded and recomputed storage_used
+type FsckUsage struct {
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	Recorded int64  `json:"recorded"`
+	Computed int64  `json:"computed"`
+}
+
+// FsckReport is the result of a consistency check
+type FsckReport struct {
+	Objects    int         `json:"objects_scanned"`
+	Thumbnails int         `json:"thumbnails_scanned"`
+	Blobs      int         `json:"blobs_checked"`
+	Files      int         `json:"files_checked"`
+	Quarantine string      `json:"quarantine,omitempty"`
+	Issues     []FsckIssue `json:"issues"`
+	Usage      []FsckUsage `json:"usage"`
+}
+
+// Unresolved counts the issues that were not repaired
+func (r *FsckReport) Unresolved() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// FsckOptions controls a consistency check
+type FsckOptions struct {
+	// Repair fixes what it can: orphans are moved under quarantine/, counters
+	// are recomputed, broken files are marked and dangling thumbnails cleared
+	Repair bool
+
+	// MinOrphanAge keeps objects younger than this out of the orphan report,
+	// as uploads in flight write their object before the row that refers to it
+	MinOrphanAge time.Duration
+}
+
+// ConsistencyChecker compares the database with the objects in storage.
+// Repairs assume the server is not writing at the same time.
+type ConsistencyChecker struct {
+	queries    *database.Queries
+	files      Backend
+	thumbnails Backend
+}
+
+func NewConsistencyChecker(queries *database.Queries, files, thumbnails Backend) *ConsistencyChecker {
+	return &ConsistencyChecker{
+		queries:    queries,
+		files:      files,
+		thumbnails: thumbnails,
+	}
+}
+
+// fsckRun carries the state of one check
+type fsckRun struct {
+	*ConsistencyChecker
+	opts       FsckOptions
+	report     FsckReport
+	quarantine string
+	now        time.Time
+}
+
+// Run checks every blob, file, version and thumbnail against storage and
+// recomputes each user's storage_used
+func (c *ConsistencyChecker) Run(ctx context.Context, opts FsckOptions) (FsckReport, error) {
+	run := &fsckRun{
+		ConsistencyChecker: c,
+		opts:               opts,
+		now:                time.Now(),
+	}
+	run.quarantine = path.Join(quarantinePrefix, run.now.UTC().Format("20060102T150405Z"))
+	if opts.Repair {
+		run.report.Quarantine = run.quarantine
+	}
+
+	objects, err := listObjects(ctx, c.files)
+	if err != nil {
+		return run.report, fmt.Errorf("failed to list file storage: %w", err)
+	}
+	thumbnails, err := listObjects(ctx, c.thumbnails)
+	if err != nil {
+		return run.report, fmt.Errorf("failed to list thumbnail storage: %w", err)
+	}
+	run.report.Objects = len(objects)
+	run.report.Thumbnails = len(thumbnails)
+
+	referenced, unusedBlobs, err := run.checkContent(ctx, objects)
+	if err != nil {
+		return run.report, err
+	}
+
+	referencedThumbnails, err := run.checkFiles(ctx, objects, thumbnails)
+	if err != nil {
+		return run.report, err
+	}
+
+	if err := run.checkPreviews(ctx, thumbnails, referencedThumbnails); err != nil {
+		return run.report, err
+	}
+
+	if err := run.checkOrphans(ctx, objects, referenced); err != nil {
+		return run.report, err
+	}
+	run.checkOrphanThumbnails(ctx, thumbnails, referencedThumbnails)
+
+	if err := run.checkBlobRefs(ctx); err != nil {
+		return run.report, err
+	}
+	run.removeUnusedBlobs(ctx, objects, unusedBlobs)
+
+	if err := run.checkStorageUsed(ctx); err != nil {
+		return run.report, err
+	}
+
+	return run.report, nil
+}
+
+// checkContent verifies that every blob and legacy version has an object of
+// the recorded size. Returns the keys the database refers to and the blobs no
+// version uses.
+func (r *fsckRun) checkContent(ctx context.Context, objects map[string]ObjectInfo) (map[string]bool, []string, error) {
+	referenced := make(map[string]bool)
+	var unused []string
+
+	blobs, err := r.queries.ListBlobsForCheck(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	r.report.Blobs = len(blobs)
+
+	for _, blob := range blobs {
+		key := BlobPath(blob.Digest)
+		referenced[key] = true
+		if !blob.InUse {
+			unused = append(unused, blob.Digest)
+			continue
+		}
+		r.checkObject(objects, key, blob.Size, "", "blob "+blob.Digest)
+	}
+
+	versions, err := r.queries.ListLegacyVersionsForCheck(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list legacy versions: %w", err)
+	}
+
+	for _, version := range versions {
+		referenced[version.StoragePath] = true
+		r.checkObject(objects, version.StoragePath, version.Size, uuidString(version.FileID), "version "+uuidString(version.ID))
+	}
+
+	return referenced, unused, nil
+}
+
+// checkObject reports a missing object or one whose size differs from size
+func (r *fsckRun) checkObject(objects map[string]ObjectInfo, key string, size int64, fileID, detail string) {
+	object, ok := objects[key]
+	switch {
+	case !ok:
+		r.add(FsckIssue{Kind: FsckMissingObject, Key: key, FileID: fileID, Detail: detail})
+	case object.Size != size:
+		r.add(FsckIssue{Kind: FsckSizeMismatch, Key: key, FileID: fileID, Expected: size, Actual: object.Size, Detail: detail})
+	}
+}
+
+// checkFiles marks files whose current content is missing or has the wrong
+// size as broken, and clears thumbnails that no longer exist. Returns the
+// thumbnail keys files refer to.
+func (r *fsckRun) checkFiles(ctx context.Context, objects, thumbnails map[string]ObjectInfo) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+
+	files, err := r.queries.ListFilesForCheck(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	r.report.Files = len(files)
+
+	for _, file := range files {
+		fileID := uuidString(file.ID)
+
+		object, ok := objects[file.StoragePath]
+		broken := !ok || object.Size != file.Size
+		switch {
+		case broken:
+			issue := FsckIssue{Kind: FsckBrokenFile, Key: file.StoragePath, FileID: fileID, UserID: uuidString(file.OwnerID), Expected: file.Size, Detail: file.Name}
+			if ok {
+				issue.Actual = object.Size
+			}
+			if r.opts.Repair {
+				r.repair(&issue, r.queries.SetFileBroken(ctx, database.SetFileBrokenParams{ID: file.ID, Broken: true}))
+			}
+			r.add(issue)
+		case file.BrokenAt.Valid && r.opts.Repair:
+			// The content is back, for example restored from a backup
+			if err := r.queries.SetFileBroken(ctx, database.SetFileBrokenParams{ID: file.ID, Broken: false}); err != nil {
+				fmt.Printf("Warning: failed to clear broken mark of file %s: %v\n", fileID, err)
+			}
+		}
+
+		if !file.ThumbnailPath.Valid || file.ThumbnailPath.String == "" {
+			continue
+		}
+		referenced[file.ThumbnailPath.String] = true
+		if _, ok := thumbnails[file.ThumbnailPath.String]; !ok {
+			issue := FsckIssue{Kind: FsckMissingThumbnail, Key: file.ThumbnailPath.String, FileID: fileID}
+			if r.opts.Repair {
+				r.repair(&issue, r.queries.ClearFileThumbnail(ctx, file.ID))
+			}
+			r.add(issue)
+		}
+	}
+
+	return referenced, nil
+}
+
+// checkPreviews reports previews whose object is missing and, when repairing,
+// queues their files for preview generation again. Adds the preview keys to
+// the referenced thumbnails.
+func (r *fsckRun) checkPreviews(ctx context.Context, thumbnails map[string]ObjectInfo, referenced map[string]bool) error {
+	previews, err := r.queries.ListPreviewsForCheck(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list previews: %w", err)
+	}
+
+	requeued := make(map[pgtype.UUID]bool)
+	for _, preview := range previews {
+		referenced[preview.Path] = true
+		if _, ok := thumbnails[preview.Path]; ok {
+			continue
+		}
+
+		issue := FsckIssue{Kind: FsckMissingThumbnail, Key: preview.Path, FileID: uuidString(preview.FileID), Detail: preview.Size + " preview"}
+		if r.opts.Repair {
+			if !requeued[preview.FileID] {
+				_, err = r.queries.EnqueueJob(ctx, previewJobParams(preview.FileID, preview.FileVersion))
+				requeued[preview.FileID] = err == nil
+			}
+			r.repair(&issue, err)
+		}
+		r.add(issue)
+	}
+	return nil
+}
+
+// checkOrphans reports objects in file storage that nothing refers to.
+// Chunks of upload sessions that are still pending are kept.
+func (r *fsckRun) checkOrphans(ctx context.Context, objects map[string]ObjectInfo, referenced map[string]bool) error {
+	pending, err := r.queries.ListPendingUploadSessionIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list upload sessions: %w", err)
+	}
+	pendingPrefixes := make([]string, 0, len(pending))
+	for _, id := range pending {
+		pendingPrefixes = append(pendingPrefixes, path.Join(".staging", uuidString(id))+"/")
+	}
+
+	for key, object := range objects {
+		if referenced[key] || r.tooRecent(object) || hasAnyPrefix(key, pendingPrefixes) {
+			continue
+		}
+
+		issue := FsckIssue{Kind: FsckOrphanObject, Key: key, Actual: object.Size}
+		if r.opts.Repair {
+			r.repair(&issue, r.files.Rename(ctx, key, path.Join(r.quarantine, key)))
+		}
+		r.add(issue)
+	}
+	return nil
+}
+
+// checkOrphanThumbnails reports thumbnails that no file refers to
+func (r *fsckRun) checkOrphanThumbnails(ctx context.Context, thumbnails map[string]ObjectInfo, referenced map[string]bool) {
+	for key, object := range thumbnails {
+		if referenced[key] || r.tooRecent(object) {
+			continue
+		}
+
+		issue := FsckIssue{Kind: FsckOrphanThumbnail, Key: key, Actual: object.Size}
+		if r.opts.Repair {
+			r.repair(&issue, r.thumbnails.Rename(ctx, key, path.Join(r.quarantine, key)))
+		}
+		r.add(issue)
+	}
+}
+
+// checkBlobRefs compares blob_refs with the versions that use each blob
+func (r *fsckRun) checkBlobRefs(ctx context.Context) error {
+	mismatches, err := r.queries.GetBlobRefMismatches(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check blob references: %w", err)
+	}
+
+	for _, ref := range mismatches {
+		issue := FsckIssue{
+			Kind:     FsckRefCountMismatch,
+			Key:      BlobPath(ref.Digest),
+			UserID:   uuidString(ref.OwnerID),
+			Expected: int64(ref.Expected),
+			Actual:   int64(ref.Recorded),
+		}
+		if r.opts.Repair {
+			if ref.Expected == 0 {
+				err = r.queries.DeleteBlobRefForRepair(ctx, database.DeleteBlobRefForRepairParams{
+					Digest:  ref.Digest,
+					OwnerID: ref.OwnerID,
+				})
+			} else {
+				err = r.queries.SetBlobRefCount(ctx, database.SetBlobRefCountParams{
+					Digest:   ref.Digest,
+					OwnerID:  ref.OwnerID,
+					RefCount: ref.Expected,
+				})
+			}
+			r.repair(&issue, err)
+		}
+		r.add(issue)
+	}
+	return nil
+}
+
+// removeUnusedBlobs reports blob rows without versions and, when repairing,
+// deletes them and quarantines their objects. Runs after checkBlobRefs has
+// dropped the references the rows would otherwise still have.
+func (r *fsckRun) removeUnusedBlobs(ctx context.Context, objects map[string]ObjectInfo, digests []string) {
+	for _, digest := range digests {
+		key := BlobPath(digest)
+		issue := FsckIssue{Kind: FsckUnusedBlob, Key: key}
+		if r.opts.Repair {
+			deleted, err := r.queries.DeleteUnreferencedBlob(ctx, digest)
+			if err == nil && deleted == 0 {
+				err = fmt.Errorf("blob is still referenced")
+			}
+			if _, ok := objects[key]; ok && err == nil {
+				err = r.files.Rename(ctx, key, path.Join(r.quarantine, key))
+			}
+			r.repair(&issue, err)
+		}
+		r.add(issue)
+	}
+}
+
+// checkStorageUsed recomputes every user's storage_used from the versions they own
+func (r *fsckRun) checkStorageUsed(ctx context.Context) error {
+	usage, err := r.queries.GetComputedStorageUsage(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to compute storage usage: %w", err)
+	}
+
+	for _, user := range usage {
+		userID := uuidString(user.ID)
+		r.report.Usage = append(r.report.Usage, FsckUsage{
+			UserID:   userID,
+			Email:    user.Email,
+			Recorded: user.Recorded,
+			Computed: user.Computed,
+		})
+		if user.Recorded == user.Computed {
+			continue
+		}
+
+		issue := FsckIssue{
+			Kind:     FsckStorageUsedMismatch,
+			UserID:   userID,
+			Expected: user.Computed,
+			Actual:   user.Recorded,
+			Detail:   user.Email,
+		}
+		if r.opts.Repair {
+			r.repair(&issue, r.queries.SetUserStorageUsed(ctx, database.SetUserStorageUsedParams{
+				ID:          user.ID,
+				StorageUsed: pgtype.Int8{Int64: user.Computed, Valid: true},
+			}))
+		}
+		r.add(issue)
+	}
+	return nil
+}
+
+func (r *fsckRun) add(issue FsckIssue) {
+	r.report.Issues = append(r.report.Issues, issue)
+}
+
+// repair records the outcome of a repair on issue
+func (r *fsckRun) repair(issue *FsckIssue, err error) {
+	if err != nil {
+		issue.RepairError = err.Error()
+		return
+	}
+	issue.Repaired = true
+}
+
+func (r *fsckRun) tooRecent(object ObjectInfo) bool {
+	return !object.ModTime.IsZero() && r.now.Sub(object.ModTime) < r.opts.MinOrphanAge
+}
+
+// listObjects loads every object of a backend outside the quarantine
+func listObjects(ctx context.Context, backend Backend) (map[string]ObjectInfo, error) {
+	objects := make(map[string]ObjectInfo)
+	err := backend.List(ctx, "", func(object ObjectInfo) error {
+		if !strings.HasPrefix(object.Key, quarantinePrefix) {
+			objects[object.Key] = object
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func uuidString(id pgtype.UUID) string {
+	if !id.Valid {
+		return ""
+	}
+	return uuid.UUID(id.Bytes).String()
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// fsckFixture is a database and a pair of disk backends holding one user's files
+type fsckFixture struct {
+	t          *testing.T
+	db         *pgxpool.Pool
+	queries    *database.Queries
+	files      *DiskBackend
+	thumbnails *DiskBackend
+	owner      database.User
+}
+
+func newFsckFixture(t *testing.T) *fsckFixture {
+	db, queries := testDB(t)
+	owner, err := queries.CreateUser(context.Background(), database.CreateUserParams{
+		Email:          "fsck@example.com",
+		HashedPassword: "x",
+		Name:           "Fsck",
+	})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	return &fsckFixture{
+		t:          t,
+		db:         db,
+		queries:    queries,
+		files:      NewDiskBackend(t.TempDir()),
+		thumbnails: NewDiskBackend(t.TempDir()),
+		owner:      owner,
+	}
+}
+
+// addFile records a file whose only version is the blob of content, as an
+// upload does, and stores stored as the blob's object unless it is nil.
+// Returns the file and its blob's key.
+func (f *fsckFixture) addFile(name, content string, stored *string) (database.File, string) {
+	f.t.Helper()
+	ctx := context.Background()
+
+	sum := sha256.Sum256([]byte(content))
+	digest := hex.EncodeToString(sum[:])
+	key := BlobPath(digest)
+	size := int64(len(content))
+
+	if _, err := f.queries.UpsertBlob(ctx, database.UpsertBlobParams{Digest: digest, Size: size}); err != nil {
+		f.t.Fatalf("failed to record blob: %v", err)
+	}
+	if _, err := f.queries.RetainBlobRef(ctx, database.RetainBlobRefParams{Digest: digest, OwnerID: f.owner.ID}); err != nil {
+		f.t.Fatalf("failed to retain blob: %v", err)
+	}
+	if err := f.queries.UpdateUserStorage(ctx, database.UpdateUserStorageParams{
+		ID:          f.owner.ID,
+		StorageUsed: pgtype.Int8{Int64: size, Valid: true},
+	}); err != nil {
+		f.t.Fatalf("failed to update storage: %v", err)
+	}
+
+	file, err := f.queries.CreateFile(ctx, database.CreateFileParams{
+		Name:             name,
+		OriginalName:     name,
+		MimeType:         "text/plain",
+		Size:             size,
+		StoragePath:      key,
+		OwnerID:          f.owner.ID,
+		PreviewAvailable: pgtype.Bool{Bool: false, Valid: true},
+	})
+	if err != nil {
+		f.t.Fatalf("failed to create file: %v", err)
+	}
+	if _, err := f.queries.CreateFileVersion(ctx, database.CreateFileVersionParams{
+		FileID:        file.ID,
+		VersionNumber: 1,
+		StoragePath:   key,
+		Size:          size,
+		UploadedBy:    f.owner.ID,
+		BlobDigest:    pgtype.Text{String: digest, Valid: true},
+	}); err != nil {
+		f.t.Fatalf("failed to create version: %v", err)
+	}
+
+	if stored != nil {
+		f.put(key, *stored)
+	}
+	return file, key
+}
+
+func (f *fsckFixture) put(key, content string) {
+	f.t.Helper()
+	if _, err := f.files.Put(context.Background(), key, strings.NewReader(content)); err != nil {
+		f.t.Fatalf("failed to store %s: %v", key, err)
+	}
+}
+
+func (f *fsckFixture) run(repair bool) FsckReport {
+	f.t.Helper()
+	checker := NewConsistencyChecker(f.queries, f.files, f.thumbnails)
+	report, err := checker.Run(context.Background(), FsckOptions{Repair: repair})
+	if err != nil {
+		f.t.Fatalf("fsck failed: %v", err)
+	}
+	return report
+}
+
+func (f *fsckFixture) exists(key string) bool {
+	f.t.Helper()
+	_, err := f.files.Stat(context.Background(), key)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		f.t.Fatalf("failed to stat %s: %v", key, err)
+	}
+	return err == nil
+}
+
+func (f *fsckFixture) broken(file database.File) bool {
+	f.t.Helper()
+	current, err := f.queries.GetFileByIDAnyStatus(context.Background(), file.ID)
+	if err != nil {
+		f.t.Fatalf("failed to get file: %v", err)
+	}
+	return current.BrokenAt.Valid
+}
+
+// findIssue returns the issue of kind for key, failing the test if there is none
+func findIssue(t *testing.T, report FsckReport, kind FsckIssueKind, key string) FsckIssue {
+	t.Helper()
+	for _, issue := range report.Issues {
+		if issue.Kind == kind && issue.Key == key {
+			return issue
+		}
+	}
+	t.Fatalf("no %s issue for %s in %+v", kind, key, report.Issues)
+	return FsckIssue{}
+}
+
+func TestConsistencyChecker(t *testing.T) {
+	f := newFsckFixture(t)
+
+	healthyContent := "healthy content"
+	healthy, healthyKey := f.addFile("healthy.txt", "healthy content", &healthyContent)
+	missing, missingKey := f.addFile("missing.txt", "content that was lost", nil)
+	truncatedContent := "trunc"
+	truncated, truncatedKey := f.addFile("truncated.txt", "content that was truncated", &truncatedContent)
+
+	orphanSum := sha256.Sum256([]byte("orphan content"))
+	orphanKey := BlobPath(hex.EncodeToString(orphanSum[:]))
+	f.put(orphanKey, "orphan content")
+
+	t.Run("report only", func(t *testing.T) {
+		report := f.run(false)
+
+		if report.Blobs != 3 || report.Files != 3 {
+			t.Errorf("checked %d blobs and %d files, want 3 and 3", report.Blobs, report.Files)
+		}
+
+		findIssue(t, report, FsckMissingObject, missingKey)
+		findIssue(t, report, FsckBrokenFile, missingKey)
+
+		mismatch := findIssue(t, report, FsckSizeMismatch, truncatedKey)
+		if mismatch.Expected != int64(len("content that was truncated")) || mismatch.Actual != int64(len(truncatedContent)) {
+			t.Errorf("size mismatch expected %d actual %d", mismatch.Expected, mismatch.Actual)
+		}
+		findIssue(t, report, FsckBrokenFile, truncatedKey)
+
+		findIssue(t, report, FsckOrphanObject, orphanKey)
+
+		for _, issue := range report.Issues {
+			if issue.Key == healthyKey {
+				t.Errorf("healthy file reported: %+v", issue)
+			}
+			if issue.Repaired {
+				t.Errorf("issue repaired without -repair: %+v", issue)
+			}
+		}
+		if report.Unresolved() != len(report.Issues) {
+			t.Errorf("%d of %d issues unresolved", report.Unresolved(), len(report.Issues))
+		}
+
+		// Nothing is changed
+		if !f.exists(orphanKey) {
+			t.Errorf("orphan %s was moved", orphanKey)
+		}
+		if f.broken(missing) || f.broken(truncated) {
+			t.Errorf("files were marked broken without -repair")
+		}
+	})
+
+	t.Run("repair", func(t *testing.T) {
+		report := f.run(true)
+		if report.Quarantine == "" {
+			t.Fatalf("repair reported no quarantine")
+		}
+
+		orphan := findIssue(t, report, FsckOrphanObject, orphanKey)
+		if !orphan.Repaired {
+			t.Errorf("orphan not repaired: %+v", orphan)
+		}
+		if f.exists(orphanKey) {
+			t.Errorf("orphan %s is still in place", orphanKey)
+		}
+		if !f.exists(path.Join(report.Quarantine, orphanKey)) {
+			t.Errorf("orphan %s was not quarantined", orphanKey)
+		}
+
+		// Lost content cannot be repaired, only marked
+		for _, key := range []string{missingKey, truncatedKey} {
+			if issue := findIssue(t, report, FsckBrokenFile, key); !issue.Repaired {
+				t.Errorf("broken file not marked: %+v", issue)
+			}
+		}
+		if issue := findIssue(t, report, FsckMissingObject, missingKey); issue.Repaired {
+			t.Errorf("missing object reported as repaired: %+v", issue)
+		}
+		if issue := findIssue(t, report, FsckSizeMismatch, truncatedKey); issue.Repaired {
+			t.Errorf("size mismatch reported as repaired: %+v", issue)
+		}
+		if !f.broken(missing) || !f.broken(truncated) {
+			t.Errorf("files were not marked broken")
+		}
+		if f.broken(healthy) {
+			t.Errorf("healthy file was marked broken")
+		}
+	})
+
+	t.Run("after repair", func(t *testing.T) {
+		report := f.run(false)
+
+		// The quarantine is not scanned, so the orphan is gone for good
+		for _, issue := range report.Issues {
+			if issue.Kind == FsckOrphanObject {
+				t.Errorf("orphan reported after repair: %+v", issue)
+			}
+		}
+		findIssue(t, report, FsckMissingObject, missingKey)
+		findIssue(t, report, FsckSizeMismatch, truncatedKey)
+	})
+}
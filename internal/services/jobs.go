@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+const (
+	// jobPollInterval is how often idle workers look for jobs queued by
+	// other server instances
+	jobPollInterval = 5 * time.Second
+	// jobHeartbeat is how often a running job's lock is renewed; a job whose
+	// lock is older than jobStaleAfter is claimed again
+	jobHeartbeat  = 30 * time.Second
+	jobStaleAfter = 5 * time.Minute
+	// scheduleInterval is how often due schedules are looked for
+	scheduleInterval = 30 * time.Second
+
+	defaultJobAttempts = 5
+	defaultJobTimeout  = 10 * time.Minute
+	jobBackoffBase     = 30 * time.Second
+	jobBackoffMax      = time.Hour
+
+	// Finished jobs are kept for inspection this long
+	succeededJobRetention = 7 * 24 * time.Hour
+	failedJobRetention    = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrJobNotFound is returned when retrying a job that does not exist
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobNotFailed is returned when retrying a job that has not failed, or
+	// whose key already has a pending job
+	ErrJobNotFailed = errors.New("job is not failed")
+)
+
+// JobHandler runs one job. Returning an error retries the job with
+// exponential backoff; errors wrapped with Permanent fail it at once.
+type JobHandler func(ctx context.Context, job database.Job) error
+
+// JobOptions configures a kind of job
+type JobOptions struct {
+	// MaxAttempts is how often a job is tried before it is failed
+	MaxAttempts int32
+	// Timeout bounds a single attempt
+	Timeout time.Duration
+}
+
+// EnqueueOptions configures a single job
+type EnqueueOptions struct {
+	// UniqueKey coalesces jobs: enqueueing a key that is already pending
+	// updates that job instead of adding another
+	UniqueKey string
+	// RunAfter delays the job; zero runs it as soon as a worker is free
+	RunAfter time.Time
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a job error that retrying will not fix
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type jobKind struct {
+	handler JobHandler
+	options JobOptions
+}
+
+type jobSchedule struct {
+	name    string
+	spec    string
+	cron    cronSpec
+	kind    string
+	payload json.RawMessage
+}
+
+// JobQueue runs background work from the jobs table. Any number of server
+// instances can share the queue: workers claim jobs with SKIP LOCKED and
+// renew their lock while a job runs, so a job is only taken over when its
+// worker has died. Kinds and schedules must be registered before Start.
+type JobQueue struct {
+	db        database.TxStarter
+	queries   *database.Queries
+	worker    string
+	kinds     map[string]jobKind
+	schedules []jobSchedule
+	wake      chan struct{}
+}
+
+func NewJobQueue(db database.TxStarter, queries *database.Queries) *JobQueue {
+	host, _ := os.Hostname()
+	q := &JobQueue{
+		db:      db,
+		queries: queries,
+		worker:  fmt.Sprintf("%s:%d", host, os.Getpid()),
+		kinds:   make(map[string]jobKind),
+		wake:    make(chan struct{}, 1),
+	}
+
+	q.Register("jobs.prune", q.pruneFinished, JobOptions{})
+	if err := q.Schedule("jobs.prune", "@daily", "jobs.prune", nil); err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// Register sets the handler of a kind of job. Zero options take the defaults.
+func (q *JobQueue) Register(kind string, handler JobHandler, options JobOptions) {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultJobAttempts
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultJobTimeout
+	}
+	q.kinds[kind] = jobKind{handler: handler, options: options}
+}
+
+// Schedule enqueues a job of kind with payload whenever spec fires. A new
+// schedule runs once right away. See parseCronSpec for the spec format.
+func (q *JobQueue) Schedule(name, spec, kind string, payload any) error {
+	cron, err := parseCronSpec(spec)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if payload == nil {
+		encoded = json.RawMessage("{}")
+	}
+
+	q.schedules = append(q.schedules, jobSchedule{name: name, spec: spec, cron: cron, kind: kind, payload: encoded})
+	return nil
+}
+
+// Enqueue adds a job of a registered kind
+func (q *JobQueue) Enqueue(ctx context.Context, kind string, payload any, options EnqueueOptions) (database.Job, error) {
+	params, err := q.enqueueParams(kind, payload, options)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	job, err := q.queries.EnqueueJob(ctx, params)
+	if err != nil {
+		return database.Job{}, fmt.Errorf("failed to enqueue %s job: %w", kind, err)
+	}
+	q.notify()
+	return job, nil
+}
+
+func (q *JobQueue) enqueueParams(kind string, payload any, options EnqueueOptions) (database.EnqueueJobParams, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return database.EnqueueJobParams{}, fmt.Errorf("failed to encode %s job: %w", kind, err)
+	}
+
+	maxAttempts := int32(defaultJobAttempts)
+	if registered, ok := q.kinds[kind]; ok {
+		maxAttempts = registered.options.MaxAttempts
+	}
+	runAfter := options.RunAfter
+	if runAfter.IsZero() {
+		runAfter = time.Now()
+	}
+
+	return database.EnqueueJobParams{
+		Kind:        kind,
+		Payload:     encoded,
+		UniqueKey:   pgtype.Text{String: options.UniqueKey, Valid: options.UniqueKey != ""},
+		MaxAttempts: maxAttempts,
+		RunAfter:    pgtype.Timestamp{Time: runAfter, Valid: true},
+	}, nil
+}
+
+// Pending reports whether a job with the key is waiting or running
+func (q *JobQueue) Pending(ctx context.Context, uniqueKey string) (bool, error) {
+	return q.queries.HasOpenJob(ctx, pgtype.Text{String: uniqueKey, Valid: true})
+}
+
+// Retry queues a failed job again with its attempts reset
+func (q *JobQueue) Retry(ctx context.Context, id pgtype.UUID) (database.Job, error) {
+	job, err := q.queries.RetryFailedJob(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := q.queries.GetJob(ctx, id); errors.Is(err, pgx.ErrNoRows) {
+			return database.Job{}, ErrJobNotFound
+		}
+		return database.Job{}, ErrJobNotFailed
+	}
+	if err != nil {
+		return database.Job{}, err
+	}
+	q.notify()
+	return job, nil
+}
+
+func (q *JobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start registers the schedules and runs the scheduler and workers until ctx
+// is cancelled
+func (q *JobQueue) Start(ctx context.Context, workers int) error {
+	now := time.Now()
+	for _, schedule := range q.schedules {
+		if err := q.queries.UpsertJobSchedule(ctx, database.UpsertJobScheduleParams{
+			Name:      schedule.name,
+			Kind:      schedule.kind,
+			Payload:   schedule.payload,
+			Spec:      schedule.spec,
+			NextRunAt: pgtype.Timestamp{Time: now, Valid: true},
+		}); err != nil {
+			return fmt.Errorf("failed to register schedule %s: %w", schedule.name, err)
+		}
+	}
+
+	go q.schedule(ctx)
+	for i := 0; i < workers; i++ {
+		go q.work(ctx)
+	}
+	return nil
+}
+
+// schedule enqueues the jobs of due schedules. Schedules are locked while
+// their job is enqueued, so only one instance enqueues each run.
+func (q *JobQueue) schedule(ctx context.Context) {
+	names := make([]string, 0, len(q.schedules))
+	byName := make(map[string]jobSchedule, len(q.schedules))
+	for _, schedule := range q.schedules {
+		names = append(names, schedule.name)
+		byName[schedule.name] = schedule
+	}
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		err := database.ExecTx(ctx, q.db, func(tx *database.Queries) error {
+			due, err := tx.ClaimDueSchedules(ctx, names)
+			if err != nil {
+				return fmt.Errorf("failed to get due schedules: %w", err)
+			}
+
+			for _, row := range due {
+				schedule := byName[row.Name]
+				params, err := q.enqueueParams(schedule.kind, schedule.payload, EnqueueOptions{UniqueKey: "schedule:" + schedule.name})
+				if err != nil {
+					return err
+				}
+				if _, err := tx.EnqueueJob(ctx, params); err != nil {
+					return fmt.Errorf("failed to enqueue %s: %w", schedule.name, err)
+				}
+				if err := tx.AdvanceJobSchedule(ctx, database.AdvanceJobScheduleParams{
+					Name:      schedule.name,
+					NextRunAt: pgtype.Timestamp{Time: schedule.cron.next(time.Now()), Valid: true},
+				}); err != nil {
+					return fmt.Errorf("failed to advance %s: %w", schedule.name, err)
+				}
+			}
+			if len(due) > 0 {
+				q.notify()
+			}
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Warning: job scheduler: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("Job scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *JobQueue) work(ctx context.Context) {
+	kinds := make([]string, 0, len(q.kinds))
+	for kind := range q.kinds {
+		kinds = append(kinds, kind)
+	}
+
+	for {
+		job, err := q.queries.ClaimJob(ctx, database.ClaimJobParams{
+			Worker:      q.worker,
+			Kinds:       kinds,
+			StaleBefore: pgtype.Timestamp{Time: time.Now().Add(-jobStaleAfter), Valid: true},
+		})
+		if err == nil {
+			q.run(ctx, job)
+			continue
+		}
+		if !errors.Is(err, pgx.ErrNoRows) && ctx.Err() == nil {
+			fmt.Printf("Warning: failed to claim job: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+// run executes a claimed job, renewing its lock meanwhile, and records the
+// outcome: success, a retry after backoff, or failure once the job is out of
+// attempts or its error is permanent
+func (q *JobQueue) run(ctx context.Context, job database.Job) {
+	claim := database.HeartbeatJobParams{ID: job.ID, Attempts: job.Attempts}
+
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// The previous attempt's worker died without recording an outcome
+		err = errors.New("worker stopped while running the job")
+	} else {
+		err = q.execute(ctx, job)
+	}
+
+	if err == nil {
+		if err := q.queries.CompleteJob(ctx, database.CompleteJobParams(claim)); err != nil {
+			fmt.Printf("Warning: failed to complete %s job %s: %v\n", job.Kind, job.ID.Bytes, err)
+		}
+		return
+	}
+
+	lastError := pgtype.Text{String: err.Error(), Valid: true}
+	var permanent permanentError
+	if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+		fmt.Printf("Warning: %s job %s failed: %v\n", job.Kind, job.ID.Bytes, err)
+		err = q.queries.FailJob(ctx, database.FailJobParams{ID: job.ID, Attempts: job.Attempts, LastError: lastError})
+	} else {
+		var retried int64
+		retried, err = q.queries.RetryJob(ctx, database.RetryJobParams{
+			ID:        job.ID,
+			Attempts:  job.Attempts,
+			LastError: lastError,
+			RunAfter:  pgtype.Timestamp{Time: time.Now().Add(jobBackoff(job.Attempts)), Valid: true},
+		})
+		// The key was enqueued again meanwhile; that job covers this one
+		if err == nil && retried == 0 && job.UniqueKey.Valid {
+			err = q.queries.DeleteSupersededJob(ctx, database.DeleteSupersededJobParams(claim))
+		}
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to record outcome of %s job %s: %v\n", job.Kind, job.ID.Bytes, err)
+	}
+}
+
+// execute calls the job's handler with its timeout, renewing the job's lock
+// until the handler returns. A panicking handler fails the attempt.
+func (q *JobQueue) execute(ctx context.Context, job database.Job) (err error) {
+	kind, ok := q.kinds[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("unknown job kind %q", job.Kind))
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, kind.options.Timeout)
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+				if err := q.queries.HeartbeatJob(jobCtx, database.HeartbeatJobParams{ID: job.ID, Attempts: job.Attempts}); err != nil && jobCtx.Err() == nil {
+					fmt.Printf("Warning: failed to renew lock of %s job %s: %v\n", job.Kind, job.ID.Bytes, err)
+				}
+			}
+		}
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return kind.handler(jobCtx, job)
+}
+
+// jobBackoff is the delay before retrying after the given attempt:
+// 30s, 1m, 2m, ... up to an hour
+func jobBackoff(attempt int32) time.Duration {
+	delay := jobBackoffBase
+	for i := int32(1); i < attempt && delay < jobBackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, jobBackoffMax)
+}
+
+// pruneFinished deletes finished jobs once they are past their retention
+func (q *JobQueue) pruneFinished(ctx context.Context, job database.Job) error {
+	now := time.Now()
+	deleted, err := q.queries.DeleteFinishedJobs(ctx, database.DeleteFinishedJobsParams{
+		SucceededBefore: pgtype.Timestamp{Time: now.Add(-succeededJobRetention), Valid: true},
+		FailedBefore:    pgtype.Timestamp{Time: now.Add(-failedJobRetention), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete finished jobs: %w", err)
+	}
+	if deleted > 0 {
+		fmt.Printf("Deleted %d finished jobs\n", deleted)
+	}
+	return nil
+}
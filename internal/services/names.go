@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// CollisionPolicy chooses what happens when an item is created, moved,
+// renamed or uploaded into a folder that already holds an active item of the
+// same type and name. Files and folders have separate names, so a file and a
+// folder may share one.
+type CollisionPolicy string
+
+const (
+	// CollisionFail refuses the change with ErrNameTaken
+	CollisionFail CollisionPolicy = "fail"
+	// CollisionRename gives the item the first free name "name (n)", with
+	// the number before a file's extension
+	CollisionRename CollisionPolicy = "rename"
+	// CollisionReplace stores an upload as a new version of the file that
+	// has its name. It only applies to uploads.
+	CollisionReplace CollisionPolicy = "replace"
+)
+
+var (
+	// ErrNameTaken is returned when an item of the same type and name is
+	// already in the folder and the policy is CollisionFail
+	ErrNameTaken = errors.New("an item with this name already exists in the folder")
+	// ErrReplaceUnsupported is returned for CollisionReplace outside uploads
+	ErrReplaceUnsupported = errors.New("on_conflict replace only applies to uploads")
+)
+
+// ParseCollisionPolicy reads an on_conflict value; empty means fallback
+func ParseCollisionPolicy(value string, fallback CollisionPolicy) (CollisionPolicy, error) {
+	switch policy := CollisionPolicy(value); policy {
+	case "":
+		return fallback, nil
+	case CollisionFail, CollisionRename, CollisionReplace:
+		return policy, nil
+	}
+	return "", fmt.Errorf("on_conflict must be fail, rename or replace")
+}
+
+// CreateFolder creates a folder called name in parentID, or at the top of
+// ownerID's drive when parentID is invalid, applying policy if the name is
+// taken
+func (s *PathService) CreateFolder(ctx context.Context, ownerID, parentID pgtype.UUID, name string, policy CollisionPolicy) (database.Folder, error) {
+	var folder database.Folder
+	err := database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		if err := lockChildren(ctx, q, ownerID, parentID); err != nil {
+			return err
+		}
+		name, err := resolveName(ctx, q, database.ItemTypeFolder, ownerID, parentID, pgtype.UUID{}, name, policy)
+		if err != nil {
+			return err
+		}
+
+		folder, err = q.CreateFolder(ctx, database.CreateFolderParams{
+			Name:           name,
+			OwnerID:        ownerID,
+			ParentFolderID: parentID,
+			IsRoot:         pgtype.Bool{Bool: false, Valid: true},
+		})
+		return nameError(err, "failed to create folder")
+	})
+	return folder, err
+}
+
+// RenameFile renames a file in its folder and returns the name it got
+func (s *PathService) RenameFile(ctx context.Context, file database.File, name string, policy CollisionPolicy) (string, error) {
+	err := database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		if err := lockChildren(ctx, q, file.OwnerID, file.ParentFolderID); err != nil {
+			return err
+		}
+		var err error
+		name, err = resolveName(ctx, q, database.ItemTypeFile, file.OwnerID, file.ParentFolderID, file.ID, name, policy)
+		if err != nil {
+			return err
+		}
+		return nameError(q.RenameFile(ctx, database.RenameFileParams{ID: file.ID, Name: name}), "failed to rename file")
+	})
+	return name, err
+}
+
+// RenameFolder renames a folder in its parent and returns the name it got
+func (s *PathService) RenameFolder(ctx context.Context, folder database.Folder, name string, policy CollisionPolicy) (string, error) {
+	err := database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		if err := lockChildren(ctx, q, folder.OwnerID, folder.ParentFolderID); err != nil {
+			return err
+		}
+		var err error
+		name, err = resolveName(ctx, q, database.ItemTypeFolder, folder.OwnerID, folder.ParentFolderID, folder.ID, name, policy)
+		if err != nil {
+			return err
+		}
+		return nameError(q.RenameFolder(ctx, database.RenameFolderParams{ID: folder.ID, Name: name}), "failed to rename folder")
+	})
+	return name, err
+}
+
+// MoveFile moves a file into parentID, or to the top of its owner's drive,
+// and returns the name it has there
+func (s *PathService) MoveFile(ctx context.Context, file database.File, parentID pgtype.UUID, policy CollisionPolicy) (name string, err error) {
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		name, err = moveFile(ctx, q, file, parentID, policy)
+		return err
+	})
+	return name, err
+}
+
+// MoveFolder moves a folder into parentID, or to the top of its owner's
+// drive, and returns the name it has there. A folder cannot be moved into
+// itself or anywhere below it.
+func (s *PathService) MoveFolder(ctx context.Context, folder database.Folder, parentID pgtype.UUID, policy CollisionPolicy) (name string, err error) {
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		name, err = moveFolder(ctx, q, folder, parentID, policy)
+		return err
+	})
+	return name, err
+}
+
+func moveFile(ctx context.Context, q *database.Queries, file database.File, parentID pgtype.UUID, policy CollisionPolicy) (string, error) {
+	if err := lockChildren(ctx, q, file.OwnerID, parentID); err != nil {
+		return "", err
+	}
+	name, err := resolveName(ctx, q, database.ItemTypeFile, file.OwnerID, parentID, file.ID, file.Name, policy)
+	if err != nil {
+		return "", err
+	}
+
+	err = q.MoveFile(ctx, database.MoveFileParams{
+		ID:             file.ID,
+		ParentFolderID: parentID,
+		Name:           name,
+	})
+	return name, nameError(err, "failed to move file")
+}
+
+func moveFolder(ctx context.Context, q *database.Queries, folder database.Folder, parentID pgtype.UUID, policy CollisionPolicy) (string, error) {
+	if parentID.Valid {
+		if parentID == folder.ID {
+			return "", ErrMoveIntoItself
+		}
+		// Moves to the top of a drive cannot close a loop and need no lock
+		if err := q.LockFolderMoves(ctx); err != nil {
+			return "", fmt.Errorf("failed to lock folder moves: %w", err)
+		}
+		chain, err := q.GetFolderAncestors(ctx, parentID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get ancestors: %w", err)
+		}
+		for _, ancestor := range chain {
+			if ancestor.ID == folder.ID {
+				return "", ErrMoveIntoDescendant
+			}
+		}
+	}
+
+	if err := lockChildren(ctx, q, folder.OwnerID, parentID); err != nil {
+		return "", err
+	}
+	name, err := resolveName(ctx, q, database.ItemTypeFolder, folder.OwnerID, parentID, folder.ID, folder.Name, policy)
+	if err != nil {
+		return "", err
+	}
+
+	err = q.MoveFolder(ctx, database.MoveFolderParams{
+		ID:             folder.ID,
+		ParentFolderID: parentID,
+		Name:           name,
+	})
+	return name, nameError(err, "failed to move folder")
+}
+
+// resolveName returns the name an item of itemType called name gets in
+// parentID under policy. excludeID is the item itself, when it is already
+// there. Callers hold lockChildren on parentID.
+func resolveName(
+	ctx context.Context,
+	q *database.Queries,
+	itemType database.ItemType,
+	ownerID pgtype.UUID,
+	parentID pgtype.UUID,
+	excludeID pgtype.UUID,
+	name string,
+	policy CollisionPolicy,
+) (string, error) {
+	if policy == CollisionReplace {
+		return "", ErrReplaceUnsupported
+	}
+
+	taken, err := childNames(ctx, q, itemType, ownerID, parentID, excludeID)
+	if err != nil {
+		return "", err
+	}
+
+	if !slices.Contains(taken, name) {
+		return name, nil
+	}
+	if policy != CollisionRename {
+		return "", ErrNameTaken
+	}
+
+	base, ext := name, ""
+	if itemType == database.ItemTypeFile {
+		if e := path.Ext(name); e != name {
+			base, ext = strings.TrimSuffix(name, e), e
+		}
+	}
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if !slices.Contains(taken, candidate) {
+			return candidate, nil
+		}
+	}
+}
+
+// childNames lists the names of the active items of itemType in parentID,
+// other than excludeID. Files and folders have separate names.
+func childNames(ctx context.Context, q *database.Queries, itemType database.ItemType, ownerID, parentID, excludeID pgtype.UUID) ([]string, error) {
+	var taken []string
+	var err error
+	if itemType == database.ItemTypeFile {
+		taken, err = q.GetChildFileNames(ctx, database.GetChildFileNamesParams{
+			FolderID:  parentID,
+			OwnerID:   ownerID,
+			ExcludeID: excludeID,
+		})
+	} else {
+		taken, err = q.GetChildFolderNames(ctx, database.GetChildFolderNamesParams{
+			FolderID:  parentID,
+			OwnerID:   ownerID,
+			ExcludeID: excludeID,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get names in folder: %w", err)
+	}
+	return taken, nil
+}
+
+// nameError turns the unique name constraint into ErrNameTaken, for writers
+// that race with one that does not hold lockChildren
+func nameError(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	if database.IsUniqueViolation(err) {
+		return ErrNameTaken
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
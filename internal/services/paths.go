@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// maxPathDepth bounds how many folders a relative path may name
+const maxPathDepth = 64
+
+// ErrInvalidPath is returned for relative paths that are empty, absolute or
+// that step outside the folder they are relative to
+var ErrInvalidPath = errors.New("invalid relative path")
+
+// SplitRelativePath splits a slash-separated path such as "a/b/c.txt" into
+// its folders and final name. Backslashes count as separators and empty and
+// "." segments are dropped; absolute paths and ".." segments are rejected.
+func SplitRelativePath(relativePath string) (dirs []string, name string, err error) {
+	relativePath = strings.ReplaceAll(relativePath, "\\", "/")
+	if strings.HasPrefix(relativePath, "/") || (len(relativePath) > 1 && relativePath[1] == ':') {
+		return nil, "", fmt.Errorf("%w: %q is absolute", ErrInvalidPath, relativePath)
+	}
+
+	var parts []string
+	for _, part := range strings.Split(relativePath, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return nil, "", fmt.Errorf("%w: %q leaves its folder", ErrInvalidPath, relativePath)
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, "", fmt.Errorf("%w: %q is empty", ErrInvalidPath, relativePath)
+	}
+	if len(parts) > maxPathDepth+1 {
+		return nil, "", fmt.Errorf("%w: more than %d folders deep", ErrInvalidPath, maxPathDepth)
+	}
+	return parts[:len(parts)-1], parts[len(parts)-1], nil
+}
+
+// PathService creates and resolves folders by name
+type PathService struct {
+	db      database.TxStarter
+	queries *database.Queries
+}
+
+func NewPathService(db database.TxStarter, queries *database.Queries) *PathService {
+	return &PathService{
+		db:      db,
+		queries: queries,
+	}
+}
+
+// ensureFolder returns the active folder called name in parentID, creating
+// it if there is none. Concurrent calls for the same parent are serialized,
+// so they agree on one folder.
+func (s *PathService) ensureFolder(ctx context.Context, ownerID, parentID pgtype.UUID, name string) (folder database.Folder, created bool, err error) {
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		if err := lockChildren(ctx, q, ownerID, parentID); err != nil {
+			return err
+		}
+
+		existing, err := q.GetChildFolderByName(ctx, database.GetChildFolderByNameParams{
+			Name:     name,
+			FolderID: parentID,
+			OwnerID:  ownerID,
+		})
+		if err == nil {
+			folder = existing
+			return nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to get folder: %w", err)
+		}
+
+		folder, err = q.CreateFolder(ctx, database.CreateFolderParams{
+			Name:           name,
+			OwnerID:        ownerID,
+			ParentFolderID: parentID,
+			IsRoot:         pgtype.Bool{Bool: false, Valid: true},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create folder: %w", err)
+		}
+		created = true
+		return nil
+	})
+	return folder, created, err
+}
+
+// lockChildren serializes changes to the items directly in parentID on the
+// parent's row, or on the owner's row at the top of their drive
+func lockChildren(ctx context.Context, q *database.Queries, ownerID, parentID pgtype.UUID) error {
+	if !parentID.Valid {
+		if _, err := q.LockUserQuota(ctx, ownerID); err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+		return nil
+	}
+
+	_, err := q.LockFolder(ctx, parentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrDestinationNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock folder: %w", err)
+	}
+	return nil
+}
+
+// FolderBuilder creates the folders of relative paths below one folder,
+// remembering the ones it has resolved so a tree of paths costs one lookup
+// per folder
+type FolderBuilder struct {
+	paths   *PathService
+	ownerID pgtype.UUID
+	rootID  pgtype.UUID
+	folders map[string]pgtype.UUID
+	// Created counts the folders that did not exist yet
+	Created int
+}
+
+// NewFolderBuilder resolves paths below rootID, or below the top of
+// ownerID's drive when rootID is invalid. New folders belong to ownerID.
+func (s *PathService) NewFolderBuilder(ownerID, rootID pgtype.UUID) *FolderBuilder {
+	return &FolderBuilder{
+		paths:   s,
+		ownerID: ownerID,
+		rootID:  rootID,
+		folders: make(map[string]pgtype.UUID),
+	}
+}
+
+// MkdirAll returns the folder at dirs below the root, reusing the folders
+// that already exist and creating the rest
+func (b *FolderBuilder) MkdirAll(ctx context.Context, dirs []string) (pgtype.UUID, error) {
+	parentID := b.rootID
+	for i, name := range dirs {
+		key := strings.Join(dirs[:i+1], "/")
+		if folderID, ok := b.folders[key]; ok {
+			parentID = folderID
+			continue
+		}
+
+		folder, created, err := b.paths.ensureFolder(ctx, b.ownerID, parentID, name)
+		if err != nil {
+			return pgtype.UUID{}, err
+		}
+		if created {
+			b.Created++
+		}
+		b.folders[key] = folder.ID
+		parentID = folder.ID
+	}
+	return parentID, nil
+}
+
+// ErrPathNotFound is returned when no active file or folder is at a path
+var ErrPathNotFound = errors.New("path not found")
+
+// Ancestors returns folderID and every folder above it, top-most first
+func (s *PathService) Ancestors(ctx context.Context, folderID pgtype.UUID) ([]database.Folder, error) {
+	folders, err := s.queries.GetFolderAncestors(ctx, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ancestors: %w", err)
+	}
+	return folders, nil
+}
+
+// ResolvePath finds the file or folder at a slash-separated path below base,
+// or below the top of userID's drive when base is nil. Folders are matched
+// before files; among items with the same name the oldest is taken.
+func (s *PathService) ResolvePath(ctx context.Context, userID pgtype.UUID, base *database.Folder, itemPath string) (*database.Folder, *database.File, error) {
+	dirs, name, err := SplitRelativePath(strings.TrimLeft(itemPath, "/"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseID, ownerID := pgtype.UUID{}, userID
+	if base != nil {
+		baseID, ownerID = base.ID, base.OwnerID
+	}
+
+	folder, err := s.queries.ResolveFolderPath(ctx, database.ResolveFolderPathParams{
+		Names:    append(dirs, name),
+		FolderID: baseID,
+		OwnerID:  ownerID,
+	})
+	if err == nil {
+		return &folder, nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, fmt.Errorf("failed to resolve folder: %w", err)
+	}
+
+	// Files belong to the owner of their folder
+	parentID := baseID
+	if len(dirs) > 0 {
+		parent, err := s.queries.ResolveFolderPath(ctx, database.ResolveFolderPathParams{
+			Names:    dirs,
+			FolderID: baseID,
+			OwnerID:  ownerID,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrPathNotFound
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve folder: %w", err)
+		}
+		parentID, ownerID = parent.ID, parent.OwnerID
+	}
+
+	file, err := s.queries.GetFileByNameAndFolder(ctx, database.GetFileByNameAndFolderParams{
+		OwnerID:        ownerID,
+		Name:           name,
+		ParentFolderID: parentID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, ErrPathNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve file: %w", err)
+	}
+	return nil, &file, nil
+}
+
+// FolderNode is a folder in a tree returned by Tree
+type FolderNode struct {
+	ID             pgtype.UUID      `json:"id"`
+	Name           string           `json:"name"`
+	ParentFolderID pgtype.UUID      `json:"parent_folder_id"`
+	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
+	// FileCount, FolderCount and Size cover the active items directly in the folder
+	FileCount   int64 `json:"file_count"`
+	FolderCount int64 `json:"folder_count"`
+	Size        int64 `json:"size"`
+	// The totals cover everything below the folder, including what the depth
+	// limit leaves out
+	TotalFiles   int64         `json:"total_files"`
+	TotalFolders int64         `json:"total_folders"`
+	TotalSize    int64         `json:"total_size"`
+	Children     []*FolderNode `json:"children,omitempty"`
+	// Truncated is set when the depth limit left out the folder's subfolders
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Tree returns the active folders below root, or every active folder of
+// ownerID's drive under a node for its top when root is nil. Folders more
+// than depth levels below the top node are left out; a negative depth means
+// no limit.
+func (s *PathService) Tree(ctx context.Context, ownerID pgtype.UUID, root *database.Folder, depth int) (*FolderNode, error) {
+	params := database.GetFolderTreeStatsParams{OwnerID: ownerID}
+	if root != nil {
+		params.FolderID = root.ID
+	}
+	rows, err := s.queries.GetFolderTreeStats(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder tree: %w", err)
+	}
+
+	// At the top of a drive the rows start one level below the top node
+	top := &FolderNode{Name: "My Drive"}
+	offset := 1
+	if root != nil {
+		if len(rows) == 0 {
+			return nil, ErrItemNotFound
+		}
+		offset = 0
+	} else {
+		stats, err := s.queries.GetRootFileStats(ctx, ownerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root files: %w", err)
+		}
+		top.FileCount, top.Size = stats.FileCount, stats.Size
+		top.TotalFiles, top.TotalSize = stats.FileCount, stats.Size
+	}
+
+	// Rows come parents first
+	nodes := make([]*FolderNode, len(rows))
+	parents := make([]*FolderNode, len(rows))
+	byID := make(map[pgtype.UUID]*FolderNode, len(rows))
+	for i, row := range rows {
+		node := &FolderNode{
+			ID:             row.ID,
+			Name:           row.Name,
+			ParentFolderID: row.ParentFolderID,
+			UpdatedAt:      row.UpdatedAt,
+			FileCount:      row.FileCount,
+			Size:           row.Size,
+		}
+		nodes[i] = node
+		byID[row.ID] = node
+
+		switch {
+		case row.Depth == 0 && root != nil:
+			top = node
+		case row.Depth == 0:
+			parents[i] = top
+		default:
+			parents[i] = byID[row.ParentFolderID]
+		}
+		if parents[i] != nil {
+			parents[i].Children = append(parents[i].Children, node)
+			parents[i].FolderCount++
+		}
+	}
+
+	// Children come after their parents, so walking backwards adds each
+	// subtree's totals to its parent once they are complete
+	for i := len(nodes) - 1; i >= 0; i-- {
+		node := nodes[i]
+		node.TotalFiles += node.FileCount
+		node.TotalSize += node.Size
+		if parent := parents[i]; parent != nil {
+			parent.TotalFiles += node.TotalFiles
+			parent.TotalFolders += node.TotalFolders + 1
+			parent.TotalSize += node.TotalSize
+		}
+	}
+
+	if depth >= 0 {
+		for i, node := range nodes {
+			if int(rows[i].Depth)+offset == depth && len(node.Children) > 0 {
+				node.Children = nil
+				node.Truncated = true
+			}
+		}
+		if root == nil && depth == 0 && len(top.Children) > 0 {
+			top.Children = nil
+			top.Truncated = true
+		}
+	}
+	return top, nil
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitRelativePath(t *testing.T) {
+	tests := []struct {
+		path string
+		dirs []string
+		name string
+	}{
+		{"notes.txt", nil, "notes.txt"},
+		{"docs/notes.txt", []string{"docs"}, "notes.txt"},
+		{"./docs//2025/./notes.txt", []string{"docs", "2025"}, "notes.txt"},
+		{`docs\2025\notes.txt`, []string{"docs", "2025"}, "notes.txt"},
+		{"docs/", nil, "docs"},
+		{"..notes", nil, "..notes"},
+		{strings.Repeat("d/", maxPathDepth) + "f", slices.Repeat([]string{"d"}, maxPathDepth), "f"},
+	}
+	for _, tt := range tests {
+		dirs, name, err := SplitRelativePath(tt.path)
+		if err != nil || !slices.Equal(dirs, tt.dirs) || name != tt.name {
+			t.Errorf("SplitRelativePath(%q) = %q, %q, %v; want %q, %q", tt.path, dirs, name, err, tt.dirs, tt.name)
+		}
+	}
+
+	for _, path := range []string{
+		"",
+		".",
+		"./",
+		"//",
+		"..",
+		"../notes.txt",
+		"docs/../../notes.txt",
+		"docs/../notes.txt",
+		`..\notes.txt`,
+		"/etc/passwd",
+		`\notes.txt`,
+		`C:\notes.txt`,
+		"c:notes.txt",
+		strings.Repeat("d/", maxPathDepth+1) + "f",
+	} {
+		if _, _, err := SplitRelativePath(path); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("SplitRelativePath(%q) = %v, want ErrInvalidPath", path, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// Action is an operation on a file or folder that requires a minimum role
+type Action int
+
+const (
+	ActionRead    Action = iota // view, download, list: viewer
+	ActionComment               // add comments: commenter
+	ActionWrite                 // upload, rename, move, trash, restore: editor
+	ActionShare                 // grant access and manage share links: owner
+	ActionDelete                // permanently delete and manage retention: owner
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionRead:
+		return "read"
+	case ActionComment:
+		return "comment"
+	case ActionWrite:
+		return "write"
+	case ActionShare:
+		return "share"
+	case ActionDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+// minimumRole is the weakest role allowed to perform each action
+var minimumRole = map[Action]database.PermissionRole{
+	ActionRead:    database.PermissionRoleViewer,
+	ActionComment: database.PermissionRoleCommenter,
+	ActionWrite:   database.PermissionRoleEditor,
+	ActionShare:   database.PermissionRoleOwner,
+	ActionDelete:  database.PermissionRoleOwner,
+}
+
+// roleRank orders roles the same way as the permission_role enum
+var roleRank = map[database.PermissionRole]int{
+	database.PermissionRoleViewer:    1,
+	database.PermissionRoleCommenter: 2,
+	database.PermissionRoleEditor:    3,
+	database.PermissionRoleOwner:     4,
+}
+
+// RoleAtLeast reports whether role grants everything min does
+func RoleAtLeast(role, min database.PermissionRole) bool {
+	return roleRank[role] >= roleRank[min]
+}
+
+// RoleAllows reports whether role is strong enough for action
+func RoleAllows(role database.PermissionRole, action Action) bool {
+	return RoleAtLeast(role, minimumRole[action])
+}
+
+// PermissionService resolves what a user may do with a file or folder.
+// Roles are inherited: a grant on a folder applies to everything below it,
+// and the owner of a folder is an owner of its contents.
+type PermissionService struct {
+	queries *database.Queries
+}
+
+func NewPermissionService(queries *database.Queries) *PermissionService {
+	return &PermissionService{
+		queries: queries,
+	}
+}
+
+// EffectiveRole returns the strongest role userID holds on the item, taking
+// grants on ancestor folders into account. ok is false when the user has no access.
+func (s *PermissionService) EffectiveRole(ctx context.Context, userID pgtype.UUID, itemType database.ItemType, itemID pgtype.UUID) (role database.PermissionRole, ok bool, err error) {
+	role, err = s.queries.GetEffectiveRole(ctx, database.GetEffectiveRoleParams{
+		UserID:   userID,
+		ItemType: itemType,
+		ItemID:   itemID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to resolve permissions: %w", err)
+	}
+
+	return role, true, nil
+}
+
+// Can reports whether userID may perform action on the item
+func (s *PermissionService) Can(ctx context.Context, userID pgtype.UUID, itemType database.ItemType, itemID pgtype.UUID, action Action) (bool, error) {
+	role, ok, err := s.EffectiveRole(ctx, userID, itemType, itemID)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return RoleAllows(role, action), nil
+}
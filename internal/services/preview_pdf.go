@@ -0,0 +1,704 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxPDFStreamBytes bounds how much a single compressed stream may inflate to
+const maxPDFStreamBytes = 256 << 20
+
+var (
+	pdfObjectHeader = regexp.MustCompile(`(?:^|\s)(\d+)\s+\d+\s+obj\b`)
+	pdfRootRef      = regexp.MustCompile(`/Root\s+(\d+)\s+\d+\s+R`)
+)
+
+type (
+	pdfName   string
+	pdfRef    int
+	pdfString string
+	pdfDict   map[pdfName]any
+	pdfStream struct {
+		dict pdfDict
+		data []byte
+	}
+)
+
+// pdfDocument is just enough of a PDF reader to find the images drawn on the
+// first page and the text of every page. Objects are located by scanning for "n g obj" rather than
+// through the cross-reference table, which tolerates damaged files and
+// incremental updates alike; objects packed in object streams are unpacked.
+type pdfDocument struct {
+	data    []byte
+	offsets map[int]int
+	packed  map[int][]byte
+	// loading guards against objects whose stream length refers to themselves
+	loading map[int]bool
+}
+
+// pdfFirstPageImage decodes the largest image on the first page of a PDF.
+// Returns errNoPreview when the page draws no image this reader can decode.
+func pdfFirstPageImage(data []byte) (image.Image, error) {
+	doc, catalog, err := openPDF(data)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := doc.firstPageResources(catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	images := doc.images(resources, 0)
+	sort.Slice(images, func(i, j int) bool {
+		return pdfImageArea(images[i]) > pdfImageArea(images[j])
+	})
+	for _, stream := range images {
+		if src, err := doc.decodeImage(stream); err == nil {
+			return src, nil
+		}
+	}
+	return nil, errNoPreview
+}
+
+// openPDF indexes a PDF's objects and returns its document catalog
+func openPDF(data []byte) (*pdfDocument, pdfDict, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil, nil, errors.New("not a PDF file")
+	}
+
+	doc := &pdfDocument{
+		data:    data,
+		offsets: make(map[int]int),
+		packed:  make(map[int][]byte),
+		loading: make(map[int]bool),
+	}
+	for _, m := range pdfObjectHeader.FindAllSubmatchIndex(data, -1) {
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err == nil {
+			// Incremental updates append newer definitions of an object
+			doc.offsets[num] = m[1]
+		}
+	}
+	doc.unpackObjectStreams()
+
+	roots := pdfRootRef.FindAllSubmatch(data, -1)
+	if len(roots) == 0 {
+		return nil, nil, errors.New("document catalog not found")
+	}
+	root, _ := strconv.Atoi(string(roots[len(roots)-1][1]))
+
+	catalog := doc.dict(pdfRef(root))
+	if catalog == nil {
+		return nil, nil, errors.New("document catalog not found")
+	}
+	return doc, catalog, nil
+}
+
+// firstPageResources walks the page tree down its first branch and returns
+// the resources of the first page, which may be inherited from a parent node
+func (d *pdfDocument) firstPageResources(catalog pdfDict) (pdfDict, error) {
+	node := d.dict(catalog["Pages"])
+	var resources any
+	for depth := 0; node != nil && depth < 32; depth++ {
+		if r, ok := node["Resources"]; ok {
+			resources = r
+		}
+		if node["Type"] == pdfName("Page") {
+			return d.dict(resources), nil
+		}
+
+		kids, _ := d.resolve(node["Kids"]).([]any)
+		if len(kids) == 0 {
+			break
+		}
+		node = d.dict(kids[0])
+	}
+	return nil, errors.New("first page not found")
+}
+
+// images collects the image XObjects in resources, including those drawn by
+// form XObjects
+func (d *pdfDocument) images(resources pdfDict, depth int) []pdfStream {
+	var images []pdfStream
+	for _, ref := range d.dict(resources["XObject"]) {
+		stream, ok := d.resolve(ref).(pdfStream)
+		if !ok {
+			continue
+		}
+		switch stream.dict["Subtype"] {
+		case pdfName("Image"):
+			images = append(images, stream)
+		case pdfName("Form"):
+			if depth < 3 {
+				images = append(images, d.images(d.dict(stream.dict["Resources"]), depth+1)...)
+			}
+		}
+	}
+	return images
+}
+
+func pdfImageArea(stream pdfStream) int {
+	width, _ := pdfInt(stream.dict["Width"])
+	height, _ := pdfInt(stream.dict["Height"])
+	return width * height
+}
+
+// decodeImage decodes JPEG images and uncompressed or deflated 8-bit gray,
+// RGB and CMYK samples. JPEG 2000, JBIG2, fax and indexed images are skipped.
+func (d *pdfDocument) decodeImage(stream pdfStream) (image.Image, error) {
+	width, _ := pdfInt(d.resolve(stream.dict["Width"]))
+	height, _ := pdfInt(d.resolve(stream.dict["Height"]))
+	if width <= 0 || height <= 0 || width*height > maxPreviewPixels {
+		return nil, fmt.Errorf("unsupported image size %dx%d", width, height)
+	}
+
+	data, filter, err := d.decode(stream)
+	if err != nil {
+		return nil, err
+	}
+	switch filter {
+	case "DCTDecode":
+		return jpeg.Decode(bytes.NewReader(data))
+	case "":
+	default:
+		return nil, fmt.Errorf("unsupported image filter %s", filter)
+	}
+
+	if bits, _ := pdfInt(d.resolve(stream.dict["BitsPerComponent"])); bits != 8 {
+		return nil, fmt.Errorf("unsupported bits per component %d", bits)
+	}
+	components := d.colorComponents(stream.dict["ColorSpace"])
+	if components == 0 || len(data) < width*height*components {
+		return nil, errors.New("unsupported image samples")
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	switch components {
+	case 1:
+		return &image.Gray{Pix: data[:width*height], Stride: width, Rect: rect}, nil
+	case 4:
+		return &image.CMYK{Pix: data[:width*height*4], Stride: width * 4, Rect: rect}, nil
+	}
+	rgba := image.NewNRGBA(rect)
+	for i := 0; i < width*height; i++ {
+		copy(rgba.Pix[i*4:], data[i*3:i*3+3])
+		rgba.Pix[i*4+3] = 0xff
+	}
+	return rgba, nil
+}
+
+// colorComponents returns how many samples a pixel has in the color space
+func (d *pdfDocument) colorComponents(space any) int {
+	switch space := d.resolve(space).(type) {
+	case pdfName:
+		switch space {
+		case "DeviceGray", "CalGray":
+			return 1
+		case "DeviceRGB", "CalRGB":
+			return 3
+		case "DeviceCMYK":
+			return 4
+		}
+	case []any:
+		if len(space) == 2 && space[0] == pdfName("ICCBased") {
+			if profile, ok := d.resolve(space[1]).(pdfStream); ok {
+				n, _ := pdfInt(d.resolve(profile.dict["N"]))
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// decode applies a stream's filters. A trailing image filter that this reader
+// does not apply itself is returned with the data still encoded.
+func (d *pdfDocument) decode(stream pdfStream) ([]byte, string, error) {
+	var filters []any
+	switch filter := d.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []any{filter}
+	case []any:
+		filters = filter
+	}
+	var params []any
+	switch param := d.resolve(stream.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = []any{param}
+	case []any:
+		params = param
+	}
+
+	data := stream.data
+	for i, f := range filters {
+		name, _ := d.resolve(f).(pdfName)
+		if name != "FlateDecode" {
+			if i < len(filters)-1 {
+				return nil, "", fmt.Errorf("unsupported filter %s", name)
+			}
+			return data, string(name), nil
+		}
+
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", err
+		}
+		inflated, err := io.ReadAll(io.LimitReader(r, maxPDFStreamBytes))
+		// Many writers leave the checksum out; keep whatever inflated
+		if err != nil && len(inflated) == 0 {
+			return nil, "", err
+		}
+
+		var param pdfDict
+		if i < len(params) {
+			param = d.dict(params[i])
+		}
+		data, err = d.unpredict(inflated, param)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return data, "", nil
+}
+
+// unpredict reverses the PNG predictors deflated streams may use
+func (d *pdfDocument) unpredict(data []byte, param pdfDict) ([]byte, error) {
+	predictor, _ := pdfInt(d.resolve(param["Predictor"]))
+	if predictor < 10 {
+		if predictor > 1 {
+			return nil, fmt.Errorf("unsupported predictor %d", predictor)
+		}
+		return data, nil
+	}
+
+	colors, bits, columns := 1, 8, 1
+	if v, ok := pdfInt(d.resolve(param["Colors"])); ok {
+		colors = v
+	}
+	if v, ok := pdfInt(d.resolve(param["BitsPerComponent"])); ok {
+		bits = v
+	}
+	if v, ok := pdfInt(d.resolve(param["Columns"])); ok {
+		columns = v
+	}
+	bpp := max((colors*bits+7)/8, 1)
+	rowLen := (colors*bits*columns + 7) / 8
+	if rowLen <= 0 {
+		return nil, errors.New("invalid predictor parameters")
+	}
+
+	out := make([]byte, 0, len(data)/(rowLen+1)*rowLen)
+	prev := make([]byte, rowLen)
+	for len(data) > rowLen {
+		filter, row := data[0], data[1:rowLen+1]
+		data = data[rowLen+1:]
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			switch filter {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += prev[i]
+			case 3:
+				row[i] += byte((int(left) + int(prev[i])) / 2)
+			case 4:
+				row[i] += paeth(left, prev[i], upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	distance := func(x, y int) int {
+		if x > y {
+			return x - y
+		}
+		return y - x
+	}
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := distance(p, int(a)), distance(p, int(b)), distance(p, int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+// unpackObjectStreams indexes the objects stored inside object streams.
+// Objects also written out directly keep their direct definition.
+func (d *pdfDocument) unpackObjectStreams() {
+	for num := range d.offsets {
+		stream, ok := d.object(num).(pdfStream)
+		if !ok || stream.dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		count, _ := pdfInt(stream.dict["N"])
+		first, _ := pdfInt(stream.dict["First"])
+		data, filter, err := d.decode(stream)
+		if err != nil || filter != "" || first > len(data) {
+			continue
+		}
+
+		header := &pdfParser{data: data[:first]}
+		var entries [][2]int
+		for i := 0; i < count; i++ {
+			objNum, ok1 := pdfInt(header.mustValue())
+			offset, ok2 := pdfInt(header.mustValue())
+			if !ok1 || !ok2 || first+offset > len(data) {
+				break
+			}
+			entries = append(entries, [2]int{objNum, first + offset})
+		}
+		for i, entry := range entries {
+			end := len(data)
+			if i+1 < len(entries) && entries[i+1][1] >= entry[1] {
+				end = entries[i+1][1]
+			}
+			if _, direct := d.offsets[entry[0]]; !direct {
+				d.packed[entry[0]] = data[entry[1]:end]
+			}
+		}
+	}
+}
+
+// object parses an object, returning a pdfStream for stream objects and nil
+// when the object does not exist or cannot be parsed
+func (d *pdfDocument) object(num int) any {
+	if d.loading[num] {
+		return nil
+	}
+	d.loading[num] = true
+	defer delete(d.loading, num)
+
+	offset, ok := d.offsets[num]
+	if !ok {
+		if body, ok := d.packed[num]; ok {
+			return (&pdfParser{data: body}).mustValue()
+		}
+		return nil
+	}
+
+	p := &pdfParser{data: d.data, pos: offset}
+	value := p.mustValue()
+	dict, ok := value.(pdfDict)
+	if !ok {
+		return value
+	}
+	p.skipSpace()
+	if !bytes.HasPrefix(d.data[p.pos:], []byte("stream")) {
+		return dict
+	}
+	p.pos += len("stream")
+	if p.peek() == '\r' {
+		p.pos++
+	}
+	if p.peek() == '\n' {
+		p.pos++
+	}
+
+	start := p.pos
+	length, ok := pdfInt(d.resolve(dict["Length"]))
+	end := start + length
+	if !ok || length < 0 || end > len(d.data) || !bytes.Contains(d.data[end:min(end+32, len(d.data))], []byte("endstream")) {
+		// Wrong or missing length: fall back to the end marker
+		i := bytes.Index(d.data[start:], []byte("endstream"))
+		if i < 0 {
+			return nil
+		}
+		end = start + i
+	}
+	return pdfStream{dict: dict, data: d.data[start:end]}
+}
+
+// resolve follows indirect references to the object they point at
+func (d *pdfDocument) resolve(value any) any {
+	for i := 0; i < 8; i++ {
+		ref, ok := value.(pdfRef)
+		if !ok {
+			return value
+		}
+		value = d.object(int(ref))
+	}
+	return nil
+}
+
+// dict resolves value to a dictionary, taking the dictionary of a stream
+func (d *pdfDocument) dict(value any) pdfDict {
+	switch value := d.resolve(value).(type) {
+	case pdfDict:
+		return value
+	case pdfStream:
+		return value.dict
+	}
+	return nil
+}
+
+func pdfInt(value any) (int, bool) {
+	n, ok := value.(float64)
+	return int(n), ok
+}
+
+// pdfParser reads PDF values: numbers become float64, indirect references
+// pdfRef, strings their decoded bytes, and keywords such as true or null a
+// plain string
+type pdfParser struct {
+	data []byte
+	pos  int
+}
+
+// mustValue parses the next value, returning nil on malformed input
+func (p *pdfParser) mustValue() any {
+	value, err := p.value(0)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+func (p *pdfParser) value(depth int) (any, error) {
+	if depth > 32 {
+		return nil, errors.New("PDF value nested too deeply")
+	}
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		p.pos++
+		return pdfName(p.token()), nil
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		p.pos += 2
+		dict := pdfDict{}
+		for {
+			p.skipSpace()
+			if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+				p.pos += 2
+				return dict, nil
+			}
+			key, err := p.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(pdfName)
+			if !ok {
+				return nil, errors.New("PDF dictionary key is not a name")
+			}
+			if dict[name], err = p.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+	case c == '<':
+		end := bytes.IndexByte(p.data[p.pos:], '>')
+		if end < 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		s := pdfString(pdfHex(p.data[p.pos+1 : p.pos+end]))
+		p.pos += end + 1
+		return s, nil
+	case c == '[':
+		p.pos++
+		var array []any
+		for {
+			p.skipSpace()
+			if p.peek() == ']' {
+				p.pos++
+				return array, nil
+			}
+			value, err := p.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+	case c == '(':
+		return p.literalString()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	}
+
+	token := p.token()
+	if len(token) == 0 {
+		return nil, fmt.Errorf("unexpected %q in PDF", p.data[p.pos])
+	}
+	return string(token), nil
+}
+
+// number parses a number, or an indirect reference "num gen R"
+func (p *pdfParser) number() (any, error) {
+	token := string(p.token())
+	n, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(token, ".+-") {
+		return n, nil
+	}
+
+	save := p.pos
+	p.skipSpace()
+	if gen := p.token(); len(gen) > 0 {
+		if _, err := strconv.Atoi(string(gen)); err == nil {
+			p.skipSpace()
+			if p.peek() == 'R' && (p.pos+1 >= len(p.data) || isPDFSpace(p.data[p.pos+1]) || isPDFDelimiter(p.data[p.pos+1])) {
+				p.pos++
+				return pdfRef(n), nil
+			}
+		}
+	}
+	p.pos = save
+	return n, nil
+}
+
+// literalString reads a parenthesised string and resolves its escapes
+func (p *pdfParser) literalString() (any, error) {
+	start := p.pos + 1
+	nesting := 0
+	for ; p.pos < len(p.data); p.pos++ {
+		switch p.data[p.pos] {
+		case '\\':
+			p.pos++
+		case '(':
+			nesting++
+		case ')':
+			nesting--
+			if nesting == 0 {
+				p.pos++
+				return pdfString(pdfUnescape(p.data[start : p.pos-1])), nil
+			}
+		}
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (p *pdfParser) token() []byte {
+	start := p.pos
+	for p.pos < len(p.data) && !isPDFSpace(p.data[p.pos]) && !isPDFDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	return p.data[start:p.pos]
+}
+
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == '%':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		case isPDFSpace(c):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *pdfParser) peek() byte {
+	if p.pos < len(p.data) {
+		return p.data[p.pos]
+	}
+	return 0
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// pdfHex decodes the digits of a hex string, ignoring whitespace. A missing
+// final digit is taken as 0.
+func pdfHex(digits []byte) []byte {
+	out := make([]byte, 0, len(digits)/2+1)
+	var b byte
+	half := false
+	for _, c := range digits {
+		var v byte
+		switch {
+		case c >= '0' && c <= '9':
+			v = c - '0'
+		case c >= 'a' && c <= 'f':
+			v = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			v = c - 'A' + 10
+		default:
+			continue
+		}
+		if half {
+			out = append(out, b<<4|v)
+		} else {
+			b = v
+		}
+		half = !half
+	}
+	if half {
+		out = append(out, b<<4)
+	}
+	return out
+}
+
+// pdfUnescape resolves the backslash escapes of a literal string
+func pdfUnescape(raw []byte) []byte {
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return raw
+	}
+	out := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' || i+1 == len(raw) {
+			out = append(out, raw[i])
+			continue
+		}
+		i++
+		switch c := raw[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '\r':
+			// A backslash at the end of a line continues the string
+			if i+1 < len(raw) && raw[i+1] == '\n' {
+				i++
+			}
+		case '\n':
+		default:
+			if c < '0' || c > '7' {
+				out = append(out, c)
+				continue
+			}
+			v := 0
+			for n := 0; n < 3 && i < len(raw) && raw[i] >= '0' && raw[i] <= '7'; n++ {
+				v = v*8 + int(raw[i]-'0')
+				i++
+			}
+			i--
+			out = append(out, byte(v))
+		}
+	}
+	return out
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// maxPreviewPixels bounds the images decoded for a preview
+	maxPreviewPixels = 64 << 20
+	// maxPDFPreviewBytes bounds the PDFs read into memory for a preview
+	maxPDFPreviewBytes = 64 << 20
+	// maxEmbeddedScanBytes is how far into audio and video files a cover
+	// image or poster frame is looked for
+	maxEmbeddedScanBytes = 16 << 20
+	// textPreviewBytes is how much of a text file the snippet preview shows
+	textPreviewBytes = 8 << 10
+
+	textPreviewColumns = 80
+	textPreviewLines   = 56
+	textPreviewMargin  = 16
+)
+
+var (
+	// errNoPreview means the file's type has no preview
+	errNoPreview = errors.New("no preview for this file type")
+	// errUnrenderable means the content could not be rendered; retrying will not help
+	errUnrenderable = errors.New("content cannot be rendered")
+)
+
+type previewKind int
+
+const (
+	previewNone previewKind = iota
+	previewImage
+	previewPDF
+	previewText
+	previewEmbedded
+)
+
+// textExtensions are source and config files browsers upload without a text/* type
+var textExtensions = map[string]bool{
+	".c": true, ".cc": true, ".cpp": true, ".cs": true, ".css": true, ".csv": true,
+	".go": true, ".h": true, ".hpp": true, ".html": true, ".ini": true, ".java": true,
+	".js": true, ".json": true, ".jsx": true, ".kt": true, ".log": true, ".lua": true,
+	".md": true, ".php": true, ".pl": true, ".py": true, ".rb": true, ".rs": true,
+	".sh": true, ".sql": true, ".swift": true, ".toml": true, ".ts": true, ".tsx": true,
+	".txt": true, ".xml": true, ".yaml": true, ".yml": true,
+}
+
+// textMimeTypes are application/* types whose content is readable text
+var textMimeTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/x-sh":       true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/sql":        true,
+	"application/toml":       true,
+	"image/svg+xml":          true,
+}
+
+// previewKindOf decides how a file is previewed from its MIME type, falling
+// back to its extension for types browsers do not know
+func previewKindOf(mimeType, name string) previewKind {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	ext := strings.ToLower(path.Ext(name))
+
+	switch {
+	case textMimeTypes[mimeType], strings.HasPrefix(mimeType, "text/"):
+		return previewText
+	case strings.HasPrefix(mimeType, "image/"):
+		return previewImage
+	case mimeType == "application/pdf", ext == ".pdf":
+		return previewPDF
+	case strings.HasPrefix(mimeType, "video/"), strings.HasPrefix(mimeType, "audio/"):
+		return previewEmbedded
+	case textExtensions[ext]:
+		return previewText
+	}
+	return previewNone
+}
+
+// renderPreview produces the full-size image previews of a file are scaled from.
+// Returns errNoPreview for types without a preview and wraps errUnrenderable
+// when the content cannot be decoded.
+func renderPreview(content io.ReadSeeker, mimeType, name string) (image.Image, error) {
+	switch previewKindOf(mimeType, name) {
+	case previewImage:
+		return renderImage(content)
+	case previewPDF:
+		return renderPDF(content)
+	case previewText:
+		return renderText(content)
+	case previewEmbedded:
+		return renderEmbedded(content)
+	}
+	return nil, errNoPreview
+}
+
+// renderImage decodes an image, checking its dimensions first so a small file
+// cannot claim an enormous canvas. Animated GIFs decode to their first frame.
+func renderImage(content io.ReadSeeker) (image.Image, error) {
+	config, _, err := image.DecodeConfig(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnrenderable, err)
+	}
+	if config.Width*config.Height > maxPreviewPixels {
+		return nil, fmt.Errorf("%w: image of %dx%d is too large", errUnrenderable, config.Width, config.Height)
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, err := imaging.Decode(content, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnrenderable, err)
+	}
+	return src, nil
+}
+
+// renderPDF renders the first page of a PDF. Drawing text and vector graphics
+// needs a full rasterizer, so the page is represented by the largest image it
+// draws, which for scanned documents is the page itself.
+func renderPDF(content io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(content, maxPDFPreviewBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxPDFPreviewBytes {
+		return nil, fmt.Errorf("%w: PDF is too large", errUnrenderable)
+	}
+
+	src, err := pdfFirstPageImage(data)
+	if errors.Is(err, errNoPreview) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnrenderable, err)
+	}
+	return src, nil
+}
+
+// renderText draws the first lines of a text file in a monospace font
+func renderText(content io.Reader) (image.Image, error) {
+	head := make([]byte, textPreviewBytes)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	// The read may have cut the last character in half
+	if n == textPreviewBytes {
+		for i := 1; i < utf8.UTFMax && !utf8.Valid(head); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+	if !utf8.Valid(head) || bytes.IndexByte(head, 0) >= 0 {
+		return nil, errNoPreview
+	}
+
+	face := basicfont.Face7x13
+	lineHeight := face.Metrics().Height.Ceil()
+	width := textPreviewColumns*face.Advance + 2*textPreviewMargin
+	height := textPreviewLines*lineHeight + 2*textPreviewMargin
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	drawer := &font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(color.RGBA{R: 0x20, G: 0x21, B: 0x24, A: 0xff}),
+		Face: face,
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(head))
+	for line := 0; line < textPreviewLines && scanner.Scan(); line++ {
+		drawer.Dot = fixed.P(textPreviewMargin, textPreviewMargin+line*lineHeight+face.Ascent)
+		drawer.DrawString(previewLine(scanner.Text()))
+	}
+	return canvas, nil
+}
+
+// previewLine expands tabs, replaces characters the font lacks and cuts the
+// line to the preview width
+func previewLine(line string) string {
+	var b strings.Builder
+	column := 0
+	for _, r := range line {
+		if column >= textPreviewColumns {
+			break
+		}
+		switch {
+		case r == '\t':
+			spaces := 4 - column%4
+			b.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+			continue
+		case r < 0x20 || r > 0x7e:
+			r = '?'
+		}
+		b.WriteRune(r)
+		column++
+	}
+	return b.String()
+}
+
+// renderEmbedded finds a picture stored inside an audio or video file: cover
+// art in MP3, M4A, FLAC and MP4 files, or the first frame of Motion JPEG video.
+// Other codecs cannot be decoded in pure Go and get no preview.
+func renderEmbedded(content io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(content, maxEmbeddedScanBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	signatures := [][]byte{{0xff, 0xd8, 0xff}, []byte("\x89PNG\r\n\x1a\n")}
+	for offset := 0; offset < len(data); {
+		next := -1
+		for _, signature := range signatures {
+			if i := bytes.Index(data[offset:], signature); i >= 0 && (next < 0 || offset+i < next) {
+				next = offset + i
+			}
+		}
+		if next < 0 {
+			break
+		}
+
+		picture, err := renderImage(bytes.NewReader(data[next:]))
+		// Tiny images are icons or false matches rather than artwork
+		if err == nil && picture.Bounds().Dx() >= 32 && picture.Bounds().Dy() >= 32 {
+			return picture, nil
+		}
+		offset = next + 1
+	}
+	return nil, errNoPreview
+}
+
+// encodePreview scales src to fit in a square of the given size, never
+// enlarging it, and encodes it as JPEG
+func encodePreview(src image.Image, pixels int) ([]byte, image.Rectangle, error) {
+	preview := src
+	if src.Bounds().Dx() > pixels || src.Bounds().Dy() > pixels {
+		preview = imaging.Fit(src, pixels, pixels, imaging.Lanczos)
+	}
+
+	// JPEG has no transparency, so flatten onto white
+	flat := imaging.New(preview.Bounds().Dx(), preview.Bounds().Dy(), color.White)
+	flat = imaging.Overlay(flat, preview, image.Point{}, 1)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, image.Rectangle{}, err
+	}
+	return buf.Bytes(), flat.Bounds(), nil
+}
+
+// PreviewPlaceholder draws the image served while a file's previews are being
+// generated: a grey square of the requested size labelled with the file type
+func PreviewPlaceholder(size PreviewSize, name string) ([]byte, error) {
+	pixels := size.Pixels()
+	canvas := imaging.New(pixels, pixels, color.RGBA{R: 0xf1, G: 0xf3, B: 0xf4, A: 0xff})
+
+	label := strings.ToUpper(strings.TrimPrefix(path.Ext(name), "."))
+	if len(label) > 4 || !isPrintableASCII(label) {
+		label = ""
+	}
+	if label != "" {
+		face := basicfont.Face7x13
+		text := image.NewNRGBA(image.Rect(0, 0, len(label)*face.Advance, face.Height))
+		drawer := &font.Drawer{
+			Dst:  text,
+			Src:  image.NewUniform(color.RGBA{R: 0x5f, G: 0x63, B: 0x68, A: 0xff}),
+			Face: face,
+			Dot:  fixed.P(0, face.Ascent),
+		}
+		drawer.DrawString(label)
+
+		// The bitmap font is tiny, so scale the label to a quarter of the width
+		scale := max(pixels/4/text.Bounds().Dx(), 1)
+		scaled := imaging.Resize(text, text.Bounds().Dx()*scale, text.Bounds().Dy()*scale, imaging.NearestNeighbor)
+		at := image.Pt((pixels-scaled.Bounds().Dx())/2, (pixels-scaled.Bounds().Dy())/2)
+		canvas = imaging.Overlay(canvas, scaled, at, 1)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isPrintableASCII(s string) bool {
+	for _, r := range s {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
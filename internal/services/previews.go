@@ -0,0 +1,285 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+const (
+	// previewJobKind is the job that renders a file's previews
+	previewJobKind = "preview.generate"
+	// previewJobTimeout bounds rendering one file
+	previewJobTimeout = 5 * time.Minute
+	// maxPreviewAttempts is how often a job is tried before it is failed
+	maxPreviewAttempts = 3
+)
+
+// PreviewSize names one of the sizes previews are generated in
+type PreviewSize string
+
+const (
+	PreviewSmall  PreviewSize = "small"
+	PreviewMedium PreviewSize = "medium"
+	PreviewLarge  PreviewSize = "large"
+)
+
+// previewSizes lists the sizes from smallest to largest
+var previewSizes = []PreviewSize{PreviewSmall, PreviewMedium, PreviewLarge}
+
+// ParsePreviewSize reads a size parameter; empty means small
+func ParsePreviewSize(s string) (PreviewSize, bool) {
+	if s == "" {
+		return PreviewSmall, true
+	}
+	for _, size := range previewSizes {
+		if PreviewSize(s) == size {
+			return size, true
+		}
+	}
+	return "", false
+}
+
+// Pixels is the longest side of a preview of this size
+func (s PreviewSize) Pixels() int {
+	switch s {
+	case PreviewMedium:
+		return 480
+	case PreviewLarge:
+		return 1024
+	}
+	return 200
+}
+
+// PreviewService generates thumbnails and previews in the background. Uploads
+// queue a preview.generate job per file; the job renders the content once and
+// stores a JPEG per size in the thumbnail backend.
+type PreviewService struct {
+	db      database.TxStarter
+	queries *database.Queries
+	storage *StorageService
+	jobs    *JobQueue
+}
+
+func NewPreviewService(db database.TxStarter, queries *database.Queries, storage *StorageService, jobs *JobQueue) *PreviewService {
+	s := &PreviewService{
+		db:      db,
+		queries: queries,
+		storage: storage,
+		jobs:    jobs,
+	}
+	jobs.Register(previewJobKind, s.generate, JobOptions{
+		MaxAttempts: maxPreviewAttempts,
+		Timeout:     previewJobTimeout,
+	})
+	return s
+}
+
+// previewJob is the payload of a preview.generate job
+type previewJob struct {
+	FileID  uuid.UUID `json:"file_id"`
+	Version int32     `json:"version"`
+}
+
+func previewJobKey(fileID pgtype.UUID) string {
+	return "preview:" + uuid.UUID(fileID.Bytes).String()
+}
+
+// previewJobParams queues preview generation for a version of a file. A job
+// already waiting for the file is updated to the new version.
+func previewJobParams(fileID pgtype.UUID, version int32) database.EnqueueJobParams {
+	payload, _ := json.Marshal(previewJob{FileID: fileID.Bytes, Version: version})
+	return database.EnqueueJobParams{
+		Kind:        previewJobKind,
+		Payload:     payload,
+		UniqueKey:   pgtype.Text{String: previewJobKey(fileID), Valid: true},
+		MaxAttempts: maxPreviewAttempts,
+		RunAfter:    pgtype.Timestamp{Time: time.Now(), Valid: true},
+	}
+}
+
+// Enqueue queues preview generation for a file's new content
+func (s *PreviewService) Enqueue(ctx context.Context, fileID pgtype.UUID, version int32) {
+	if _, err := s.queries.EnqueueJob(ctx, previewJobParams(fileID, version)); err != nil {
+		fmt.Printf("Warning: failed to queue preview of file %s: %v\n", fileID.Bytes, err)
+		return
+	}
+	s.jobs.notify()
+}
+
+// Thumbnail returns the key of the stored preview to serve for size: the
+// requested size, or the largest smaller one when the content is too small to
+// need it. pending is true while the file's previews are being generated; an
+// empty key otherwise means the file has no preview.
+func (s *PreviewService) Thumbnail(ctx context.Context, file database.File, size PreviewSize) (key string, pending bool, err error) {
+	pending, err = s.jobs.Pending(ctx, previewJobKey(file.ID))
+	if err != nil || pending {
+		return "", pending, err
+	}
+
+	previews, err := s.queries.GetFilePreviews(ctx, file.ID)
+	if err != nil {
+		return "", false, err
+	}
+	version := int32(1)
+	if file.Version.Valid {
+		version = file.Version.Int32
+	}
+	for _, preview := range previews {
+		// Left from earlier content when generating this version failed
+		if preview.Version != version {
+			continue
+		}
+		if key == "" || PreviewSize(preview.Size).Pixels() <= size.Pixels() {
+			key = preview.Path
+		}
+	}
+
+	// Thumbnails generated before the queue existed
+	if len(previews) == 0 && file.ThumbnailPath.Valid {
+		key = file.ThumbnailPath.String
+	}
+	return key, false, nil
+}
+
+// generate renders a file's content and replaces its previews. Content that
+// cannot be rendered fails the job at once; storage and database errors are
+// retried.
+func (s *PreviewService) generate(ctx context.Context, row database.Job) error {
+	var job previewJob
+	if err := json.Unmarshal(row.Payload, &job); err != nil {
+		return Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+	fileID := pgtype.UUID{Bytes: job.FileID, Valid: true}
+
+	file, err := s.queries.GetFileByIDAnyStatus(ctx, fileID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Deleted meanwhile
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get file: %w", err)
+	}
+	if file.BrokenAt.Valid {
+		return Permanent(fmt.Errorf("%w: content is missing from storage", errUnrenderable))
+	}
+
+	content, err := s.storage.GetFile(ctx, file.StoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	src, err := renderPreview(content, file.MimeType, file.Name)
+	content.Close()
+	if errors.Is(err, errUnrenderable) {
+		return Permanent(err)
+	}
+	if err != nil && !errors.Is(err, errNoPreview) {
+		return err
+	}
+
+	// One JPEG per size, stopping at the first size that holds the whole image
+	var previews []database.CreateFilePreviewParams
+	if src != nil {
+		for _, size := range previewSizes {
+			encoded, bounds, err := encodePreview(src, size.Pixels())
+			if err != nil {
+				return Permanent(fmt.Errorf("%w: %v", errUnrenderable, err))
+			}
+
+			key := fmt.Sprintf("%s_v%d_%s.jpg", uuid.UUID(file.ID.Bytes), job.Version, size)
+			if err := s.storage.SaveThumbnail(ctx, key, bytes.NewReader(encoded)); err != nil {
+				s.deletePreviews(ctx, previews)
+				return err
+			}
+			previews = append(previews, database.CreateFilePreviewParams{
+				FileID:  file.ID,
+				Size:    string(size),
+				Path:    key,
+				Width:   int32(bounds.Dx()),
+				Height:  int32(bounds.Dy()),
+				Version: job.Version,
+			})
+
+			if bounds.Dx() < size.Pixels() && bounds.Dy() < size.Pixels() {
+				break
+			}
+		}
+	}
+
+	var replaced []database.FilePreview
+	stale := false
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		var thumbnail pgtype.Text
+		if len(previews) > 0 {
+			thumbnail = pgtype.Text{String: previews[0].Path, Valid: true}
+		}
+		updated, err := q.SetFilePreview(ctx, database.SetFilePreviewParams{
+			ID:               file.ID,
+			ThumbnailPath:    thumbnail,
+			PreviewAvailable: pgtype.Bool{Bool: len(previews) > 0, Valid: true},
+			Version:          job.Version,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update file: %w", err)
+		}
+		// A newer version was uploaded meanwhile and has been queued
+		if updated == 0 {
+			stale = true
+			return nil
+		}
+
+		replaced, err = q.GetFilePreviews(ctx, file.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get previews: %w", err)
+		}
+		if err := q.DeleteFilePreviews(ctx, file.ID); err != nil {
+			return fmt.Errorf("failed to delete previews: %w", err)
+		}
+		for _, preview := range previews {
+			if err := q.CreateFilePreview(ctx, preview); err != nil {
+				return fmt.Errorf("failed to record preview: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil || stale {
+		s.deletePreviews(ctx, previews)
+		return err
+	}
+
+	// Remove what the new previews replaced, including a thumbnail that
+	// predates the queue
+	current := make(map[string]bool, len(previews))
+	for _, preview := range previews {
+		current[preview.Path] = true
+	}
+	for _, preview := range replaced {
+		if !current[preview.Path] {
+			s.deleteThumbnail(ctx, preview.Path)
+		}
+	}
+	if file.ThumbnailPath.Valid && file.ThumbnailPath.String != "" && !current[file.ThumbnailPath.String] {
+		s.deleteThumbnail(ctx, file.ThumbnailPath.String)
+	}
+	return nil
+}
+
+func (s *PreviewService) deletePreviews(ctx context.Context, previews []database.CreateFilePreviewParams) {
+	for _, preview := range previews {
+		s.deleteThumbnail(ctx, preview.Path)
+	}
+}
+
+func (s *PreviewService) deleteThumbnail(ctx context.Context, key string) {
+	if err := s.storage.DeleteThumbnail(ctx, key); err != nil {
+		fmt.Printf("Warning: failed to delete thumbnail: %s, error: %v\n", key, err)
+	}
+}
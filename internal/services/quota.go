@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// reservationTTL bounds how long a direct upload's reservation outlives a
+// server that died before releasing it
+const reservationTTL = time.Hour
+
+// QuotaError is returned when an upload does not fit in the owner's quota
+type QuotaError struct {
+	Requested int64
+	Used      int64
+	Reserved  int64
+	Limit     int64
+}
+
+func (e *QuotaError) Error() string {
+	if e.TooLarge() {
+		return fmt.Sprintf("file of %d bytes exceeds the storage limit of %d bytes", e.Requested, e.Limit)
+	}
+	return fmt.Sprintf("storage quota exceeded: %d bytes requested, %d available", e.Requested, e.Available())
+}
+
+// TooLarge reports whether the upload could not fit even in an empty drive
+func (e *QuotaError) TooLarge() bool {
+	return e.Requested > e.Limit
+}
+
+// Available is the space left once stored content and reservations are counted
+func (e *QuotaError) Available() int64 {
+	return max(e.Limit-e.Used-e.Reserved, 0)
+}
+
+// QuotaService holds storage_limit against uploads. Space is reserved before
+// content is written; the blob store charges storage_used for what is actually
+// stored, after which the reservation is released.
+type QuotaService struct {
+	db      database.TxStarter
+	queries *database.Queries
+}
+
+func NewQuotaService(db database.TxStarter, queries *database.Queries) *QuotaService {
+	return &QuotaService{
+		db:      db,
+		queries: queries,
+	}
+}
+
+// Reserve holds bytes of ownerID's quota. A reservation tied to an upload
+// session lasts as long as the session; others expire after reservationTTL.
+// Returns a *QuotaError when the space is not available.
+func (s *QuotaService) Reserve(ctx context.Context, ownerID pgtype.UUID, bytes int64, uploadSessionID pgtype.UUID, expiresAt time.Time) (database.StorageReservation, error) {
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(reservationTTL)
+	}
+
+	var reservation database.StorageReservation
+	err := database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		// Concurrent reservations for the owner wait here, so each one sees
+		// the reservations committed before it
+		if _, err := q.LockUserQuota(ctx, ownerID); err != nil {
+			return fmt.Errorf("failed to lock storage quota: %w", err)
+		}
+
+		usage, err := q.GetQuotaUsage(ctx, ownerID)
+		if err != nil {
+			return fmt.Errorf("failed to get storage usage: %w", err)
+		}
+		if err := checkQuota(usage, bytes); err != nil {
+			return err
+		}
+
+		reservation, err = q.CreateStorageReservation(ctx, database.CreateStorageReservationParams{
+			UserID:          ownerID,
+			UploadSessionID: uploadSessionID,
+			Bytes:           bytes,
+			ExpiresAt:       pgtype.Timestamp{Time: expiresAt, Valid: true},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to reserve storage: %w", err)
+		}
+		return nil
+	})
+	return reservation, err
+}
+
+// Release gives a reservation's space back
+func (s *QuotaService) Release(ctx context.Context, reservationID pgtype.UUID) {
+	if err := s.queries.DeleteStorageReservation(ctx, reservationID); err != nil {
+		fmt.Printf("Warning: failed to release storage reservation %s: %v\n", reservationID.Bytes, err)
+	}
+}
+
+// ReleaseUpload gives back the space held by a resumable upload session
+func (s *QuotaService) ReleaseUpload(ctx context.Context, uploadSessionID pgtype.UUID) {
+	if err := s.queries.DeleteUploadReservation(ctx, uploadSessionID); err != nil {
+		fmt.Printf("Warning: failed to release storage reservation of upload %s: %v\n", uploadSessionID.Bytes, err)
+	}
+}
+
+func checkQuota(usage database.GetQuotaUsageRow, bytes int64) error {
+	if usage.StorageUsed+usage.StorageReserved+bytes <= usage.StorageLimit {
+		return nil
+	}
+	return &QuotaError{
+		Requested: bytes,
+		Used:      usage.StorageUsed,
+		Reserved:  usage.StorageReserved,
+		Limit:     usage.StorageLimit,
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// retentionBatchSize is how many prunable versions are loaded per query
+const retentionBatchSize = 500
+
+// RetentionService prunes file versions that fall outside their retention policy
+type RetentionService struct {
+	queries     *database.Queries
+	blobService *BlobService
+}
+
+func NewRetentionService(queries *database.Queries, blobService *BlobService) *RetentionService {
+	return &RetentionService{
+		queries:     queries,
+		blobService: blobService,
+	}
+}
+
+// PreviewPrune lists the versions the next pruning run would delete.
+// ownerID and fileID optionally narrow the result.
+func (s *RetentionService) PreviewPrune(ctx context.Context, ownerID, fileID pgtype.UUID, limit int32) ([]database.GetPrunableVersionsRow, error) {
+	return s.queries.GetPrunableVersions(ctx, database.GetPrunableVersionsParams{
+		OwnerID:     ownerID,
+		FileID:      fileID,
+		MaxVersions: limit,
+	})
+}
+
+// PruneVersions deletes every version outside its retention policy, releasing
+// its content and refunding the owner's storage
+func (s *RetentionService) PruneVersions(ctx context.Context) (int, error) {
+	pruned := 0
+
+	for {
+		versions, err := s.PreviewPrune(ctx, pgtype.UUID{}, pgtype.UUID{}, retentionBatchSize)
+		if err != nil {
+			return pruned, fmt.Errorf("failed to get prunable versions: %w", err)
+		}
+
+		batchPruned := 0
+		for _, version := range versions {
+			deleted, err := s.blobService.ReleaseVersion(ctx, version.ID, version.OwnerID)
+			if err != nil {
+				fmt.Printf("Warning: failed to prune version %d of file %s: %v\n", version.VersionNumber, version.FileID.Bytes, err)
+				continue
+			}
+			if deleted {
+				batchPruned++
+			}
+		}
+		pruned += batchPruned
+
+		// Stop on a short batch, or when nothing in a batch could be pruned
+		if len(versions) < retentionBatchSize || batchPruned == 0 {
+			return pruned, nil
+		}
+	}
+}
+
+// RegisterJobs schedules version pruning daily
+func (s *RetentionService) RegisterJobs(jobs *JobQueue) error {
+	jobs.Register("versions.prune", func(ctx context.Context, job database.Job) error {
+		pruned, err := s.PruneVersions(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Version pruning completed: %d versions deleted\n", pruned)
+		return nil
+	}, JobOptions{Timeout: time.Hour})
+
+	return jobs.Schedule("versions.prune", "@daily", "versions.prune", nil)
+}
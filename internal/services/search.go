@@ -0,0 +1,468 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// Search sort orders
+const (
+	SortRelevance = "relevance"
+	SortName      = "name"
+	SortSize      = "size"
+	SortModified  = "modified"
+)
+
+// snippetOptions has ts_headline mark matches with control characters, which
+// extracted text never contains, so the excerpt can be escaped before the
+// marks become HTML
+const snippetOptions = "StartSel=\"\x02\", StopSel=\"\x03\", MaxFragments=2, MinWords=8, MaxWords=24, FragmentDelimiter=\" … \""
+
+// sortKeys are the expression each sort orders files and folders by, and
+// the type a cursor's key is cast back to
+var sortKeys = map[string]struct {
+	file, folder, cast string
+}{
+	SortRelevance: {"rank", "rank", "real"},
+	SortName:      {"lower(f.name)", "lower(fo.name)", "text"},
+	SortSize:      {"f.size", "0::bigint", "bigint"},
+	SortModified:  {"COALESCE(f.updated_at, f.created_at)", "COALESCE(fo.updated_at, fo.created_at)", "timestamp"},
+}
+
+// SearchOptions selects the order and page of results
+type SearchOptions struct {
+	// Sort is one of the Sort constants; empty sorts by relevance when the
+	// query has text and by modification time otherwise
+	Sort string
+	// Descending defaults to true except when sorting by name
+	Descending *bool
+	Limit      int32
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+// SearchItem is a file or folder in search results
+type SearchItem struct {
+	Type   string           `json:"type"`
+	File   *database.File   `json:"file,omitempty"`
+	Folder *database.Folder `json:"folder,omitempty"`
+	Rank   float32          `json:"rank,omitempty"`
+	// Snippet is an HTML-escaped excerpt of a file's text with the matched
+	// words in <mark>, present when the text matched
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// SearchPage is one page of results
+type SearchPage struct {
+	Items []SearchItem `json:"items"`
+	// NextCursor fetches the following page; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// searchCursor is the position after the last item of a page
+type searchCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Key        string `json:"k"`
+	Type       string `json:"t"`
+	ID         string `json:"i"`
+}
+
+// SearchService runs structured searches over the files and folders a user
+// can see: their own and those shared with them, directly or through a
+// folder. Results are a single stream of files and folders paged by keyset,
+// so paging stays stable while items are added.
+type SearchService struct {
+	db      database.DBTX
+	queries *database.Queries
+}
+
+func NewSearchService(db database.DBTX, queries *database.Queries) *SearchService {
+	return &SearchService{db: db, queries: queries}
+}
+
+// Search returns a page of the items matching q
+func (s *SearchService) Search(ctx context.Context, userID pgtype.UUID, q SearchQuery, opts SearchOptions) (SearchPage, error) {
+	sort := opts.Sort
+	if sort == "" {
+		sort = SortModified
+		if q.Text != "" {
+			sort = SortRelevance
+		}
+	}
+	key, ok := sortKeys[sort]
+	if !ok {
+		return SearchPage{}, fmt.Errorf("%w: sort must be relevance, name, size or modified", ErrInvalidSearch)
+	}
+	descending := sort != SortName
+	if opts.Descending != nil {
+		descending = *opts.Descending
+	}
+
+	b := &searchBuilder{q: q, user: userID}
+	var branches []string
+	if b.includesFiles() {
+		branches = append(branches, b.fileBranch(key.file))
+	}
+	if b.includesFolders() {
+		branches = append(branches, b.folderBranch(key.folder))
+	}
+	page := SearchPage{Items: []SearchItem{}}
+	if len(branches) == 0 {
+		return page, nil
+	}
+
+	direction, comparison := "ASC", ">"
+	if descending {
+		direction, comparison = "DESC", "<"
+	}
+	// The key is returned as text for the cursor under a name of its own, so
+	// ORDER BY and the cursor comparison both use the typed sort_key
+	sql := b.with() + "SELECT kind, id, rank, sort_key::text AS cursor_key FROM (" + strings.Join(branches, " UNION ALL ") + ") items"
+	if opts.Cursor != "" {
+		cursor, err := decodeSearchCursor(opts.Cursor)
+		if err != nil || cursor.Sort != sort || cursor.Descending != descending {
+			return SearchPage{}, fmt.Errorf("%w: cursor does not belong to this search", ErrInvalidSearch)
+		}
+		id, err := uuid.Parse(cursor.ID)
+		if err != nil {
+			return SearchPage{}, fmt.Errorf("%w: cursor does not belong to this search", ErrInvalidSearch)
+		}
+		sql += fmt.Sprintf(" WHERE (sort_key, kind, id) %s (%s::%s, %s, %s)",
+			comparison, b.arg(cursor.Key), key.cast, b.arg(cursor.Type), b.arg(pgtype.UUID{Bytes: id, Valid: true}))
+	}
+	sql += fmt.Sprintf(" ORDER BY sort_key %[1]s, kind %[1]s, id %[1]s LIMIT %s", direction, b.arg(opts.Limit+1))
+
+	rows, err := s.db.Query(ctx, sql, b.args...)
+	if err != nil {
+		return SearchPage{}, fmt.Errorf("failed to search: %w", err)
+	}
+	type hit struct {
+		kind    string
+		id      pgtype.UUID
+		rank    float32
+		sortKey string
+	}
+	var hits []hit
+	for rows.Next() {
+		var h hit
+		if err := rows.Scan(&h.kind, &h.id, &h.rank, &h.sortKey); err != nil {
+			rows.Close()
+			return SearchPage{}, fmt.Errorf("failed to read search results: %w", err)
+		}
+		hits = append(hits, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return SearchPage{}, fmt.Errorf("failed to search: %w", err)
+	}
+
+	if len(hits) > int(opts.Limit) {
+		hits = hits[:opts.Limit]
+		last := hits[len(hits)-1]
+		page.NextCursor = encodeSearchCursor(searchCursor{
+			Sort:       sort,
+			Descending: descending,
+			Key:        last.sortKey,
+			Type:       last.kind,
+			ID:         uuid.UUID(last.id.Bytes).String(),
+		})
+	}
+
+	// Load the rows of the page, and excerpts of the files whose text matched
+	var fileIDs, folderIDs []pgtype.UUID
+	for _, h := range hits {
+		if h.kind == "file" {
+			fileIDs = append(fileIDs, h.id)
+		} else {
+			folderIDs = append(folderIDs, h.id)
+		}
+	}
+	files := make(map[pgtype.UUID]database.File, len(fileIDs))
+	if len(fileIDs) > 0 {
+		rows, err := s.queries.GetFilesByIDs(ctx, fileIDs)
+		if err != nil {
+			return SearchPage{}, fmt.Errorf("failed to get files: %w", err)
+		}
+		for _, file := range rows {
+			files[file.ID] = file
+		}
+	}
+	folders := make(map[pgtype.UUID]database.Folder, len(folderIDs))
+	if len(folderIDs) > 0 {
+		rows, err := s.queries.GetFoldersByIDs(ctx, folderIDs)
+		if err != nil {
+			return SearchPage{}, fmt.Errorf("failed to get folders: %w", err)
+		}
+		for _, folder := range rows {
+			folders[folder.ID] = folder
+		}
+	}
+	snippets := make(map[pgtype.UUID]string)
+	if q.Text != "" && len(fileIDs) > 0 {
+		rows, err := s.queries.GetContentSnippets(ctx, database.GetContentSnippetsParams{
+			Query:   q.Text,
+			Options: snippetOptions,
+			FileIds: fileIDs,
+		})
+		if err != nil {
+			return SearchPage{}, fmt.Errorf("failed to get snippets: %w", err)
+		}
+		for _, row := range rows {
+			snippets[row.FileID] = highlightSnippet(row.Snippet)
+		}
+	}
+
+	for _, h := range hits {
+		item := SearchItem{Type: h.kind, Rank: h.rank}
+		if h.kind == "file" {
+			file, ok := files[h.id]
+			if !ok {
+				// Deleted since the search ran
+				continue
+			}
+			item.File = &file
+			item.Snippet = snippets[h.id]
+		} else {
+			folder, ok := folders[h.id]
+			if !ok {
+				continue
+			}
+			item.Folder = &folder
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page, nil
+}
+
+// highlightSnippet escapes a ts_headline excerpt and wraps its matches in <mark>
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(strings.Join(strings.Fields(snippet), " "))
+	return strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(snippet)
+}
+
+func encodeSearchCursor(c searchCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(s string) (searchCursor, error) {
+	var c searchCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// searchBuilder compiles a SearchQuery into SQL. Every value is passed as a
+// parameter; only fixed fragments are written into the statement.
+type searchBuilder struct {
+	q    SearchQuery
+	user pgtype.UUID
+	args []any
+	me   string
+	// sharedFolders is set once a branch needs the folders shared with the user
+	sharedFolders bool
+}
+
+func (b *searchBuilder) arg(value any) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *searchBuilder) userArg() string {
+	if b.me == "" {
+		b.me = b.arg(b.user)
+	}
+	return b.me
+}
+
+// with declares the folders shared with the user and everything below them
+func (b *searchBuilder) with() string {
+	if !b.sharedFolders {
+		return ""
+	}
+	return `WITH RECURSIVE shared_folders (id) AS (
+		SELECT p.item_id FROM permissions p WHERE p.item_type = 'folder' AND p.user_id = ` + b.me + `
+		UNION
+		SELECT child.id FROM folders child JOIN shared_folders s ON child.parent_folder_id = s.id
+	) `
+}
+
+func (b *searchBuilder) includesFiles() bool {
+	if len(b.q.Types) == 0 {
+		return true
+	}
+	for _, t := range b.q.Types {
+		if t != "folder" {
+			return true
+		}
+	}
+	return false
+}
+
+// includesFolders is false when a condition only files can meet is present
+func (b *searchBuilder) includesFolders() bool {
+	if b.q.MinSize >= 0 || b.q.MaxSize >= 0 {
+		return false
+	}
+	if len(b.q.Types) == 0 {
+		return true
+	}
+	for _, t := range b.q.Types {
+		if t == "folder" {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *searchBuilder) fileBranch(sortKey string) string {
+	q := b.q
+	from := "files f"
+	rank := "0::real"
+	var where []string
+
+	if q.Text != "" {
+		tsq := b.arg(q.Text)
+		from += " CROSS JOIN websearch_to_tsquery('english', " + tsq + ") AS tsq" +
+			" LEFT JOIN file_contents c ON c.file_id = f.id AND c.version = COALESCE(f.version, 1)"
+		rank = "(ts_rank(to_tsvector('english', f.name), tsq) * 2 + COALESCE(ts_rank(c.search_vector, tsq), 0))::real"
+		where = append(where, "(to_tsvector('english', f.name) @@ tsq OR c.search_vector @@ tsq)")
+	}
+	where = append(where, b.common("f", "file")...)
+
+	var types []string
+	for _, t := range q.Types {
+		switch t {
+		case "folder":
+		case "file":
+			types = append(types, "TRUE")
+		default:
+			for _, prefix := range searchTypes[t] {
+				types = append(types, "f.mime_type LIKE "+b.arg(escapeLike(prefix)+"%"))
+			}
+		}
+	}
+	if len(types) > 0 {
+		where = append(where, "("+strings.Join(types, " OR ")+")")
+	}
+	if q.MinSize >= 0 {
+		where = append(where, "f.size >= "+b.arg(q.MinSize))
+	}
+	if q.MaxSize >= 0 {
+		where = append(where, "f.size <= "+b.arg(q.MaxSize))
+	}
+
+	if sortKey == "rank" {
+		sortKey = rank
+	}
+	return fmt.Sprintf("SELECT 'file'::text AS kind, f.id, %s AS rank, %s AS sort_key FROM %s WHERE %s",
+		rank, sortKey, from, strings.Join(where, " AND "))
+}
+
+func (b *searchBuilder) folderBranch(sortKey string) string {
+	q := b.q
+	from := "folders fo"
+	rank := "0::real"
+	where := []string{"fo.is_root IS NOT TRUE"}
+
+	if q.Text != "" {
+		tsq := b.arg(q.Text)
+		from += " CROSS JOIN websearch_to_tsquery('english', " + tsq + ") AS tsq"
+		rank = "(ts_rank(to_tsvector('english', fo.name), tsq) * 2)::real"
+		where = append(where, "to_tsvector('english', fo.name) @@ tsq")
+	}
+	where = append(where, b.common("fo", "folder")...)
+
+	if sortKey == "rank" {
+		sortKey = rank
+	}
+	return fmt.Sprintf("SELECT 'folder'::text AS kind, fo.id, %s AS rank, %s AS sort_key FROM %s WHERE %s",
+		rank, sortKey, from, strings.Join(where, " AND "))
+}
+
+// common builds the conditions files and folders share. t is the table
+// alias and itemType the item_type of its rows.
+func (b *searchBuilder) common(t, itemType string) []string {
+	q := b.q
+	me := b.userArg()
+	var where []string
+
+	// Items shared with the user directly or through a folder above them
+	sharedWithMe := fmt.Sprintf(`(EXISTS (SELECT 1 FROM permissions p WHERE p.item_type = '%[2]s' AND p.item_id = %[1]s.id AND p.user_id = %[3]s)
+		OR %[1]s.parent_folder_id IN (SELECT id FROM shared_folders))`, t, itemType, me)
+	switch q.Owner {
+	case "me":
+		where = append(where, t+".owner_id = "+me)
+	case "":
+		b.sharedFolders = true
+		where = append(where, fmt.Sprintf("(%s.owner_id = %s OR %s)", t, me, sharedWithMe))
+	case "notme":
+		b.sharedFolders = true
+		where = append(where, fmt.Sprintf("%s.owner_id <> %s AND %s", t, me, sharedWithMe))
+	default:
+		b.sharedFolders = true
+		where = append(where,
+			fmt.Sprintf("%s.owner_id = (SELECT u.id FROM users u WHERE lower(u.email) = lower(%s))", t, b.arg(q.Owner)),
+			fmt.Sprintf("(%s.owner_id = %s OR %s)", t, me, sharedWithMe))
+	}
+
+	if q.Status != "all" {
+		where = append(where, fmt.Sprintf("%s.status = %s::file_status", t, b.arg(q.Status)))
+	}
+	for _, name := range q.Names {
+		where = append(where, fmt.Sprintf(`%s.name ILIKE %s ESCAPE '\'`, t, b.arg("%"+escapeLike(name)+"%")))
+	}
+	if q.Folder != nil {
+		where = append(where, fmt.Sprintf("%s.parent_folder_id = %s", t, b.arg(pgtype.UUID{Bytes: *q.Folder, Valid: true})))
+	}
+	if q.InRoot {
+		where = append(where, fmt.Sprintf("%s.parent_folder_id IS NULL AND %s.owner_id = %s", t, t, me))
+	}
+	if q.Starred != nil {
+		where = append(where, fmt.Sprintf("%s.is_starred = %s", t, b.arg(*q.Starred)))
+	}
+	if q.Shared != nil {
+		shared := fmt.Sprintf(`(EXISTS (SELECT 1 FROM permissions p WHERE p.item_type = '%[2]s' AND p.item_id = %[1]s.id AND p.user_id <> %[3]s)
+			OR EXISTS (SELECT 1 FROM shares s WHERE s.item_type = '%[2]s' AND s.item_id = %[1]s.id AND s.is_active IS TRUE
+				AND (s.expires_at IS NULL OR s.expires_at > NOW())))`, t, itemType, me)
+		if !*q.Shared {
+			shared = "NOT " + shared
+		}
+		where = append(where, t+".owner_id = "+me, shared)
+	}
+	if !q.ModifiedFrom.IsZero() {
+		where = append(where, fmt.Sprintf("%s.updated_at >= %s", t, b.arg(timestamp(q.ModifiedFrom))))
+	}
+	if !q.ModifiedTo.IsZero() {
+		where = append(where, fmt.Sprintf("%s.updated_at < %s", t, b.arg(timestamp(q.ModifiedTo))))
+	}
+	if !q.CreatedFrom.IsZero() {
+		where = append(where, fmt.Sprintf("%s.created_at >= %s", t, b.arg(timestamp(q.CreatedFrom))))
+	}
+	if !q.CreatedTo.IsZero() {
+		where = append(where, fmt.Sprintf("%s.created_at < %s", t, b.arg(timestamp(q.CreatedTo))))
+	}
+	return where
+}
+
+func timestamp(t time.Time) pgtype.Timestamp {
+	return pgtype.Timestamp{Time: t, Valid: true}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
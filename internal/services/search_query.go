@@ -0,0 +1,383 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidSearch is wrapped by errors describing a malformed search
+var ErrInvalidSearch = errors.New("invalid search")
+
+// SearchQuery is a parsed search. Every condition must hold, except that
+// repeated type: operators are alternatives.
+type SearchQuery struct {
+	// Text is matched against names and document text, in the syntax of
+	// websearch_to_tsquery: "quoted phrases", or, -excluded
+	Text string
+	// Names must each appear in the item's name, ignoring case
+	Names []string
+	// Types are file types such as pdf or image, or folder or file
+	Types []string
+	// Owner is "me", "notme" or an owner's email; empty means anyone
+	Owner string
+	// Folder restricts the search to the direct children of a folder;
+	// InRoot to items at the top level of the caller's drive
+	Folder *uuid.UUID
+	InRoot bool
+	// Status is "active", "trashed" or "all"
+	Status  string
+	Starred *bool
+	// Shared selects items the caller owns that are, or are not, shared
+	// with other users or through a link
+	Shared *bool
+	// Modified and Created are [From, To) ranges; zero bounds are open
+	ModifiedFrom, ModifiedTo time.Time
+	CreatedFrom, CreatedTo   time.Time
+	// MinSize and MaxSize bound file sizes inclusively; -1 is unbounded
+	MinSize, MaxSize int64
+}
+
+// NewSearchQuery returns a query matching every active item
+func NewSearchQuery() SearchQuery {
+	return SearchQuery{Status: "active", MinSize: -1, MaxSize: -1}
+}
+
+// searchTypes are the values of type:, with the MIME type prefixes of files
+var searchTypes = map[string][]string{
+	"folder":       nil,
+	"file":         nil,
+	"pdf":          {"application/pdf"},
+	"image":        {"image/"},
+	"video":        {"video/"},
+	"audio":        {"audio/"},
+	"text":         {"text/"},
+	"document":     {"application/vnd.openxmlformats-officedocument.wordprocessingml", "application/msword", "application/vnd.oasis.opendocument.text"},
+	"spreadsheet":  {"application/vnd.openxmlformats-officedocument.spreadsheetml", "application/vnd.ms-excel", "application/vnd.oasis.opendocument.spreadsheet", "text/csv"},
+	"presentation": {"application/vnd.openxmlformats-officedocument.presentationml", "application/vnd.ms-powerpoint", "application/vnd.oasis.opendocument.presentation"},
+	"archive":      {"application/zip", "application/x-tar", "application/gzip", "application/x-7z-compressed", "application/vnd.rar"},
+}
+
+// ParseSearchQuery parses a search such as
+//
+//	type:pdf owner:me name:"q3 report" modified:>2025-01-01 size:>10mb in:<folder> is:starred shared:yes
+//
+// Words and "quoted phrases" that are not operators are matched as text.
+// Words with an unknown prefix, such as a URL, are text too.
+func ParseSearchQuery(input string) (SearchQuery, error) {
+	q := NewSearchQuery()
+	var text []string
+
+	for _, token := range splitSearchQuery(input) {
+		key, value, isOperator := strings.Cut(token, ":")
+		key = strings.ToLower(key)
+		if !isOperator || !isSearchOperator(key) {
+			text = append(text, token)
+			continue
+		}
+		value = unquoteSearchValue(value)
+		if value == "" {
+			return SearchQuery{}, fmt.Errorf("%w: %s: needs a value", ErrInvalidSearch, key)
+		}
+
+		if err := q.apply(key, value); err != nil {
+			return SearchQuery{}, err
+		}
+	}
+
+	q.Text = strings.Join(text, " ")
+	return q, nil
+}
+
+func isSearchOperator(key string) bool {
+	switch key {
+	case "type", "owner", "name", "in", "is", "shared", "modified", "created", "size":
+		return true
+	}
+	return false
+}
+
+// apply adds the condition of one operator
+func (q *SearchQuery) apply(key, value string) error {
+	lower := strings.ToLower(value)
+	switch key {
+	case "type":
+		if _, ok := searchTypes[lower]; !ok {
+			return fmt.Errorf("%w: unknown type %q", ErrInvalidSearch, value)
+		}
+		q.Types = append(q.Types, lower)
+	case "owner":
+		q.Owner = lower
+		if lower == "anyone" {
+			q.Owner = ""
+		}
+	case "name":
+		q.Names = append(q.Names, value)
+	case "in":
+		if lower == "root" {
+			q.InRoot = true
+			return nil
+		}
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return fmt.Errorf("%w: in: needs a folder ID or root", ErrInvalidSearch)
+		}
+		q.Folder = &id
+	case "is":
+		yes, no := true, false
+		switch lower {
+		case "starred":
+			q.Starred = &yes
+		case "unstarred":
+			q.Starred = &no
+		case "trashed":
+			q.Status = "trashed"
+		case "active":
+			q.Status = "active"
+		default:
+			return fmt.Errorf("%w: is: must be starred, unstarred, trashed or active", ErrInvalidSearch)
+		}
+	case "shared":
+		switch lower {
+		case "yes", "true":
+			shared := true
+			q.Shared = &shared
+		case "no", "false":
+			shared := false
+			q.Shared = &shared
+		default:
+			return fmt.Errorf("%w: shared: must be yes or no", ErrInvalidSearch)
+		}
+	case "modified", "created":
+		from, to, err := parseDateCondition(value, time.Now())
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidSearch, key, err)
+		}
+		if key == "modified" {
+			q.NarrowModified(from, to)
+		} else {
+			q.CreatedFrom, q.CreatedTo = narrowRange(q.CreatedFrom, q.CreatedTo, from, to)
+		}
+	case "size":
+		lo, hi, err := parseSizeCondition(lower)
+		if err != nil {
+			return fmt.Errorf("%w: size: %v", ErrInvalidSearch, err)
+		}
+		if lo >= 0 && (q.MinSize < 0 || lo > q.MinSize) {
+			q.MinSize = lo
+		}
+		if hi >= 0 && (q.MaxSize < 0 || hi < q.MaxSize) {
+			q.MaxSize = hi
+		}
+	}
+	return nil
+}
+
+// NarrowModified intersects the modified range with [from, to)
+func (q *SearchQuery) NarrowModified(from, to time.Time) {
+	q.ModifiedFrom, q.ModifiedTo = narrowRange(q.ModifiedFrom, q.ModifiedTo, from, to)
+}
+
+func narrowRange(from, to, newFrom, newTo time.Time) (time.Time, time.Time) {
+	if !newFrom.IsZero() && (from.IsZero() || newFrom.After(from)) {
+		from = newFrom
+	}
+	if !newTo.IsZero() && (to.IsZero() || newTo.Before(to)) {
+		to = newTo
+	}
+	return from, to
+}
+
+// splitSearchQuery splits on spaces outside double quotes, keeping the quotes
+func splitSearchQuery(input string) []string {
+	var tokens []string
+	var token strings.Builder
+	quoted := false
+	for _, r := range input {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			token.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(r)
+		}
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens
+}
+
+func unquoteSearchValue(value string) string {
+	return strings.TrimSpace(strings.ReplaceAll(value, `"`, ""))
+}
+
+// searchDateLayouts are the date formats conditions accept
+var searchDateLayouts = []string{"2006-01-02", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// ParseSearchDate reads a date or timestamp. A date alone is its midnight in
+// the server's time zone.
+func ParseSearchDate(value string) (t time.Time, dateOnly bool, err error) {
+	for i, layout := range searchDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, i == 0, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("%q is not a date (use YYYY-MM-DD)", value)
+}
+
+// parseDateCondition reads >date, >=date, <date, <=date, date, from..to,
+// today, yesterday or a number of days back such as 7d, into a [from, to)
+// range. A date alone covers its whole day.
+func parseDateCondition(value string, now time.Time) (from, to time.Time, err error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	keyword := strings.ToLower(value)
+	switch {
+	case keyword == "today":
+		return today, time.Time{}, nil
+	case keyword == "yesterday":
+		return today.AddDate(0, 0, -1), today, nil
+	case strings.HasSuffix(keyword, "d") && isDigits(strings.TrimSuffix(keyword, "d")):
+		days, err := strconv.Atoi(strings.TrimSuffix(keyword, "d"))
+		if err != nil || days > 36500 {
+			return time.Time{}, time.Time{}, fmt.Errorf("%q is too many days", value)
+		}
+		return today.AddDate(0, 0, -days), time.Time{}, nil
+	}
+
+	if start, end, isRange := strings.Cut(value, ".."); isRange {
+		if start != "" {
+			if from, _, err = dayBounds(start); err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+		}
+		if end != "" {
+			if _, to, err = dayBounds(end); err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+		}
+		return from, to, nil
+	}
+
+	op, rest := splitComparison(value)
+	start, end, err := dayBounds(rest)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	switch op {
+	case ">":
+		return end, time.Time{}, nil
+	case ">=":
+		return start, time.Time{}, nil
+	case "<":
+		return time.Time{}, start, nil
+	case "<=":
+		return time.Time{}, end, nil
+	}
+	return start, end, nil
+}
+
+// dayBounds returns the day a date covers, or an instant for a timestamp
+func dayBounds(value string) (time.Time, time.Time, error) {
+	t, dateOnly, err := ParseSearchDate(value)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if dateOnly {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	return t, t.Add(time.Microsecond), nil
+}
+
+// parseSizeCondition reads >10mb, <=1gb, 2mb..5mb or an exact size into
+// inclusive bounds, -1 when open
+func parseSizeCondition(value string) (lo, hi int64, err error) {
+	if start, end, isRange := strings.Cut(value, ".."); isRange {
+		lo, hi = -1, -1
+		if start != "" {
+			if lo, err = parseByteSize(start); err != nil {
+				return 0, 0, err
+			}
+		}
+		if end != "" {
+			if hi, err = parseByteSize(end); err != nil {
+				return 0, 0, err
+			}
+		}
+		return lo, hi, nil
+	}
+
+	op, rest := splitComparison(value)
+	n, err := parseByteSize(rest)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch op {
+	case ">":
+		return n + 1, -1, nil
+	case ">=":
+		return n, -1, nil
+	case "<":
+		return -1, max(n-1, 0), nil
+	case "<=":
+		return -1, n, nil
+	}
+	return n, n, nil
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"tb", 1 << 40},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"t", 1 << 40}, {"b", 1},
+}
+
+// parseByteSize reads a size such as 512, 10mb or 1.5gb; units are binary
+func parseByteSize(value string) (int64, error) {
+	unit := int64(1)
+	number := value
+	for _, u := range byteUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			unit = u.size
+			number = strings.TrimSuffix(value, u.suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 || n*float64(unit) > 1<<62 {
+		return 0, fmt.Errorf("%q is not a size (such as 500kb or 10mb)", value)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+func splitComparison(value string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if rest, ok := strings.CutPrefix(value, op); ok {
+			return op, rest
+		}
+	}
+	return "", value
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
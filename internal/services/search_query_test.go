@@ -0,0 +1,216 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	folder := uuid.MustParse("3f2a9c1b-0000-4000-8000-000000000001")
+	yes, no := true, false
+
+	tests := []struct {
+		input string
+		want  func(q *SearchQuery)
+	}{
+		{"", func(q *SearchQuery) {}},
+		{"quarterly budget", func(q *SearchQuery) { q.Text = "quarterly budget" }},
+		{`"exact phrase" -excluded`, func(q *SearchQuery) { q.Text = `"exact phrase" -excluded` }},
+		// Unknown prefixes are text
+		{"https://example.com", func(q *SearchQuery) { q.Text = "https://example.com" }},
+		{"type:pdf", func(q *SearchQuery) { q.Types = []string{"pdf"} }},
+		{"TYPE:Image type:folder", func(q *SearchQuery) { q.Types = []string{"image", "folder"} }},
+		{"owner:me", func(q *SearchQuery) { q.Owner = "me" }},
+		{"owner:notme", func(q *SearchQuery) { q.Owner = "notme" }},
+		{"owner:Alice@Example.com", func(q *SearchQuery) { q.Owner = "alice@example.com" }},
+		{"owner:anyone", func(q *SearchQuery) {}},
+		{`name:"q3 report" notes`, func(q *SearchQuery) {
+			q.Names = []string{"q3 report"}
+			q.Text = "notes"
+		}},
+		{"name:a name:b", func(q *SearchQuery) { q.Names = []string{"a", "b"} }},
+		{"in:root", func(q *SearchQuery) { q.InRoot = true }},
+		{"in:" + folder.String(), func(q *SearchQuery) { q.Folder = &folder }},
+		{"is:starred", func(q *SearchQuery) { q.Starred = &yes }},
+		{"is:unstarred", func(q *SearchQuery) { q.Starred = &no }},
+		{"is:trashed", func(q *SearchQuery) { q.Status = "trashed" }},
+		{"shared:yes", func(q *SearchQuery) { q.Shared = &yes }},
+		{"shared:false", func(q *SearchQuery) { q.Shared = &no }},
+		{"size:>10mb", func(q *SearchQuery) { q.MinSize = 10<<20 + 1 }},
+		{"size:<=1kb", func(q *SearchQuery) { q.MaxSize = 1 << 10 }},
+		{"size:2mb..5mb", func(q *SearchQuery) { q.MinSize, q.MaxSize = 2<<20, 5<<20 }},
+		// Repeated conditions narrow each other
+		{"size:>1kb size:>=4kb size:<1mb", func(q *SearchQuery) { q.MinSize, q.MaxSize = 4<<10, 1<<20-1 }},
+		{"modified:>2025-01-01", func(q *SearchQuery) {
+			q.ModifiedFrom = time.Date(2025, 1, 2, 0, 0, 0, 0, time.Local)
+		}},
+		{"created:2025-01-01..2025-01-31 created:>=2025-01-10", func(q *SearchQuery) {
+			q.CreatedFrom = time.Date(2025, 1, 10, 0, 0, 0, 0, time.Local)
+			q.CreatedTo = time.Date(2025, 2, 1, 0, 0, 0, 0, time.Local)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			want := NewSearchQuery()
+			tt.want(&want)
+
+			got, err := ParseSearchQuery(tt.input)
+			if err != nil {
+				t.Fatalf("ParseSearchQuery: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	for _, input := range []string{
+		"type:",
+		`name:""`,
+		"type:spaceship",
+		"in:documents",
+		"is:important",
+		"shared:maybe",
+		"modified:last-week",
+		"modified:>2025-13-01",
+		"created:99999d",
+		"size:ten",
+		"size:-5mb",
+		"size:>10zb",
+		"size:1mb..lots",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseSearchQuery(input)
+			if !errors.Is(err, ErrInvalidSearch) {
+				t.Errorf("ParseSearchQuery(%q) = %v, want ErrInvalidSearch", input, err)
+			}
+		})
+	}
+}
+
+func TestParseDateCondition(t *testing.T) {
+	now := time.Date(2025, 3, 15, 10, 30, 0, 0, time.Local)
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2025, month, d, 0, 0, 0, 0, time.Local)
+	}
+	var open time.Time
+
+	tests := []struct {
+		value    string
+		from, to time.Time
+	}{
+		{"today", day(3, 15), open},
+		{"Yesterday", day(3, 14), day(3, 15)},
+		{"7d", day(3, 8), open},
+		{"2025-01-01", day(1, 1), day(1, 2)},
+		{">2025-01-01", day(1, 2), open},
+		{">=2025-01-01", day(1, 1), open},
+		{"<2025-01-01", open, day(1, 1)},
+		{"<=2025-01-01", open, day(1, 2)},
+		{"=2025-01-01", day(1, 1), day(1, 2)},
+		{"2025-01-01..2025-01-31", day(1, 1), day(2, 1)},
+		{"2025-01-01..", day(1, 1), open},
+		{"..2025-01-31", open, day(2, 1)},
+		{">2025-01-01T10:00:00", time.Date(2025, 1, 1, 10, 0, 0, 1000, time.Local), open},
+		{"2025-01-01T10:00:00Z", time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 10, 0, 0, 1000, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			from, to, err := parseDateCondition(tt.value, now)
+			if err != nil {
+				t.Fatalf("parseDateCondition: %v", err)
+			}
+			if !from.Equal(tt.from) || !to.Equal(tt.to) {
+				t.Errorf("got [%v, %v), want [%v, %v)", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512b", 512},
+		{"10k", 10 << 10},
+		{"10kb", 10 << 10},
+		{"10mb", 10 << 20},
+		{"1.5gb", 3 << 29},
+		{"2t", 2 << 40},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.value, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "mb", "ten", "-1", "1e30", "10 mb"} {
+		if _, err := parseByteSize(value); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded", value)
+		}
+	}
+}
+
+func TestSearchCursor(t *testing.T) {
+	cursor := searchCursor{
+		Sort:       SortSize,
+		Descending: true,
+		Key:        "1048576",
+		Type:       "file",
+		ID:         uuid.NewString(),
+	}
+	decoded, err := decodeSearchCursor(encodeSearchCursor(cursor))
+	if err != nil || decoded != cursor {
+		t.Errorf("round trip = %+v, %v; want %+v", decoded, err, cursor)
+	}
+
+	for _, bad := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := decodeSearchCursor(bad); err == nil {
+			t.Errorf("decodeSearchCursor(%q) succeeded", bad)
+		}
+	}
+}
+
+// Values reach the statement only as parameters
+func TestSearchBuilderParameters(t *testing.T) {
+	q, err := ParseSearchQuery(`name:"o'brien%_" owner:eve@example.com type:pdf size:>1kb robert'); DROP TABLE files; --`)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery: %v", err)
+	}
+	b := &searchBuilder{q: q, user: pgtype.UUID{Bytes: uuid.New(), Valid: true}}
+	sql := b.fileBranch("f.size") + b.with()
+
+	for _, value := range []string{"o'brien", "eve@example.com", "DROP TABLE", "application/pdf"} {
+		if strings.Contains(sql, value) {
+			t.Errorf("%q was written into the statement: %s", value, sql)
+		}
+	}
+
+	want := []any{`%o'brien\%\_%`, "eve@example.com", "application/pdf%", int64(1<<10 + 1)}
+	for _, value := range want {
+		found := false
+		for _, arg := range b.args {
+			if reflect.DeepEqual(arg, value) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%v is not a parameter: %v", value, b.args)
+		}
+	}
+	if !strings.Contains(sql, "WITH RECURSIVE shared_folders") {
+		t.Errorf("owner: search does not declare shared folders: %s", sql)
+	}
+}
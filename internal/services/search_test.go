@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// searchFixture is a database holding one user's files and folders
+type searchFixture struct {
+	t       *testing.T
+	queries *database.Queries
+	search  *SearchService
+	owner   database.User
+}
+
+func newSearchFixture(t *testing.T) *searchFixture {
+	pool, queries := testDB(t)
+	f := &searchFixture{t: t, queries: queries, search: NewSearchService(pool, queries)}
+	f.owner = f.user("search@example.com")
+	return f
+}
+
+func (f *searchFixture) user(email string) database.User {
+	f.t.Helper()
+	user, err := f.queries.CreateUser(context.Background(), database.CreateUserParams{
+		Email:          email,
+		HashedPassword: "x",
+		Name:           email,
+	})
+	if err != nil {
+		f.t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+func (f *searchFixture) file(owner database.User, parent pgtype.UUID, name, mimeType string, size int64) database.File {
+	f.t.Helper()
+	file, err := f.queries.CreateFile(context.Background(), database.CreateFileParams{
+		Name:             name,
+		OriginalName:     name,
+		MimeType:         mimeType,
+		Size:             size,
+		StoragePath:      "blobs/" + name,
+		OwnerID:          owner.ID,
+		ParentFolderID:   parent,
+		PreviewAvailable: pgtype.Bool{Bool: false, Valid: true},
+	})
+	if err != nil {
+		f.t.Fatalf("failed to create file: %v", err)
+	}
+	return file
+}
+
+func (f *searchFixture) folder(owner database.User, parent pgtype.UUID, name string) database.Folder {
+	f.t.Helper()
+	folder, err := f.queries.CreateFolder(context.Background(), database.CreateFolderParams{
+		Name:           name,
+		OwnerID:        owner.ID,
+		ParentFolderID: parent,
+		IsRoot:         pgtype.Bool{Bool: false, Valid: true},
+	})
+	if err != nil {
+		f.t.Fatalf("failed to create folder: %v", err)
+	}
+	return folder
+}
+
+// all pages through the results of query, limit items at a time
+func (f *searchFixture) all(query string, opts SearchOptions) []SearchItem {
+	f.t.Helper()
+	q, err := ParseSearchQuery(query)
+	if err != nil {
+		f.t.Fatalf("failed to parse %q: %v", query, err)
+	}
+
+	var items []SearchItem
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			f.t.Fatalf("paging did not end")
+		}
+		page, err := f.search.Search(context.Background(), f.owner.ID, q, opts)
+		if err != nil {
+			f.t.Fatalf("search %q failed: %v", query, err)
+		}
+		items = append(items, page.Items...)
+		if page.NextCursor == "" {
+			return items
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// names returns the names of items, in order
+func names(items []SearchItem) []string {
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.File != nil {
+			result = append(result, item.File.Name)
+		} else {
+			result = append(result, item.Folder.Name)
+		}
+	}
+	return result
+}
+
+func TestSearchPagesBySize(t *testing.T) {
+	f := newSearchFixture(t)
+
+	// Sizes with different numbers of digits sort differently as text
+	sizes := []int64{5, 20, 100, 3, 1000, 20, 9, 250000, 31}
+	for i, size := range sizes {
+		f.file(f.owner, pgtype.UUID{}, fmt.Sprintf("file-%d.txt", i), "text/plain", size)
+	}
+	f.folder(f.owner, pgtype.UUID{}, "folder")
+
+	for _, descending := range []bool{false, true} {
+		t.Run(fmt.Sprintf("descending=%v", descending), func(t *testing.T) {
+			items := f.all("", SearchOptions{Sort: SortSize, Descending: &descending, Limit: 2})
+			if len(items) != len(sizes)+1 {
+				t.Fatalf("got %d items, want %d: %v", len(items), len(sizes)+1, names(items))
+			}
+
+			seen := make(map[pgtype.UUID]bool)
+			var previous int64 = -1
+			for i, item := range items {
+				var id pgtype.UUID
+				var size int64
+				if item.File != nil {
+					id, size = item.File.ID, item.File.Size
+				} else {
+					id = item.Folder.ID
+				}
+				if seen[id] {
+					t.Errorf("%s returned twice", names(items[i:i+1]))
+				}
+				seen[id] = true
+
+				if previous >= 0 && (descending && size > previous || !descending && size < previous) {
+					t.Errorf("out of order: %v", names(items))
+				}
+				previous = size
+			}
+		})
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	f := newSearchFixture(t)
+	ctx := context.Background()
+	other := f.user("other@example.com")
+	root := pgtype.UUID{}
+
+	reports := f.folder(f.owner, root, "Reports")
+	budget := f.file(f.owner, root, "budget plan.pdf", "application/pdf", 20<<20)
+	f.file(f.owner, reports.ID, "Q3 report.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", 2<<10)
+	holiday := f.file(f.owner, root, "holiday.jpg", "image/jpeg", 3<<20)
+	f.file(f.owner, root, "100%_done.txt", "text/plain", 10)
+	old := f.file(f.owner, root, "old.txt", "text/plain", 10)
+	draft := f.file(other, root, "budget draft.pdf", "application/pdf", 1<<10)
+	f.file(other, root, "private.pdf", "application/pdf", 1<<10)
+
+	if err := f.queries.SetFileStarred(ctx, database.SetFileStarredParams{ID: budget.ID, IsStarred: pgtype.Bool{Bool: true, Valid: true}}); err != nil {
+		t.Fatalf("failed to star file: %v", err)
+	}
+	if err := f.queries.TrashFile(ctx, database.TrashFileParams{ID: old.ID}); err != nil {
+		t.Fatalf("failed to trash file: %v", err)
+	}
+	for _, grant := range []struct {
+		file     database.File
+		from, to database.User
+	}{{holiday, f.owner, other}, {draft, other, f.owner}} {
+		if _, err := f.queries.CreatePermission(ctx, database.CreatePermissionParams{
+			ItemType:  database.ItemTypeFile,
+			ItemID:    grant.file.ID,
+			UserID:    grant.to.ID,
+			Role:      database.PermissionRoleViewer,
+			GrantedBy: grant.from.ID,
+		}); err != nil {
+			t.Fatalf("failed to share file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"type:pdf", []string{"budget draft.pdf", "budget plan.pdf"}},
+		{"type:pdf owner:me", []string{"budget plan.pdf"}},
+		{"owner:notme", []string{"budget draft.pdf"}},
+		{"owner:OTHER@example.com", []string{"budget draft.pdf"}},
+		{`name:"q3 report"`, []string{"Q3 report.docx"}},
+		// Wildcards in names are matched literally
+		{"name:%_", []string{"100%_done.txt"}},
+		{"size:>10mb", []string{"budget plan.pdf"}},
+		{"size:1kb..5mb", []string{"Q3 report.docx", "budget draft.pdf", "holiday.jpg"}},
+		{"type:document", []string{"Q3 report.docx"}},
+		{"type:folder", []string{"Reports"}},
+		{"type:image type:text", []string{"100%_done.txt", "holiday.jpg"}},
+		{"in:" + uuid.UUID(reports.ID.Bytes).String(), []string{"Q3 report.docx"}},
+		{"in:root", []string{"100%_done.txt", "Reports", "budget plan.pdf", "holiday.jpg"}},
+		{"is:starred", []string{"budget plan.pdf"}},
+		{"is:trashed", []string{"old.txt"}},
+		{"shared:yes", []string{"holiday.jpg"}},
+		{"shared:no type:pdf", []string{"budget plan.pdf"}},
+		{"budget", []string{"budget draft.pdf", "budget plan.pdf"}},
+		{"budget owner:me", []string{"budget plan.pdf"}},
+		{"created:today type:pdf", []string{"budget draft.pdf", "budget plan.pdf"}},
+		{"modified:<2000-01-01", []string{}},
+		{`name:"'; DROP TABLE files; --"`, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := names(f.all(tt.query, SearchOptions{Sort: SortName, Limit: 3}))
+			sort.Strings(got)
+			want := slices.Clone(tt.want)
+			sort.Strings(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -1,169 +1,271 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
 	"io"
-	"os"
-	"path/filepath"
-	"strings"
+	"path"
+	"time"
 
-	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
 )
 
 type StorageService struct {
-	basePath      string
-	thumbnailPath string
+	backend    Backend
+	thumbnails Backend
 }
 
-func NewStorageService(basePath, thumbnailPath string) *StorageService {
+// NewStorageService creates a storage service that keeps file contents in
+// backend and generated thumbnails in thumbnails
+func NewStorageService(backend, thumbnails Backend) *StorageService {
 	return &StorageService{
-		basePath:      basePath,
-		thumbnailPath: thumbnailPath,
+		backend:    backend,
+		thumbnails: thumbnails,
 	}
 }
 
-// SaveFile saves a file to the storage system
-// Returns: (storagePath, error)
-func (s *StorageService) SaveFile(
-	userID uuid.UUID,
-	fileID uuid.UUID,
-	file io.Reader,
-	filename string,
-	version int,
-) (string, error) {
-	// Create path: storage/uploads/user_{uuid}/file_{uuid}/
-	userDir := filepath.Join(s.basePath, fmt.Sprintf("user_%s", userID.String()))
-	fileDir := filepath.Join(userDir, fileID.String())
-
-	// Create directories if they don't exist
-	if err := os.MkdirAll(fileDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create directory: %w", err)
-	}
+// BlobPath returns the storage key of a content-addressed blob:
+// blobs/{aa}/{bb}/{sha256}
+func BlobPath(digest string) string {
+	return path.Join("blobs", digest[:2], digest[2:4], digest)
+}
 
-	// Save file with version prefix: v1_filename.ext
-	filenameWithVersion := fmt.Sprintf("v%d_%s", version, filename)
-	fullPath := filepath.Join(fileDir, filenameWithVersion)
+// blobStagingPrefix holds uploaded content until it is moved to its address
+const blobStagingPrefix = ".staging/blobs/"
+
+// StagedBlob is uploaded content that has been written to a staging key and hashed
+type StagedBlob struct {
+	Key    string
+	Digest string
+	Size   int64
+}
 
-	// Create the file
-	dst, err := os.Create(fullPath)
+// StageBlob streams content to a staging key while computing its SHA-256 digest
+func (s *StorageService) StageBlob(ctx context.Context, content io.Reader) (StagedBlob, error) {
+	key := blobStagingPrefix + uuid.New().String()
+
+	hash := sha256.New()
+	size, err := s.backend.Put(ctx, key, io.TeeReader(content, hash))
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %w", err)
+		s.backend.Delete(ctx, key)
+		return StagedBlob{}, err
 	}
-	defer dst.Close()
 
-	// Copy the uploaded file content
-	if _, err := io.Copy(dst, file); err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+	return StagedBlob{
+		Key:    key,
+		Digest: hex.EncodeToString(hash.Sum(nil)),
+		Size:   size,
+	}, nil
+}
+
+// CommitBlob moves a staged blob to its content address. If an identical
+// blob is already stored the staged copy is discarded instead.
+// Returns: (blobPath, error)
+func (s *StorageService) CommitBlob(ctx context.Context, staged StagedBlob) (string, error) {
+	blobPath := BlobPath(staged.Digest)
+
+	_, err := s.backend.Stat(ctx, blobPath)
+	if err == nil {
+		return blobPath, s.backend.Delete(ctx, staged.Key)
+	}
+	if !errors.Is(err, ErrObjectNotFound) {
+		return "", err
 	}
 
-	// Return relative path for database
-	relativePath := filepath.Join(
-		fmt.Sprintf("user_%s", userID.String()),
-		fileID.String(),
-		fmt.Sprintf("v%d_%s", version, filename),
-	)
+	if err := s.backend.Rename(ctx, staged.Key, blobPath); err != nil {
+		return "", err
+	}
 
-	return relativePath, nil
+	return blobPath, nil
 }
 
-// GetFile opens a file from storage
-func (s *StorageService) GetFile(storagePath string) (*os.File, error) {
-	fullPath := filepath.Join(s.basePath, storagePath)
+// DiscardBlob removes a staged blob that will not be committed
+func (s *StorageService) DiscardBlob(ctx context.Context, staged StagedBlob) error {
+	return s.backend.Delete(ctx, staged.Key)
+}
 
-	// Check if file exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("file not found: %s", storagePath)
+// RemoveAbandoned deletes staged blobs and chunks and partly written objects
+// last modified before cutoff. Uploads that finish or fail remove their own,
+// so these were left by a crash.
+// Returns: (objects removed, error)
+func (s *StorageService) RemoveAbandoned(ctx context.Context, cutoff time.Time) (int, error) {
+	removed := 0
+	remove := func(backend Backend, prefix string) error {
+		var keys []string
+		err := backend.List(ctx, prefix, func(object ObjectInfo) error {
+			if object.ModTime.Before(cutoff) {
+				keys = append(keys, object.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+		for _, key := range keys {
+			if err := backend.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+			removed++
+		}
+		return nil
 	}
 
-	// Open the file
-	file, err := os.Open(fullPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+	if err := remove(s.backend, blobStagingPrefix); err != nil {
+		return removed, err
 	}
+	if err := remove(s.backend, chunkStagingPrefix); err != nil {
+		return removed, err
+	}
+	if err := remove(s.backend, partialPrefix); err != nil {
+		return removed, err
+	}
+	return removed, remove(s.thumbnails, partialPrefix)
+}
 
-	return file, nil
+// GetFile opens a file from storage
+func (s *StorageService) GetFile(ctx context.Context, storagePath string) (io.ReadSeekCloser, error) {
+	return s.backend.Get(ctx, storagePath)
 }
 
 // DeleteFile removes a file from storage
-func (s *StorageService) DeleteFile(storagePath string) error {
-	fullPath := filepath.Join(s.basePath, storagePath)
-
-	if err := os.Remove(fullPath); err != nil {
-		return fmt.Errorf("failed to delete file: %w", err)
-	}
-
-	return nil
+func (s *StorageService) DeleteFile(ctx context.Context, storagePath string) error {
+	return s.backend.Delete(ctx, storagePath)
 }
 
 // GetFileSize returns the size of a file in bytes
-func (s *StorageService) GetFileSize(storagePath string) (int64, error) {
-	fullPath := filepath.Join(s.basePath, storagePath)
-
-	info, err := os.Stat(fullPath)
+func (s *StorageService) GetFileSize(ctx context.Context, storagePath string) (int64, error) {
+	info, err := s.backend.Stat(ctx, storagePath)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get file info: %w", err)
+		return 0, err
 	}
 
-	return info.Size(), nil
+	return info.Size, nil
 }
 
 // MoveFile moves a file from one location to another
-func (s *StorageService) MoveFile(oldPath, newPath string) error {
-	oldFullPath := filepath.Join(s.basePath, oldPath)
-	newFullPath := filepath.Join(s.basePath, newPath)
-
-	// Create destination directory if it doesn't exist
-	newDir := filepath.Dir(newFullPath)
-	if err := os.MkdirAll(newDir, 0755); err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
-	}
+func (s *StorageService) MoveFile(ctx context.Context, oldPath, newPath string) error {
+	return s.backend.Rename(ctx, oldPath, newPath)
+}
 
-	// Move the file
-	if err := os.Rename(oldFullPath, newFullPath); err != nil {
-		return fmt.Errorf("failed to move file: %w", err)
-	}
+// uploadChunkPath returns the staging key of one chunk of a resumable upload:
+// .staging/{session_uuid}/chunk_000001
+func uploadChunkPath(sessionID uuid.UUID, index int32) string {
+	return path.Join(".staging", sessionID.String(), fmt.Sprintf("chunk_%06d", index))
+}
 
-	return nil
+// chunkStagingPrefix holds received chunks until their upload session
+// accepts them
+const chunkStagingPrefix = ".staging/chunks/"
+
+// StagedChunk is a chunk of a resumable upload written to a key of its own
+type StagedChunk struct {
+	Key  string
+	Size int64
 }
 
-// GenerateThumbnail generates a thumbnail for an image file
-// Returns: (thumbnailPath, error)
-func (s *StorageService) GenerateThumbnail(storagePath string, mimeType string, fileID uuid.UUID) (string, error) {
-	// Only generate thumbnails for images
-	if !strings.HasPrefix(mimeType, "image/") {
-		return "", fmt.Errorf("file is not an image")
+// StageUploadChunk writes a received chunk to a unique staging key, so
+// concurrent requests for the same chunk of a session never share a key
+func (s *StorageService) StageUploadChunk(ctx context.Context, chunk io.Reader) (StagedChunk, error) {
+	key := chunkStagingPrefix + uuid.New().String()
+
+	n, err := s.backend.Put(ctx, key, chunk)
+	if err != nil {
+		s.backend.Delete(ctx, key)
+		return StagedChunk{}, fmt.Errorf("failed to write chunk: %w", err)
 	}
 
-	// Full path to original file
-	fullPath := filepath.Join(s.basePath, storagePath)
+	return StagedChunk{Key: key, Size: n}, nil
+}
 
-	// Open and decode the image
-	src, err := imaging.Open(fullPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open image: %w", err)
+// CommitUploadChunk moves a staged chunk to its place in the upload session
+func (s *StorageService) CommitUploadChunk(ctx context.Context, staged StagedChunk, sessionID uuid.UUID, index int32) error {
+	return s.backend.Rename(ctx, staged.Key, uploadChunkPath(sessionID, index))
+}
+
+// DiscardUploadChunk removes a staged chunk that will not be committed
+func (s *StorageService) DiscardUploadChunk(ctx context.Context, staged StagedChunk) error {
+	return s.backend.Delete(ctx, staged.Key)
+}
+
+// OpenUploadChunks returns a reader that streams the staged chunks of an
+// upload session in order, opening each chunk only when it is reached
+func (s *StorageService) OpenUploadChunks(ctx context.Context, sessionID uuid.UUID, count int32) io.ReadCloser {
+	return &chunkReader{ctx: ctx, backend: s.backend, sessionID: sessionID, count: count}
+}
+
+// DeleteUploadChunks removes the staged chunks of an upload session.
+// count is the number of accepted chunks; one extra index is removed in
+// case a chunk was moved into place but its session was never advanced.
+func (s *StorageService) DeleteUploadChunks(ctx context.Context, sessionID uuid.UUID, count int32) error {
+	for i := int32(0); i <= count; i++ {
+		if err := s.backend.Delete(ctx, uploadChunkPath(sessionID, i)); err != nil {
+			return fmt.Errorf("failed to delete staged chunks: %w", err)
+		}
 	}
 
-	// Create thumbnail (200x200 max, maintaining aspect ratio)
-	thumbnail := imaging.Fit(src, 200, 200, imaging.Lanczos)
+	return nil
+}
+
+type chunkReader struct {
+	ctx       context.Context
+	backend   Backend
+	sessionID uuid.UUID
+	count     int32
+	next      int32
+	current   io.ReadCloser
+}
 
-	// Create thumbnail directory structure: thumbnails/file_{uuid}.jpg
-	thumbnailFilename := fmt.Sprintf("%s.jpg", fileID.String())
-	thumbnailFullPath := filepath.Join(s.thumbnailPath, thumbnailFilename)
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if c.next >= c.count {
+				return 0, io.EOF
+			}
+			chunk, err := c.backend.Get(c.ctx, uploadChunkPath(c.sessionID, c.next))
+			if err != nil {
+				return 0, fmt.Errorf("failed to open chunk %d: %w", c.next, err)
+			}
+			c.current = chunk
+			c.next++
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
 
-	// Ensure thumbnail directory exists
-	if err := os.MkdirAll(s.thumbnailPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create thumbnail directory: %w", err)
+func (c *chunkReader) Close() error {
+	if c.current != nil {
+		return c.current.Close()
 	}
+	return nil
+}
 
-	// Save thumbnail as JPEG (smaller file size)
-	if err := imaging.Save(thumbnail, thumbnailFullPath); err != nil {
-		return "", fmt.Errorf("failed to save thumbnail: %w", err)
+// SaveThumbnail stores a generated thumbnail or preview under key
+func (s *StorageService) SaveThumbnail(ctx context.Context, key string, content io.Reader) error {
+	if _, err := s.thumbnails.Put(ctx, key, content); err != nil {
+		return fmt.Errorf("failed to save thumbnail: %w", err)
 	}
+	return nil
+}
+
+// GetThumbnail opens a generated thumbnail
+func (s *StorageService) GetThumbnail(ctx context.Context, thumbnailPath string) (io.ReadSeekCloser, error) {
+	return s.thumbnails.Get(ctx, thumbnailPath)
+}
 
-	// Return relative path for database
-	return thumbnailFilename, nil
+// DeleteThumbnail removes a generated thumbnail
+func (s *StorageService) DeleteThumbnail(ctx context.Context, thumbnailPath string) error {
+	return s.thumbnails.Delete(ctx, thumbnailPath)
 }
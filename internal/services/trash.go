@@ -0,0 +1,361 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+var (
+	// ErrNotTrashed is returned when restoring an item that is not in trash
+	ErrNotTrashed = errors.New("item is not in trash")
+	// ErrParentTrashed is returned when an item's parent folder is still in
+	// trash and no restore destination was chosen
+	ErrParentTrashed = errors.New("parent folder is in trash")
+)
+
+// RestoreDestination chooses where an item goes when its parent folder is
+// still in trash. Items whose parent is active always return to it.
+type RestoreDestination string
+
+const (
+	RestoreInPlace  RestoreDestination = ""
+	RestoreToRoot   RestoreDestination = "root"
+	RestoreAncestor RestoreDestination = "ancestor"
+)
+
+// TrashService moves items to and from trash and deletes them permanently.
+// Trashing a folder trashes its whole active subtree as one operation;
+// restoring the folder brings back exactly the items of that operation.
+type TrashService struct {
+	db          database.TxStarter
+	queries     *database.Queries
+	storage     *StorageService
+	blobService *BlobService
+}
+
+func NewTrashService(db database.TxStarter, queries *database.Queries, storage *StorageService, blobService *BlobService) *TrashService {
+	return &TrashService{
+		db:          db,
+		queries:     queries,
+		storage:     storage,
+		blobService: blobService,
+	}
+}
+
+// TrashFile moves a single file to trash
+func (s *TrashService) TrashFile(ctx context.Context, userID pgtype.UUID, file database.File) error {
+	return database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		op, err := q.CreateTrashOperation(ctx, database.CreateTrashOperationParams{
+			UserID:   userID,
+			RootType: database.ItemTypeFile,
+			RootID:   file.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record trash operation: %w", err)
+		}
+
+		if err := q.TrashFile(ctx, database.TrashFileParams{
+			ID:               file.ID,
+			TrashOperationID: op.ID,
+		}); err != nil {
+			return fmt.Errorf("failed to trash file: %w", err)
+		}
+		return nil
+	})
+}
+
+// TrashFolder moves a folder and everything active below it to trash and
+// returns how many folders and files were trashed
+func (s *TrashService) TrashFolder(ctx context.Context, userID pgtype.UUID, folder database.Folder) (folders int64, files int64, err error) {
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		op, err := q.CreateTrashOperation(ctx, database.CreateTrashOperationParams{
+			UserID:   userID,
+			RootType: database.ItemTypeFolder,
+			RootID:   folder.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record trash operation: %w", err)
+		}
+
+		folders, err = q.TrashFolderTree(ctx, database.TrashFolderTreeParams{
+			FolderID:    folder.ID,
+			OperationID: op.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to trash folders: %w", err)
+		}
+
+		files, err = q.TrashOperationFiles(ctx, op.ID)
+		if err != nil {
+			return fmt.Errorf("failed to trash files: %w", err)
+		}
+		return nil
+	})
+	return folders, files, err
+}
+
+// NearestActiveAncestor walks up from folderID to the first folder that is not
+// in trash. ok is false when every ancestor is trashed or deleted.
+func (s *TrashService) NearestActiveAncestor(ctx context.Context, folderID pgtype.UUID) (database.Folder, bool, error) {
+	folder, err := s.queries.GetNearestActiveAncestor(ctx, folderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return database.Folder{}, false, nil
+	}
+	if err != nil {
+		return database.Folder{}, false, err
+	}
+	return folder, true, nil
+}
+
+// RestoreParent returns the folder an item with the given parent is restored
+// into. An invalid result means the root.
+func (s *TrashService) RestoreParent(ctx context.Context, parentID pgtype.UUID, dest RestoreDestination) (pgtype.UUID, error) {
+	if !parentID.Valid {
+		return pgtype.UUID{}, nil
+	}
+
+	parent, err := s.queries.GetFolderByIDAnyStatus(ctx, parentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return pgtype.UUID{}, nil
+	}
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	// Folders without a status predate the column default and count as active
+	if !parent.Status.Valid || parent.Status.FileStatus == database.FileStatusActive {
+		return parentID, nil
+	}
+
+	switch dest {
+	case RestoreToRoot:
+		return pgtype.UUID{}, nil
+	case RestoreAncestor:
+		ancestor, ok, err := s.NearestActiveAncestor(ctx, parentID)
+		if err != nil || !ok {
+			return pgtype.UUID{}, err
+		}
+		return ancestor.ID, nil
+	default:
+		return pgtype.UUID{}, ErrParentTrashed
+	}
+}
+
+// RestoreFile takes a file out of trash into parentID, as resolved by
+// RestoreParent. If its name has been taken meanwhile it gets the next free
+// "name (n)".
+func (s *TrashService) RestoreFile(ctx context.Context, file database.File, parentID pgtype.UUID) error {
+	if file.Status.FileStatus != database.FileStatusTrashed {
+		return ErrNotTrashed
+	}
+
+	return database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		if err := lockChildren(ctx, q, file.OwnerID, parentID); err != nil {
+			return err
+		}
+		name, err := resolveName(ctx, q, database.ItemTypeFile, file.OwnerID, parentID, file.ID, file.Name, CollisionRename)
+		if err != nil {
+			return err
+		}
+
+		return nameError(q.RestoreFile(ctx, database.RestoreFileParams{
+			ID:             file.ID,
+			ParentFolderID: parentID,
+			Name:           name,
+		}), "failed to restore file")
+	})
+}
+
+// RestoreFolder takes a folder out of trash into parentID, as resolved by
+// RestoreParent, together with the items trashed along with it. Items below it
+// that were trashed separately stay in trash. The folder is renamed like in
+// RestoreFile if its name has been taken.
+func (s *TrashService) RestoreFolder(ctx context.Context, folder database.Folder, parentID pgtype.UUID) (folders int64, files int64, err error) {
+	if folder.Status.FileStatus != database.FileStatusTrashed {
+		return 0, 0, ErrNotTrashed
+	}
+
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		// Files first: restoring the folders clears the operation marks the file query follows
+		if folder.TrashOperationID.Valid {
+			files, err = q.RestoreOperationFiles(ctx, database.RestoreOperationFilesParams{
+				FolderID:    folder.ID,
+				OperationID: folder.TrashOperationID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to restore files: %w", err)
+			}
+
+			folders, err = q.RestoreOperationFolders(ctx, database.RestoreOperationFoldersParams{
+				FolderID:    folder.ID,
+				OperationID: folder.TrashOperationID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to restore folders: %w", err)
+			}
+		}
+
+		if err := lockChildren(ctx, q, folder.OwnerID, parentID); err != nil {
+			return err
+		}
+		name, err := resolveName(ctx, q, database.ItemTypeFolder, folder.OwnerID, parentID, folder.ID, folder.Name, CollisionRename)
+		if err != nil {
+			return err
+		}
+		if err := q.RestoreFolder(ctx, database.RestoreFolderParams{
+			ID:             folder.ID,
+			ParentFolderID: parentID,
+			Name:           name,
+		}); err != nil {
+			return nameError(err, "failed to restore folder")
+		}
+		folders++
+		return nil
+	})
+	return folders, files, err
+}
+
+// PurgeResult reports what a permanent delete removed
+type PurgeResult struct {
+	Folders int64
+	Files   int64
+	// BytesReclaimed is how much was refunded to the owners' storage_used
+	BytesReclaimed int64
+}
+
+// PurgeFile deletes a file permanently: its versions' content, its thumbnail
+// and its database rows. The owner's storage_used is refunded by the bytes
+// actually freed, so content still shared with their other files is not counted.
+func (s *TrashService) PurgeFile(ctx context.Context, file database.File) (int64, error) {
+	var purged purgedContent
+	err := database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		var err error
+		purged, err = s.purgeFileRows(ctx, q, file)
+		if err != nil {
+			return err
+		}
+		return s.blobService.UnlinkBlobs(ctx, purged.released)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.removePurged(ctx, purged)
+	return purged.released.Bytes, nil
+}
+
+// purgedContent is what deleting files' rows leaves to remove from storage
+type purgedContent struct {
+	released   ReleasedContent
+	thumbnails []string
+}
+
+func (p *purgedContent) add(other purgedContent) {
+	p.released.add(other.released)
+	p.thumbnails = append(p.thumbnails, other.thumbnails...)
+}
+
+// purgeFileRows releases a file's content and deletes its rows in q's
+// transaction. The caller unlinks the released blobs before committing and
+// removes the rest with removePurged afterwards.
+func (s *TrashService) purgeFileRows(ctx context.Context, q *database.Queries, file database.File) (purgedContent, error) {
+	var purged purgedContent
+
+	// The rows go with the file; their objects are removed afterwards
+	previews, err := q.GetFilePreviews(ctx, file.ID)
+	if err != nil {
+		return purged, fmt.Errorf("failed to get previews: %w", err)
+	}
+	if file.ThumbnailPath.Valid && file.ThumbnailPath.String != "" {
+		purged.thumbnails = append(purged.thumbnails, file.ThumbnailPath.String)
+	}
+	for _, preview := range previews {
+		purged.thumbnails = append(purged.thumbnails, preview.Path)
+	}
+
+	purged.released, err = s.blobService.ReleaseFile(ctx, q, file)
+	if err != nil {
+		return purged, err
+	}
+
+	if err := q.PermanentDeleteFile(ctx, file.ID); err != nil {
+		return purged, fmt.Errorf("failed to permanently delete file: %w", err)
+	}
+	return purged, nil
+}
+
+// removePurged deletes the legacy objects, thumbnails and previews of purged
+// files once their rows are gone
+func (s *TrashService) removePurged(ctx context.Context, purged purgedContent) {
+	s.blobService.RemoveLegacyObjects(ctx, purged.released)
+
+	removed := make(map[string]bool)
+	for _, key := range purged.thumbnails {
+		if removed[key] {
+			continue
+		}
+		removed[key] = true
+		if err := s.storage.DeleteThumbnail(ctx, key); err != nil {
+			fmt.Printf("Warning: failed to delete thumbnail: %s, error: %v\n", key, err)
+		}
+	}
+}
+
+// PurgeFolder deletes a folder and everything below it permanently. Folders
+// are only removed once all their files are gone, so a failed purge can be
+// retried; a folder that no longer exists purges nothing.
+func (s *TrashService) PurgeFolder(ctx context.Context, folder database.Folder) (PurgeResult, error) {
+	var result PurgeResult
+
+	descendants, err := s.queries.GetSubtreeFilesForDeletion(ctx, folder.ID)
+	if err != nil {
+		return result, fmt.Errorf("failed to get folder contents: %w", err)
+	}
+
+	for _, file := range descendants {
+		reclaimed, err := s.PurgeFile(ctx, file)
+		if err != nil {
+			return result, err
+		}
+		result.Files++
+		result.BytesReclaimed += reclaimed
+	}
+
+	result.Folders, err = s.queries.PermanentDeleteFolderTree(ctx, folder.ID)
+	if err != nil {
+		return result, fmt.Errorf("failed to permanently delete folders: %w", err)
+	}
+	return result, nil
+}
+
+// purgeFolderRows deletes a folder and everything below it in q's
+// transaction, like purgeFileRows does for a file
+func (s *TrashService) purgeFolderRows(ctx context.Context, q *database.Queries, folder database.Folder) (PurgeResult, purgedContent, error) {
+	var result PurgeResult
+	var purged purgedContent
+
+	descendants, err := q.GetSubtreeFilesForDeletion(ctx, folder.ID)
+	if err != nil {
+		return result, purged, fmt.Errorf("failed to get folder contents: %w", err)
+	}
+
+	for _, file := range descendants {
+		filePurged, err := s.purgeFileRows(ctx, q, file)
+		if err != nil {
+			return result, purged, err
+		}
+		purged.add(filePurged)
+		result.Files++
+	}
+	result.BytesReclaimed = purged.released.Bytes
+
+	result.Folders, err = q.PermanentDeleteFolderTree(ctx, folder.ID)
+	if err != nil {
+		return result, purged, fmt.Errorf("failed to permanently delete folders: %w", err)
+	}
+	return result, purged, nil
+}
@@ -0,0 +1,625 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shri771/gdrive/internal/database"
+)
+
+// abandonedUploadAge is how long staged content and unfinished rows are left
+// alone before recovery treats them as abandoned, so uploads still running on
+// another server are not touched
+const abandonedUploadAge = 15 * time.Minute
+
+// ErrUploadSessionClosed is returned when a resumable upload was completed or
+// aborted while its content was being stored
+var ErrUploadSessionClosed = errors.New("upload session is no longer pending")
+
+// ErrVersionCurrent is returned when restoring the version a file is already at
+var ErrVersionCurrent = errors.New("version is already current")
+
+// ErrChunkOutOfOrder is returned when a chunk does not start where its upload
+// session left off, because another request stored that chunk first
+var ErrChunkOutOfOrder = errors.New("chunk does not start at the received offset")
+
+// IncompleteChunkError is returned when a chunk's body ends before its range
+type IncompleteChunkError struct {
+	Received int64
+	Expected int64
+}
+
+func (e *IncompleteChunkError) Error() string {
+	return fmt.Sprintf("incomplete chunk: received %d of %d bytes", e.Received, e.Expected)
+}
+
+// errUploadFinished rolls back the recovery of a file whose upload turned out
+// to have finished after it was listed
+var errUploadFinished = errors.New("upload finished")
+
+// UploadService records uploaded content as files and versions. The content
+// is staged and synced to storage first; the blob, the file, its version, the
+// jobs that process it and the activity entry are then recorded in one
+// transaction, which moves the content to its address before committing. A
+// crash at any point leaves only staged objects, which Recover removes.
+type UploadService struct {
+	db      database.TxStarter
+	queries *database.Queries
+	storage *StorageService
+	blobs   *BlobService
+	trash   *TrashService
+	jobs    *JobQueue
+}
+
+func NewUploadService(db database.TxStarter, queries *database.Queries, storage *StorageService, blobs *BlobService, trash *TrashService, jobs *JobQueue) *UploadService {
+	return &UploadService{
+		db:      db,
+		queries: queries,
+		storage: storage,
+		blobs:   blobs,
+		trash:   trash,
+		jobs:    jobs,
+	}
+}
+
+// Upload is content to store as a file
+type Upload struct {
+	// UserID is the uploader. Files in a folder belong to the folder's owner,
+	// so an upload into a shared folder is charged to the owner.
+	UserID   pgtype.UUID
+	FolderID pgtype.UUID
+	Name     string
+	MimeType string
+	Content  io.Reader
+	// Policy decides what happens when a file called Name is already in the
+	// folder: replace adds a new version to it, rename picks the next free
+	// name and fail returns ErrNameTaken
+	Policy CollisionPolicy
+	// SessionID is the resumable upload session the content comes from, if
+	// any. It is marked completed with the file.
+	SessionID pgtype.UUID
+}
+
+// Store writes an upload's content to storage and records it as a new file,
+// or as a new version of the file it replaces
+func (s *UploadService) Store(ctx context.Context, upload Upload) (database.File, error) {
+	ownerID := upload.UserID
+	if upload.FolderID.Valid {
+		folder, err := s.queries.GetFolderByID(ctx, upload.FolderID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return database.File{}, ErrDestinationNotFound
+		}
+		if err != nil {
+			return database.File{}, fmt.Errorf("failed to get folder: %w", err)
+		}
+		ownerID = folder.OwnerID
+	}
+
+	// Refuse a taken name before streaming the content; it is checked again
+	// once the folder is locked
+	if upload.Policy == CollisionFail {
+		_, err := s.queries.GetFileByNameAndFolder(ctx, database.GetFileByNameAndFolderParams{
+			OwnerID:        ownerID,
+			Name:           upload.Name,
+			ParentFolderID: upload.FolderID,
+		})
+		if err == nil {
+			return database.File{}, ErrNameTaken
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return database.File{}, fmt.Errorf("failed to check file name: %w", err)
+		}
+	}
+
+	staged, err := s.storage.StageBlob(ctx, upload.Content)
+	if err != nil {
+		return database.File{}, fmt.Errorf("failed to save file: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.storage.DiscardBlob(ctx, staged)
+		}
+	}()
+
+	var file database.File
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		// The blob row is locked before the owner's and the folder's rows, as
+		// copies and releases do
+		blob, err := q.UpsertBlob(ctx, database.UpsertBlobParams{
+			Digest: staged.Digest,
+			Size:   staged.Size,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record blob: %w", err)
+		}
+		if _, err := q.LockUserQuota(ctx, ownerID); err != nil {
+			return fmt.Errorf("failed to lock quota: %w", err)
+		}
+		if err := lockChildren(ctx, q, ownerID, upload.FolderID); err != nil {
+			return err
+		}
+
+		file, err = s.record(ctx, q, upload, ownerID, blob)
+		if err != nil {
+			return err
+		}
+
+		if upload.SessionID.Valid {
+			completed, err := q.CompleteUploadSession(ctx, database.CompleteUploadSessionParams{
+				ID:     upload.SessionID,
+				FileID: file.ID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to complete upload session: %w", err)
+			}
+			if completed == 0 {
+				return ErrUploadSessionClosed
+			}
+		}
+
+		// Staged content moves to its address last; until the commit the
+		// blob row lock keeps releases from unlinking it
+		if _, err := s.storage.CommitBlob(ctx, staged); err != nil {
+			return fmt.Errorf("failed to store blob: %w", err)
+		}
+		committed = true
+		return nil
+	})
+	if err != nil {
+		// Content moved to its address is kept: the blob row of a failed
+		// upload is rolled back, and a later upload or fsck reuses or
+		// removes the object
+		return database.File{}, err
+	}
+
+	s.jobs.notify()
+	return file, nil
+}
+
+// AppendChunk stores the size bytes of chunk as the next chunk of a resumable
+// upload session, starting at byte offset. The chunk is staged under a key of
+// its own and moved into place with the session row locked, so of concurrent
+// requests for the same range exactly one is accepted and the others return
+// ErrChunkOutOfOrder. Returns the advanced session.
+func (s *UploadService) AppendChunk(ctx context.Context, sessionID pgtype.UUID, offset int64, chunk io.Reader, size int64, expiresAt time.Time) (database.UploadSession, error) {
+	staged, err := s.storage.StageUploadChunk(ctx, io.LimitReader(chunk, size))
+	if err != nil {
+		return database.UploadSession{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			s.storage.DiscardUploadChunk(ctx, staged)
+		}
+	}()
+
+	// A short body means the connection dropped; the client resumes from the last offset
+	if staged.Size != size {
+		return database.UploadSession{}, &IncompleteChunkError{Received: staged.Size, Expected: size}
+	}
+
+	var upload database.UploadSession
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		locked, err := q.LockUploadSession(ctx, sessionID)
+		if err != nil {
+			return fmt.Errorf("failed to lock upload session: %w", err)
+		}
+		if locked.Status != database.UploadStatusPending {
+			return ErrUploadSessionClosed
+		}
+		if locked.ReceivedBytes != offset {
+			return ErrChunkOutOfOrder
+		}
+
+		if _, err := q.AdvanceUploadSession(ctx, database.AdvanceUploadSessionParams{
+			ChunkSize:      size,
+			ExpiresAt:      pgtype.Timestamp{Time: expiresAt, Valid: true},
+			ID:             sessionID,
+			ExpectedOffset: offset,
+		}); err != nil {
+			return fmt.Errorf("failed to advance upload session: %w", err)
+		}
+		upload, err = q.GetUploadSession(ctx, sessionID)
+		if err != nil {
+			return fmt.Errorf("failed to get upload session: %w", err)
+		}
+
+		// If the commit fails the chunk is left past the session's chunk
+		// count, where a retry overwrites it
+		if err := s.storage.CommitUploadChunk(ctx, staged, uuid.UUID(sessionID.Bytes), locked.ChunkCount); err != nil {
+			return fmt.Errorf("failed to store chunk: %w", err)
+		}
+		committed = true
+		return nil
+	})
+	if err != nil {
+		return database.UploadSession{}, err
+	}
+	return upload, nil
+}
+
+// record adds the file or version for an upload whose blob is locked and
+// whose folder's names are held, and takes the version's blob reference
+func (s *UploadService) record(ctx context.Context, q *database.Queries, upload Upload, ownerID pgtype.UUID, blob database.Blob) (database.File, error) {
+	name := upload.Name
+	existing, err := q.GetFileByNameAndFolder(ctx, database.GetFileByNameAndFolderParams{
+		OwnerID:        ownerID,
+		Name:           name,
+		ParentFolderID: upload.FolderID,
+	})
+	replace := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return database.File{}, fmt.Errorf("failed to check file name: %w", err)
+	}
+	if replace && upload.Policy != CollisionReplace {
+		name, err = resolveName(ctx, q, database.ItemTypeFile, ownerID, upload.FolderID, pgtype.UUID{}, name, upload.Policy)
+		if err != nil {
+			return database.File{}, err
+		}
+		replace = false
+	}
+
+	var file database.File
+	version := int32(1)
+	if replace {
+		// A file moved in from another drive keeps its owner, who is
+		// charged for its versions
+		file, err = q.LockFile(ctx, existing.ID)
+		if err != nil {
+			return database.File{}, fmt.Errorf("failed to lock file: %w", err)
+		}
+		latest, err := q.GetLatestFileVersion(ctx, file.ID)
+		if err == nil {
+			version = latest.VersionNumber + 1
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return database.File{}, fmt.Errorf("failed to get latest version: %w", err)
+		}
+	} else {
+		file, err = q.CreateFile(ctx, database.CreateFileParams{
+			Name:             name,
+			OriginalName:     name,
+			MimeType:         upload.MimeType,
+			Size:             blob.Size,
+			StoragePath:      BlobPath(blob.Digest),
+			OwnerID:          ownerID,
+			ParentFolderID:   upload.FolderID,
+			PreviewAvailable: pgtype.Bool{Bool: false, Valid: true},
+		})
+		if err != nil {
+			return database.File{}, nameError(err, "failed to create file")
+		}
+	}
+
+	file, _, err = addVersion(ctx, q, file, blob, version, upload.UserID, upload.MimeType)
+	if err != nil {
+		return database.File{}, err
+	}
+
+	if err := q.LogActivity(ctx, database.LogActivityParams{
+		UserID:       upload.UserID,
+		FileID:       file.ID,
+		ActivityType: database.ActivityTypeUpload,
+	}); err != nil {
+		return database.File{}, fmt.Errorf("failed to log activity: %w", err)
+	}
+	return file, nil
+}
+
+// addVersion makes blob the content of file as version number version, with
+// the file's row and blob's row locked: it takes the blob reference, records
+// the version and queues the previews and text extraction of the content.
+// Returns the updated file and the new version.
+func addVersion(ctx context.Context, q *database.Queries, file database.File, blob database.Blob, version int32, userID pgtype.UUID, mimeType string) (database.File, database.FileVersion, error) {
+	if err := retainBlob(ctx, q, blob, file.OwnerID); err != nil {
+		return database.File{}, database.FileVersion{}, err
+	}
+
+	blobPath := BlobPath(blob.Digest)
+	created, err := q.CreateFileVersion(ctx, database.CreateFileVersionParams{
+		FileID:        file.ID,
+		VersionNumber: version,
+		StoragePath:   blobPath,
+		Size:          blob.Size,
+		UploadedBy:    userID,
+		BlobDigest:    pgtype.Text{String: blob.Digest, Valid: true},
+	})
+	if err != nil {
+		return database.File{}, database.FileVersion{}, fmt.Errorf("failed to create file version: %w", err)
+	}
+	if err := q.UpdateFileStorageAndVersion(ctx, database.UpdateFileStorageAndVersionParams{
+		ID:               file.ID,
+		StoragePath:      blobPath,
+		Size:             blob.Size,
+		MimeType:         mimeType,
+		Version:          pgtype.Int4{Int32: version, Valid: true},
+		CurrentVersionID: created.ID,
+	}); err != nil {
+		return database.File{}, database.FileVersion{}, fmt.Errorf("failed to update file: %w", err)
+	}
+	file.StoragePath = blobPath
+	file.Size = blob.Size
+	file.MimeType = mimeType
+	file.Version = pgtype.Int4{Int32: version, Valid: true}
+	file.CurrentVersionID = created.ID
+
+	// Thumbnails, previews and the searchable text are extracted in the
+	// background once the transaction commits
+	if _, err := q.EnqueueJob(ctx, previewJobParams(file.ID, version)); err != nil {
+		return database.File{}, database.FileVersion{}, fmt.Errorf("failed to queue preview: %w", err)
+	}
+	if contentKindOf(file.MimeType, file.Name) != contentNone {
+		if _, err := q.EnqueueJob(ctx, contentJobParams(file.ID, version)); err != nil {
+			return database.File{}, database.FileVersion{}, fmt.Errorf("failed to queue text extraction: %w", err)
+		}
+	} else if err := q.DeleteFileContent(ctx, file.ID); err != nil {
+		return database.File{}, database.FileVersion{}, fmt.Errorf("failed to delete file content: %w", err)
+	}
+	return file, created, nil
+}
+
+// RestoreVersion makes an old version of file current again by adding a new
+// version with the same content, recorded by userID. The content is shared
+// with the old version, which charges the owner nothing; a version stored
+// before deduplication is moved into the blob store first and has to fit in
+// the owner's quota. Returns ErrVersionCurrent when the version is already
+// current and ErrContentMissing when its content is not in storage.
+func (s *UploadService) RestoreVersion(ctx context.Context, userID pgtype.UUID, file database.File, version database.FileVersion) (database.FileVersion, error) {
+	if version.VersionNumber == file.Version.Int32 {
+		return database.FileVersion{}, ErrVersionCurrent
+	}
+	// Restoring the content fsck found missing would restore a broken file
+	if file.BrokenAt.Valid && version.StoragePath == file.StoragePath {
+		return database.FileVersion{}, fmt.Errorf("%w: %s", ErrContentMissing, file.Name)
+	}
+
+	var staged *StagedBlob
+	if version.BlobDigest.Valid {
+		if _, err := s.storage.GetFileSize(ctx, version.StoragePath); errors.Is(err, ErrObjectNotFound) {
+			return database.FileVersion{}, fmt.Errorf("%w: %s", ErrContentMissing, file.Name)
+		} else if err != nil {
+			return database.FileVersion{}, fmt.Errorf("failed to check version content: %w", err)
+		}
+	} else {
+		content, err := s.storage.GetFile(ctx, version.StoragePath)
+		if errors.Is(err, ErrObjectNotFound) {
+			return database.FileVersion{}, fmt.Errorf("%w: %s", ErrContentMissing, file.Name)
+		}
+		if err != nil {
+			return database.FileVersion{}, fmt.Errorf("failed to open %s: %w", version.StoragePath, err)
+		}
+		blob, err := s.storage.StageBlob(ctx, content)
+		content.Close()
+		if err != nil {
+			return database.FileVersion{}, fmt.Errorf("failed to stage version content: %w", err)
+		}
+		staged = &blob
+	}
+	committed := false
+	defer func() {
+		if staged != nil && !committed {
+			s.storage.DiscardBlob(ctx, *staged)
+		}
+	}()
+
+	var restored database.FileVersion
+	err := database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		// Blob, owner and file rows are locked in the order uploads lock them
+		var blob database.Blob
+		var err error
+		if staged != nil {
+			blob, err = q.UpsertBlob(ctx, database.UpsertBlobParams{
+				Digest: staged.Digest,
+				Size:   staged.Size,
+			})
+		} else {
+			blob, err = q.LockBlob(ctx, version.BlobDigest.String)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("%w: blob %s", ErrContentMissing, version.BlobDigest.String)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock blob: %w", err)
+		}
+
+		if _, err := q.LockUserQuota(ctx, file.OwnerID); err != nil {
+			return fmt.Errorf("failed to lock quota: %w", err)
+		}
+		usage, err := q.GetQuotaUsage(ctx, file.OwnerID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota usage: %w", err)
+		}
+		charged, err := q.GetUnreferencedBlobBytes(ctx, database.GetUnreferencedBlobBytesParams{
+			Digests: []string{blob.Digest},
+			OwnerID: file.OwnerID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get blob size: %w", err)
+		}
+		if err := checkQuota(usage, charged); err != nil {
+			return err
+		}
+
+		// Concurrent restores and uploads of the file take their version
+		// numbers one at a time
+		locked, err := q.LockFile(ctx, file.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrItemNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock file: %w", err)
+		}
+		if locked.Version.Int32 == version.VersionNumber {
+			return ErrVersionCurrent
+		}
+		latest, err := q.GetLatestVersionNumber(ctx, file.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get latest version: %w", err)
+		}
+
+		locked, restored, err = addVersion(ctx, q, locked, blob, latest+1, userID, locked.MimeType)
+		if err != nil {
+			return err
+		}
+		// The restored content is known to exist
+		if locked.BrokenAt.Valid {
+			if err := q.SetFileBroken(ctx, database.SetFileBrokenParams{ID: file.ID, Broken: false}); err != nil {
+				return fmt.Errorf("failed to clear broken mark: %w", err)
+			}
+		}
+
+		if err := q.LogActivity(ctx, database.LogActivityParams{
+			UserID:       userID,
+			FileID:       file.ID,
+			ActivityType: database.ActivityTypeRestore,
+			Details:      json.RawMessage(fmt.Sprintf(`{"restored_version": %d, "new_version": %d}`, version.VersionNumber, restored.VersionNumber)),
+		}); err != nil {
+			return fmt.Errorf("failed to log activity: %w", err)
+		}
+
+		if staged != nil {
+			if _, err := s.storage.CommitBlob(ctx, *staged); err != nil {
+				return fmt.Errorf("failed to store blob: %w", err)
+			}
+			committed = true
+		}
+		return nil
+	})
+	if err != nil {
+		return database.FileVersion{}, err
+	}
+
+	s.jobs.notify()
+	return restored, nil
+}
+
+// RecoveryReport describes what Recover cleaned up
+type RecoveryReport struct {
+	// Objects counts staged and partly written objects removed from storage
+	Objects int
+	// FilesRemoved counts files an upload created without recording any
+	// version of their content
+	FilesRemoved int
+	// FilesRolledBack counts files an upload switched to content it did not
+	// record as a version, returned to their newest version
+	FilesRolledBack int
+}
+
+// Recover removes what uploads interrupted by a crash left behind: staged
+// and partly written objects, and the rows of files whose upload stopped
+// halfway before uploads ran in one transaction. Anything changed in the
+// last abandonedUploadAge is left alone. It runs when the server starts.
+func (s *UploadService) Recover(ctx context.Context) (RecoveryReport, error) {
+	var report RecoveryReport
+	cutoff := time.Now().Add(-abandonedUploadAge)
+
+	removed, err := s.storage.RemoveAbandoned(ctx, cutoff)
+	report.Objects = removed
+	if err != nil {
+		return report, err
+	}
+
+	files, err := s.queries.GetInterruptedUploads(ctx, pgtype.Timestamp{Time: cutoff, Valid: true})
+	if err != nil {
+		return report, fmt.Errorf("failed to get interrupted uploads: %w", err)
+	}
+	for _, file := range files {
+		rolledBack, err := s.recoverFile(ctx, file)
+		switch {
+		case errors.Is(err, errUploadFinished):
+		case err != nil:
+			fmt.Printf("Warning: failed to recover upload of file %s: %v\n", file.ID.Bytes, err)
+		case rolledBack:
+			report.FilesRolledBack++
+		default:
+			report.FilesRemoved++
+		}
+	}
+	return report, nil
+}
+
+// recoverFile drops the blob reference an interrupted upload took for file's
+// content, then returns the file to its newest version, or deletes it if it
+// has none
+func (s *UploadService) recoverFile(ctx context.Context, file database.File) (rolledBack bool, err error) {
+	digest, _ := blobDigest(file.StoragePath)
+
+	var purged purgedContent
+	err = database.ExecTx(ctx, s.db, func(q *database.Queries) error {
+		// Blob and owner rows are locked before the file's, as uploads do
+		_, unreferenced, err := s.blobs.release(ctx, q, digest, file.OwnerID)
+		if err != nil {
+			return err
+		}
+
+		locked, err := q.LockFile(ctx, file.ID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errUploadFinished
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock file: %w", err)
+		}
+		if locked.StoragePath != file.StoragePath || locked.Version != file.Version {
+			return errUploadFinished
+		}
+
+		latest, err := q.GetLatestFileVersion(ctx, file.ID)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			purged, err = s.trash.purgeFileRows(ctx, q, locked)
+			if err != nil {
+				return err
+			}
+			if err := s.blobs.UnlinkBlobs(ctx, purged.released); err != nil {
+				return err
+			}
+		case err != nil:
+			return fmt.Errorf("failed to get latest version: %w", err)
+		case locked.Version.Valid && locked.Version.Int32 <= latest.VersionNumber:
+			return errUploadFinished
+		default:
+			if err := q.UpdateFileStorageAndVersion(ctx, database.UpdateFileStorageAndVersionParams{
+				ID:               file.ID,
+				StoragePath:      latest.StoragePath,
+				Size:             latest.Size,
+				MimeType:         locked.MimeType,
+				Version:          pgtype.Int4{Int32: latest.VersionNumber, Valid: true},
+				CurrentVersionID: latest.ID,
+			}); err != nil {
+				return fmt.Errorf("failed to roll back file: %w", err)
+			}
+			// Derived data may have been generated from the unrecorded content
+			if _, err := q.EnqueueJob(ctx, previewJobParams(file.ID, latest.VersionNumber)); err != nil {
+				return fmt.Errorf("failed to queue preview: %w", err)
+			}
+			if contentKindOf(locked.MimeType, locked.Name) != contentNone {
+				if _, err := q.EnqueueJob(ctx, contentJobParams(file.ID, latest.VersionNumber)); err != nil {
+					return fmt.Errorf("failed to queue text extraction: %w", err)
+				}
+			}
+			rolledBack = true
+		}
+
+		if unreferenced {
+			return s.blobs.unlink(ctx, digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if rolledBack {
+		s.jobs.notify()
+	} else {
+		s.trash.removePurged(ctx, purged)
+	}
+	return rolledBack, nil
+}
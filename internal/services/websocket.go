@@ -50,6 +50,9 @@ type Hub struct {
 
 	// Database queries for validation
 	queries *database.Queries
+
+	// Resolves access to the file a client subscribes to
+	permissions *PermissionService
 }
 
 // Message represents a WebSocket message
@@ -62,13 +65,14 @@ type Message struct {
 }
 
 // NewHub creates a new Hub instance
-func NewHub(queries *database.Queries) *Hub {
+func NewHub(queries *database.Queries, permissions *PermissionService) *Hub {
 	return &Hub{
-		clients:    make(map[uuid.UUID]map[*Client]bool),
-		broadcast:  make(chan *Message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		queries:    queries,
+		clients:     make(map[uuid.UUID]map[*Client]bool),
+		broadcast:   make(chan *Message),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		queries:     queries,
+		permissions: permissions,
 	}
 }
 
@@ -229,25 +233,13 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, fileID uuid.UUID)
 		return
 	}
 
-	// Check if user has access
-	hasAccess := file.OwnerID.Bytes == session.UserID.Bytes
-	if !hasAccess {
-		// Check if file has been shared with user
-		perms, err := h.queries.GetItemPermissions(r.Context(), database.GetItemPermissionsParams{
-			ItemID:   pgtype.UUID{Bytes: fileID, Valid: true},
-			ItemType: database.ItemTypeFile,
-		})
-		if err == nil {
-			for _, perm := range perms {
-				if perm.UserID.Bytes == session.UserID.Bytes {
-					hasAccess = true
-					break
-				}
-			}
-		}
+	// Viewers and above can follow a file's comments
+	allowed, err := h.permissions.Can(r.Context(), session.UserID, database.ItemTypeFile, file.ID, ActionRead)
+	if err != nil {
+		http.Error(w, "failed to check permissions", http.StatusInternalServerError)
+		return
 	}
-
-	if !hasAccess {
+	if !allowed {
 		http.Error(w, "access denied", http.StatusForbidden)
 		return
 	}
@@ -4,7 +4,7 @@ VALUES ($1, $2, $3)
 RETURNING *;
 
 -- name: GetSessionByToken :one
-SELECT s.id, s.user_id, s.token, s.expires_at, s.created_at, u.email, u.name
+SELECT s.id, s.user_id, s.token, s.expires_at, s.created_at, u.email, u.name, u.is_admin
 FROM sessions s
 JOIN users u ON s.user_id = u.id
 WHERE s.token = $1 AND s.expires_at > NOW();
@@ -0,0 +1,47 @@
+-- name: UpsertBlob :one
+-- Inserts the blob row or locks the existing one for the rest of the transaction
+INSERT INTO blobs (digest, size)
+VALUES ($1, $2)
+ON CONFLICT (digest) DO UPDATE SET size = EXCLUDED.size
+RETURNING *;
+
+-- name: LockBlob :one
+SELECT * FROM blobs
+WHERE digest = $1
+FOR UPDATE;
+
+-- name: GetBlob :one
+SELECT * FROM blobs WHERE digest = $1;
+
+-- name: RetainBlobRef :one
+INSERT INTO blob_refs (digest, owner_id, ref_count)
+VALUES ($1, $2, 1)
+ON CONFLICT (digest, owner_id) DO UPDATE SET ref_count = blob_refs.ref_count + 1
+RETURNING ref_count;
+
+-- name: ReleaseBlobRef :one
+UPDATE blob_refs
+SET ref_count = ref_count - 1
+WHERE digest = $1 AND owner_id = $2 AND ref_count > 0
+RETURNING ref_count;
+
+-- name: DeleteBlobRef :exec
+DELETE FROM blob_refs
+WHERE digest = $1 AND owner_id = $2 AND ref_count = 0;
+
+-- name: DeleteUnreferencedBlob :execrows
+DELETE FROM blobs b
+WHERE b.digest = $1
+  AND NOT EXISTS (SELECT 1 FROM blob_refs r WHERE r.digest = b.digest)
+  AND NOT EXISTS (SELECT 1 FROM file_versions v WHERE v.blob_digest = b.digest);
+
+-- name: GetUnreferencedBlobBytes :one
+-- Sums the sizes of the given blobs that owner_id holds no reference on,
+-- which is what taking a reference on each would charge the owner
+SELECT COALESCE(SUM(b.size), 0)::bigint AS bytes
+FROM blobs b
+WHERE b.digest = ANY(sqlc.arg('digests')::text[])
+  AND NOT EXISTS (
+      SELECT 1 FROM blob_refs r
+      WHERE r.digest = b.digest AND r.owner_id = sqlc.arg('owner_id')::uuid
+  );
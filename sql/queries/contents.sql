@@ -0,0 +1,23 @@
+-- name: SetFileContent :execrows
+-- Stores the text extracted from a version of a file. Affects no rows once
+-- the file has moved on to another version.
+INSERT INTO file_contents (file_id, version, content)
+SELECT f.id, sqlc.arg('version')::int, sqlc.arg('content')::text
+FROM files f
+WHERE f.id = sqlc.arg('file_id') AND COALESCE(f.version, 1) = sqlc.arg('version')::int
+ON CONFLICT (file_id) DO UPDATE
+SET version = EXCLUDED.version,
+    content = EXCLUDED.content,
+    extracted_at = NOW();
+
+-- name: DeleteFileContent :exec
+DELETE FROM file_contents WHERE file_id = $1;
+
+-- name: GetContentSnippets :many
+-- Excerpts of the files' text around the words of a websearch query, for
+-- files whose text matches it
+SELECT c.file_id,
+       ts_headline('english', c.content, websearch_to_tsquery('english', sqlc.arg('query')::text), sqlc.arg('options')::text)::text AS snippet
+FROM file_contents c
+WHERE c.file_id = ANY(sqlc.arg('file_ids')::uuid[])
+  AND c.search_vector @@ websearch_to_tsquery('english', sqlc.arg('query')::text);
@@ -12,10 +12,13 @@ SELECT * FROM files WHERE id = $1 AND status = 'active';
 -- name: GetFileByIDAnyStatus :one
 SELECT * FROM files WHERE id = $1;
 
+-- name: LockFile :one
+SELECT * FROM files WHERE id = $1 FOR UPDATE;
+
 -- name: GetFilesByFolder :many
+-- Lists every file in the folder whoever owns it; callers check access to the folder
 SELECT * FROM files
-WHERE owner_id = $1
-  AND parent_folder_id = $2
+WHERE parent_folder_id = $1
   AND status = 'active'
 ORDER BY created_at DESC;
 
@@ -44,9 +47,14 @@ WHERE owner_id = $1 AND is_starred = TRUE AND status = 'active'
 ORDER BY updated_at DESC;
 
 -- name: GetTrashedFiles :many
-SELECT * FROM files
-WHERE owner_id = $1 AND status = 'trashed'
-ORDER BY trashed_at DESC;
+-- Files trashed together with their folder are listed through the folder
+SELECT * FROM files f
+WHERE f.owner_id = $1 AND f.status = 'trashed'
+  AND NOT EXISTS (
+      SELECT 1 FROM folders p
+      WHERE p.id = f.parent_folder_id AND p.trash_operation_id = f.trash_operation_id
+  )
+ORDER BY f.trashed_at DESC;
 
 -- name: RenameFile :exec
 UPDATE files
@@ -55,29 +63,40 @@ WHERE id = $1;
 
 -- name: MoveFile :exec
 UPDATE files
-SET parent_folder_id = $2, updated_at = NOW()
+SET parent_folder_id = $2, name = $3, updated_at = NOW()
 WHERE id = $1;
 
 -- name: TrashFile :exec
 UPDATE files
-SET status = 'trashed', trashed_at = NOW()
+SET status = 'trashed', trashed_at = NOW(), trash_operation_id = $2
 WHERE id = $1;
 
 -- name: RestoreFile :exec
 UPDATE files
-SET status = 'active', trashed_at = NULL
+SET status = 'active', trashed_at = NULL, trash_operation_id = NULL, parent_folder_id = $2, name = $3
 WHERE id = $1;
 
 -- name: PermanentDeleteFile :exec
-UPDATE files
-SET status = 'deleted'
-WHERE id = $1;
+-- Removes the file row along with the grants and share links that point at it.
+-- Versions, comments and activity go with the row through their foreign keys.
+WITH revoked AS (
+    DELETE FROM permissions p WHERE p.item_type = 'file' AND p.item_id = sqlc.arg('id')::uuid
+), unlinked AS (
+    DELETE FROM shares s WHERE s.item_type = 'file' AND s.item_id = sqlc.arg('id')::uuid
+)
+DELETE FROM files f
+WHERE f.id = sqlc.arg('id')::uuid;
 
 -- name: ToggleStarFile :exec
 UPDATE files
 SET is_starred = NOT is_starred, updated_at = NOW()
 WHERE id = $1;
 
+-- name: SetFileStarred :exec
+UPDATE files
+SET is_starred = $2, updated_at = NOW()
+WHERE id = $1;
+
 -- name: UpdateLastAccessed :exec
 UPDATE files
 SET last_accessed_at = NOW()
@@ -98,6 +117,9 @@ WHERE owner_id = $1
   AND mime_type LIKE $2 || '%'
 ORDER BY updated_at DESC;
 
+-- name: GetFilesByIDs :many
+SELECT * FROM files WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
 -- name: GetFilesInTrashOlderThan :many
 SELECT * FROM files
 WHERE status = 'trashed'
@@ -105,10 +127,11 @@ WHERE status = 'trashed'
 ORDER BY trashed_at ASC;
 
 -- name: GetFileByNameAndFolder :one
+-- Files in a folder may belong to other users; owner_id only scopes the top
+-- of a drive
 SELECT * FROM files
-WHERE owner_id = $1
-  AND name = $2
-  AND (parent_folder_id = $3 OR (parent_folder_id IS NULL AND $3 IS NULL))
+WHERE name = $2
+  AND (parent_folder_id = $3 OR (parent_folder_id IS NULL AND $3 IS NULL AND owner_id = $1))
   AND status = 'active'
 LIMIT 1;
 
@@ -121,3 +144,18 @@ SET storage_path = $2,
     current_version_id = $6,
     updated_at = NOW()
 WHERE id = $1;
+
+-- name: GetRootFileStats :one
+-- Counts the active files at the top of owner_id's drive and their total size
+SELECT COUNT(*)::bigint AS file_count, COALESCE(SUM(size), 0)::bigint AS size
+FROM files
+WHERE owner_id = $1 AND parent_folder_id IS NULL AND status = 'active';
+
+-- name: GetChildFileNames :many
+-- Lists the names of the active files directly in folder_id, or at the top of
+-- owner_id's drive when folder_id is null, leaving out exclude_id
+SELECT name FROM files
+WHERE status = 'active'
+  AND (parent_folder_id = sqlc.narg('folder_id')::uuid
+       OR (sqlc.narg('folder_id')::uuid IS NULL AND parent_folder_id IS NULL AND owner_id = sqlc.arg('owner_id')::uuid))
+  AND (sqlc.narg('exclude_id')::uuid IS NULL OR id <> sqlc.narg('exclude_id')::uuid);
@@ -9,6 +9,9 @@ SELECT * FROM folders WHERE id = $1 AND status = 'active';
 -- name: GetFolderByIDAnyStatus :one
 SELECT * FROM folders WHERE id = $1;
 
+-- name: GetFoldersByIDs :many
+SELECT * FROM folders WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
 -- name: GetSubfolders :many
 SELECT * FROM folders
 WHERE parent_folder_id = $1 AND status = 'active'
@@ -26,28 +29,23 @@ WHERE id = $1;
 
 -- name: MoveFolder :exec
 UPDATE folders
-SET parent_folder_id = $2, updated_at = NOW()
-WHERE id = $1;
-
--- name: TrashFolder :exec
-UPDATE folders
-SET status = 'trashed', trashed_at = NOW()
+SET parent_folder_id = $2, name = $3, updated_at = NOW()
 WHERE id = $1;
 
 -- name: RestoreFolder :exec
 UPDATE folders
-SET status = 'active', trashed_at = NULL
-WHERE id = $1;
-
--- name: PermanentDeleteFolder :exec
-UPDATE folders
-SET status = 'deleted'
+SET status = 'active', trashed_at = NULL, trash_operation_id = NULL, parent_folder_id = $2, name = $3
 WHERE id = $1;
 
 -- name: GetTrashedFolders :many
-SELECT * FROM folders
-WHERE owner_id = $1 AND status = 'trashed'
-ORDER BY trashed_at DESC;
+-- Subfolders trashed together with their parent are listed through the parent
+SELECT * FROM folders f
+WHERE f.owner_id = $1 AND f.status = 'trashed'
+  AND NOT EXISTS (
+      SELECT 1 FROM folders p
+      WHERE p.id = f.parent_folder_id AND p.trash_operation_id = f.trash_operation_id
+  )
+ORDER BY f.trashed_at DESC;
 
 -- name: GetFoldersByOwner :many
 SELECT * FROM folders
@@ -72,8 +70,146 @@ UPDATE folders
 SET is_starred = NOT is_starred, updated_at = NOW()
 WHERE id = $1;
 
+-- name: SetFolderStarred :exec
+UPDATE folders
+SET is_starred = $2, updated_at = NOW()
+WHERE id = $1;
+
 -- name: GetFoldersInTrashOlderThan :many
 SELECT * FROM folders
 WHERE status = 'trashed'
   AND trashed_at < NOW() - INTERVAL '1 day' * $1
 ORDER BY trashed_at ASC;
+
+-- name: GetActiveSubtreeFolders :many
+-- Lists folder_id and every active folder below it, parents before children.
+-- ids stops the walk if folders form a cycle.
+WITH RECURSIVE tree (id, depth, ids) AS (
+    SELECT f.id, 0, ARRAY[f.id]
+    FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid AND f.status = 'active'
+    UNION ALL
+    SELECT child.id, tree.depth + 1, tree.ids || child.id
+    FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.status = 'active' AND child.id <> ALL(tree.ids)
+)
+SELECT f.*
+FROM folders f
+JOIN tree ON f.id = tree.id
+ORDER BY tree.depth, f.name;
+
+-- name: GetActiveSubtreeFiles :many
+-- Lists the active files in folder_id and in every active folder below it
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid AND f.status = 'active'
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.status = 'active'
+)
+SELECT * FROM files
+WHERE parent_folder_id IN (SELECT id FROM tree) AND status = 'active'
+ORDER BY name;
+
+-- name: LockFolder :one
+-- Serializes changes to a folder's children; hold it for the rest of the transaction
+SELECT id FROM folders WHERE id = $1 AND status = 'active' FOR UPDATE;
+
+-- name: GetChildFolderByName :one
+-- Finds the oldest active folder called name directly in folder_id, or at the
+-- top of owner_id's drive when folder_id is null
+SELECT * FROM folders
+WHERE status = 'active' AND is_root IS NOT TRUE AND name = sqlc.arg('name')
+  AND (parent_folder_id = sqlc.narg('folder_id')::uuid
+       OR (sqlc.narg('folder_id')::uuid IS NULL AND parent_folder_id IS NULL AND owner_id = sqlc.arg('owner_id')::uuid))
+ORDER BY created_at
+LIMIT 1;
+
+-- name: GetFolderAncestors :many
+-- Lists folder_id and every folder above it, top-most first, whatever their
+-- status. ids stops the walk if folders form a cycle.
+WITH RECURSIVE chain (id, parent_folder_id, depth, ids) AS (
+    SELECT f.id, f.parent_folder_id, 0, ARRAY[f.id]
+    FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid
+    UNION ALL
+    SELECT parent.id, parent.parent_folder_id, chain.depth + 1, chain.ids || parent.id
+    FROM folders parent
+    JOIN chain ON parent.id = chain.parent_folder_id
+    WHERE parent.id <> ALL(chain.ids)
+)
+SELECT f.*
+FROM folders f
+JOIN chain ON f.id = chain.id
+ORDER BY chain.depth DESC;
+
+-- name: ResolveFolderPath :one
+-- Follows names down from folder_id, or from the top of owner_id's drive when
+-- folder_id is null, to the active folder they lead to. Among folders with
+-- the same name the oldest is taken.
+WITH RECURSIVE walk (id, depth, created) AS (
+    SELECT f.id, 1, ARRAY[f.created_at]
+    FROM folders f
+    WHERE f.status = 'active' AND f.is_root IS NOT TRUE
+      AND f.name = (sqlc.arg('names')::text[])[1]
+      AND (f.parent_folder_id = sqlc.narg('folder_id')::uuid
+           OR (sqlc.narg('folder_id')::uuid IS NULL AND f.parent_folder_id IS NULL AND f.owner_id = sqlc.arg('owner_id')::uuid))
+    UNION ALL
+    SELECT child.id, walk.depth + 1, walk.created || child.created_at
+    FROM folders child
+    JOIN walk ON child.parent_folder_id = walk.id
+    WHERE child.status = 'active'
+      AND walk.depth < cardinality(sqlc.arg('names')::text[])
+      AND child.name = (sqlc.arg('names')::text[])[walk.depth + 1]
+)
+SELECT f.*
+FROM folders f
+JOIN walk ON f.id = walk.id
+WHERE walk.depth = cardinality(sqlc.arg('names')::text[])
+ORDER BY walk.created
+LIMIT 1;
+
+-- name: GetFolderTreeStats :many
+-- Lists folder_id and every active folder below it, or every active folder of
+-- owner_id's drive when folder_id is null, parents first, with the number and
+-- total size of the active files directly in each. ids stops the walk if
+-- folders form a cycle.
+WITH RECURSIVE tree (id, depth, ids) AS (
+    SELECT f.id, 0, ARRAY[f.id]
+    FROM folders f
+    WHERE f.status = 'active'
+      AND (f.id = sqlc.narg('folder_id')::uuid
+           OR (sqlc.narg('folder_id')::uuid IS NULL AND f.parent_folder_id IS NULL
+               AND f.is_root IS NOT TRUE AND f.owner_id = sqlc.arg('owner_id')::uuid))
+    UNION ALL
+    SELECT child.id, tree.depth + 1, tree.ids || child.id
+    FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.status = 'active' AND child.id <> ALL(tree.ids)
+)
+SELECT f.id, f.name, f.parent_folder_id, f.owner_id, f.updated_at,
+       tree.depth::int AS depth, stats.file_count, stats.size
+FROM folders f
+JOIN tree ON f.id = tree.id
+CROSS JOIN LATERAL (
+    SELECT COUNT(*)::bigint AS file_count, COALESCE(SUM(fi.size), 0)::bigint AS size
+    FROM files fi
+    WHERE fi.parent_folder_id = f.id AND fi.status = 'active'
+) stats
+ORDER BY tree.depth, f.name;
+
+-- name: GetChildFolderNames :many
+-- Lists the names of the active folders directly in folder_id, or at the top
+-- of owner_id's drive when folder_id is null, leaving out exclude_id
+SELECT name FROM folders
+WHERE status = 'active' AND is_root IS NOT TRUE
+  AND (parent_folder_id = sqlc.narg('folder_id')::uuid
+       OR (sqlc.narg('folder_id')::uuid IS NULL AND parent_folder_id IS NULL AND owner_id = sqlc.arg('owner_id')::uuid))
+  AND (sqlc.narg('exclude_id')::uuid IS NULL OR id <> sqlc.narg('exclude_id')::uuid);
+
+-- name: LockFolderMoves :exec
+-- Serializes folder moves, so two moves cannot each pass the cycle check and
+-- together form a loop; held until the transaction ends
+SELECT pg_advisory_xact_lock(hashtext('folder_moves'));
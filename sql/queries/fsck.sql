@@ -0,0 +1,94 @@
+-- name: ListBlobsForCheck :many
+SELECT b.digest, b.size,
+       EXISTS (SELECT 1 FROM file_versions v WHERE v.blob_digest = b.digest) AS in_use
+FROM blobs b
+ORDER BY b.digest;
+
+-- name: ListFilesForCheck :many
+SELECT id, owner_id, name, storage_path, size, thumbnail_path, broken_at
+FROM files
+ORDER BY id;
+
+-- name: ListLegacyVersionsForCheck :many
+-- Versions stored before deduplication own their object directly
+SELECT v.id, v.file_id, v.storage_path, v.size
+FROM file_versions v
+WHERE v.blob_digest IS NULL
+ORDER BY v.id;
+
+-- name: ListPendingUploadSessionIDs :many
+SELECT id FROM upload_sessions WHERE status = 'pending';
+
+-- name: GetBlobRefMismatches :many
+-- Compares blob_refs with the versions that actually use each blob
+WITH expected AS (
+    SELECT v.blob_digest AS digest, f.owner_id, COUNT(*)::int AS ref_count
+    FROM file_versions v
+    JOIN files f ON f.id = v.file_id
+    WHERE v.blob_digest IS NOT NULL
+    GROUP BY v.blob_digest, f.owner_id
+)
+SELECT COALESCE(e.digest, r.digest)::text AS digest,
+       COALESCE(e.owner_id, r.owner_id)::uuid AS owner_id,
+       COALESCE(r.ref_count, 0)::int AS recorded,
+       COALESCE(e.ref_count, 0)::int AS expected
+FROM expected e
+FULL JOIN blob_refs r ON r.digest = e.digest AND r.owner_id = e.owner_id
+WHERE COALESCE(r.ref_count, 0) <> COALESCE(e.ref_count, 0)
+ORDER BY 1, 2;
+
+-- name: GetComputedStorageUsage :many
+-- Recomputes storage_used from the versions each user owns: every distinct
+-- blob once, plus every version stored before deduplication
+SELECT u.id, u.email,
+       COALESCE(u.storage_used, 0)::bigint AS recorded,
+       (
+           COALESCE((
+               SELECT SUM(b.size)
+               FROM blobs b
+               WHERE b.digest IN (
+                   SELECT v.blob_digest
+                   FROM file_versions v
+                   JOIN files f ON f.id = v.file_id
+                   WHERE f.owner_id = u.id
+               )
+           ), 0)
+           + COALESCE((
+               SELECT SUM(v.size)
+               FROM file_versions v
+               JOIN files f ON f.id = v.file_id
+               WHERE f.owner_id = u.id AND v.blob_digest IS NULL
+           ), 0)
+       )::bigint AS computed
+FROM users u
+ORDER BY u.email;
+
+-- name: SetBlobRefCount :exec
+INSERT INTO blob_refs (digest, owner_id, ref_count)
+VALUES ($1, $2, $3)
+ON CONFLICT (digest, owner_id) DO UPDATE SET ref_count = EXCLUDED.ref_count;
+
+-- name: DeleteBlobRefForRepair :exec
+DELETE FROM blob_refs WHERE digest = $1 AND owner_id = $2;
+
+-- name: SetUserStorageUsed :exec
+UPDATE users
+SET storage_used = $2,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetFileBroken :exec
+UPDATE files
+SET broken_at = CASE WHEN sqlc.arg('broken')::boolean THEN COALESCE(broken_at, NOW()) END
+WHERE id = sqlc.arg('id');
+
+-- name: ClearFileThumbnail :exec
+UPDATE files
+SET thumbnail_path = NULL
+WHERE id = $1;
+
+-- name: ListPreviewsForCheck :many
+SELECT p.file_id, p.size, p.path, COALESCE(f.version, 1)::int AS file_version
+FROM file_previews p
+JOIN files f ON f.id = p.file_id
+ORDER BY p.file_id, p.size;
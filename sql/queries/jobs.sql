@@ -0,0 +1,150 @@
+-- name: EnqueueJob :one
+-- A job with a unique_key that is already pending is updated instead: it
+-- takes the new payload and runs no later than the new run_after
+INSERT INTO jobs (kind, payload, unique_key, max_attempts, run_after)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL AND status = 'pending' DO UPDATE
+SET payload = EXCLUDED.payload,
+    max_attempts = EXCLUDED.max_attempts,
+    run_after = LEAST(jobs.run_after, EXCLUDED.run_after),
+    attempts = 0,
+    last_error = NULL,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: ClaimJob :one
+-- Takes the next due job of the given kinds, or a running one whose worker
+-- stopped heartbeating before stale_before
+UPDATE jobs
+SET status = 'running',
+    attempts = attempts + 1,
+    locked_by = sqlc.arg('worker')::text,
+    locked_at = NOW(),
+    updated_at = NOW()
+WHERE id = (
+    SELECT j.id FROM jobs j
+    WHERE j.kind = ANY(sqlc.arg('kinds')::text[])
+      AND ((j.status = 'pending' AND j.run_after <= NOW())
+        OR (j.status = 'running' AND j.locked_at < sqlc.arg('stale_before')::timestamp))
+    ORDER BY j.run_after
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: HeartbeatJob :exec
+UPDATE jobs SET locked_at = NOW()
+WHERE id = $1 AND attempts = $2 AND status = 'running';
+
+-- name: CompleteJob :exec
+-- Every outcome query matches the attempt, so a worker whose job was
+-- claimed again after it stalled cannot overwrite the newer attempt
+UPDATE jobs
+SET status = 'succeeded',
+    last_error = NULL,
+    locked_by = NULL,
+    locked_at = NULL,
+    finished_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND attempts = $2 AND status = 'running';
+
+-- name: RetryJob :execrows
+-- Affects no rows when the same key was enqueued again meanwhile
+UPDATE jobs
+SET status = 'pending',
+    last_error = $3,
+    run_after = $4,
+    locked_by = NULL,
+    locked_at = NULL,
+    updated_at = NOW()
+WHERE jobs.id = $1 AND jobs.attempts = $2 AND jobs.status = 'running'
+  AND NOT EXISTS (
+      SELECT 1 FROM jobs p
+      WHERE p.unique_key = jobs.unique_key AND p.status = 'pending'
+  );
+
+-- name: DeleteSupersededJob :exec
+-- Drops a job whose retry is covered by a pending job with the same key
+DELETE FROM jobs
+WHERE id = $1 AND attempts = $2 AND status = 'running';
+
+-- name: FailJob :exec
+UPDATE jobs
+SET status = 'failed',
+    last_error = $3,
+    locked_by = NULL,
+    locked_at = NULL,
+    finished_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1 AND attempts = $2 AND status = 'running';
+
+-- name: GetJob :one
+SELECT * FROM jobs WHERE id = $1;
+
+-- name: HasOpenJob :one
+SELECT EXISTS (
+    SELECT 1 FROM jobs
+    WHERE unique_key = $1 AND status IN ('pending', 'running')
+);
+
+-- name: ListJobs :many
+SELECT * FROM jobs
+WHERE (sqlc.narg('status')::job_status IS NULL OR status = sqlc.narg('status')::job_status)
+  AND (sqlc.narg('kind')::text IS NULL OR kind = sqlc.narg('kind')::text)
+ORDER BY created_at DESC
+LIMIT sqlc.arg('max_jobs');
+
+-- name: CountJobs :many
+SELECT kind, status, COUNT(*)::int AS jobs
+FROM jobs
+GROUP BY kind, status
+ORDER BY kind, status;
+
+-- name: RetryFailedJob :one
+-- Returns no row when the job is not failed or its key is already pending
+UPDATE jobs
+SET status = 'pending',
+    attempts = 0,
+    run_after = NOW(),
+    finished_at = NULL,
+    updated_at = NOW()
+WHERE jobs.id = $1 AND jobs.status = 'failed'
+  AND NOT EXISTS (
+      SELECT 1 FROM jobs p
+      WHERE p.unique_key = jobs.unique_key AND p.status = 'pending'
+  )
+RETURNING *;
+
+-- name: DeleteFinishedJobs :execrows
+DELETE FROM jobs
+WHERE (status = 'succeeded' AND finished_at < sqlc.arg('succeeded_before')::timestamp)
+   OR (status = 'failed' AND finished_at < sqlc.arg('failed_before')::timestamp);
+
+-- name: UpsertJobSchedule :exec
+-- Keeps the planned next run unless the schedule itself changed
+INSERT INTO job_schedules (name, kind, payload, spec, next_run_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (name) DO UPDATE
+SET kind = EXCLUDED.kind,
+    payload = EXCLUDED.payload,
+    next_run_at = CASE WHEN job_schedules.spec = EXCLUDED.spec
+                       THEN job_schedules.next_run_at
+                       ELSE EXCLUDED.next_run_at END,
+    spec = EXCLUDED.spec,
+    updated_at = NOW();
+
+-- name: ClaimDueSchedules :many
+SELECT * FROM job_schedules
+WHERE name = ANY(sqlc.arg('names')::text[]) AND next_run_at <= NOW()
+ORDER BY next_run_at
+FOR UPDATE SKIP LOCKED;
+
+-- name: AdvanceJobSchedule :exec
+UPDATE job_schedules
+SET next_run_at = $2,
+    last_run_at = NOW(),
+    updated_at = NOW()
+WHERE name = $1;
+
+-- name: ListJobSchedules :many
+SELECT * FROM job_schedules ORDER BY name;
@@ -0,0 +1,21 @@
+-- name: GetFilePreview :one
+SELECT * FROM file_previews WHERE file_id = $1 AND size = $2;
+
+-- name: GetFilePreviews :many
+SELECT * FROM file_previews WHERE file_id = $1 ORDER BY width;
+
+-- name: DeleteFilePreviews :exec
+DELETE FROM file_previews WHERE file_id = $1;
+
+-- name: CreateFilePreview :exec
+INSERT INTO file_previews (file_id, size, path, width, height, version)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: SetFilePreview :execrows
+-- Records the outcome of preview generation without touching updated_at,
+-- which tracks the file's content. Affects no rows once the file has moved
+-- on to another version.
+UPDATE files
+SET thumbnail_path = $2,
+    preview_available = $3
+WHERE id = $1 AND COALESCE(version, 1) = sqlc.arg('version')::int;
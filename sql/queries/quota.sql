@@ -0,0 +1,44 @@
+-- name: LockUserQuota :one
+-- Serializes reservations for a user; hold it for the rest of the transaction
+SELECT id FROM users WHERE id = $1 FOR UPDATE;
+
+-- name: GetQuotaUsage :one
+-- Reservations of resumable uploads count until their session expires
+SELECT
+    COALESCE(u.storage_used, 0)::bigint AS storage_used,
+    COALESCE(u.storage_limit, 0)::bigint AS storage_limit,
+    COALESCE((
+        SELECT SUM(r.bytes)
+        FROM storage_reservations r
+        LEFT JOIN upload_sessions s ON s.id = r.upload_session_id
+        WHERE r.user_id = u.id
+          AND COALESCE(s.expires_at, r.expires_at) > NOW()
+    ), 0)::bigint AS storage_reserved
+FROM users u
+WHERE u.id = $1;
+
+-- name: CreateStorageReservation :one
+INSERT INTO storage_reservations (user_id, upload_session_id, bytes, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: DeleteStorageReservation :exec
+DELETE FROM storage_reservations WHERE id = $1;
+
+-- name: DeleteUploadReservation :exec
+DELETE FROM storage_reservations WHERE upload_session_id = $1;
+
+-- name: DeleteExpiredStorageReservations :execrows
+DELETE FROM storage_reservations r
+WHERE r.expires_at < NOW()
+  AND (r.upload_session_id IS NULL OR NOT EXISTS (
+      SELECT 1 FROM upload_sessions s
+      WHERE s.id = r.upload_session_id AND s.expires_at > NOW()
+  ));
+
+-- name: SetUserStorageLimit :one
+UPDATE users
+SET storage_limit = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, email, name, storage_used, storage_limit;
@@ -0,0 +1,66 @@
+-- name: UpsertUserRetentionPolicy :one
+INSERT INTO version_retention_policies (user_id, keep_last, keep_days)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) WHERE file_id IS NULL
+DO UPDATE SET keep_last = EXCLUDED.keep_last,
+              keep_days = EXCLUDED.keep_days,
+              updated_at = NOW()
+RETURNING *;
+
+-- name: UpsertFileRetentionPolicy :one
+INSERT INTO version_retention_policies (user_id, file_id, keep_last, keep_days)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (file_id) WHERE file_id IS NOT NULL
+DO UPDATE SET keep_last = EXCLUDED.keep_last,
+              keep_days = EXCLUDED.keep_days,
+              updated_at = NOW()
+RETURNING *;
+
+-- name: GetUserRetentionPolicy :one
+SELECT * FROM version_retention_policies
+WHERE user_id = $1 AND file_id IS NULL;
+
+-- name: GetFileRetentionPolicy :one
+SELECT * FROM version_retention_policies
+WHERE file_id = $1;
+
+-- name: DeleteUserRetentionPolicy :exec
+DELETE FROM version_retention_policies
+WHERE user_id = $1 AND file_id IS NULL;
+
+-- name: DeleteFileRetentionPolicy :exec
+DELETE FROM version_retention_policies
+WHERE file_id = $1;
+
+-- name: GetPrunableVersions :many
+-- Versions outside their effective retention policy. The current version and
+-- pinned versions are never returned. owner_id and file_id optionally narrow the scan.
+WITH ranked AS (
+    SELECT fv.id, fv.file_id, fv.version_number, fv.size, fv.created_at, fv.is_pinned,
+           f.owner_id, f.name AS file_name, f.version AS current_version, f.current_version_id,
+           ROW_NUMBER() OVER (PARTITION BY fv.file_id ORDER BY fv.version_number DESC) AS version_rank
+    FROM file_versions fv
+    JOIN files f ON f.id = fv.file_id
+    WHERE f.status != 'deleted'
+      AND (sqlc.narg('owner_id')::uuid IS NULL OR f.owner_id = sqlc.narg('owner_id')::uuid)
+      AND (sqlc.narg('file_id')::uuid IS NULL OR f.id = sqlc.narg('file_id')::uuid)
+)
+SELECT r.id, r.file_id, r.file_name, r.owner_id, r.version_number, r.size, r.created_at,
+       p.keep_last, p.keep_days
+FROM ranked r
+JOIN LATERAL (
+    SELECT vrp.keep_last, vrp.keep_days
+    FROM version_retention_policies vrp
+    WHERE vrp.file_id = r.file_id
+       OR (vrp.file_id IS NULL AND vrp.user_id = r.owner_id)
+    ORDER BY vrp.file_id NULLS LAST
+    LIMIT 1
+) p ON TRUE
+WHERE NOT r.is_pinned
+  AND r.version_number <> COALESCE(r.current_version, 0)
+  AND r.id IS DISTINCT FROM r.current_version_id
+  AND (p.keep_last IS NOT NULL OR p.keep_days IS NOT NULL)
+  AND (p.keep_last IS NULL OR r.version_rank > p.keep_last)
+  AND (p.keep_days IS NULL OR r.created_at < NOW() - make_interval(days => p.keep_days))
+ORDER BY r.file_id, r.version_number
+LIMIT sqlc.arg('max_versions');
@@ -34,8 +34,11 @@ JOIN users u ON fo.owner_id = u.id
 WHERE p.user_id = $1 AND fo.status = 'active';
 
 -- name: CreateShare :one
-INSERT INTO shares (item_type, item_id, token, created_by, permission, expires_at)
-VALUES ($1, $2, $3, $4, $5, $6)
+INSERT INTO shares (
+    item_type, item_id, token, created_by, permission, expires_at,
+    password_hash, max_downloads, allow_download
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 RETURNING *;
 
 -- name: GetShareByToken :one
@@ -45,4 +48,76 @@ SELECT * FROM shares WHERE token = $1 AND is_active = TRUE;
 UPDATE shares SET is_active = FALSE WHERE id = $1;
 
 -- name: GetSharesByItem :many
-SELECT * FROM shares WHERE item_type = $1 AND item_id = $2 AND is_active = TRUE;
+SELECT *, (password_hash IS NOT NULL)::boolean AS has_password
+FROM shares WHERE item_type = $1 AND item_id = $2 AND is_active = TRUE;
+
+-- name: ConsumeShareDownload :one
+-- Counts one download against the link's limit. No row means the limit is used up.
+UPDATE shares
+SET download_count = download_count + 1
+WHERE id = $1
+  AND (max_downloads IS NULL OR download_count < max_downloads)
+RETURNING download_count;
+
+-- name: GetEffectiveRole :one
+-- Resolves the strongest role a user holds on an item. Owning the item or
+-- any ancestor folder makes the user an owner; otherwise the highest grant on
+-- the item or on any ancestor folder applies. UNION (not UNION ALL) stops the
+-- walk if parent_folder_id ever forms a cycle. No row means no access.
+WITH RECURSIVE chain (item_type, item_id, owner_id, parent_id) AS (
+    SELECT 'file'::item_type, f.id, f.owner_id, f.parent_folder_id
+    FROM files f
+    WHERE sqlc.arg('item_type')::item_type = 'file' AND f.id = sqlc.arg('item_id')::uuid
+    UNION ALL
+    SELECT 'folder'::item_type, fo.id, fo.owner_id, fo.parent_folder_id
+    FROM folders fo
+    WHERE sqlc.arg('item_type')::item_type = 'folder' AND fo.id = sqlc.arg('item_id')::uuid
+    UNION
+    SELECT 'folder'::item_type, parent.id, parent.owner_id, parent.parent_folder_id
+    FROM folders parent
+    JOIN chain c ON parent.id = c.parent_id
+)
+SELECT MAX(grants.role)::permission_role AS role
+FROM (
+    SELECT 'owner'::permission_role AS role
+    FROM chain
+    WHERE chain.owner_id = sqlc.arg('user_id')::uuid
+    UNION ALL
+    SELECT p.role
+    FROM permissions p
+    JOIN chain c ON p.item_type = c.item_type AND p.item_id = c.item_id
+    WHERE p.user_id = sqlc.arg('user_id')::uuid
+) grants
+HAVING MAX(grants.role) IS NOT NULL;
+
+-- name: GetShareByID :one
+SELECT * FROM shares WHERE id = $1;
+
+-- name: IsFolderWithin :one
+-- Reports whether folder_id is root_id or one of its active descendants.
+-- The walk stops at trashed folders, so their contents are out of reach.
+WITH RECURSIVE ancestors (id, parent_folder_id) AS (
+    SELECT f.id, f.parent_folder_id
+    FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid AND f.status = 'active'
+    UNION
+    SELECT parent.id, parent.parent_folder_id
+    FROM folders parent
+    JOIN ancestors a ON parent.id = a.parent_folder_id
+    WHERE parent.status = 'active'
+)
+SELECT EXISTS (
+    SELECT 1 FROM ancestors WHERE id = sqlc.arg('root_id')::uuid
+)::boolean AS within;
+
+-- name: LogShareAccess :exec
+INSERT INTO share_access_log (share_id, action, item_type, item_id, user_id, ip_address, user_agent)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: GetShareAccessLog :many
+SELECT l.*, u.email, u.name AS user_name
+FROM share_access_log l
+LEFT JOIN users u ON l.user_id = u.id
+WHERE l.share_id = $1
+ORDER BY l.accessed_at DESC
+LIMIT $2;
@@ -0,0 +1,114 @@
+-- name: CreateTrashOperation :one
+INSERT INTO trash_operations (user_id, root_type, root_id)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: TrashFolderTree :execrows
+-- Trashes folder_id and every active folder below it as part of operation_id
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid AND f.status = 'active'
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.status = 'active'
+)
+UPDATE folders
+SET status = 'trashed', trashed_at = NOW(), trash_operation_id = sqlc.arg('operation_id')::uuid
+WHERE id IN (SELECT id FROM tree);
+
+-- name: TrashOperationFiles :execrows
+-- Trashes the active files inside the folders trashed by operation_id
+UPDATE files
+SET status = 'trashed', trashed_at = NOW(), trash_operation_id = sqlc.arg('operation_id')::uuid
+WHERE status = 'active'
+  AND parent_folder_id IN (
+      SELECT id FROM folders WHERE trash_operation_id = sqlc.arg('operation_id')::uuid
+  );
+
+-- name: RestoreOperationFiles :execrows
+-- Restores the files trashed by operation_id inside folder_id's part of the
+-- operation. Run before RestoreOperationFolders, which clears the folder marks.
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid AND f.trash_operation_id = sqlc.arg('operation_id')::uuid
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.trash_operation_id = sqlc.arg('operation_id')::uuid
+)
+UPDATE files
+SET status = 'active', trashed_at = NULL, trash_operation_id = NULL
+WHERE trash_operation_id = sqlc.arg('operation_id')::uuid
+  AND parent_folder_id IN (SELECT id FROM tree);
+
+-- name: RestoreOperationFolders :execrows
+-- Restores the folders below folder_id that were trashed by operation_id.
+-- folder_id itself is restored with RestoreFolder, which also sets its parent.
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid AND f.trash_operation_id = sqlc.arg('operation_id')::uuid
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+    WHERE child.trash_operation_id = sqlc.arg('operation_id')::uuid
+)
+UPDATE folders
+SET status = 'active', trashed_at = NULL, trash_operation_id = NULL
+WHERE id IN (SELECT id FROM tree) AND id <> sqlc.arg('folder_id')::uuid;
+
+-- name: GetNearestActiveAncestor :one
+-- Walks up from folder_id (inclusive) to the first folder that is not in trash.
+-- No row means every ancestor is trashed or deleted.
+WITH RECURSIVE chain (id, parent_folder_id, status, depth) AS (
+    SELECT f.id, f.parent_folder_id, f.status, 0
+    FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid
+    UNION ALL
+    SELECT parent.id, parent.parent_folder_id, parent.status, chain.depth + 1
+    FROM folders parent
+    JOIN chain ON parent.id = chain.parent_folder_id
+    WHERE chain.status <> 'active' AND chain.depth < 1000
+)
+SELECT f.*
+FROM folders f
+JOIN chain ON f.id = chain.id
+WHERE chain.status = 'active'
+ORDER BY chain.depth
+LIMIT 1;
+
+-- name: GetSubtreeFilesForDeletion :many
+-- Lists every file below folder_id, whatever its status
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+)
+SELECT * FROM files
+WHERE parent_folder_id IN (SELECT id FROM tree);
+
+-- name: PermanentDeleteFolderTree :execrows
+-- Removes folder_id and every folder below it, along with the grants and share
+-- links that point at them. Files must be purged first: deleting a folder only
+-- detaches the files still in it.
+WITH RECURSIVE tree (id) AS (
+    SELECT f.id FROM folders f
+    WHERE f.id = sqlc.arg('folder_id')::uuid
+    UNION
+    SELECT child.id FROM folders child
+    JOIN tree ON child.parent_folder_id = tree.id
+), revoked AS (
+    DELETE FROM permissions WHERE item_type = 'folder' AND item_id IN (SELECT id FROM tree)
+), unlinked AS (
+    DELETE FROM shares WHERE item_type = 'folder' AND item_id IN (SELECT id FROM tree)
+)
+DELETE FROM folders
+WHERE id IN (SELECT id FROM tree);
+
+-- name: DeleteFinishedTrashOperations :execrows
+-- Removes operations none of whose items are still in trash
+DELETE FROM trash_operations t
+WHERE NOT EXISTS (SELECT 1 FROM files f WHERE f.trash_operation_id = t.id)
+  AND NOT EXISTS (SELECT 1 FROM folders d WHERE d.trash_operation_id = t.id);
@@ -0,0 +1,51 @@
+-- name: CreateUploadSession :one
+INSERT INTO upload_sessions (user_id, filename, mime_type, parent_folder_id, total_size, expires_at, on_conflict)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: GetUploadSession :one
+SELECT * FROM upload_sessions WHERE id = $1;
+
+-- name: LockUploadSession :one
+-- Chunks of a session are accepted one at a time
+SELECT * FROM upload_sessions WHERE id = $1 FOR UPDATE;
+
+-- name: AdvanceUploadSession :execrows
+UPDATE upload_sessions
+SET received_bytes = received_bytes + @chunk_size,
+    chunk_count = chunk_count + 1,
+    expires_at = @expires_at,
+    updated_at = NOW()
+WHERE id = @id
+  AND status = 'pending'
+  AND received_bytes = @expected_offset;
+
+-- name: CompleteUploadSession :execrows
+UPDATE upload_sessions
+SET status = 'completed', file_id = $2, updated_at = NOW()
+WHERE id = $1
+  AND status = 'pending';
+
+-- name: AbortUploadSession :exec
+UPDATE upload_sessions
+SET status = 'aborted', updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetExpiredUploadSessions :many
+SELECT * FROM upload_sessions
+WHERE expires_at < NOW()
+ORDER BY expires_at ASC;
+
+-- name: DeleteUploadSession :exec
+DELETE FROM upload_sessions WHERE id = $1;
+
+-- name: GetInterruptedUploads :many
+-- Files that uploads from before uploads ran in one transaction recorded
+-- without finishing: content in the blob store but no version row for it, or
+-- a version number ahead of the newest version row
+SELECT * FROM files
+WHERE updated_at < @before
+  AND storage_path LIKE 'blobs/%'
+  AND COALESCE(version, 1) > COALESCE(
+      (SELECT MAX(fv.version_number) FROM file_versions fv WHERE fv.file_id = files.id), 0
+  );
@@ -1,6 +1,6 @@
 -- name: CreateFileVersion :one
-INSERT INTO file_versions (file_id, version_number, storage_path, size, uploaded_by)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO file_versions (file_id, version_number, storage_path, size, uploaded_by, blob_digest)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING *;
 
 -- name: GetFileVersions :many
@@ -11,16 +11,45 @@ WHERE fv.file_id = $1
 ORDER BY fv.version_number DESC;
 
 -- name: GetLatestVersionNumber :one
-SELECT COALESCE(MAX(version_number), 0) as latest_version
+SELECT COALESCE(MAX(version_number), 0)::int AS latest_version
 FROM file_versions
 WHERE file_id = $1;
 
+-- name: GetLatestFileVersion :one
+SELECT * FROM file_versions
+WHERE file_id = $1
+ORDER BY version_number DESC
+LIMIT 1;
+
 -- name: GetFileVersion :one
 SELECT fv.*, u.name as uploader_name
 FROM file_versions fv
 JOIN users u ON fv.uploaded_by = u.id
 WHERE fv.id = $1;
 
+-- name: GetFileVersionsForDeletion :many
+SELECT id, storage_path, size, blob_digest
+FROM file_versions
+WHERE file_id = $1
+ORDER BY blob_digest;
+
 -- name: DeleteFileVersions :exec
 DELETE FROM file_versions
 WHERE file_id = $1;
+
+-- name: SetFileVersionPinned :exec
+UPDATE file_versions
+SET is_pinned = $2
+WHERE id = $1;
+
+-- name: DeletePrunableFileVersion :one
+-- Deletes a version unless it is pinned or has become the file's current version
+DELETE FROM file_versions fv
+WHERE fv.id = $1
+  AND NOT fv.is_pinned
+  AND NOT EXISTS (
+      SELECT 1 FROM files f
+      WHERE f.id = fv.file_id
+        AND (f.version = fv.version_number OR f.current_version_id = fv.id)
+  )
+RETURNING fv.storage_path, fv.size, fv.blob_digest;
@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TYPE upload_status AS ENUM ('pending', 'completed', 'aborted');
+
+-- Resumable upload sessions: chunks are staged under STORAGE_PATH/.staging/{id}
+-- and assembled into a regular file (or a new version) on completion
+CREATE TABLE upload_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename VARCHAR(500) NOT NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    parent_folder_id UUID REFERENCES folders(id) ON DELETE CASCADE,
+    total_size BIGINT NOT NULL,
+    received_bytes BIGINT NOT NULL DEFAULT 0,
+    chunk_count INTEGER NOT NULL DEFAULT 0,
+    status upload_status NOT NULL DEFAULT 'pending',
+    file_id UUID REFERENCES files(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_upload_sessions_user ON upload_sessions(user_id);
+CREATE INDEX idx_upload_sessions_expires ON upload_sessions(expires_at);
+
+-- +goose Down
+DROP TABLE upload_sessions;
+DROP TYPE upload_status;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- Content-addressed blobs: each distinct upload is stored once under
+-- blobs/{aa}/{bb}/{sha256} and shared by every file version with that content
+CREATE TABLE blobs (
+    digest TEXT PRIMARY KEY, -- hex SHA-256 of the content
+    size BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Reference counts per owner. A blob is charged to an owner's storage_used
+-- once, when their first reference is taken, and unlinked when the last
+-- reference of any owner is released.
+CREATE TABLE blob_refs (
+    digest TEXT NOT NULL REFERENCES blobs(digest),
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    ref_count INTEGER NOT NULL DEFAULT 0 CHECK (ref_count >= 0),
+    PRIMARY KEY (digest, owner_id)
+);
+
+CREATE INDEX idx_blob_refs_owner ON blob_refs(owner_id);
+
+-- Versions uploaded before this migration keep their legacy storage_path and a NULL digest
+ALTER TABLE file_versions ADD COLUMN blob_digest TEXT REFERENCES blobs(digest);
+
+CREATE INDEX idx_file_versions_blob ON file_versions(blob_digest);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_file_versions_blob;
+ALTER TABLE file_versions DROP COLUMN blob_digest;
+DROP TABLE blob_refs;
+DROP TABLE blobs;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- Pinned versions are never pruned by retention policies
+ALTER TABLE file_versions ADD COLUMN is_pinned BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Retention policies. A row with a NULL file_id is the user's default; a
+-- per-file row overrides it. A version is kept if it is current, pinned, one
+-- of the newest keep_last versions or younger than keep_days. A policy with
+-- neither limit keeps every version.
+CREATE TABLE version_retention_policies (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_id UUID REFERENCES files(id) ON DELETE CASCADE,
+    keep_last INTEGER CHECK (keep_last > 0),
+    keep_days INTEGER CHECK (keep_days > 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_retention_user_default ON version_retention_policies(user_id) WHERE file_id IS NULL;
+CREATE UNIQUE INDEX idx_retention_file ON version_retention_policies(file_id) WHERE file_id IS NOT NULL;
+
+-- +goose Down
+DROP TABLE version_retention_policies;
+ALTER TABLE file_versions DROP COLUMN is_pinned;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TYPE share_access_action AS ENUM ('view', 'browse', 'download', 'archive');
+
+-- Every use of a public share link, so owners can see who opened it.
+-- user_id is set when the visitor happened to be signed in.
+CREATE TABLE share_access_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    share_id UUID NOT NULL REFERENCES shares(id) ON DELETE CASCADE,
+    action share_access_action NOT NULL,
+    item_type item_type NOT NULL,
+    item_id UUID NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+    accessed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_share_access_log_share ON share_access_log(share_id, accessed_at DESC);
+
+-- +goose Down
+DROP TABLE share_access_log;
+DROP TYPE share_access_action;
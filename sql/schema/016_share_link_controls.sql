@@ -0,0 +1,16 @@
+-- +goose Up
+-- Share link controls. password_hash is a bcrypt hash; NULL means no password.
+-- max_downloads NULL means unlimited. View-only links (allow_download = FALSE)
+-- show metadata and folder listings but never serve content.
+ALTER TABLE shares
+    ADD COLUMN password_hash TEXT,
+    ADD COLUMN max_downloads INTEGER CHECK (max_downloads > 0),
+    ADD COLUMN download_count INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN allow_download BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- +goose Down
+ALTER TABLE shares
+    DROP COLUMN allow_download,
+    DROP COLUMN download_count,
+    DROP COLUMN max_downloads,
+    DROP COLUMN password_hash;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- One row per trash action. Trashing a folder trashes its whole active
+-- subtree; every item trashed by the action carries the operation id, so a
+-- restore brings back exactly that set and leaves items that were already in
+-- trash where they are.
+CREATE TABLE trash_operations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    root_type item_type NOT NULL,
+    root_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE files ADD COLUMN trash_operation_id UUID REFERENCES trash_operations(id) ON DELETE SET NULL;
+ALTER TABLE folders ADD COLUMN trash_operation_id UUID REFERENCES trash_operations(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_files_trash_operation ON files(trash_operation_id) WHERE trash_operation_id IS NOT NULL;
+CREATE INDEX idx_folders_trash_operation ON folders(trash_operation_id) WHERE trash_operation_id IS NOT NULL;
+
+-- +goose Down
+ALTER TABLE folders DROP COLUMN trash_operation_id;
+ALTER TABLE files DROP COLUMN trash_operation_id;
+DROP TABLE trash_operations;
@@ -0,0 +1,24 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Space held for uploads that are still in flight. An upload reserves its
+-- declared size before any content is written and releases the reservation
+-- once the content has been charged to storage_used, so concurrent uploads
+-- cannot together exceed storage_limit. Reservations of resumable uploads
+-- last as long as their session; the others expire on their own if the
+-- server dies before releasing them.
+CREATE TABLE storage_reservations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    upload_session_id UUID REFERENCES upload_sessions(id) ON DELETE CASCADE,
+    bytes BIGINT NOT NULL CHECK (bytes > 0),
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_storage_reservations_user ON storage_reservations(user_id);
+CREATE UNIQUE INDEX idx_storage_reservations_upload ON storage_reservations(upload_session_id) WHERE upload_session_id IS NOT NULL;
+
+-- +goose Down
+DROP TABLE storage_reservations;
+ALTER TABLE users DROP COLUMN is_admin;
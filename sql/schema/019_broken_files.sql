@@ -0,0 +1,9 @@
+-- +goose Up
+-- Set by the storage consistency checker when a file's current content is
+-- missing from storage or does not match its recorded size
+ALTER TABLE files ADD COLUMN broken_at TIMESTAMP;
+
+CREATE INDEX idx_files_broken ON files(broken_at) WHERE broken_at IS NOT NULL;
+
+-- +goose Down
+ALTER TABLE files DROP COLUMN broken_at;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- One rendered preview per file and size, stored in the thumbnail backend.
+-- files.thumbnail_path keeps pointing at the small one. Previews are
+-- generated by preview.generate jobs on the queue added in 021_jobs.sql.
+CREATE TABLE file_previews (
+    file_id UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    size VARCHAR(16) NOT NULL,
+    path TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (file_id, size)
+);
+
+-- +goose Down
+DROP TABLE file_previews;
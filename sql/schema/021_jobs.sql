@@ -0,0 +1,56 @@
+-- +goose Up
+CREATE TYPE job_status AS ENUM ('pending', 'running', 'succeeded', 'failed');
+
+-- Background jobs. Workers on any server instance claim due jobs with
+-- FOR UPDATE SKIP LOCKED; a job whose worker stops heartbeating is claimed
+-- again. Failed attempts are retried with exponential backoff until
+-- max_attempts, after which the job stays failed (the dead-letter state)
+-- until an administrator retries it.
+CREATE TABLE jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    kind VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    status job_status NOT NULL DEFAULT 'pending',
+    -- At most one pending job per key; enqueueing again updates it
+    unique_key TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    last_error TEXT,
+    run_after TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_by TEXT,
+    locked_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_jobs_queue ON jobs(run_after) WHERE status IN ('pending', 'running');
+CREATE INDEX idx_jobs_status ON jobs(status, kind);
+CREATE INDEX idx_jobs_unique_key ON jobs(unique_key) WHERE unique_key IS NOT NULL;
+CREATE UNIQUE INDEX idx_jobs_pending_key ON jobs(unique_key) WHERE unique_key IS NOT NULL AND status = 'pending';
+
+-- Recurring jobs. Each server registers the schedules it knows; whichever
+-- instance finds a schedule due first enqueues its job and moves next_run_at.
+CREATE TABLE job_schedules (
+    name VARCHAR(100) PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    spec TEXT NOT NULL,
+    next_run_at TIMESTAMP NOT NULL,
+    last_run_at TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Generate previews for everything uploaded before the queue existed
+INSERT INTO jobs (kind, payload, unique_key, max_attempts)
+SELECT 'preview.generate',
+       jsonb_build_object('file_id', id, 'version', COALESCE(version, 1)),
+       'preview:' || id,
+       3
+FROM files
+WHERE status IS DISTINCT FROM 'deleted';
+
+-- +goose Down
+DROP TABLE job_schedules;
+DROP TABLE jobs;
+DROP TYPE job_status;
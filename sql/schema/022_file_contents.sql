@@ -0,0 +1,30 @@
+-- +goose Up
+-- Text extracted from documents in the background so search can match what
+-- files contain. One row per file, for the version the text was read from.
+CREATE TABLE file_contents (
+    file_id UUID PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    search_vector TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', content)) STORED,
+    extracted_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_file_contents_search ON file_contents USING GIN (search_vector);
+
+-- Extract the text of files uploaded before extraction existed
+INSERT INTO jobs (kind, payload, unique_key, max_attempts)
+SELECT 'content.extract',
+       jsonb_build_object('file_id', id, 'version', COALESCE(version, 1)),
+       'content:' || id,
+       3
+FROM files
+WHERE status != 'deleted'
+  AND (mime_type LIKE 'text/%'
+    OR mime_type IN ('application/pdf', 'application/json', 'application/xml', 'application/javascript')
+    OR mime_type LIKE 'application/vnd.openxmlformats-officedocument.%'
+    OR name ~* '\.(pdf|docx|xlsx|pptx|txt|md|csv|json|xml|yaml|yml|go|py|js|ts|java|c|h|cpp|rs|rb|sh|sql)$')
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM jobs WHERE kind = 'content.extract';
+DROP TABLE file_contents;
@@ -0,0 +1,58 @@
+-- +goose Up
+-- At most one active file and one active folder of each name directly in a
+-- folder, and at the top of each user's drive. Trashed items are left out so
+-- a name can be reused while the old item waits in trash; restoring renames
+-- the item if its name was taken meanwhile.
+--
+-- Duplicates created before the constraint keep the oldest item's name; the
+-- others get the start of their id added, before the extension for files.
+WITH ranked AS (
+    SELECT id, ROW_NUMBER() OVER (
+        PARTITION BY parent_folder_id, CASE WHEN parent_folder_id IS NULL THEN owner_id END, name
+        ORDER BY created_at, id
+    ) AS n
+    FROM files
+    WHERE status = 'active'
+)
+UPDATE files f
+SET name = CASE
+        WHEN regexp_replace(f.name, '\.[^.]*$', '') IN ('', f.name)
+            THEN f.name || ' (' || left(f.id::text, 8) || ')'
+        ELSE regexp_replace(f.name, '\.[^.]*$', '') || ' (' || left(f.id::text, 8) || ')'
+             || substring(f.name FROM '\.[^.]*$')
+    END,
+    updated_at = NOW()
+FROM ranked
+WHERE f.id = ranked.id AND ranked.n > 1;
+
+WITH ranked AS (
+    SELECT id, ROW_NUMBER() OVER (
+        PARTITION BY parent_folder_id, CASE WHEN parent_folder_id IS NULL THEN owner_id END, name
+        ORDER BY created_at, id
+    ) AS n
+    FROM folders
+    WHERE status = 'active' AND is_root IS NOT TRUE
+)
+UPDATE folders f
+SET name = f.name || ' (' || left(f.id::text, 8) || ')', updated_at = NOW()
+FROM ranked
+WHERE f.id = ranked.id AND ranked.n > 1;
+
+CREATE UNIQUE INDEX idx_files_unique_name ON files(parent_folder_id, name)
+    WHERE status = 'active' AND parent_folder_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_files_unique_top_name ON files(owner_id, name)
+    WHERE status = 'active' AND parent_folder_id IS NULL;
+CREATE UNIQUE INDEX idx_folders_unique_name ON folders(parent_folder_id, name)
+    WHERE status = 'active' AND parent_folder_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_folders_unique_top_name ON folders(owner_id, name)
+    WHERE status = 'active' AND parent_folder_id IS NULL AND is_root IS NOT TRUE;
+
+-- What a resumable upload does if its name is taken when it completes
+ALTER TABLE upload_sessions ADD COLUMN on_conflict VARCHAR(20) NOT NULL DEFAULT 'replace';
+
+-- +goose Down
+ALTER TABLE upload_sessions DROP COLUMN on_conflict;
+DROP INDEX idx_folders_unique_top_name;
+DROP INDEX idx_folders_unique_name;
+DROP INDEX idx_files_unique_top_name;
+DROP INDEX idx_files_unique_name;